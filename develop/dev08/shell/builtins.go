@@ -0,0 +1,330 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// builtin встроенная команда: выполняется в самом шелле и возвращает код завершения
+type builtin func(sh *shell, args []string, std stdio) int
+
+// builtins встроенные команды по имени. Заполняется в init: команды обращаются
+// к шеллу, который сам ищет их в этой таблице
+var builtins map[string]builtin
+
+func init() {
+	builtins = map[string]builtin{
+		"cd":     builtinCd,
+		"pwd":    builtinPwd,
+		"echo":   builtinEcho,
+		"exit":   builtinExit,
+		"kill":   builtinKill,
+		"ps":     builtinPs,
+		"export": builtinExport,
+		"unset":  builtinUnset,
+		"env":    builtinEnv,
+		"alias":  builtinAlias,
+		"type":   builtinType,
+		"which":  builtinWhich,
+	}
+}
+
+// builtinCd меняет каталог шелла, без аргумента - на $HOME, "cd -" - на $OLDPWD
+func builtinCd(sh *shell, args []string, std stdio) int {
+	var dir string
+	switch len(args) {
+	case 1:
+		dir = sh.getVar("HOME")
+		if dir == "" {
+			fmt.Fprintln(std.err, "cd: HOME not set")
+			return 1
+		}
+	case 2:
+		dir = args[1]
+		if dir == "-" {
+			dir = sh.getVar("OLDPWD")
+			if dir == "" {
+				fmt.Fprintln(std.err, "cd: OLDPWD not set")
+				return 1
+			}
+			fmt.Fprintln(std.out, dir)
+		}
+	default:
+		fmt.Fprintln(std.err, "cd: too many arguments")
+		return 1
+	}
+
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(sh.dir, dir)
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		fmt.Fprintf(std.err, "cd: %s: no such file or directory\n", args[len(args)-1])
+		return 1
+	}
+	if !info.IsDir() {
+		fmt.Fprintf(std.err, "cd: %s: not a directory\n", args[len(args)-1])
+		return 1
+	}
+
+	sh.setVar("OLDPWD", sh.dir)
+	sh.setVar("PWD", dir)
+	sh.dir = dir
+	return 0
+}
+
+// builtinPwd печатает каталог шелла
+func builtinPwd(sh *shell, args []string, std stdio) int {
+	fmt.Fprintln(std.out, sh.dir)
+	return 0
+}
+
+// builtinEcho печатает аргументы через пробел. -n - без перевода строки в конце,
+// -e - с разбором escape-последовательностей, -E - без разбора. Флаги можно объединять: -ne
+func builtinEcho(sh *shell, args []string, std stdio) int {
+	newline, escapes := true, false
+	args = args[1:]
+	for len(args) > 0 && len(args[0]) > 1 && args[0][0] == '-' && strings.Trim(args[0][1:], "neE") == "" {
+		for _, f := range args[0][1:] {
+			switch f {
+			case 'n':
+				newline = false
+			case 'e':
+				escapes = true
+			case 'E':
+				escapes = false
+			}
+		}
+		args = args[1:]
+	}
+
+	text := strings.Join(args, " ")
+	if escapes {
+		var stop bool
+		text, stop = unescape(text)
+		if stop {
+			newline = false
+		}
+	}
+	if newline {
+		text += "\n"
+	}
+	if _, err := io.WriteString(std.out, text); err != nil {
+		fmt.Fprintf(std.err, "echo: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// unescape разбирает escape-последовательности echo -e. \c прекращает вывод: stop = true
+func unescape(s string) (res string, stop bool) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i == len(s)-1 {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch c := s[i]; c {
+		case 'a':
+			b.WriteByte('\a')
+		case 'b':
+			b.WriteByte('\b')
+		case 'c':
+			return b.String(), true
+		case 'e', 'E':
+			b.WriteByte(0x1b)
+		case 'f':
+			b.WriteByte('\f')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 't':
+			b.WriteByte('\t')
+		case 'v':
+			b.WriteByte('\v')
+		case '\\':
+			b.WriteByte('\\')
+		case '0', 'x':
+			// \0nnn - до трех восьмеричных цифр, \xHH - до двух шестнадцатеричных
+			base, digits, maxDigits := 8, "01234567", 3
+			if c == 'x' {
+				base, digits, maxDigits = 16, "0123456789abcdefABCDEF", 2
+			}
+			j := i + 1
+			for j < len(s) && j-i-1 < maxDigits && strings.IndexByte(digits, s[j]) >= 0 {
+				j++
+			}
+			if c == 'x' && j == i+1 {
+				b.WriteString("\\x")
+				continue
+			}
+			n, _ := strconv.ParseUint("0"+s[i+1:j], base, 8)
+			b.WriteByte(byte(n))
+			i = j - 1
+		default:
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		}
+	}
+	return b.String(), false
+}
+
+// builtinExit завершает шелл с кодом из аргумента или кодом последней команды
+func builtinExit(sh *shell, args []string, std stdio) int {
+	status := sh.status
+	if len(args) > 1 {
+		n, err := strconv.Atoi(args[1])
+		if err != nil {
+			fmt.Fprintf(std.err, "exit: %s: numeric argument required\n", args[1])
+			n = 2
+		}
+		status = n
+	}
+
+	sh.exited = true
+	return status & 0xff
+}
+
+// builtinExport помечает переменные для передачи внешним командам: "export NAME=value"
+// или "export NAME". Без аргументов и с -p печатает экспортированные переменные
+func builtinExport(sh *shell, args []string, std stdio) int {
+	if len(args) == 1 || (len(args) == 2 && args[1] == "-p") {
+		for _, kv := range sh.environ() {
+			name, value, _ := strings.Cut(kv, "=")
+			fmt.Fprintf(std.out, "export %s=%s\n", name, shellQuote(value))
+		}
+		return 0
+	}
+
+	status := 0
+	for _, arg := range args[1:] {
+		name, value, assign := strings.Cut(arg, "=")
+		if !validName(name) {
+			fmt.Fprintf(std.err, "export: `%s': not a valid identifier\n", arg)
+			status = 1
+			continue
+		}
+		v := sh.vars[name]
+		if assign {
+			v.value = value
+		}
+		v.exported = true
+		sh.vars[name] = v
+	}
+	return status
+}
+
+// builtinUnset удаляет переменные
+func builtinUnset(sh *shell, args []string, std stdio) int {
+	status := 0
+	for _, name := range args[1:] {
+		if !validName(name) {
+			fmt.Fprintf(std.err, "unset: `%s': not a valid identifier\n", name)
+			status = 1
+			continue
+		}
+		delete(sh.vars, name)
+	}
+	return status
+}
+
+// builtinEnv печатает окружение внешних команд. "env NAME=value... команда" запускает
+// внешнюю команду с дополненным окружением, не меняя переменные шелла
+func builtinEnv(sh *shell, args []string, std stdio) int {
+	sub := sh.clone()
+	args = args[1:]
+	for len(args) > 0 && strings.Contains(args[0], "=") {
+		name, value, _ := strings.Cut(args[0], "=")
+		sub.vars[name] = variable{value: value, exported: true}
+		args = args[1:]
+	}
+
+	if len(args) == 0 {
+		for _, kv := range sub.environ() {
+			fmt.Fprintln(std.out, kv)
+		}
+		return 0
+	}
+	cmd, status := sub.startProcess(args, std)
+	if cmd == nil {
+		return status
+	}
+	return exitStatus(cmd.Wait())
+}
+
+// builtinAlias задает псевдонимы "alias name=value" и печатает их: все без аргументов
+// или названные. Псевдоним раскрывается, когда стоит на месте имени команды
+func builtinAlias(sh *shell, args []string, std stdio) int {
+	if len(args) == 1 {
+		names := make([]string, 0, len(sh.aliases))
+		for name := range sh.aliases {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(std.out, "alias %s=%s\n", name, shellQuote(sh.aliases[name]))
+		}
+		return 0
+	}
+
+	status := 0
+	for _, arg := range args[1:] {
+		name, value, assign := strings.Cut(arg, "=")
+		if name == "" || strings.ContainsAny(name, "/$`'\"\\ \t") {
+			fmt.Fprintf(std.err, "alias: `%s': invalid alias name\n", name)
+			status = 1
+			continue
+		}
+		if assign {
+			sh.aliases[name] = value
+			continue
+		}
+		value, ok := sh.aliases[name]
+		if !ok {
+			fmt.Fprintf(std.err, "alias: %s: not found\n", name)
+			status = 1
+			continue
+		}
+		fmt.Fprintf(std.out, "alias %s=%s\n", name, shellQuote(value))
+	}
+	return status
+}
+
+// builtinType сообщает, чем шелл считает каждое имя: псевдоним, встроенная команда или файл
+func builtinType(sh *shell, args []string, std stdio) int {
+	status := 0
+	for _, name := range args[1:] {
+		if value, ok := sh.aliases[name]; ok {
+			fmt.Fprintf(std.out, "%s is aliased to `%s'\n", name, value)
+		} else if _, ok := builtins[name]; ok {
+			fmt.Fprintf(std.out, "%s is a shell builtin\n", name)
+		} else if path, ok := sh.lookPath(name); ok {
+			fmt.Fprintf(std.out, "%s is %s\n", name, path)
+		} else {
+			fmt.Fprintf(std.err, "type: %s: not found\n", name)
+			status = 1
+		}
+	}
+	return status
+}
+
+// builtinWhich печатает путь к исполняемому файлу по PATH шелла. Код 1, если хоть одно имя не найдено
+func builtinWhich(sh *shell, args []string, std stdio) int {
+	status := 0
+	for _, name := range args[1:] {
+		path, ok := sh.lookPath(name)
+		if !ok {
+			status = 1
+			continue
+		}
+		fmt.Fprintln(std.out, path)
+	}
+	return status
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestEcho(t *testing.T) {
+	tests := []struct {
+		line string
+		out  string
+	}{
+		{"echo a  b", "a b\n"},
+		{"echo", "\n"},
+		{"echo -n a", "a"},
+		{`echo 'a\tb'`, "a\\tb\n"},
+		{`echo -e 'a\tb\n'`, "a\tb\n\n"},
+		{`echo -ne 'x\x41\0101\\'`, "xAA\\"},
+		{`echo -e 'a\cb'`, "a"},
+		{`echo -eE 'a\tb'`, "a\\tb\n"},
+		{"echo -x -n", "-x -n\n"},
+		{"echo - a", "- a\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			out, errOut, status := runLine(t, testShell(t), tt.line)
+			if out != tt.out || status != 0 {
+				t.Errorf("got %q (status %v, stderr %q), want %q", out, status, errOut, tt.out)
+			}
+		})
+	}
+}
+
+func TestCdPwd(t *testing.T) {
+	sh := testShell(t)
+	dir := sh.dir
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	if out, _, _ := runLine(t, sh, "cd sub && pwd"); out != filepath.Join(dir, "sub")+"\n" {
+		t.Errorf("pwd after cd: %q", out)
+	}
+	if out, _, _ := runLine(t, sh, "cd -"); out != dir+"\n" || sh.dir != dir {
+		t.Errorf("cd -: printed %q, dir %q", out, sh.dir)
+	}
+	if sh.getVar("PWD") != dir || sh.getVar("OLDPWD") != filepath.Join(dir, "sub") {
+		t.Errorf("PWD %q, OLDPWD %q", sh.getVar("PWD"), sh.getVar("OLDPWD"))
+	}
+
+	runLine(t, sh, "export HOME="+shellQuote(filepath.Join(dir, "sub")))
+	if runLine(t, sh, "cd"); sh.dir != filepath.Join(dir, "sub") {
+		t.Errorf("cd without arguments: dir %q", sh.dir)
+	}
+}
+
+// TestVariables внешним командам передаются только экспортированные переменные
+func TestVariables(t *testing.T) {
+	sh := testShell(t)
+	sh.setVar("LOCAL", "1")
+
+	out, _, _ := runLine(t, sh, `export A=x B='y z'; sh -c 'echo "$A|$B|$LOCAL"'`)
+	if out != "x|y z|\n" {
+		t.Errorf("exported variables: %q", out)
+	}
+	if out, _, _ := runLine(t, sh, `export LOCAL; sh -c 'echo $LOCAL'`); out != "1\n" {
+		t.Errorf("export of local variable: %q", out)
+	}
+	if out, _, _ := runLine(t, sh, `export -p | grep '^export B='`); out != "export B='y z'\n" {
+		t.Errorf("export -p: %q", out)
+	}
+	if out, _, _ := runLine(t, sh, `unset A; env | grep '^A='`); out != "" {
+		t.Errorf("unset: %q", out)
+	}
+	if out, _, _ := runLine(t, sh, `env A=1 sh -c 'echo $A'; env | grep -c '^A='`); out != "1\n0\n" {
+		t.Errorf("env with assignment: %q", out)
+	}
+	if _, errOut, status := runLine(t, sh, "export 1x=2"); status != 1 || !strings.Contains(errOut, "not a valid identifier") {
+		t.Errorf("invalid name: status %v, stderr %q", status, errOut)
+	}
+
+	// PATH шелла, а не процесса
+	if _, _, status := runLine(t, sh, "export PATH=/nonexistent; ls"); status != 127 {
+		t.Errorf("command with empty PATH: status %v", status)
+	}
+}
+
+func TestAlias(t *testing.T) {
+	sh := testShell(t)
+	runLine(t, sh, `alias hi='echo hello' count='wc -l' ls='ls -a' self=self`)
+
+	tests := []struct {
+		line   string
+		out    string
+		status int
+	}{
+		{"hi world", "hello world\n", 0},
+		{"seq 3 | count", "3\n", 0},
+		{"ls | head -n 1", ".\n", 0},
+		{"self", "", 127},
+		{"alias hi", "alias hi='echo hello'\n", 0},
+		{"alias | head -n 1", "alias count='wc -l'\n", 0},
+		{"alias nope", "", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			out, errOut, status := runLine(t, sh, tt.line)
+			if strings.TrimLeft(out, " ") != tt.out || status != tt.status {
+				t.Errorf("got %q (status %v, stderr %q), want %q (status %v)", out, status, errOut, tt.out, tt.status)
+			}
+		})
+	}
+}
+
+func TestTypeWhich(t *testing.T) {
+	sh := testShell(t)
+	lsPath, err := exec.LookPath("ls")
+	if err != nil {
+		t.Skip(err)
+	}
+	sh.setVar("PATH", filepath.Dir(lsPath))
+	runLine(t, sh, "alias ll='ls -l'")
+
+	out, _, status := runLine(t, sh, "type ll cd ls nope")
+	want := "ll is aliased to `ls -l'\ncd is a shell builtin\nls is " + lsPath + "\n"
+	if out != want || status != 1 {
+		t.Errorf("type: got %q (status %v), want %q", out, status, want)
+	}
+	if out, _, status := runLine(t, sh, "which ls"); out != lsPath+"\n" || status != 0 {
+		t.Errorf("which ls: %q (status %v)", out, status)
+	}
+	if out, _, status := runLine(t, sh, "which nope"); out != "" || status != 1 {
+		t.Errorf("which nope: %q (status %v)", out, status)
+	}
+}
+
+func TestKill(t *testing.T) {
+	for _, spec := range []string{"", "-9", "-KILL", "-sigint", "-s TERM", "-n 15"} {
+		t.Run(spec, func(t *testing.T) {
+			cmd := exec.Command("sleep", "10")
+			if err := cmd.Start(); err != nil {
+				t.Fatal(err)
+			}
+			defer cmd.Process.Kill()
+
+			line := "kill " + spec + " " + strconv.Itoa(cmd.Process.Pid)
+			if _, errOut, status := runLine(t, testShell(t), line); status != 0 {
+				t.Fatalf("%s: status %v, stderr %q", line, status, errOut)
+			}
+			err := cmd.Wait()
+			if ws, ok := cmd.ProcessState.Sys().(syscall.WaitStatus); !ok || !ws.Signaled() {
+				t.Errorf("%s: process not killed: %v", line, err)
+			}
+		})
+	}
+
+	sh := testShell(t)
+	if out, _, _ := runLine(t, sh, "kill -l 9 137 TERM"); out != "KILL\nKILL\n15\n" {
+		t.Errorf("kill -l: %q", out)
+	}
+	if _, errOut, status := runLine(t, sh, "kill -NOPE 1"); status != 1 || !strings.Contains(errOut, "invalid signal") {
+		t.Errorf("bad signal: status %v, stderr %q", status, errOut)
+	}
+	if _, _, status := runLine(t, sh, "kill"); status != 2 {
+		t.Errorf("kill without pid: status %v", status)
+	}
+}
+
+func TestPs(t *testing.T) {
+	root := t.TempDir()
+	procs := map[string][2]string{
+		"10":   {"10 (bash) S 1 10 10 0", "bash\x00-l\x00"},
+		"2":    {"2 (kthreadd) S 0 0 0 0", ""},
+		"7":    {"7 (a (b) c) R 2 7 7 0", "a b\x00c\x00"},
+		"self": {"1 (x) S 0", ""},
+	}
+	for name, files := range procs {
+		dir := filepath.Join(root, name)
+		if err := os.Mkdir(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		os.WriteFile(filepath.Join(dir, "stat"), []byte(files[0]+"\n"), 0o644)
+		os.WriteFile(filepath.Join(dir, "cmdline"), []byte(files[1]), 0o644)
+	}
+	defer func(old string) { procRoot = old }(procRoot)
+	procRoot = root
+
+	out, errOut, status := runLine(t, testShell(t), "ps")
+	want := "    PID    PPID S CMD\n" +
+		"      2       0 S [kthreadd]\n" +
+		"      7       2 R a b c\n" +
+		"     10       1 S bash -l\n"
+	if out != want || status != 0 {
+		t.Errorf("got %q (status %v, stderr %q), want %q", out, status, errOut, want)
+	}
+
+	// Привычные опции принимаются, вывод тот же
+	for _, line := range []string{"ps aux | grep -c bash", "ps -ef | grep -c bash", "ps -A | grep -c bash", "ps -e | wc -l"} {
+		want := "1\n"
+		if strings.HasSuffix(line, "wc -l") {
+			want = "4\n"
+		}
+		if out, errOut, status := runLine(t, testShell(t), line); strings.TrimLeft(out, " ") != want || status != 0 {
+			t.Errorf("%s: got %q (status %v, stderr %q), want %q", line, out, status, errOut, want)
+		}
+	}
+	if _, errOut, status := runLine(t, testShell(t), "ps --forest"); status != 1 || !strings.Contains(errOut, "unsupported option") {
+		t.Errorf("unknown option: status %v, stderr %q", status, errOut)
+	}
+}
@@ -0,0 +1,502 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Подстановки в словах команды выполняются перед запуском, по порядку:
+//	~ и ~user в начале слова - домашний каталог
+//	$NAME, ${NAME}, ${NAME:-слово} и другие формы ${...} - значение переменной
+//	$? - код завершения последней команды, $$ - PID шелла
+//	$(команда) - вывод команды без завершающих переводов строки
+// Результат подстановок вне кавычек делится на поля по символам IFS, затем поля с *, ? и [...]
+// заменяются подходящими путями. В двойных кавычках подстановки выполняются без деления на поля
+// и без поиска файлов, в одинарных не выполняются
+
+// defaultIFS разделители полей, если IFS не задана
+const defaultIFS = " \t\n"
+
+// assignment присваивание NAME=value перед именем команды
+type assignment struct {
+	name, value string
+}
+
+// field поле результата подстановок. pattern - то же значение как шаблон для поиска файлов:
+// символы из кавычек в нем экранированы
+type field struct {
+	value   strings.Builder
+	pattern strings.Builder
+	// glob в поле есть * ? или [ не из кавычек
+	glob bool
+	// quoted в поле есть часть в кавычках: пустое поле "" остается аргументом
+	quoted bool
+}
+
+// expander выполняет подстановки для одной команды. $(...) пишет ошибки в std.err
+type expander struct {
+	sh     *shell
+	std    stdio
+	fields []*field
+	cur    *field
+	// status код завершения последней $(...), он становится кодом команды из одних присваиваний
+	status int
+}
+
+func (sh *shell) expander(std stdio) *expander {
+	return &expander{sh: sh, std: std}
+}
+
+// command разбирает слова команды на присваивания в начале и аргументы
+func (e *expander) command(c *simpleCommand) ([]assignment, []string, error) {
+	var assigns []assignment
+	words := c.words
+	for len(words) > 0 {
+		name, ok := assignmentName(words[0])
+		if !ok {
+			break
+		}
+		// Значение - остаток слова после =, без деления на поля и поиска файлов
+		value := append(word{{text: words[0][0].text[len(name)+1:], quoting: unquoted}}, words[0][1:]...)
+		s, err := e.string(value)
+		if err != nil {
+			return nil, nil, err
+		}
+		assigns = append(assigns, assignment{name: name, value: s})
+		words = words[1:]
+	}
+
+	args, err := e.words(words)
+	return assigns, args, err
+}
+
+// assignmentName имя переменной, если слово - присваивание NAME=value без кавычек в имени
+func assignmentName(w word) (string, bool) {
+	if len(w) == 0 || w[0].quoting != unquoted {
+		return "", false
+	}
+	name, _, ok := strings.Cut(w[0].text, "=")
+	return name, ok && validName(name)
+}
+
+// words подстановки во всех словах. Слово может дать несколько полей или ни одного
+func (e *expander) words(words []word) ([]string, error) {
+	var args []string
+	for _, w := range words {
+		fields, err := e.word(w)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, fields...)
+	}
+	return args, nil
+}
+
+// word подстановки в слове с делением на поля и поиском файлов
+func (e *expander) word(w word) ([]string, error) {
+	e.fields, e.cur = nil, nil
+	for i, part := range w {
+		text := part.text
+		if i == 0 && part.quoting == unquoted {
+			var home string
+			if home, text = e.tilde(text); home != "" {
+				e.literal(home, true)
+			}
+		}
+
+		switch part.quoting {
+		case singleQuoted:
+			e.literal(text, true)
+		case doubleQuoted:
+			e.literal("", true)
+			if err := e.scan(text, func(s string) { e.literal(s, true) }, func(s string) { e.literal(s, true) }); err != nil {
+				return nil, err
+			}
+		default:
+			if err := e.scan(text, func(s string) { e.literal(s, false) }, e.split); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	var res []string
+	for _, f := range e.fields {
+		if f.glob {
+			if matches := e.sh.glob(f.pattern.String()); len(matches) > 0 {
+				res = append(res, matches...)
+				continue
+			}
+		}
+		if f.value.Len() > 0 || f.quoted {
+			res = append(res, f.value.String())
+		}
+	}
+	return res, nil
+}
+
+// string подстановки в слове без деления на поля и поиска файлов: для присваиваний
+// и текста ${NAME:-слово}
+func (e *expander) string(w word) (string, error) {
+	var b strings.Builder
+	for i, part := range w {
+		text := part.text
+		if part.quoting == singleQuoted {
+			b.WriteString(text)
+			continue
+		}
+		if i == 0 && part.quoting == unquoted {
+			var home string
+			home, text = e.tilde(text)
+			b.WriteString(home)
+		}
+		if err := e.scan(text, func(s string) { b.WriteString(s) }, func(s string) { b.WriteString(s) }); err != nil {
+			return "", err
+		}
+	}
+	return b.String(), nil
+}
+
+// heredoc подстановки в тексте <<. Обратная косая экранирует только $ ` \ и перевод строки
+func (e *expander) heredoc(text string) (string, error) {
+	var b strings.Builder
+	for text != "" {
+		i := strings.IndexAny(text, `\$`)
+		if i == -1 {
+			b.WriteString(text)
+			break
+		}
+		b.WriteString(text[:i])
+		text = text[i:]
+
+		if text[0] == '\\' {
+			if len(text) > 1 && strings.IndexByte("$`\\\n", text[1]) != -1 {
+				if text[1] != '\n' {
+					b.WriteByte(text[1])
+				}
+				text = text[2:]
+				continue
+			}
+			b.WriteByte('\\')
+			text = text[1:]
+			continue
+		}
+
+		end, value, err := e.dollar(text)
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(value)
+		text = text[end:]
+	}
+	return b.String(), nil
+}
+
+// scan находит в тексте подстановки $: обычный текст передает в literal, результат подстановки - в value
+func (e *expander) scan(text string, literal, value func(string)) error {
+	for text != "" {
+		i := strings.IndexByte(text, '$')
+		if i == -1 {
+			literal(text)
+			return nil
+		}
+		literal(text[:i])
+		end, v, err := e.dollar(text[i:])
+		if err != nil {
+			return err
+		}
+		if end == 1 {
+			// $ без имени после него остается как есть
+			literal("$")
+		} else {
+			value(v)
+		}
+		text = text[i+end:]
+	}
+	return nil
+}
+
+// dollar подстановка в начале текста, который начинается с $. Возвращает длину подстановки
+// в тексте и ее значение
+func (e *expander) dollar(text string) (int, string, error) {
+	if len(text) < 2 {
+		return 1, "$", nil
+	}
+	switch c := text[1]; {
+	case c == '(':
+		l := &lexer{src: text, pos: 2}
+		if err := l.skipBalanced(')'); err != nil {
+			return 0, "", fmt.Errorf("%s: unterminated command substitution", text)
+		}
+		return l.pos, e.substitute(text[2 : l.pos-1]), nil
+	case c == '{':
+		l := &lexer{src: text, pos: 2}
+		if err := l.skipBalanced('}'); err != nil {
+			return 0, "", fmt.Errorf("%s: bad substitution", text)
+		}
+		value, err := e.param(text[2 : l.pos-1])
+		return l.pos, value, err
+	case c == '?' || c == '$':
+		value, _ := e.special(c)
+		return 2, value, nil
+	case c >= '0' && c <= '9':
+		value, _ := e.positional(text[1:2])
+		return 2, value, nil
+	}
+
+	n := nameLen(text[1:])
+	if n == 0 {
+		return 1, "$", nil
+	}
+	return 1 + n, e.sh.getVar(text[1 : 1+n]), nil
+}
+
+// nameLen длина имени переменной в начале строки
+func nameLen(s string) int {
+	n := 0
+	for n < len(s) && validName(s[:n+1]) {
+		n++
+	}
+	return n
+}
+
+// special значения $? и $$
+func (e *expander) special(c byte) (string, bool) {
+	switch c {
+	case '?':
+		return strconv.Itoa(e.sh.status), true
+	case '$':
+		return strconv.Itoa(os.Getpid()), true
+	}
+	return "", false
+}
+
+// shellName значение $0
+const shellName = "shell"
+
+// positional позиционный параметр: $0 - имя шелла, аргументов у интерактивного шелла нет,
+// поэтому $1, ${10} пустые, как в bash без аргументов
+func (e *expander) positional(name string) (string, bool) {
+	if name == "0" {
+		return shellName, true
+	}
+	return "", false
+}
+
+// param подстановка ${...}: ${NAME}, ${NAME-слово} и ${NAME:-слово} - значение или слово,
+// ${NAME=слово} и ${NAME:=слово} - то же с присваиванием, ${NAME+слово} и ${NAME:+слово} -
+// слово, если значение есть. С двоеточием пустое значение считается отсутствующим
+func (e *expander) param(expr string) (string, error) {
+	n := nameLen(expr)
+	var value string
+	set := false
+	if n == 0 && expr != "" {
+		for n < len(expr) && expr[n] >= '0' && expr[n] <= '9' {
+			n++
+		}
+		if n > 0 {
+			value, set = e.positional(expr[:n])
+		} else if value, set = e.special(expr[0]); set {
+			n = 1
+		}
+	} else if n > 0 {
+		var v variable
+		v, set = e.sh.vars[expr[:n]]
+		value = v.value
+	}
+	if n == 0 {
+		return "", fmt.Errorf("${%s}: bad substitution", expr)
+	}
+
+	name, op := expr[:n], expr[n:]
+	if op == "" {
+		return value, nil
+	}
+	if strings.HasPrefix(op, ":") {
+		op = op[1:]
+		set = set && value != ""
+	}
+	if op == "" || strings.IndexByte("-=+", op[0]) == -1 {
+		return "", fmt.Errorf("${%s}: bad substitution", expr)
+	}
+
+	// Слово после оператора может содержать кавычки и вложенные подстановки: ${A:-"}"}, ${A:-${B}}
+	w, err := (&lexer{src: op[1:]}).wordUntil(func(byte) bool { return false })
+	if err != nil {
+		return "", fmt.Errorf("${%s}: bad substitution", expr)
+	}
+	alt, err := e.string(w)
+	if err != nil {
+		return "", err
+	}
+	switch op[0] {
+	case '+':
+		if set {
+			return alt, nil
+		}
+		return "", nil
+	case '=':
+		if !set {
+			if !validName(name) {
+				return "", fmt.Errorf("$%s: cannot assign in this way", name)
+			}
+			e.sh.setVar(name, alt)
+			return alt, nil
+		}
+	default:
+		if !set {
+			return alt, nil
+		}
+	}
+	return value, nil
+}
+
+// substitute выполняет $(команда) с копией шелла и возвращает ее вывод без переводов строки в конце
+func (e *expander) substitute(src string) string {
+	var out bytes.Buffer
+	e.status = e.sh.clone().commandExec(src, stdio{in: e.std.in, out: &out, err: e.std.err})
+	return strings.TrimRight(out.String(), "\n")
+}
+
+// tilde домашний каталог для ~ или ~user в начале текста до первого / и остаток текста.
+// Без ~ и для неизвестного пользователя каталог пустой, текст остается как есть
+func (e *expander) tilde(text string) (string, string) {
+	if !strings.HasPrefix(text, "~") {
+		return "", text
+	}
+	name, rest := text[1:], ""
+	if i := strings.IndexByte(name, '/'); i != -1 {
+		name, rest = name[:i], name[i:]
+	}
+
+	if name == "" {
+		if home := e.sh.getVar("HOME"); home != "" {
+			return home, rest
+		}
+		return "", text
+	}
+	u, err := user.Lookup(name)
+	if err != nil {
+		return "", text
+	}
+	return u.HomeDir, rest
+}
+
+// literal добавляет текст к текущему полю. Текст из кавычек не участвует в поиске файлов
+func (e *expander) literal(text string, quoted bool) {
+	if e.cur == nil {
+		e.cur = &field{}
+		e.fields = append(e.fields, e.cur)
+	}
+	f := e.cur
+	f.value.WriteString(text)
+	if quoted {
+		f.quoted = true
+	}
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case quoted && strings.IndexByte(`*?[]\`, c) != -1:
+			f.pattern.WriteByte('\\')
+		case !quoted && strings.IndexByte("*?[", c) != -1:
+			f.glob = true
+		}
+		f.pattern.WriteByte(c)
+	}
+}
+
+// split добавляет результат подстановки вне кавычек, разделяя его на поля по символам IFS
+func (e *expander) split(value string) {
+	ifs := defaultIFS
+	if v, ok := e.sh.vars["IFS"]; ok {
+		ifs = v.value
+	}
+	for value != "" {
+		i := strings.IndexAny(value, ifs)
+		if ifs == "" || i == -1 {
+			e.literal(value, false)
+			return
+		}
+		if i > 0 {
+			e.literal(value[:i], false)
+		}
+		e.cur = nil
+		value = value[i+1:]
+	}
+}
+
+// glob пути, подходящие под шаблон, по возрастанию. Относительный шаблон ищется от каталога шелла.
+// Имена на точку подходят только под шаблон, который сам начинается с точки
+func (sh *shell) glob(pattern string) []string {
+	var matches []string
+	var walk func(prefix string, segments []string)
+	walk = func(prefix string, segments []string) {
+		if len(segments) == 0 {
+			if _, err := os.Lstat(sh.path(prefix)); err == nil {
+				matches = append(matches, prefix)
+			}
+			return
+		}
+		seg, rest := segments[0], segments[1:]
+		next := func(name string) {
+			p := prefix + name
+			if len(rest) > 0 {
+				p += "/"
+			}
+			walk(p, rest)
+		}
+
+		if !hasGlobMeta(seg) {
+			next(unescapeGlob(seg))
+			return
+		}
+		dir := prefix
+		if dir == "" {
+			dir = "."
+		}
+		entries, err := os.ReadDir(sh.path(dir))
+		if err != nil {
+			return
+		}
+		for _, entry := range entries {
+			name := entry.Name()
+			if name[0] == '.' && seg[0] != '.' {
+				continue
+			}
+			if ok, _ := filepath.Match(seg, name); ok {
+				next(name)
+			}
+		}
+	}
+	walk("", strings.Split(pattern, "/"))
+	return matches
+}
+
+// hasGlobMeta в части шаблона есть неэкранированные * ? или [
+func hasGlobMeta(s string) bool {
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '*', '?', '[':
+			return true
+		}
+	}
+	return false
+}
+
+// unescapeGlob часть шаблона без метасимволов как имя файла
+func unescapeGlob(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestExpand(t *testing.T) {
+	sh := testShell(t)
+	for _, name := range []string{"a.txt", "b.txt", "c.go", ".hidden.txt", "sub/x.txt", "sub/y.go", "*.txt"} {
+		path := filepath.Join(sh.dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sh.setVar("HOME", "/home/test")
+	sh.setVar("X", "1")
+	sh.setVar("LIST", " a  b\tc ")
+	sh.setVar("EMPTY", "")
+	sh.setVar("STAR", "*.go")
+	sh.status = 3
+
+	tests := []struct {
+		line string
+		out  string
+	}{
+		{`echo $X ${X}y $Xy "$X"`, "1 1y 1\n"},
+		{`echo '$X' \$X "\$X" $ "a$"`, "$X $X $X $ a$\n"},
+		{`echo $?`, "3\n"},
+		{`echo ${NOPE:-def} ${EMPTY:-def} "${EMPTY-def}" ${X:-def}`, "def def  1\n"},
+		{`echo ${X:+set} ${EMPTY:+set} ${NOPE+set}.`, "set .\n"},
+		{`echo ${NOPE:-$X and $X}`, "1 and 1\n"},
+		{`echo ${NEW:=v}; echo $NEW`, "v\nv\n"},
+		{`echo ${NOPE:-${X}y} "${NOPE:-${EMPTY:-in}}"`, "1y in\n"},
+		{`echo ${NOPE:-"}"} ${NOPE:-'a}b'} ${NOPE:-$(echo })} ${NOPE:-{x}}`, "} a}b } {x}\n"},
+		{`printf '<%s>' $LIST; echo`, "<a><b><c>\n"},
+		{`printf '<%s>' "$LIST"; echo`, "< a  b\tc >\n"},
+		{`printf '<%s>' $EMPTY "$EMPTY" ''; echo`, "<><>\n"},
+		{`echo ~ ~/x "~" \~ a~ ~nosuchuser`, "/home/test /home/test/x ~ ~ a~ ~nosuchuser\n"},
+		{`echo $(echo hi) "$(printf 'a\n\n')" x$(echo 1; echo 2)y`, "hi a x1 2y\n"},
+		{`echo $(echo $(echo nested))`, "nested\n"},
+		{`echo *.txt`, "*.txt a.txt b.txt\n"},
+		{`echo ?.go [ab].txt sub/*`, "c.go a.txt b.txt sub/x.txt sub/y.go\n"},
+		{`echo */*.go ./c*`, "sub/y.go ./c.go\n"},
+		{`echo .*.txt`, ".hidden.txt\n"},
+		{`echo "*.go" '*.go' \*.go`, "*.go *.go *.go\n"},
+		{`echo $STAR "$STAR"`, "c.go *.go\n"},
+		{`echo *.none`, "*.none\n"},
+		{`echo "\*.txt"`, "\\*.txt\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			out, errOut, status := runLine(t, sh.clone(), tt.line)
+			if out != tt.out || status != 0 {
+				t.Errorf("got %q (status %v, stderr %q), want %q", out, status, errOut, tt.out)
+			}
+		})
+	}
+
+	if out, _, _ := runLine(t, sh, "echo $$"); out != strconv.Itoa(os.Getpid())+"\n" {
+		t.Errorf("$$: %q", out)
+	}
+	if _, errOut, status := runLine(t, sh, "echo ${X%y}"); status != 1 || !strings.Contains(errOut, "bad substitution") {
+		t.Errorf("bad substitution: status %v, stderr %q", status, errOut)
+	}
+	// Аргументов у шелла нет: $1 пустой, $0 - имя шелла
+	positional := []struct{ line, out string }{
+		{`echo "costs $5"`, "costs \n"},
+		{`echo "$0"`, "shell\n"},
+		{"echo ${1:-x} ${0}", "x shell\n"},
+		{"echo a${10}b $12", "ab 2\n"},
+	}
+	for _, tt := range positional {
+		if out, errOut, status := runLine(t, sh, tt.line); out != tt.out || status != 0 {
+			t.Errorf("%s: out %q, status %v, stderr %q", tt.line, out, status, errOut)
+		}
+	}
+	if _, errOut, status := runLine(t, sh, "echo ${1=x}"); status != 1 || !strings.Contains(errOut, "cannot assign") {
+		t.Errorf("${1=x}: status %v, stderr %q", status, errOut)
+	}
+}
+
+// TestAssignments присваивание без команды задает переменную шелла, перед командой - окружение только для нее
+func TestAssignments(t *testing.T) {
+	sh := testShell(t)
+	sh.setVar("HOME", "/home/test")
+
+	tests := []struct {
+		line   string
+		out    string
+		status int
+	}{
+		{`A=1 B="x y"; echo $A $B; sh -c 'echo "[$A]"'`, "1 x y\n[]\n", 0},
+		{`A=2 sh -c 'echo $A'; echo $A`, "2\n1\n", 0},
+		{`C=3 sh -c 'echo $C'; echo "[$C]"`, "3\n[]\n", 0},
+		{`export A; A=4; sh -c 'echo $A'`, "4\n", 0},
+		{`D=~/x E=$A$A; echo $D $E`, "/home/test/x 44\n", 0},
+		{`F=$(exit 5)`, "", 5},
+		{`F=$(echo ok); echo $F`, "ok\n", 0},
+		{`'G=1'`, "", 127},
+	}
+	for _, tt := range tests {
+		out, errOut, status := runLine(t, sh, tt.line)
+		if out != tt.out || status != tt.status {
+			t.Errorf("%s: got %q (status %v, stderr %q), want %q (status %v)", tt.line, out, status, errOut, tt.out, tt.status)
+		}
+	}
+}
+
+func TestExpandRedirects(t *testing.T) {
+	sh := testShell(t)
+	sh.setVar("NAME", "out.txt")
+	sh.setVar("TWO", "a b")
+
+	if _, errOut, status := runLine(t, sh, `echo hi > $NAME; cat "$NAME"`); errOut != "" || status != 0 {
+		t.Fatalf("redirect to variable: status %v, stderr %q", status, errOut)
+	}
+	if data, err := os.ReadFile(filepath.Join(sh.dir, "out.txt")); err != nil || string(data) != "hi\n" {
+		t.Errorf("out.txt: %q, %v", data, err)
+	}
+	if _, errOut, status := runLine(t, sh, "echo hi > $TWO"); status != 1 || !strings.Contains(errOut, "$TWO: ambiguous redirect") {
+		t.Errorf("ambiguous redirect: status %v, stderr %q", status, errOut)
+	}
+	if out, _, _ := runLine(t, sh, "cat < *.txt"); out != "hi\n" {
+		t.Errorf("glob in redirect: %q", out)
+	}
+
+	// В тексте << подстановки выполняются, если ограничитель без кавычек
+	out, _, _ := runLine(t, sh, "cat <<EOF\n$NAME \\$NAME $(echo sub) '$TWO' \\a\nEOF")
+	if out != "out.txt $NAME sub 'a b' \\a\n" {
+		t.Errorf("heredoc: %q", out)
+	}
+	out, _, _ = runLine(t, sh, "cat <<'EOF'\n$NAME $(echo sub)\nEOF")
+	if out != "$NAME $(echo sub)\n" {
+		t.Errorf("quoted heredoc: %q", out)
+	}
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// errIncomplete ввод оборвался посреди конструкции: незакрытая кавычка, скобка
+// или оператор без второй команды. Шелл в этом случае дочитывает следующую строку
+var errIncomplete = errors.New("syntax error: unexpected end of file")
+
+// quoting как записана часть слова, от этого зависят подстановки
+type quoting int
+
+const (
+	unquoted quoting = iota
+	doubleQuoted
+	// singleQuoted текст в одинарных кавычках и экранированные символы: берется как есть
+	singleQuoted
+)
+
+// wordPart часть слова с одним способом записи
+type wordPart struct {
+	text    string
+	quoting quoting
+}
+
+// word слово команды: "a"'b'c - одно слово из трех частей
+type word []wordPart
+
+// literal значение слова без подстановок
+func (w word) literal() string {
+	var b strings.Builder
+	for _, p := range w {
+		b.WriteString(p.text)
+	}
+	return b.String()
+}
+
+// tokenKind вид лексемы
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokWord
+	tokOp
+	tokRedirect
+)
+
+// token лексема: слово, оператор (|, ||, &&, ;, (, ), перевод строки)
+// или перенаправление (<, >, >>, >&, <<) с необязательным номером дескриптора
+type token struct {
+	kind tokenKind
+	op   string
+	// fd номер дескриптора перед перенаправлением, -1 - по умолчанию
+	fd   int
+	word word
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of file"
+	case tokWord:
+		return t.word.literal()
+	}
+	if t.op == "\n" {
+		return "newline"
+	}
+	return t.op
+}
+
+// lexer разбивает строку на лексемы
+type lexer struct {
+	src string
+	pos int
+	// heredocs перенаправления <<, чьи тексты начнутся со следующей строки
+	heredocs []*redirect
+}
+
+// operators операторы от длинных к коротким
+var operators = []string{"&&", "||", "|", ";", "(", ")", "\n"}
+
+// redirectOps операторы перенаправления от длинных к коротким
+var redirectOps = []string{">>", ">&", "<<", ">", "<"}
+
+// isMeta символ завершает слово, если не экранирован
+func isMeta(c byte) bool {
+	return strings.IndexByte(" \t\n|&;()<>", c) != -1
+}
+
+// next следующая лексема. Комментарий от # в начале слова до конца строки пропускается
+func (l *lexer) next() (token, error) {
+	for l.pos < len(l.src) {
+		switch c := l.src[l.pos]; {
+		case c == ' ' || c == '\t':
+			l.pos++
+		case c == '\\' && strings.HasPrefix(l.src[l.pos:], "\\\n"):
+			l.pos += 2
+		case c == '#':
+			for l.pos < len(l.src) && l.src[l.pos] != '\n' {
+				l.pos++
+			}
+		default:
+			return l.token()
+		}
+	}
+	if len(l.heredocs) > 0 {
+		return token{}, errIncomplete
+	}
+	return token{kind: tokEOF}, nil
+}
+
+func (l *lexer) token() (token, error) {
+	rest := l.src[l.pos:]
+	for _, op := range operators {
+		if strings.HasPrefix(rest, op) {
+			l.pos += len(op)
+			if op == "\n" {
+				if err := l.readHeredocs(); err != nil {
+					return token{}, err
+				}
+			}
+			return token{kind: tokOp, op: op}, nil
+		}
+	}
+
+	// Цифры вплотную перед < или > - номер дескриптора: 2>err.log
+	digits := len(rest) - len(strings.TrimLeft(rest, "0123456789"))
+	for _, op := range redirectOps {
+		if strings.HasPrefix(rest[digits:], op) {
+			fd := -1
+			if digits > 0 {
+				n, err := strconv.Atoi(rest[:digits])
+				if err != nil {
+					return token{}, fmt.Errorf("%s: bad file descriptor", rest[:digits])
+				}
+				fd = n
+			}
+			l.pos += digits + len(op)
+			return token{kind: tokRedirect, op: op, fd: fd}, nil
+		}
+	}
+
+	if rest[0] == '&' {
+		return token{}, fmt.Errorf("background jobs are not supported")
+	}
+
+	w, err := l.word()
+	if err != nil {
+		return token{}, err
+	}
+	return token{kind: tokWord, word: w}, nil
+}
+
+// word читает слово до пробела или оператора. Соседние части с одинаковой записью склеиваются
+func (l *lexer) word() (word, error) {
+	return l.wordUntil(isMeta)
+}
+
+// wordUntil читает слово до неэкранированного символа, для которого stop возвращает true
+func (l *lexer) wordUntil(stop func(byte) bool) (word, error) {
+	var w word
+	add := func(text string, q quoting) {
+		if n := len(w); n > 0 && w[n-1].quoting == q {
+			w[n-1].text += text
+			return
+		}
+		w = append(w, wordPart{text: text, quoting: q})
+	}
+
+	for l.pos < len(l.src) && !stop(l.src[l.pos]) {
+		c := l.src[l.pos]
+		switch c {
+		case '\\':
+			if l.pos+1 == len(l.src) {
+				return nil, errIncomplete
+			}
+			if l.src[l.pos+1] != '\n' {
+				add(l.src[l.pos+1:l.pos+2], singleQuoted)
+			}
+			l.pos += 2
+		case '\'':
+			end := strings.IndexByte(l.src[l.pos+1:], '\'')
+			if end == -1 {
+				return nil, errIncomplete
+			}
+			add(l.src[l.pos+1:l.pos+1+end], singleQuoted)
+			l.pos += end + 2
+		case '"':
+			if err := l.doubleQuoted(add); err != nil {
+				return nil, err
+			}
+		case '$':
+			text, err := l.dollar()
+			if err != nil {
+				return nil, err
+			}
+			add(text, unquoted)
+		default:
+			add(string(c), unquoted)
+			l.pos++
+		}
+	}
+	return w, nil
+}
+
+// doubleQuoted читает строку в двойных кавычках: обратная косая экранирует только $ ` " \ и перевод строки
+func (l *lexer) doubleQuoted(add func(text string, q quoting)) error {
+	l.pos++
+	// Пустые кавычки - тоже слово: "" дает пустой аргумент
+	add("", doubleQuoted)
+	for {
+		if l.pos >= len(l.src) {
+			return errIncomplete
+		}
+		c := l.src[l.pos]
+		switch {
+		case c == '"':
+			l.pos++
+			return nil
+		case c == '\\' && l.pos+1 < len(l.src) && strings.IndexByte("$`\"\\\n", l.src[l.pos+1]) != -1:
+			if l.src[l.pos+1] != '\n' {
+				add(l.src[l.pos+1:l.pos+2], singleQuoted)
+			}
+			l.pos += 2
+		case c == '$':
+			text, err := l.dollar()
+			if err != nil {
+				return err
+			}
+			add(text, doubleQuoted)
+		default:
+			add(string(c), doubleQuoted)
+			l.pos++
+		}
+	}
+}
+
+// dollar читает $ с тем, что к нему относится: $(команда) и ${...} целиком, до парной скобки.
+// Сами подстановки выполняются при запуске команды
+func (l *lexer) dollar() (string, error) {
+	start := l.pos
+	rest := l.src[l.pos:]
+	switch {
+	case strings.HasPrefix(rest, "$("):
+		l.pos += 2
+		if err := l.skipBalanced(')'); err != nil {
+			return "", err
+		}
+	case strings.HasPrefix(rest, "${"):
+		l.pos += 2
+		if err := l.skipBalanced('}'); err != nil {
+			return "", err
+		}
+	default:
+		l.pos++
+	}
+	return l.src[start:l.pos], nil
+}
+
+// readHeredocs читает тексты ожидающих перенаправлений << со следующей строки:
+// каждый до строки, равной своему ограничителю
+func (l *lexer) readHeredocs() error {
+	for len(l.heredocs) > 0 {
+		r := l.heredocs[0]
+		var body strings.Builder
+		for {
+			if l.pos >= len(l.src) {
+				return errIncomplete
+			}
+			line := l.src[l.pos:]
+			next := len(l.src)
+			if end := strings.IndexByte(line, '\n'); end != -1 {
+				line, next = line[:end], l.pos+end+1
+			}
+			l.pos = next
+			if line == r.delimiter {
+				break
+			}
+			body.WriteString(line + "\n")
+		}
+		r.heredoc = body.String()
+		l.heredocs = l.heredocs[1:]
+	}
+	return nil
+}
+
+// skipBalanced пропускает текст до закрывающей скобки close, ) или }, с учетом вложенных
+// скобок, кавычек и подстановок $(...) и ${...} внутри
+func (l *lexer) skipBalanced(close byte) error {
+	open := byte('(')
+	if close == '}' {
+		open = '{'
+	}
+	depth := 1
+	for l.pos < len(l.src) {
+		switch c := l.src[l.pos]; c {
+		case '\\':
+			l.pos++
+		case '\'', '"':
+			end := strings.IndexByte(l.src[l.pos+1:], c)
+			if end == -1 {
+				return errIncomplete
+			}
+			l.pos += end + 1
+		case '$':
+			if l.pos+1 < len(l.src) && (l.src[l.pos+1] == '(' || l.src[l.pos+1] == '{') {
+				inner := byte(')')
+				if l.src[l.pos+1] == '{' {
+					inner = '}'
+				}
+				l.pos += 2
+				if err := l.skipBalanced(inner); err != nil {
+					return err
+				}
+				continue
+			}
+		case open:
+			depth++
+		case close:
+			depth--
+			if depth == 0 {
+				l.pos++
+				return nil
+			}
+		}
+		l.pos++
+	}
+	return errIncomplete
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Грамматика разбора:
+//	list     = andOr { (";" | "\n") andOr }
+//	andOr    = pipeline { ("&&" | "||") pipeline }
+//	pipeline = command { "|" command }
+//	command  = "(" list ")" { redirect } | ( word | redirect ) { word | redirect }
+//	redirect = [ fd ] ( "<" | ">" | ">>" | ">&" | "<<" ) word
+// После |, && и || допускается перевод строки. Пустые строки пропускаются, ; - только после команды
+
+// list последовательность команд через ; или перевод строки
+type list struct {
+	items []*andOr
+}
+
+// andOr конвейеры через && и ||: следующий выполняется в зависимости от кода предыдущего
+type andOr struct {
+	first *pipeline
+	rest  []andOrItem
+}
+
+type andOrItem struct {
+	op       string
+	pipeline *pipeline
+}
+
+// pipeline команды, соединенные |
+type pipeline struct {
+	commands []command
+}
+
+// command команда конвейера: simpleCommand или subshell
+type command interface {
+	String() string
+}
+
+// simpleCommand имя команды, аргументы и перенаправления
+type simpleCommand struct {
+	words     []word
+	redirects []*redirect
+}
+
+// subshell список команд в скобках, выполняется с копией состояния шелла
+type subshell struct {
+	body      *list
+	redirects []*redirect
+}
+
+// redirect перенаправление дескриптора fd: в файл target, в другой дескриптор (>&)
+// или на текст heredoc (<<). Перенаправления применяются слева направо
+type redirect struct {
+	fd     int
+	op     string
+	target word
+	// delimiter и heredoc ограничитель и текст <<. В тексте при ограничителе
+	// в кавычках подстановки не выполняются
+	delimiter     string
+	heredoc       string
+	quotedHeredoc bool
+}
+
+func (l *list) String() string {
+	parts := make([]string, len(l.items))
+	for i, item := range l.items {
+		parts[i] = item.String()
+	}
+	return strings.Join(parts, "; ")
+}
+
+func (a *andOr) String() string {
+	var b strings.Builder
+	b.WriteString(a.first.String())
+	for _, item := range a.rest {
+		fmt.Fprintf(&b, " %s %s", item.op, item.pipeline)
+	}
+	return b.String()
+}
+
+func (p *pipeline) String() string {
+	parts := make([]string, len(p.commands))
+	for i, c := range p.commands {
+		parts[i] = c.String()
+	}
+	return strings.Join(parts, " | ")
+}
+
+// String слова в кавычках Go, чтобы в тестах были видны границы слов
+func (c *simpleCommand) String() string {
+	parts := make([]string, 0, len(c.words)+len(c.redirects))
+	for _, w := range c.words {
+		parts = append(parts, strconv.Quote(w.literal()))
+	}
+	for _, r := range c.redirects {
+		parts = append(parts, r.String())
+	}
+	return strings.Join(parts, " ")
+}
+
+func (s *subshell) String() string {
+	res := "(" + s.body.String() + ")"
+	for _, r := range s.redirects {
+		res += " " + r.String()
+	}
+	return res
+}
+
+func (r *redirect) String() string {
+	fd := ""
+	if r.fd != -1 {
+		fd = strconv.Itoa(r.fd)
+	}
+	switch r.op {
+	case ">&":
+		return fd + ">&" + r.target.literal()
+	case "<<":
+		return fmt.Sprintf("%s<<%q", fd, r.heredoc)
+	}
+	return fmt.Sprintf("%s%s %q", fd, r.op, r.target.literal())
+}
+
+// defaultFD дескриптор перенаправления без номера: 0 для ввода, 1 для вывода
+func (r *redirect) defaultFD() int {
+	if r.fd != -1 {
+		return r.fd
+	}
+	if r.op == "<" || r.op == "<<" {
+		return 0
+	}
+	return 1
+}
+
+// parser разбор лексем с просмотром на одну вперед
+type parser struct {
+	lex  *lexer
+	tok  token
+	err  error
+	read bool
+}
+
+// parse разбирает исходный текст в список команд. Пустой текст и комментарии дают пустой список
+func parse(src string) (*list, error) {
+	p := &parser{lex: &lexer{src: src}}
+	l, err := p.list(false)
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, p.unexpected(t)
+	}
+	if p.err != nil {
+		return nil, p.err
+	}
+	return l, nil
+}
+
+// peek текущая лексема без продвижения. Ошибка лексера превращается в EOF и запоминается
+func (p *parser) peek() token {
+	if !p.read {
+		p.tok, p.err = p.lex.next()
+		if p.err != nil {
+			p.tok = token{kind: tokEOF}
+		}
+		p.read = true
+	}
+	return p.tok
+}
+
+func (p *parser) advance() token {
+	t := p.peek()
+	p.read = false
+	return t
+}
+
+// isOp текущая лексема - оператор op
+func (p *parser) isOp(op string) bool {
+	t := p.peek()
+	return t.kind == tokOp && t.op == op
+}
+
+// skipNewlines пропускает переводы строк
+func (p *parser) skipNewlines() {
+	for p.isOp("\n") {
+		p.advance()
+	}
+}
+
+// unexpected ошибка о лексеме t. На конце ввода - errIncomplete, чтобы шелл дочитал строку
+func (p *parser) unexpected(t token) error {
+	if p.err != nil {
+		return p.err
+	}
+	if t.kind == tokEOF {
+		return errIncomplete
+	}
+	return fmt.Errorf("syntax error near unexpected token `%v'", t)
+}
+
+// list разбирает команды до конца ввода, а внутри скобок - до )
+func (p *parser) list(inSubshell bool) (*list, error) {
+	l := &list{}
+	// afterCommand ; допустима только сразу после команды, пустые строки - где угодно
+	afterCommand := false
+	for {
+		for p.isOp(";") || p.isOp("\n") {
+			t := p.advance()
+			if t.op == ";" && !afterCommand {
+				return nil, p.unexpected(t)
+			}
+			afterCommand = false
+		}
+		t := p.peek()
+		if t.kind == tokEOF || (inSubshell && p.isOp(")")) {
+			return l, nil
+		}
+
+		item, err := p.andOr()
+		if err != nil {
+			return nil, err
+		}
+		l.items = append(l.items, item)
+		afterCommand = true
+
+		if t := p.peek(); t.kind != tokEOF && !p.isOp(";") && !p.isOp("\n") && !(inSubshell && p.isOp(")")) {
+			return nil, p.unexpected(t)
+		}
+	}
+}
+
+func (p *parser) andOr() (*andOr, error) {
+	first, err := p.pipeline()
+	if err != nil {
+		return nil, err
+	}
+	a := &andOr{first: first}
+	for p.isOp("&&") || p.isOp("||") {
+		op := p.advance().op
+		p.skipNewlines()
+		next, err := p.pipeline()
+		if err != nil {
+			return nil, err
+		}
+		a.rest = append(a.rest, andOrItem{op: op, pipeline: next})
+	}
+	return a, nil
+}
+
+func (p *parser) pipeline() (*pipeline, error) {
+	pl := &pipeline{}
+	for {
+		c, err := p.command()
+		if err != nil {
+			return nil, err
+		}
+		pl.commands = append(pl.commands, c)
+		if !p.isOp("|") {
+			return pl, nil
+		}
+		p.advance()
+		p.skipNewlines()
+	}
+}
+
+func (p *parser) command() (command, error) {
+	if p.isOp("(") {
+		p.advance()
+		body, err := p.list(true)
+		if err != nil {
+			return nil, err
+		}
+		if !p.isOp(")") {
+			return nil, p.unexpected(p.peek())
+		}
+		p.advance()
+		if len(body.items) == 0 {
+			return nil, fmt.Errorf("syntax error near unexpected token `)'")
+		}
+		sub := &subshell{body: body}
+		for p.peek().kind == tokRedirect {
+			r, err := p.redirect()
+			if err != nil {
+				return nil, err
+			}
+			sub.redirects = append(sub.redirects, r)
+		}
+		return sub, nil
+	}
+
+	c := &simpleCommand{}
+	for kind := p.peek().kind; kind == tokWord || kind == tokRedirect; kind = p.peek().kind {
+		if kind == tokWord {
+			c.words = append(c.words, p.advance().word)
+			continue
+		}
+		r, err := p.redirect()
+		if err != nil {
+			return nil, err
+		}
+		c.redirects = append(c.redirects, r)
+	}
+	if len(c.words) == 0 && len(c.redirects) == 0 {
+		return nil, p.unexpected(p.peek())
+	}
+	return c, nil
+}
+
+// redirect разбирает перенаправление. Текст << лексер прочитает после конца строки
+func (p *parser) redirect() (*redirect, error) {
+	t := p.advance()
+	r := &redirect{fd: t.fd, op: t.op}
+	target := p.advance()
+	if target.kind != tokWord {
+		return nil, p.unexpected(target)
+	}
+	r.target = target.word
+
+	if r.op == "<<" {
+		r.delimiter = target.word.literal()
+		for _, part := range target.word {
+			if part.quoting != unquoted {
+				r.quotedHeredoc = true
+			}
+		}
+		p.lex.heredocs = append(p.lex.heredocs, r)
+	}
+	return r, nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"words", "ls -l /tmp", `"ls" "-l" "/tmp"`},
+		{"extra spaces", "  ls \t -l   ", `"ls" "-l"`},
+		{"double quotes", `echo "hello world"`, `"echo" "hello world"`},
+		{"single quotes", `echo 'a "b" \c'`, `"echo" "a \"b\" \\c"`},
+		{"adjacent quotes", `echo a"b c"'d'e`, `"echo" "ab cde"`},
+		{"empty quotes", `echo "" ''`, `"echo" "" ""`},
+		{"escaped space", `echo a\ b`, `"echo" "a b"`},
+		{"escaped quote", `echo \"x\'`, `"echo" "\"x'"`},
+		{"escapes in double quotes", `echo "\$ \" \\ \n"`, `"echo" "$ \" \\ \\n"`},
+		{"line continuation", "echo a\\\nb", `"echo" "ab"`},
+		{"operators without spaces", "a|b&&c||d;e", `"a" | "b" && "c" || "d"; "e"`},
+		{"quoted operators", `echo "a|b" 'c;d' e\&f`, `"echo" "a|b" "c;d" "e&f"`},
+		{"comment", "ls # list files", `"ls"`},
+		{"hash inside word", "echo a#b", `"echo" "a#b"`},
+		{"only comment", "# nothing", ``},
+		{"newlines", "a\n\nb\n", `"a"; "b"`},
+		{"trailing semicolon", "a; b;", `"a"; "b"`},
+		{"newline after operator", "a &&\n b |\n c", `"a" && "b" | "c"`},
+		{"subshell", "(cd /tmp; ls) | wc -l", `("cd" "/tmp"; "ls") | "wc" "-l"`},
+		{"nested subshell", "((a) || b) && c", `(("a") || "b") && "c"`},
+		{"redirects", "sort <in >out 2>>err.log", `"sort" < "in" > "out" 2>> "err.log"`},
+		{"redirects without spaces", "ls>out 2>&1", `"ls" > "out" 2>&1`},
+		{"fd only at word start", "echo a2>f", `"echo" "a2" > "f"`},
+		{"quoted redirect", `echo ">" '2>&1'`, `"echo" ">" "2>&1"`},
+		{"only redirect", "> empty", `> "empty"`},
+		{"subshell redirect", "(ls; pwd) > out", `("ls"; "pwd") > "out"`},
+		{"heredoc", "cat <<EOF\nhello\n  world\nEOF\n", `"cat" <<"hello\n  world\n"`},
+		{"heredoc then command", "cat <<EOF | wc -l; echo done\na\nEOF\nls", `"cat" <<"a\n" | "wc" "-l"; "echo" "done"; "ls"`},
+		{"two heredocs", "cat <<A; cat <<B\n1\nA\n2\nB", `"cat" <<"1\n"; "cat" <<"2\n"`},
+		{"heredoc at end of input", "cat <<EOF\nx\nEOF", `"cat" <<"x\n"`},
+		{"command substitution", `echo $(echo ")" (x)) "${HOME}"`, `"echo" "$(echo \")\" (x))" "${HOME}"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, err := parse(tt.src)
+			if err != nil {
+				t.Fatalf("parse(%q): %v", tt.src, err)
+			}
+			if got := l.String(); got != tt.want {
+				t.Errorf("parse(%q) = %s, want %s", tt.src, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"leading pipe", "| ls", "unexpected token `|'"},
+		{"double pipe operator", "ls | | wc", "unexpected token `|'"},
+		{"leading semicolon", "; ls", "unexpected token `;'"},
+		{"double semicolon", "ls;; pwd", "unexpected token `;'"},
+		{"unmatched paren", "ls )", "unexpected token `)'"},
+		{"empty subshell", "( )", "unexpected token `)'"},
+		{"word after subshell", "(ls) wc", "unexpected token `wc'"},
+		{"background", "sleep 1 &", "background jobs"},
+		{"redirect without file", "ls > | wc", "unexpected token `|'"},
+		{"redirect to redirect", "ls > > out", "unexpected token `>'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parse(tt.src)
+			if err == nil || errors.Is(err, errIncomplete) || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("parse(%q) error %v, want %q", tt.src, err, tt.want)
+			}
+		})
+	}
+}
+
+// TestParseIncomplete незаконченный ввод шелл дочитывает со следующей строки
+func TestParseIncomplete(t *testing.T) {
+	for _, src := range []string{
+		`echo "abc`, `echo 'abc`, `echo abc\`, "ls |", "ls &&", "true ||\n", "(ls", "(ls; (pwd)", "echo $(ls", "echo ${HOME",
+		"ls >", "cat <<EOF", "cat <<EOF\n", "cat <<EOF\nhello\n", "cat <<EOF\nEOFX",
+	} {
+		if _, err := parse(src); !errors.Is(err, errIncomplete) {
+			t.Errorf("parse(%q) error %v, want incomplete input", src, err)
+		}
+	}
+}
+
+// TestWordQuoting подстановки потом выполняются только в частях без одинарных кавычек
+func TestWordQuoting(t *testing.T) {
+	l, err := parse(`echo a'$b'"$c"\$d`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := l.items[0].first.commands[0].(*simpleCommand).words[1]
+	want := word{{"a", unquoted}, {"$b", singleQuoted}, {"$c", doubleQuoted}, {"$", singleQuoted}, {"d", unquoted}}
+	if len(w) != len(want) {
+		t.Fatalf("got parts %+v, want %+v", w, want)
+	}
+	for i := range w {
+		if w[i] != want[i] {
+			t.Errorf("part %d: got %+v, want %+v", i, w[i], want[i])
+		}
+	}
+}
+
+// TestHeredocQuoting с ограничителем в кавычках подстановки в тексте не выполняются
+func TestHeredocQuoting(t *testing.T) {
+	for src, quoted := range map[string]bool{"cat <<EOF\n$x\nEOF": false, "cat <<'EOF'\n$x\nEOF": true, "cat <<\"E\"OF\n$x\nEOF": true} {
+		l, err := parse(src)
+		if err != nil {
+			t.Fatalf("parse(%q): %v", src, err)
+		}
+		r := l.items[0].first.commands[0].(*simpleCommand).redirects[0]
+		if r.quotedHeredoc != quoted || r.heredoc != "$x\n" {
+			t.Errorf("parse(%q): quoted %v, text %q", src, r.quotedHeredoc, r.heredoc)
+		}
+	}
+}
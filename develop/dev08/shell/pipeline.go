@@ -0,0 +1,229 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// stdio потоки ввода-вывода команды
+type stdio struct {
+	in  io.Reader
+	out io.Writer
+	err io.Writer
+}
+
+// runPipeline запускает команды конвейера одновременно, stdout каждой соединяется
+// со stdin следующей через os.Pipe. Возвращает код завершения последней команды.
+// Встроенная команда и подоболочка в конвейере из нескольких команд работают с копией шелла,
+// поэтому cd или exit в них не меняют сам шелл
+func (sh *shell) runPipeline(pl *pipeline, std stdio) int {
+	if len(pl.commands) == 1 {
+		return sh.runCommand(pl.commands[0], std)
+	}
+
+	// stderr общий у всех команд, для exec.Cmd не файл копируется своей горутиной на каждую команду
+	if _, ok := std.err.(*os.File); !ok {
+		std.err = &lockedWriter{w: std.err}
+	}
+
+	statuses := make([]int, len(pl.commands))
+	var wg sync.WaitGroup
+	in := std.in
+	for i, c := range pl.commands {
+		cmdStd := stdio{in: in, out: std.out, err: std.err}
+
+		// Концы труб, которые принадлежат этой команде: шелл закрывает свои копии,
+		// когда команда завершится, иначе соседи не увидят конец потока
+		var own []*os.File
+		if i > 0 {
+			own = append(own, in.(*os.File))
+		}
+		if i < len(pl.commands)-1 {
+			r, w, err := os.Pipe()
+			if err != nil {
+				fmt.Fprintf(std.err, "shell: %v\n", err)
+				closeFiles(own)
+				wg.Wait()
+				return 1
+			}
+			cmdStd.out, in = w, r
+			own = append(own, w)
+		}
+
+		wait := sh.start(c, cmdStd)
+		wg.Add(1)
+		go func(i int, own []*os.File) {
+			defer wg.Done()
+			statuses[i] = wait()
+			closeFiles(own)
+		}(i, own)
+	}
+	wg.Wait()
+
+	return statuses[len(pl.commands)-1]
+}
+
+// runCommand выполняет команду и дожидается ее: встроенную - в самом шелле,
+// подоболочку - с копией шелла, внешнюю - отдельным процессом.
+// Подстановки в словах выполняются до перенаправлений, перенаправления действуют только на эту команду
+func (sh *shell) runCommand(c command, std stdio) int {
+	var redirects []*redirect
+	var assigns []assignment
+	var args []string
+	e := sh.expander(std)
+	switch c := c.(type) {
+	case *subshell:
+		redirects = c.redirects
+	case *simpleCommand:
+		redirects = c.redirects
+		var err error
+		if assigns, args, err = e.command(c); err != nil {
+			fmt.Fprintf(std.err, "shell: %v\n", err)
+			return 1
+		}
+	}
+	cmdStd, files, err := sh.redirect(redirects, std)
+	if err != nil {
+		fmt.Fprintf(std.err, "shell: %v\n", err)
+		return 1
+	}
+	defer closeFiles(files)
+
+	switch c := c.(type) {
+	case *subshell:
+		return sh.clone().run(c.body, cmdStd)
+	case *simpleCommand:
+		// Команда из одних присваиваний задает переменные шелла, из одних перенаправлений -
+		// только создает или проверяет файлы
+		if len(args) == 0 {
+			for _, a := range assigns {
+				sh.setVar(a.name, a.value)
+			}
+			return e.status
+		}
+		// Присваивания перед командой экспортируются только для нее
+		if len(assigns) > 0 {
+			defer sh.restoreVars(sh.saveVars(assigns))
+			for _, a := range assigns {
+				sh.vars[a.name] = variable{value: a.value, exported: true}
+			}
+		}
+		if value, ok := sh.aliases[args[0]]; ok && !sh.expanding[args[0]] && plainWord(c.words[len(assigns)]) {
+			return sh.runAlias(args, value, cmdStd)
+		}
+		return sh.exec(args, cmdStd)
+	}
+	panic(fmt.Sprintf("unknown command %T", c))
+}
+
+// plainWord слово без кавычек и подстановок: только такое имя команды раскрывается как псевдоним,
+// \ls или 'ls' запускает саму команду
+func plainWord(w word) bool {
+	return len(w) == 1 && w[0].quoting == unquoted && !strings.ContainsAny(w[0].text, "$~*?[")
+}
+
+// exec выполняет встроенную команду или внешнюю, без псевдонимов
+func (sh *shell) exec(args []string, std stdio) int {
+	if fn, ok := builtins[args[0]]; ok {
+		return fn(sh, args, std)
+	}
+	cmd, status := sh.startProcess(args, std)
+	if cmd == nil {
+		return status
+	}
+	return exitStatus(cmd.Wait())
+}
+
+// runAlias подставляет текст псевдонима вместо имени команды и выполняет результат.
+// Текст разбирается заново, поэтому псевдоним может содержать конвейер или список
+func (sh *shell) runAlias(args []string, value string, std stdio) int {
+	src := value
+	for _, arg := range args[1:] {
+		src += " " + shellQuote(arg)
+	}
+	prog, err := parse(src)
+	if err != nil {
+		fmt.Fprintf(std.err, "shell: %s: %v\n", args[0], err)
+		return 2
+	}
+
+	sh.expanding[args[0]] = true
+	defer delete(sh.expanding, args[0])
+	return sh.run(prog, std)
+}
+
+// start запускает команду конвейера в горутине с копией шелла
+// и возвращает ожидание ее кода завершения
+func (sh *shell) start(c command, std stdio) func() int {
+	done := make(chan int, 1)
+	sub := sh.clone()
+	go func() { done <- sub.runCommand(c, std) }()
+	return func() int { return <-done }
+}
+
+// startProcess запускает внешнюю команду в каталоге шелла с экспортированными переменными.
+// Команда ищется по PATH шелла. Если запустить не удалось, сообщает об ошибке
+// и возвращает код завершения: 127 - команда не найдена, 126 - не запускается
+func (sh *shell) startProcess(args []string, std stdio) (*exec.Cmd, int) {
+	path, ok := sh.lookPath(args[0])
+	if !ok {
+		if !strings.Contains(args[0], "/") {
+			fmt.Fprintf(std.err, "shell: %s: command not found\n", args[0])
+			return nil, 127
+		}
+		if _, err := os.Stat(path); err != nil {
+			fmt.Fprintf(std.err, "shell: %s: no such file or directory\n", args[0])
+			return nil, 127
+		}
+		fmt.Fprintf(std.err, "shell: %s: permission denied\n", args[0])
+		return nil, 126
+	}
+
+	cmd := &exec.Cmd{Path: path, Args: args, Env: sh.environ(), Dir: sh.dir}
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = std.in, std.out, std.err
+	if err := cmd.Start(); err != nil {
+		fmt.Fprintf(std.err, "shell: %s: %v\n", args[0], err)
+		return nil, 126
+	}
+	return cmd, 0
+}
+
+// exitStatus код завершения по ошибке Wait. Процесс, убитый сигналом, завершается с 128+N
+func exitStatus(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return 1
+	}
+	if ws, ok := exitErr.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		return 128 + int(ws.Signal())
+	}
+	return exitErr.ExitCode()
+}
+
+// lockedWriter writer, в который можно писать из нескольких горутин
+type lockedWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (lw *lockedWriter) Write(p []byte) (int, error) {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	return lw.w.Write(p)
+}
+
+// closeFiles закрывает файлы команды: концы труб и перенаправления
+func closeFiles(files []*os.File) {
+	for _, f := range files {
+		f.Close()
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// signals сигналы, которые kill понимает по имени, в порядке номеров
+var signals = []struct {
+	name string
+	sig  syscall.Signal
+}{
+	{"HUP", syscall.SIGHUP}, {"INT", syscall.SIGINT}, {"QUIT", syscall.SIGQUIT},
+	{"ILL", syscall.SIGILL}, {"TRAP", syscall.SIGTRAP}, {"ABRT", syscall.SIGABRT},
+	{"BUS", syscall.SIGBUS}, {"FPE", syscall.SIGFPE}, {"KILL", syscall.SIGKILL},
+	{"USR1", syscall.SIGUSR1}, {"SEGV", syscall.SIGSEGV}, {"USR2", syscall.SIGUSR2},
+	{"PIPE", syscall.SIGPIPE}, {"ALRM", syscall.SIGALRM}, {"TERM", syscall.SIGTERM},
+	{"CHLD", syscall.SIGCHLD}, {"CONT", syscall.SIGCONT}, {"STOP", syscall.SIGSTOP},
+	{"TSTP", syscall.SIGTSTP}, {"TTIN", syscall.SIGTTIN}, {"TTOU", syscall.SIGTTOU},
+	{"URG", syscall.SIGURG}, {"XCPU", syscall.SIGXCPU}, {"XFSZ", syscall.SIGXFSZ},
+	{"VTALRM", syscall.SIGVTALRM}, {"PROF", syscall.SIGPROF}, {"WINCH", syscall.SIGWINCH},
+	{"IO", syscall.SIGIO}, {"SYS", syscall.SIGSYS},
+}
+
+func init() {
+	// Номера сигналов различаются в разных ОС
+	sort.Slice(signals, func(i, j int) bool { return signals[i].sig < signals[j].sig })
+}
+
+// parseSignal сигнал по номеру или имени: 9, KILL, kill, SIGKILL
+func parseSignal(s string) (syscall.Signal, bool) {
+	if n, err := strconv.Atoi(s); err == nil {
+		return syscall.Signal(n), n >= 0 && n < 65
+	}
+	name := strings.TrimPrefix(strings.ToUpper(s), "SIG")
+	for _, sg := range signals {
+		if sg.name == name {
+			return sg.sig, true
+		}
+	}
+	return 0, false
+}
+
+// signalName имя сигнала без SIG, для неизвестного - номер
+func signalName(sig syscall.Signal) string {
+	for _, sg := range signals {
+		if sg.sig == sig {
+			return sg.name
+		}
+	}
+	return strconv.Itoa(int(sig))
+}
+
+const killUsage = "kill: usage: kill [-s sigspec | -n signum | -sigspec] pid... or kill -l [sigspec]"
+
+// builtinKill посылает сигнал процессам, по умолчанию TERM. Сигнал задается как -s NAME,
+// -n N, -NAME или -N, имя - с SIG и без. Отрицательный pid после -- - группа процессов.
+// kill -l печатает список сигналов, kill -l N - имя сигнала, в том числе по коду 128+N
+func builtinKill(sh *shell, args []string, std stdio) int {
+	args = args[1:]
+	if len(args) > 0 && args[0] == "-l" {
+		return killList(args[1:], std)
+	}
+
+	sig := syscall.SIGTERM
+	if len(args) > 0 && strings.HasPrefix(args[0], "-") && args[0] != "--" {
+		spec := args[0][1:]
+		args = args[1:]
+		if spec == "s" || spec == "n" {
+			if len(args) == 0 {
+				fmt.Fprintf(std.err, "kill: -%s: option requires an argument\n", spec)
+				return 2
+			}
+			spec, args = args[0], args[1:]
+		}
+		var ok bool
+		if sig, ok = parseSignal(spec); !ok {
+			fmt.Fprintf(std.err, "kill: %s: invalid signal specification\n", spec)
+			return 1
+		}
+	}
+	if len(args) > 0 && args[0] == "--" {
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		fmt.Fprintln(std.err, killUsage)
+		return 2
+	}
+
+	status := 0
+	for _, arg := range args {
+		pid, err := strconv.Atoi(arg)
+		if err != nil {
+			fmt.Fprintf(std.err, "kill: %s: arguments must be process ids\n", arg)
+			status = 1
+			continue
+		}
+		if err := syscall.Kill(pid, sig); err != nil {
+			fmt.Fprintf(std.err, "kill: (%d) - %v\n", pid, err)
+			status = 1
+		}
+	}
+	return status
+}
+
+// killList список сигналов для kill -l
+func killList(args []string, std stdio) int {
+	if len(args) == 0 {
+		for _, sg := range signals {
+			fmt.Fprintf(std.out, "%2d) SIG%s\n", int(sg.sig), sg.name)
+		}
+		return 0
+	}
+
+	status := 0
+	for _, arg := range args {
+		n, err := strconv.Atoi(arg)
+		if err != nil {
+			// По имени печатается номер
+			sig, ok := parseSignal(arg)
+			if !ok {
+				fmt.Fprintf(std.err, "kill: %s: invalid signal specification\n", arg)
+				status = 1
+				continue
+			}
+			fmt.Fprintln(std.out, int(sig))
+			continue
+		}
+		if n > 128 {
+			n -= 128
+		}
+		fmt.Fprintln(std.out, signalName(syscall.Signal(n)))
+	}
+	return status
+}
+
+// procRoot каталог procfs, подменяется в тестах
+var procRoot = "/proc"
+
+// procInfo сведения о процессе из procfs
+type procInfo struct {
+	pid, ppid int
+	state     string
+	cmd       string
+}
+
+// psOptions буквы привычных опций ps: aux, -e, -ef, -A. Встроенный ps и так
+// выводит все процессы, поэтому они принимаются и ничего не меняют
+const psOptions = "aefluxwAH"
+
+// builtinPs печатает процессы системы по /proc: PID, PPID, состояние и командную строку.
+// У процессов ядра командной строки нет, вместо нее имя в квадратных скобках
+func builtinPs(sh *shell, args []string, std stdio) int {
+	for _, arg := range args[1:] {
+		opts := strings.TrimPrefix(arg, "-")
+		if opts == "" || strings.Trim(opts, psOptions) != "" {
+			fmt.Fprintf(std.err, "ps: %s: unsupported option\n", arg)
+			return 1
+		}
+	}
+
+	procs, err := readProcs(procRoot)
+	if err != nil {
+		fmt.Fprintf(std.err, "ps: %v\n", err)
+		return 1
+	}
+	fmt.Fprintf(std.out, "%7s %7s S CMD\n", "PID", "PPID")
+	for _, p := range procs {
+		fmt.Fprintf(std.out, "%7d %7d %s %s\n", p.pid, p.ppid, p.state, p.cmd)
+	}
+	return 0
+}
+
+// readProcs процессы из каталогов procfs по возрастанию PID.
+// Процессы, завершившиеся во время чтения, пропускаются
+func readProcs(root string) ([]procInfo, error) {
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return nil, err
+	}
+
+	var procs []procInfo
+	for _, e := range entries {
+		pid, err := strconv.Atoi(e.Name())
+		if err != nil || !e.IsDir() {
+			continue
+		}
+		p, err := readProc(filepath.Join(root, e.Name()))
+		if err != nil {
+			continue
+		}
+		p.pid = pid
+		procs = append(procs, p)
+	}
+	sort.Slice(procs, func(i, j int) bool { return procs[i].pid < procs[j].pid })
+	return procs, nil
+}
+
+// readProc разбирает stat и cmdline процесса. stat: "pid (comm) state ppid ...",
+// comm может содержать пробелы и скобки, поэтому ищется последняя )
+func readProc(dir string) (procInfo, error) {
+	stat, err := os.ReadFile(filepath.Join(dir, "stat"))
+	if err != nil {
+		return procInfo{}, err
+	}
+	s := string(stat)
+	open, end := strings.IndexByte(s, '('), strings.LastIndexByte(s, ')')
+	if open < 0 || end < open {
+		return procInfo{}, fmt.Errorf("%s: malformed stat", dir)
+	}
+	fields := strings.Fields(s[end+1:])
+	if len(fields) < 2 {
+		return procInfo{}, fmt.Errorf("%s: malformed stat", dir)
+	}
+	ppid, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return procInfo{}, fmt.Errorf("%s: malformed stat", dir)
+	}
+	p := procInfo{ppid: ppid, state: fields[0]}
+
+	cmdline, err := os.ReadFile(filepath.Join(dir, "cmdline"))
+	if err != nil {
+		return procInfo{}, err
+	}
+	p.cmd = strings.ReplaceAll(strings.TrimRight(string(cmdline), "\x00"), "\x00", " ")
+	if p.cmd == "" {
+		p.cmd = "[" + s[open+1:end] + "]"
+	}
+	return p, nil
+}
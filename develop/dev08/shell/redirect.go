@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+)
+
+// redirect применяет перенаправления к потокам команды слева направо, как шелл:
+// в "cmd >out 2>&1" оба потока идут в файл, в "cmd 2>&1 >out" stderr остается прежним.
+// В имени файла и тексте << без кавычек у ограничителя выполняются подстановки.
+// Возвращает новые потоки и открытые файлы, которые нужно закрыть после команды
+func (sh *shell) redirect(redirects []*redirect, std stdio) (stdio, []*os.File, error) {
+	var files []*os.File
+	e := sh.expander(std)
+	for _, r := range redirects {
+		fd := r.defaultFD()
+		if fd > 2 {
+			closeFiles(files)
+			return std, nil, fmt.Errorf("%d: bad file descriptor", fd)
+		}
+		// Ввод (<, <<) можно направить только в 0, вывод - только в 1 и 2: потоки
+		// команды однонаправленные, stdout из файла на чтение был бы сломан
+		if input := r.op == "<" || r.op == "<<"; r.op != ">&" && input != (fd == 0) {
+			closeFiles(files)
+			return std, nil, fmt.Errorf("%d%s: bad file descriptor", fd, r.op)
+		}
+
+		if r.op == "<<" {
+			text := r.heredoc
+			if !r.quotedHeredoc {
+				var err error
+				if text, err = e.heredoc(text); err != nil {
+					closeFiles(files)
+					return std, nil, err
+				}
+			}
+			std.in = strings.NewReader(text)
+			continue
+		}
+
+		// Имя файла должно остаться одним словом: "> $files" с несколькими файлами - ошибка
+		fields, err := e.word(r.target)
+		if err != nil {
+			closeFiles(files)
+			return std, nil, err
+		}
+		if len(fields) != 1 {
+			closeFiles(files)
+			return std, nil, fmt.Errorf("%s: ambiguous redirect", r.target.literal())
+		}
+		target := fields[0]
+
+		if r.op == ">&" {
+			if fd == 0 || (target != "1" && target != "2") {
+				closeFiles(files)
+				return std, nil, fmt.Errorf("%s: bad file descriptor", target)
+			}
+			if target == "1" {
+				std = setFD(std, fd, std.out)
+			} else {
+				std = setFD(std, fd, std.err)
+			}
+			continue
+		}
+
+		f, err := sh.open(target, r.op)
+		if err != nil {
+			closeFiles(files)
+			return std, nil, err
+		}
+		files = append(files, f)
+		if fd == 0 {
+			std.in = f
+		} else {
+			std = setFD(std, fd, f)
+		}
+	}
+	return std, files, nil
+}
+
+// open открывает файл перенаправления относительно каталога шелла: < - на чтение,
+// > - с созданием и обрезкой, >> - с созданием и дописыванием в конец
+func (sh *shell) open(name, op string) (*os.File, error) {
+	path := sh.path(name)
+	var f *os.File
+	var err error
+	switch op {
+	case "<":
+		f, err = os.Open(path)
+	case ">":
+		f, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	case ">>":
+		f, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	default:
+		return nil, fmt.Errorf("unknown redirection %q", op)
+	}
+
+	// Ошибка без полного пути, как у шелла: "out/x: no such file or directory"
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		return nil, fmt.Errorf("%s: %v", name, pathErr.Err)
+	}
+	return f, err
+}
+
+// setFD подменяет поток вывода 1 или 2
+func setFD(std stdio, fd int, w io.Writer) stdio {
+	if fd == 1 {
+		std.out = w
+	} else {
+		std.err = w
+	}
+	return std
+}
@@ -4,9 +4,9 @@ import (
 	"bufio"
 	"errors"
 	"fmt"
+	"io"
 	"os"
-	"os/exec"
-	"strings"
+	"path/filepath"
 )
 
 /*
@@ -20,37 +20,137 @@ import (
 Программа должна проходить все тесты. Код должен проходить проверки go vet и golint.
 */
 
-func commandExec(command string) error {
-	command = strings.TrimSuffix(command, "\n")
-	comArgs := strings.Split(command, " ")
+// shell состояние шелла: текущий каталог, переменные, псевдонимы и код завершения
+// последней команды. Каталог хранится здесь, а не в процессе (os.Chdir), чтобы встроенные
+// команды конвейера могли работать с копией состояния, как в отдельном процессе
+type shell struct {
+	dir     string
+	status  int
+	vars    map[string]variable
+	aliases map[string]string
+	// expanding псевдонимы, которые сейчас раскрываются: внутри себя не раскрываются повторно
+	expanding map[string]bool
+	// exited выставляет встроенная команда exit
+	exited bool
+}
 
-	switch comArgs[0] {
-	case "cd":
-		if len(comArgs) < 2 {
-			return errors.New("path required")
-		}
-		return os.Chdir(comArgs[1])
-	case "exit":
-		os.Exit(0)
+// Конструктор шелла в текущем каталоге процесса, переменные - из окружения
+func newShell() (*shell, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return nil, err
 	}
+	return &shell{
+		dir:       dir,
+		vars:      environVars(os.Environ()),
+		aliases:   make(map[string]string),
+		expanding: make(map[string]bool),
+	}, nil
+}
 
-	cmd := exec.Command(comArgs[0], comArgs[1:]...)
+// clone копия состояния для команды, которая не должна менять шелл
+func (sh *shell) clone() *shell {
+	c := *sh
+	c.vars = make(map[string]variable, len(sh.vars))
+	for name, v := range sh.vars {
+		c.vars[name] = v
+	}
+	c.aliases = make(map[string]string, len(sh.aliases))
+	for name, value := range sh.aliases {
+		c.aliases[name] = value
+	}
+	c.expanding = make(map[string]bool, len(sh.expanding))
+	for name := range sh.expanding {
+		c.expanding[name] = true
+	}
+	return &c
+}
+
+// path путь относительно каталога шелла
+func (sh *shell) path(name string) string {
+	if filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(sh.dir, name)
+}
+
+// commandExec разбирает и выполняет текст, запоминает код завершения
+func (sh *shell) commandExec(src string, std stdio) int {
+	prog, err := parse(src)
+	if err != nil {
+		fmt.Fprintf(std.err, "shell: %v\n", err)
+		sh.status = 2
+		return sh.status
+	}
+	return sh.run(prog, std)
+}
 
-	cmd.Stderr, cmd.Stdout = os.Stderr, os.Stdout
+// run выполняет список команд, пока не встретится exit
+func (sh *shell) run(l *list, std stdio) int {
+	for _, item := range l.items {
+		if sh.exited {
+			break
+		}
+		sh.status = sh.runAndOr(item, std)
+	}
+	return sh.status
+}
 
-	return cmd.Run()
+// runAndOr выполняет конвейер после && только при успехе предыдущего, после || - только при неудаче
+func (sh *shell) runAndOr(a *andOr, std stdio) int {
+	status := sh.runPipeline(a.first, std)
+	for _, item := range a.rest {
+		if sh.exited {
+			break
+		}
+		if (item.op == "&&") != (status == 0) {
+			continue
+		}
+		sh.status = status
+		status = sh.runPipeline(item.pipeline, std)
+	}
+	return status
 }
 
 func main() {
+	sh, err := newShell()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	std := stdio{in: os.Stdin, out: os.Stdout, err: os.Stderr}
 	reader := bufio.NewReader(os.Stdin)
-	for {
-		fmt.Print("$ ")
-		cmd, err := reader.ReadString('\n')
-		if err != nil {
-			fmt.Fprintln(os.Stderr, err)
+	// src накапливает строки, пока ввод не станет законченной командой
+	var src string
+	for !sh.exited {
+		if src == "" {
+			fmt.Print("$ ")
+		} else {
+			fmt.Print("> ")
 		}
-		if err = commandExec(cmd); err != nil {
+		line, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
 			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		src += line
+
+		prog, perr := parse(src)
+		switch {
+		case errors.Is(perr, errIncomplete) && err == nil:
+			continue
+		case perr != nil:
+			fmt.Fprintf(os.Stderr, "shell: %v\n", perr)
+			sh.status = 2
+		default:
+			sh.run(prog, std)
+		}
+		src = ""
+
+		if err == io.EOF {
+			break
 		}
 	}
+	os.Exit(sh.status)
 }
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// runLine выполняет строку в шелле sh и возвращает stdout, stderr и код завершения
+func runLine(t *testing.T, sh *shell, line string) (string, string, int) {
+	t.Helper()
+	var out, errOut bytes.Buffer
+	status := sh.commandExec(line, stdio{in: strings.NewReader(""), out: &out, err: &errOut})
+	return out.String(), errOut.String(), status
+}
+
+func testShell(t *testing.T) *shell {
+	t.Helper()
+	sh, err := newShell()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sh.dir = t.TempDir()
+	return sh
+}
+
+func TestPipeline(t *testing.T) {
+	tests := []struct {
+		line   string
+		out    string
+		status int
+	}{
+		{"seq 1 12 | grep 1 | wc -l", "4\n", 0},
+		{"seq 1 3 | tac", "3\n2\n1\n", 0},
+		{"true | false", "", 1},
+		{"false | true", "", 0},
+		{"seq 1 100000 | head -n 1", "1\n", 0},
+		{"no-such-command | wc -l", "0\n", 0},
+		{"seq 1 3 | no-such-command", "", 127},
+		{"ls |", "", 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			out, errOut, status := runLine(t, testShell(t), tt.line)
+			if strings.TrimLeft(out, " ") != tt.out || status != tt.status {
+				t.Errorf("got %q (status %v, stderr %q), want %q (status %v)", out, status, errOut, tt.out, tt.status)
+			}
+		})
+	}
+}
+
+func TestBuiltinsInPipeline(t *testing.T) {
+	sh := testShell(t)
+	dir := sh.dir
+
+	// В конвейере встроенная команда меняет только копию шелла
+	runLine(t, sh, "cd / | true")
+	if sh.dir != dir {
+		t.Errorf("cd in pipeline changed shell dir to %v", sh.dir)
+	}
+	runLine(t, sh, "exit 3 | true")
+	if sh.exited {
+		t.Error("exit in pipeline exited the shell")
+	}
+	if _, _, status := runLine(t, sh, "true | exit 3"); status != 3 {
+		t.Errorf("pipeline status %v, want status of exit", status)
+	}
+
+	if _, _, status := runLine(t, sh, "cd /"); status != 0 || sh.dir != "/" {
+		t.Fatalf("cd: status %v, dir %v", status, sh.dir)
+	}
+	if out, _, _ := runLine(t, sh, "pwd"); out != "/\n" {
+		t.Errorf("external command runs in %q, want shell dir", out)
+	}
+	if _, errOut, status := runLine(t, sh, "cd /no/such/dir"); status != 1 || !strings.Contains(errOut, "no such file") {
+		t.Errorf("cd to missing dir: %v %q", status, errOut)
+	}
+}
+
+func TestLists(t *testing.T) {
+	tests := []struct {
+		line   string
+		out    string
+		status int
+	}{
+		{"printf a; printf b", "ab", 0},
+		{"true && printf yes", "yes", 0},
+		{"false && printf yes", "", 1},
+		{"false || printf no", "no", 0},
+		{"true || printf no", "", 0},
+		{"false && printf a || printf b", "b", 0},
+		{"printf 'x y'", "x y", 0},
+		{"(printf a; printf b) | wc -c", "2\n", 0},
+		{"(exit 3) || printf caught", "caught", 0},
+		{"(exit 4)", "", 4},
+		{"exit 5; printf unreachable", "", 5},
+		{"printf \"a|b\" # comment", "a|b", 0},
+		{"printf '", "", 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			out, errOut, status := runLine(t, testShell(t), tt.line)
+			if strings.TrimLeft(out, " ") != tt.out || status != tt.status {
+				t.Errorf("got %q (status %v, stderr %q), want %q (status %v)", out, status, errOut, tt.out, tt.status)
+			}
+		})
+	}
+
+	// Подоболочка не меняет каталог шелла
+	sh := testShell(t)
+	dir := sh.dir
+	if out, _, _ := runLine(t, sh, "(cd /; pwd); pwd"); out != "/\n"+dir+"\n" {
+		t.Errorf("subshell cd: %q", out)
+	}
+}
+
+func TestRedirects(t *testing.T) {
+	sh := testShell(t)
+	read := func(name string) string {
+		data, err := os.ReadFile(filepath.Join(sh.dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+
+	tests := []struct {
+		line   string
+		out    string
+		errOut string
+		status int
+	}{
+		{"printf 'b\\na\\n' > in", "", "", 0},
+		{"sort < in", "a\nb\n", "", 0},
+		{"sort <in >out; cat out", "a\nb\n", "", 0},
+		{"printf c >> out; cat out", "a\nb\nc", "", 0},
+		{"printf d > out; cat out", "d", "", 0},
+		{"ls no-such-file 2> err; test -s err", "", "", 0},
+		{"ls no-such-file 2>/dev/null", "", "", 2},
+		{"ls in no-such-file > both 2>&1; grep -c . both", "2\n", "", 0},
+		{"ls no-such-file 2>&1 > /dev/null | wc -l", "1\n", "", 0},
+		{"(printf x; printf y) > sub; cat sub", "xy", "", 0},
+		{"cd . > cd.out; cat cd.out", "", "", 0},
+		{"(exit 3) > exit.out", "", "", 3},
+		{"cat <<EOF\nhello\n  world\nEOF", "hello\n  world\n", "", 0},
+		{"cat <<EOF | wc -l\n1\n2\nEOF\n", "2\n", "", 0},
+		{"> created; test -f created", "", "", 0},
+		{"cat < missing", "", "shell: missing: no such file or directory\n", 1},
+		{"printf x > no/such/dir", "", "shell: no/such/dir: no such file or directory\n", 1},
+		{"printf x > .", "", "shell: .: is a directory\n", 1},
+		{"printf x 3> f", "", "shell: 3: bad file descriptor\n", 1},
+		{"printf x >&5", "", "shell: 5: bad file descriptor\n", 1},
+		// Номер дескриптора у << и < учитывается: ввод только в 0, вывод только в 1 и 2
+		{"cat 0<in 0<<EOF\nz\nEOF", "z\n", "", 0},
+		{"cat 3<<EOF\nz\nEOF", "", "shell: 3: bad file descriptor\n", 1},
+		{"cat 1<<EOF\nz\nEOF", "", "shell: 1<<: bad file descriptor\n", 1},
+		{"printf x 1<in", "", "shell: 1<: bad file descriptor\n", 1},
+		{"cat 2<in", "", "shell: 2<: bad file descriptor\n", 1},
+		{"cat 0>in", "", "shell: 0>: bad file descriptor\n", 1},
+	}
+	for _, tt := range tests {
+		out, errOut, status := runLine(t, sh, tt.line)
+		if strings.TrimLeft(out, " ") != tt.out || errOut != tt.errOut || status != tt.status {
+			t.Errorf("%q: got %q, stderr %q, status %v; want %q, stderr %q, status %v",
+				tt.line, out, errOut, status, tt.out, tt.errOut, tt.status)
+		}
+	}
+
+	if got := read("in"); got != "b\na\n" {
+		t.Errorf("in = %q", got)
+	}
+	// Файлы создаются с правами 0644 с учетом umask
+	if info, err := os.Stat(filepath.Join(sh.dir, "out")); err != nil || info.Mode().Perm()&^0o644 != 0 {
+		t.Errorf("out mode %v %v", info.Mode(), err)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// variable переменная шелла. Внешним командам передаются только экспортированные
+type variable struct {
+	value    string
+	exported bool
+}
+
+// validName имя переменной или псевдонима: буквы, цифры и _, не с цифры
+func validName(name string) bool {
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		return false
+	}
+	for _, c := range name {
+		if !(c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// environVars переменные окружения процесса, все экспортированные
+func environVars(environ []string) map[string]variable {
+	vars := make(map[string]variable, len(environ))
+	for _, kv := range environ {
+		if name, value, ok := strings.Cut(kv, "="); ok && validName(name) {
+			vars[name] = variable{value: value, exported: true}
+		}
+	}
+	return vars
+}
+
+// getVar значение переменной, пустая строка - если ее нет
+func (sh *shell) getVar(name string) string {
+	return sh.vars[name].value
+}
+
+// setVar меняет значение, сохраняя признак экспорта. Новая переменная - локальная
+func (sh *shell) setVar(name, value string) {
+	v := sh.vars[name]
+	v.value = value
+	sh.vars[name] = v
+}
+
+// saveVars текущее состояние переменных, которые задают присваивания
+func (sh *shell) saveVars(assigns []assignment) map[string]*variable {
+	saved := make(map[string]*variable, len(assigns))
+	for _, a := range assigns {
+		if v, ok := sh.vars[a.name]; ok {
+			saved[a.name] = &v
+		} else {
+			saved[a.name] = nil
+		}
+	}
+	return saved
+}
+
+// restoreVars возвращает переменные из saveVars, несуществовавшие удаляет
+func (sh *shell) restoreVars(saved map[string]*variable) {
+	for name, v := range saved {
+		if v == nil {
+			delete(sh.vars, name)
+		} else {
+			sh.vars[name] = *v
+		}
+	}
+}
+
+// environ окружение для внешних команд: экспортированные переменные по имени
+func (sh *shell) environ() []string {
+	env := make([]string, 0, len(sh.vars))
+	for name, v := range sh.vars {
+		if v.exported {
+			env = append(env, name+"="+v.value)
+		}
+	}
+	sort.Strings(env)
+	return env
+}
+
+// lookPath ищет исполняемый файл как шелл: имя со / - относительно каталога шелла,
+// иначе в каталогах PATH шелла, а не процесса
+func (sh *shell) lookPath(name string) (string, bool) {
+	if strings.Contains(name, "/") {
+		path := sh.path(name)
+		return path, isExecutable(path)
+	}
+
+	for _, dir := range filepath.SplitList(sh.getVar("PATH")) {
+		if dir == "" {
+			dir = "."
+		}
+		path := sh.path(filepath.Join(dir, name))
+		if isExecutable(path) {
+			return path, true
+		}
+	}
+	return "", false
+}
+
+// isExecutable обычный файл с правом на исполнение
+func isExecutable(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.Mode().IsRegular() && info.Mode().Perm()&0o111 != 0
+}
+
+// shellQuote заключает строку в одинарные кавычки, если без них шелл ее разберет иначе
+func shellQuote(s string) string {
+	if s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_-./:=,+@%") == "" {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
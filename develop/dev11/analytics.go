@@ -0,0 +1,329 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maxAnalyticsRange наибольший диапазон статистики
+	maxAnalyticsRange = 366 * 24 * time.Hour
+	// maxAnalyticsEntries сколько результатов держит кеш, при переполнении он очищается
+	maxAnalyticsEntries = 1000
+)
+
+// dayStats число событий, начинающихся в день Date
+type dayStats struct {
+	Date   string `json:"date"`
+	Events int    `json:"events"`
+}
+
+// weekdayStats события, начинающиеся в этот день недели, и их часы
+type weekdayStats struct {
+	Weekday string  `json:"weekday"`
+	Events  int     `json:"events"`
+	Hours   float64 `json:"hours"`
+}
+
+// userStats события пользователя в диапазоне и их суммарные часы
+type userStats struct {
+	UserID int     `json:"user_id"`
+	Events int     `json:"events"`
+	Hours  float64 `json:"hours"`
+}
+
+// analyticsReport статистика по пользователям за даты [From, To]
+type analyticsReport struct {
+	From     string         `json:"from"`
+	To       string         `json:"to"`
+	TimeZone string         `json:"time_zone"`
+	PerDay   []dayStats     `json:"per_day"`
+	Weekdays []weekdayStats `json:"weekdays"`
+	Users    []userStats    `json:"users"`
+}
+
+// computeAnalytics считает статистику по экземплярам событий в [from, to). В расписание
+// пользователя входят его события и приглашения с ответом accepted или tentative, как в busyIntervals.
+// По дням и дням недели событие считается один раз в день своего начала, даже если в нем
+// участвуют несколько пользователей запроса. Часы обрезаются границами диапазона
+// и суммируются без склейки пересечений
+func computeAnalytics(st Storage, userIDs []int, from, to time.Time) (*analyticsReport, error) {
+	loc := from.Location()
+	report := &analyticsReport{
+		From:     from.Format(dateFormat),
+		To:       to.AddDate(0, 0, -1).Format(dateFormat),
+		TimeZone: loc.String(),
+		PerDay:   []dayStats{},
+		Users:    make([]userStats, 0, len(userIDs)),
+	}
+
+	perDay := make(map[string]int)
+	for d := from; d.Before(to); d = d.AddDate(0, 0, 1) {
+		perDay[d.Format(dateFormat)] = 0
+		report.PerDay = append(report.PerDay, dayStats{Date: d.Format(dateFormat)})
+	}
+	var weekdays [7]weekdayStats
+	for i := range weekdays {
+		weekdays[i].Weekday = time.Weekday(i).String()
+	}
+
+	// counted экземпляры, уже учтенные по дням: организатор, событие и начало
+	type instanceKey struct {
+		userID, eventID int
+		start           int64
+	}
+	counted := make(map[instanceKey]bool)
+
+	for _, userID := range userIDs {
+		stats := userStats{UserID: userID}
+
+		events, err := st.between(userID, from, to)
+		var unknown *unknownUserError
+		if err != nil && !errors.As(err, &unknown) {
+			return nil, err
+		}
+		for i := range events {
+			ev := &events[i]
+			if ev.UserID != userID && ev.attendeeStatus(userID) == rsvpNeedsAction {
+				continue
+			}
+			start, end := ev.start(), ev.end()
+			clippedStart, clippedEnd := start, end
+			if clippedStart.Before(from) {
+				clippedStart = from
+			}
+			if clippedEnd.After(to) {
+				clippedEnd = to
+			}
+			hours := 0.0
+			if clippedEnd.After(clippedStart) {
+				hours = clippedEnd.Sub(clippedStart).Hours()
+			}
+			stats.Hours += hours
+
+			if start.Before(from) {
+				continue
+			}
+			stats.Events++
+
+			key := instanceKey{userID: ev.UserID, eventID: ev.EventID, start: start.UnixNano()}
+			if counted[key] {
+				continue
+			}
+			counted[key] = true
+			local := start.In(loc)
+			perDay[local.Format(dateFormat)]++
+			weekdays[local.Weekday()].Events++
+			weekdays[local.Weekday()].Hours += hours
+		}
+
+		stats.Hours = roundHours(stats.Hours)
+		report.Users = append(report.Users, stats)
+	}
+
+	for i := range report.PerDay {
+		report.PerDay[i].Events = perDay[report.PerDay[i].Date]
+	}
+
+	// Дни недели от самого загруженного: по числу событий, затем по часам, затем с понедельника
+	report.Weekdays = weekdays[:]
+	for i := range report.Weekdays {
+		report.Weekdays[i].Hours = roundHours(report.Weekdays[i].Hours)
+	}
+	isoDay := func(w string) int {
+		for i := 0; i < 7; i++ {
+			if time.Weekday(i).String() == w {
+				return (i + 6) % 7
+			}
+		}
+		return 7
+	}
+	sort.SliceStable(report.Weekdays, func(i, j int) bool {
+		a, b := report.Weekdays[i], report.Weekdays[j]
+		if a.Events != b.Events {
+			return a.Events > b.Events
+		}
+		if a.Hours != b.Hours {
+			return a.Hours > b.Hours
+		}
+		return isoDay(a.Weekday) < isoDay(b.Weekday)
+	})
+
+	return report, nil
+}
+
+// roundHours округляет часы до минут, чтобы в JSON не было хвостов вида 1.4999999
+func roundHours(h float64) float64 {
+	return float64(time.Duration(h*float64(time.Hour)).Round(time.Minute)) / float64(time.Hour)
+}
+
+// AnalyticsCache кеш статистики. Результат хранится, пока не изменится событие
+// одного из пользователей запроса: кеш получает изменения как наблюдатель хранилища
+type AnalyticsCache struct {
+	mu      sync.Mutex
+	entries map[string]*analyticsReport
+	// byUser ключи результатов, в которые входит пользователь
+	byUser map[int]map[string]bool
+	// generation растет с каждым изменением хранилища. Результат, посчитанный
+	// во время изменения, не сохраняется: он мог прочитать данные до него
+	generation uint64
+
+	hits, misses uint64
+}
+
+// Конструктор кеша статистики
+func newAnalyticsCache() *AnalyticsCache {
+	return &AnalyticsCache{entries: make(map[string]*analyticsReport), byUser: make(map[int]map[string]bool)}
+}
+
+// analytics - глобальный кеш статистики
+var analytics = newAnalyticsCache()
+
+// analyticsKey ключ запроса: пользователи по возрастанию, даты и пояс
+func analyticsKey(userIDs []int, from, to time.Time) string {
+	ids := append([]int(nil), userIDs...)
+	sort.Ints(ids)
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		parts[i] = strconv.Itoa(id)
+	}
+	return fmt.Sprintf("%s|%s|%s|%s", strings.Join(parts, ","), from.Format(dateFormat), to.Format(dateFormat), from.Location())
+}
+
+// get статистика из кеша или посчитанная по хранилищу
+func (c *AnalyticsCache) get(st Storage, userIDs []int, from, to time.Time) (*analyticsReport, error) {
+	key := analyticsKey(userIDs, from, to)
+
+	c.mu.Lock()
+	if report, ok := c.entries[key]; ok {
+		c.hits++
+		c.mu.Unlock()
+		return report, nil
+	}
+	c.misses++
+	generation := c.generation
+	c.mu.Unlock()
+
+	report, err := computeAnalytics(st, userIDs, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.generation != generation {
+		return report, nil
+	}
+	if len(c.entries) >= maxAnalyticsEntries {
+		c.entries = make(map[string]*analyticsReport)
+		c.byUser = make(map[int]map[string]bool)
+	}
+	c.entries[key] = report
+	for _, id := range userIDs {
+		if c.byUser[id] == nil {
+			c.byUser[id] = make(map[string]bool)
+		}
+		c.byUser[id][key] = true
+	}
+	return report, nil
+}
+
+// record наблюдатель хранилища: сбрасывает результаты организатора и всех приглашенных,
+// прежних и новых, потому что событие входит и в их расписание
+func (c *AnalyticsCache) record(change storageChange) {
+	users := []int{change.Event.UserID}
+	for _, a := range change.Event.Attendees {
+		users = append(users, a.UserID)
+	}
+	if change.Prev != nil {
+		for _, a := range change.Prev.Attendees {
+			users = append(users, a.UserID)
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	for _, id := range users {
+		for key := range c.byUser[id] {
+			delete(c.entries, key)
+		}
+		delete(c.byUser, id)
+	}
+}
+
+// analyticsHandler /analytics?users=3,7&from=2019-09-01&to=2019-09-30&tz=Europe/Moscow.
+// Без users - статистика по своему календарю. Статистику чужих календарей видит только
+// администратор: в ней есть события, которые пользователю читать нельзя
+func analyticsHandler(tokens *TokenStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, status, err := authorize(r, 0)
+		if err != nil {
+			getErrResponse(w, err.Error(), status)
+			return
+		}
+
+		userIDs := []int{userID}
+		if v := r.URL.Query().Get("users"); v != "" {
+			if userIDs, err = parseUserIDs(v); err != nil {
+				getErrResponse(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		if !tokens.isAdmin(r) {
+			for _, id := range userIDs {
+				if id != userID {
+					getErrResponse(w, errForbidden.Error(), http.StatusForbidden)
+					return
+				}
+			}
+		}
+
+		analyticsReportFor(w, r, userIDs)
+	}
+}
+
+// analyticsReportFor отдает статистику по календарям userIDs
+func analyticsReportFor(w http.ResponseWriter, r *http.Request, userIDs []int) {
+	q := r.URL.Query()
+
+	loc, err := loadLocation(q.Get("tz"))
+	if err != nil {
+		getErrResponse(w, fmt.Sprintf("invalid tz %q", q.Get("tz")), http.StatusBadRequest)
+		return
+	}
+	from, err := time.ParseInLocation(dateFormat, q.Get("from"), loc)
+	if err != nil {
+		getErrResponse(w, "invalid from", http.StatusBadRequest)
+		return
+	}
+	to, err := time.ParseInLocation(dateFormat, q.Get("to"), loc)
+	if err != nil || to.Before(from) {
+		getErrResponse(w, "invalid to", http.StatusBadRequest)
+		return
+	}
+	to = to.AddDate(0, 0, 1)
+	if to.Sub(from) > maxAnalyticsRange {
+		getErrResponse(w, fmt.Sprintf("range is too long, max %v days", int(maxAnalyticsRange.Hours()/24)), http.StatusBadRequest)
+		return
+	}
+
+	report, err := analytics.get(storage, userIDs, from, to)
+	if err != nil {
+		getErrResponse(w, err.Error(), storageErrStatus(r, err))
+		return
+	}
+
+	resp := struct {
+		Result string `json:"result"`
+		*analyticsReport
+	}{Result: "Запрос успешно выполнен!", analyticsReport: report}
+
+	writeJSON(w, resp, http.StatusOK)
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestComputeAnalytics(t *testing.T) {
+	st := newMemoryStorage()
+	createAll(t, st,
+		Event{UserID: 1, EventID: 1, Title: "standup", Start: mustTime(t, "2019-09-09T10:00:00Z"), End: mustTime(t, "2019-09-09T11:00:00Z")},
+		Event{UserID: 1, EventID: 2, Title: "review", Start: mustTime(t, "2019-09-09T14:00:00Z"), End: mustTime(t, "2019-09-09T16:00:00Z")},
+		// Серия: вторник, среда и четверг по полчаса
+		Event{UserID: 1, EventID: 3, Title: "sync", Start: mustTime(t, "2019-09-10T09:00:00Z"), End: mustTime(t, "2019-09-10T09:30:00Z"),
+			Recurrence: &Recurrence{Freq: freqDaily, Count: 3}},
+		// Началось до диапазона: в часы входит только час внутри него
+		Event{UserID: 1, EventID: 4, Title: "night", Start: mustTime(t, "2019-08-31T23:00:00Z"), End: mustTime(t, "2019-09-01T01:00:00Z")},
+		// Встреча второго пользователя, первый принял приглашение: по дням считается один раз
+		Event{UserID: 2, EventID: 1, Title: "lunch", Start: mustTime(t, "2019-09-11T12:00:00Z"), End: mustTime(t, "2019-09-11T13:00:00Z"),
+			Attendees: []Attendee{{UserID: 1}}},
+		// Без ответа приглашение в расписание не входит
+		Event{UserID: 2, EventID: 2, Title: "maybe", Start: mustTime(t, "2019-09-12T12:00:00Z"), End: mustTime(t, "2019-09-12T13:00:00Z"),
+			Attendees: []Attendee{{UserID: 1}}},
+	)
+	if _, err := st.respond(2, 1, 1, rsvpAccepted); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := computeAnalytics(st, []int{1, 2, 99}, mustTime(t, "2019-09-01T00:00:00Z"), mustTime(t, "2019-10-01T00:00:00Z"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.From != "2019-09-01" || report.To != "2019-09-30" || len(report.PerDay) != 30 {
+		t.Fatalf("unexpected range %v - %v, %d days", report.From, report.To, len(report.PerDay))
+	}
+	perDay := make(map[string]int)
+	for _, d := range report.PerDay {
+		if d.Events != 0 {
+			perDay[d.Date] = d.Events
+		}
+	}
+	want := map[string]int{"2019-09-09": 2, "2019-09-10": 1, "2019-09-11": 2, "2019-09-12": 2}
+	for date, n := range want {
+		if perDay[date] != n {
+			t.Errorf("%v: got %v events, want %v", date, perDay[date], n)
+		}
+	}
+	if len(perDay) != len(want) {
+		t.Errorf("unexpected days %v", perDay)
+	}
+
+	var order []string
+	for _, w := range report.Weekdays {
+		order = append(order, w.Weekday)
+	}
+	if got := strings.Join(order, ","); got != "Monday,Wednesday,Thursday,Tuesday,Friday,Saturday,Sunday" {
+		t.Errorf("weekdays order %v", got)
+	}
+	if mon := report.Weekdays[0]; mon.Events != 2 || mon.Hours != 3 {
+		t.Errorf("monday %+v", mon)
+	}
+	// Среда и четверг равны по событиям и часам, раньше идет среда
+	if wed := report.Weekdays[1]; wed.Events != 2 || wed.Hours != 1.5 {
+		t.Errorf("wednesday %+v", wed)
+	}
+
+	wantUsers := []userStats{{UserID: 1, Events: 6, Hours: 6.5}, {UserID: 2, Events: 2, Hours: 2}, {UserID: 99}}
+	for i, w := range wantUsers {
+		if report.Users[i] != w {
+			t.Errorf("got %+v, want %+v", report.Users[i], w)
+		}
+	}
+}
+
+func TestAnalyticsCache(t *testing.T) {
+	srv, _ := newTestServer(t)
+	alice := issueToken(t, srv, "1")
+	url := srv.URL + "/analytics?from=2019-09-01&to=2019-09-30"
+
+	hours := func() float64 {
+		t.Helper()
+		status, res := doRequest(t, http.MethodGet, url, alice, "", nil)
+		if status != http.StatusOK {
+			t.Fatalf("analytics: %v %v", status, res)
+		}
+		users := res["users"].([]interface{})
+		return users[0].(map[string]interface{})["hours"].(float64)
+	}
+
+	if h := hours(); h != 0 {
+		t.Fatalf("empty calendar has %v hours", h)
+	}
+	hours()
+	if analytics.hits != 1 || analytics.misses != 1 {
+		t.Fatalf("second request is not cached: %d hits, %d misses", analytics.hits, analytics.misses)
+	}
+
+	// Изменение события сбрасывает кеш
+	doRequest(t, http.MethodPost, srv.URL+"/users/1/events", alice,
+		`{"event_id": 1, "title": "standup", "start": "2019-09-09T10:00:00Z", "end": "2019-09-09T11:30:00Z"}`, nil)
+	if h := hours(); h != 1.5 {
+		t.Fatalf("got %v hours after create", h)
+	}
+	doRequest(t, http.MethodPatch, srv.URL+"/users/1/events/1", alice, `{"end": "2019-09-09T12:00:00Z"}`, nil)
+	if h := hours(); h != 2 {
+		t.Fatalf("got %v hours after update", h)
+	}
+	doRequest(t, http.MethodDelete, srv.URL+"/users/1/events/1", alice, "", nil)
+	if h := hours(); h != 0 {
+		t.Fatalf("got %v hours after delete", h)
+	}
+	if analytics.misses != 4 {
+		t.Errorf("%d misses, want 4", analytics.misses)
+	}
+
+	for _, bad := range []string{"?from=2019-09-01&to=2019-08-01", "?from=2019-01-01&to=2020-06-01", "?users=x&from=2019-09-01&to=2019-09-30"} {
+		if status, res := doRequest(t, http.MethodGet, srv.URL+"/analytics"+bad, alice, "", nil); status != http.StatusBadRequest {
+			t.Errorf("%s: got %v %v", bad, status, res)
+		}
+	}
+}
+
+// TestAnalyticsAccess статистику чужого календаря видит только администратор
+func TestAnalyticsAccess(t *testing.T) {
+	srv, _ := newTestServer(t)
+	alice := issueToken(t, srv, "1")
+	bob := issueToken(t, srv, "2")
+	doRequest(t, http.MethodPost, srv.URL+"/users/2/events", bob,
+		`{"event_id": 1, "title": "private", "start": "2019-09-09T10:00:00Z", "end": "2019-09-09T11:00:00Z"}`, nil)
+
+	url := srv.URL + "/analytics?from=2019-09-01&to=2019-09-30&users="
+	for _, users := range []string{"2", "1,2"} {
+		if status, res := doRequest(t, http.MethodGet, url+users, alice, "", nil); status != http.StatusForbidden {
+			t.Errorf("users=%s: got %v %v, want 403", users, status, res)
+		}
+	}
+	if status, res := doRequest(t, http.MethodGet, url+"1", alice, "", nil); status != http.StatusOK {
+		t.Errorf("own calendar: %v %v", status, res)
+	}
+
+	status, res := doRequest(t, http.MethodGet, url+"1,2", alice, "", map[string]string{adminKeyHeader: testAdminKey})
+	if status != http.StatusOK {
+		t.Fatalf("admin: %v %v", status, res)
+	}
+	if hours := res["users"].([]interface{})[1].(map[string]interface{})["hours"]; hours != 1.0 {
+		t.Errorf("admin got %v hours for bob", hours)
+	}
+}
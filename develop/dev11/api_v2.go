@@ -0,0 +1,381 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// API v2 в стиле ресурсов:
+//	GET    /users/{id}/events?from=2019-09-01&to=2019-09-30 - поиск экземпляров событий за диапазон дат
+//	POST   /users/{id}/events                               - создание
+//	GET    /users/{id}/events/{eventID}                     - событие как оно хранится
+//	PUT    /users/{id}/events/{eventID}                     - полная замена
+//	PATCH  /users/{id}/events/{eventID}                     - изменение переданных полей
+//	DELETE /users/{id}/events/{eventID}?recurrence_id=...   - перенос события в корзину или удаление повторения
+//	GET    /users/{id}/events/{eventID}/history             - история изменений события
+//	GET    /users/{id}/trash                                 - удаленные события
+//	POST   /users/{id}/trash/{eventID}/restore               - восстановление события из корзины
+//	GET    /users/{id}/invitations?status=needs-action       - приглашения на чужие события
+//	PUT    /users/{id}/invitations/{organizerID}/{eventID}   - ответ на приглашение (status)
+//	GET    /users/{id}/webhooks                              - подписки на изменения событий
+//	POST   /users/{id}/webhooks                              - подписка (url, events), секрет только в ответе
+//	GET    /users/{id}/webhooks/{webhookID}                  - подписка без секрета
+//	DELETE /users/{id}/webhooks/{webhookID}                  - отписка
+//	GET    /users/{id}/webhooks/{webhookID}/deliveries       - журнал доставок
+// Тело принимается в JSON или www-url-form-encoded, ответы те же, что у старых методов
+
+const usersPrefix = "/users/"
+
+// eventsPath путь к коллекции или к одному событию пользователя
+func eventsPath(userID, eventID int) string {
+	path := fmt.Sprintf("%s%d/events", usersPrefix, userID)
+	if eventID != 0 {
+		path += "/" + strconv.Itoa(eventID)
+	}
+	return path
+}
+
+// UsersHandler разбирает путь /users/{id}/... и передает запрос обработчику ресурса
+func UsersHandler(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, usersPrefix), "/"), "/")
+	if len(parts) < 2 {
+		getErrResponse(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	pathUserID, err := strconv.Atoi(parts[0])
+	if err != nil || pathUserID <= 0 {
+		getErrResponse(w, "invalid user_id", http.StatusBadRequest)
+		return
+	}
+
+	// Остальные сегменты пути - идентификаторы, кроме завершающего history, deliveries или restore
+	suffix := ""
+	if last := parts[len(parts)-1]; len(parts) > 2 && (last == "history" || last == "deliveries" || last == "restore") {
+		suffix, parts = last, parts[:len(parts)-1]
+	}
+	ids := make([]int, 0, len(parts)-2)
+	for _, part := range parts[2:] {
+		id, err := strconv.Atoi(part)
+		if err != nil || id <= 0 {
+			getErrResponse(w, fmt.Sprintf("invalid id %q", part), http.StatusBadRequest)
+			return
+		}
+		ids = append(ids, id)
+	}
+
+	var handler http.HandlerFunc
+	switch {
+	case suffix == "history" && parts[1] == "events" && len(ids) == 1:
+		handler = allowMethods(func(w http.ResponseWriter, r *http.Request) {
+			historyV2(w, pathUserID, ids[0])
+		}, http.MethodGet)
+	case suffix == "deliveries" && parts[1] == "webhooks" && len(ids) == 1:
+		handler = allowMethods(func(w http.ResponseWriter, r *http.Request) {
+			deliveriesV2(w, r, pathUserID, ids[0])
+		}, http.MethodGet)
+	case suffix == "restore" && parts[1] == "trash" && len(ids) == 1:
+		handler = allowMethods(func(w http.ResponseWriter, r *http.Request) {
+			restoreV2(w, r, pathUserID, ids[0])
+		}, http.MethodPost)
+	case suffix != "":
+		getErrResponse(w, "not found", http.StatusNotFound)
+		return
+	case parts[1] == "trash" && len(ids) == 0:
+		handler = allowMethods(func(w http.ResponseWriter, r *http.Request) {
+			trashV2(w, r, pathUserID)
+		}, http.MethodGet)
+	case parts[1] == "events" && len(ids) == 0:
+		handler = allowMethods(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodGet {
+				listEventsV2(w, r, pathUserID)
+			} else {
+				createEventV2(w, r, pathUserID)
+			}
+		}, http.MethodGet, http.MethodPost)
+	case parts[1] == "events" && len(ids) == 1:
+		eventID := ids[0]
+		handler = allowMethods(func(w http.ResponseWriter, r *http.Request) {
+			switch r.Method {
+			case http.MethodGet:
+				getEventV2(w, r, pathUserID, eventID)
+			case http.MethodPut:
+				replaceEventV2(w, r, pathUserID, eventID)
+			case http.MethodPatch:
+				patchEventV2(w, r, pathUserID, eventID)
+			case http.MethodDelete:
+				deleteEventV2(w, r, pathUserID, eventID)
+			}
+		}, http.MethodGet, http.MethodPut, http.MethodPatch, http.MethodDelete)
+	case parts[1] == "invitations" && len(ids) == 0:
+		handler = allowMethods(func(w http.ResponseWriter, r *http.Request) {
+			listInvitationsV2(w, r, pathUserID)
+		}, http.MethodGet)
+	case parts[1] == "invitations" && len(ids) == 2:
+		handler = allowMethods(func(w http.ResponseWriter, r *http.Request) {
+			respondV2(w, r, pathUserID, ids[0], ids[1])
+		}, http.MethodPut)
+	case parts[1] == "webhooks" && len(ids) == 0:
+		handler = allowMethods(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodGet {
+				listWebhooksV2(w, pathUserID)
+			} else {
+				createWebhookV2(w, r, pathUserID)
+			}
+		}, http.MethodGet, http.MethodPost)
+	case parts[1] == "webhooks" && len(ids) == 1:
+		webhookID := ids[0]
+		handler = allowMethods(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodGet {
+				getWebhookV2(w, r, pathUserID, webhookID)
+			} else {
+				deleteWebhookV2(w, r, pathUserID, webhookID)
+			}
+		}, http.MethodGet, http.MethodDelete)
+	default:
+		getErrResponse(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	if !acceptsJSON(r) {
+		getErrResponse(w, "only application/json responses are supported", http.StatusNotAcceptable)
+		return
+	}
+
+	if _, status, err := authorize(r, pathUserID); err != nil {
+		getErrResponse(w, err.Error(), status)
+		return
+	}
+
+	handler(w, r)
+}
+
+// listEventsV2 экземпляры событий за даты [from, to] включительно в поясе tz.
+// q - поиск по словам, contains - по подстроке, order=desc - от поздних к ранним,
+// limit и cursor - постраничная выдача, курсор следующей страницы приходит в next_cursor
+func listEventsV2(w http.ResponseWriter, r *http.Request, userID int) {
+	q := r.URL.Query()
+	loc, err := loadLocation(q.Get("tz"))
+	if err != nil {
+		getErrResponse(w, fmt.Sprintf("invalid tz %q", q.Get("tz")), http.StatusBadRequest)
+		return
+	}
+
+	from, err := time.ParseInLocation(dateFormat, q.Get("from"), loc)
+	if err != nil {
+		getErrResponse(w, "invalid from", http.StatusBadRequest)
+		return
+	}
+	to, err := time.ParseInLocation(dateFormat, q.Get("to"), loc)
+	if err != nil || to.Before(from) {
+		getErrResponse(w, "invalid to", http.StatusBadRequest)
+		return
+	}
+
+	query := eventQuery{UserID: userID, From: from, To: to.AddDate(0, 0, 1),
+		Text: q.Get("q"), Contains: q.Get("contains")}
+
+	switch q.Get("order") {
+	case "", "asc":
+	case "desc":
+		query.Desc = true
+	default:
+		getErrResponse(w, "invalid order", http.StatusBadRequest)
+		return
+	}
+	if v := q.Get("limit"); v != "" {
+		if query.Limit, err = strconv.Atoi(v); err != nil || query.Limit <= 0 || query.Limit > maxQueryLimit {
+			getErrResponse(w, fmt.Sprintf("limit must be between 1 and %d", maxQueryLimit), http.StatusBadRequest)
+			return
+		}
+	}
+	if v := q.Get("cursor"); v != "" {
+		if query.After, err = decodeCursor(v); err != nil {
+			getErrResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	events, next, err := storage.query(query)
+	if err != nil {
+		getErrResponse(w, err.Error(), storageErrStatus(r, err))
+		return
+	}
+
+	resp := struct {
+		Result     string  `json:"result"`
+		Events     []Event `json:"events"`
+		NextCursor string  `json:"next_cursor,omitempty"`
+	}{Result: "Запрос успешно выполнен!", Events: events}
+	if next != nil {
+		resp.NextCursor = next.encode()
+	}
+
+	writeJSON(w, resp, http.StatusOK)
+}
+
+// createEventV2 создает событие, user_id и event_id в теле необязательны, если совпадают с путем
+func createEventV2(w http.ResponseWriter, r *http.Request, userID int) {
+	var ev Event
+	if err := decodeEvent(r, &ev); err != nil {
+		getErrResponse(w, err.Error(), decodeErrStatus(err))
+		return
+	}
+	if ev.UserID != 0 && ev.UserID != userID {
+		getErrResponse(w, "user_id doesn't match the path", http.StatusBadRequest)
+		return
+	}
+	ev.UserID = userID
+
+	saveEventV2(w, r, &ev, true)
+}
+
+// getEventV2 отдает событие с ETag, при совпадении If-None-Match - 304 без тела
+func getEventV2(w http.ResponseWriter, r *http.Request, userID, eventID int) {
+	ev, err := storage.getEvent(userID, eventID)
+	if err != nil {
+		getErrResponse(w, err.Error(), storageErrStatus(r, err))
+		return
+	}
+
+	w.Header().Set("ETag", etag(ev.Version))
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		for _, tag := range strings.Split(match, ",") {
+			tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+			if tag == "*" || tag == etag(ev.Version) {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		}
+	}
+
+	getResponse(w, "Запрос успешно выполнен!", []Event{*ev}, http.StatusOK)
+}
+
+// replaceEventV2 заменяет событие целиком, как /update_event
+func replaceEventV2(w http.ResponseWriter, r *http.Request, userID, eventID int) {
+	var ev Event
+	if err := decodeEvent(r, &ev); err != nil {
+		getErrResponse(w, err.Error(), decodeErrStatus(err))
+		return
+	}
+	if err := matchPath(&ev, userID, eventID); err != nil {
+		getErrResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var err error
+	if ev.Version, err = ifMatch(r, ev.Version); err != nil {
+		getErrResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	saveEventV2(w, r, &ev, false)
+}
+
+// patchEventV2 меняет только переданные поля. Если сдвинуто только начало, событие
+// переносится целиком с прежней длительностью
+func patchEventV2(w http.ResponseWriter, r *http.Request, userID, eventID int) {
+	stored, err := storage.getEvent(userID, eventID)
+	if err != nil {
+		getErrResponse(w, err.Error(), storageErrStatus(r, err))
+		return
+	}
+
+	ev := stored.clone()
+	ev.RecurrenceID = nil
+	if err := decodeEvent(r, &ev); err != nil {
+		getErrResponse(w, err.Error(), decodeErrStatus(err))
+		return
+	}
+	if err := matchPath(&ev, userID, eventID); err != nil {
+		getErrResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if ev.Version, err = ifMatch(r, ev.Version); err != nil {
+		getErrResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !ev.Date.Equal(stored.Date) && ev.Start.Equal(stored.Start) {
+		ev.Start = ev.Date
+	}
+	if !ev.Start.Equal(stored.Start) && ev.End.Equal(stored.End) {
+		ev.End = ev.Start.Add(stored.end().Sub(stored.start()))
+	}
+
+	saveEventV2(w, r, &ev, false)
+}
+
+func deleteEventV2(w http.ResponseWriter, r *http.Request, userID, eventID int) {
+	ev := Event{UserID: userID, EventID: eventID}
+
+	var err error
+	if ev.Version, err = ifMatch(r, 0); err != nil {
+		getErrResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if v := r.URL.Query().Get("recurrence_id"); v != "" {
+		loc, err := loadLocation(r.URL.Query().Get("tz"))
+		if err != nil {
+			getErrResponse(w, "invalid tz", http.StatusBadRequest)
+			return
+		}
+		t, err := parseTimeValue(v, loc)
+		if err != nil {
+			getErrResponse(w, "invalid recurrence_id", http.StatusBadRequest)
+			return
+		}
+		ev.RecurrenceID = &t
+	}
+
+	deleted, err := storage.Delete(&ev)
+	if err != nil {
+		getErrResponse(w, err.Error(), storageErrStatus(r, err))
+		return
+	}
+
+	getResponse(w, "Событие удалено!", []Event{*deleted}, http.StatusOK)
+}
+
+// matchPath подставляет идентификаторы из пути, расхождение с телом - ошибка входных данных
+func matchPath(ev *Event, userID, eventID int) error {
+	if ev.UserID != 0 && ev.UserID != userID {
+		return fmt.Errorf("user_id doesn't match the path")
+	}
+	if ev.EventID != 0 && ev.EventID != eventID {
+		return fmt.Errorf("event_id doesn't match the path")
+	}
+	ev.UserID, ev.EventID = userID, eventID
+	return nil
+}
+
+// saveEventV2 общая часть POST, PUT и PATCH: валидация, режим пересечений и сохранение
+func saveEventV2(w http.ResponseWriter, r *http.Request, ev *Event, create bool) {
+	if err := ev.validate(); err != nil {
+		getErrResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	mode, err := overlapMode(r)
+	if err != nil {
+		getErrResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	conflicts, err := saveEvent(storage, ev, create, mode)
+	if err != nil {
+		getOverlapErrResponse(w, err, storageErrStatus(r, err))
+		return
+	}
+
+	if create {
+		w.Header().Set("Location", eventsPath(ev.UserID, ev.EventID))
+		getOverlapResponse(w, "Событие успешно создано!", *ev, conflicts, http.StatusCreated)
+		return
+	}
+	getOverlapResponse(w, "Событие обновлено!", *ev, conflicts, http.StatusOK)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestAPIv2(t *testing.T) {
+	srv, _ := newTestServer(t)
+	alice := issueToken(t, srv, "1")
+	form := map[string]string{"Content-Type": "application/x-www-form-urlencoded"}
+	events := srv.URL + "/users/1/events"
+
+	// Создание формой, как в исходном задании
+	status, res := doRequest(t, http.MethodPost, events, alice,
+		"event_id=1&title=standup&start=2019-09-09T10:00&end=2019-09-09T10:30&time_zone=Europe/Moscow", form)
+	if status != http.StatusCreated {
+		t.Fatalf("create failed: %v %v", status, res)
+	}
+
+	tests := []struct {
+		name    string
+		method  string
+		path    string
+		body    string
+		headers map[string]string
+		want    int
+	}{
+		{"list", http.MethodGet, "/users/1/events?from=2019-09-09&to=2019-09-09", "", nil, http.StatusOK},
+		{"list without range", http.MethodGet, "/users/1/events", "", nil, http.StatusBadRequest},
+		{"get", http.MethodGet, "/users/1/events/1", "", nil, http.StatusOK},
+		{"get missing", http.MethodGet, "/users/1/events/2", "", nil, http.StatusServiceUnavailable},
+		{"foreign user", http.MethodGet, "/users/2/events/1", "", nil, http.StatusForbidden},
+		{"bad event id", http.MethodGet, "/users/1/events/abc", "", nil, http.StatusBadRequest},
+		{"unknown path", http.MethodGet, "/users/1/todos", "", nil, http.StatusNotFound},
+		{"collection method", http.MethodDelete, "/users/1/events", "", nil, http.StatusMethodNotAllowed},
+		{"item method", http.MethodPost, "/users/1/events/1", "", nil, http.StatusMethodNotAllowed},
+		{"legacy method", http.MethodGet, "/create_event", "", nil, http.StatusMethodNotAllowed},
+		{"duplicate", http.MethodPost, "/users/1/events", `{"event_id": 1, "title": "x", "date": "2019-09-09T00:00:00Z"}`, nil, http.StatusServiceUnavailable},
+		{"broken json", http.MethodPost, "/users/1/events", `{"event_id": `, nil, http.StatusBadRequest},
+		{"bad form int", http.MethodPost, "/users/1/events", "event_id=x&title=x&date=2019-09-09", form, http.StatusBadRequest},
+		{"id mismatch", http.MethodPut, "/users/1/events/1", `{"event_id": 5, "title": "x", "date": "2019-09-09T00:00:00Z"}`, nil, http.StatusBadRequest},
+		{"media type", http.MethodPost, "/users/1/events", "x", map[string]string{"Content-Type": "text/plain"}, http.StatusUnsupportedMediaType},
+		{"not acceptable", http.MethodGet, "/users/1/events/1", "", map[string]string{"Accept": "text/html"}, http.StatusNotAcceptable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if status, res := doRequest(t, tt.method, srv.URL+tt.path, alice, tt.body, tt.headers); status != tt.want {
+				t.Errorf("got %v %v, want %v", status, res, tt.want)
+			}
+		})
+	}
+
+	// PATCH переносит событие с сохранением длительности
+	status, res = doRequest(t, http.MethodPatch, events+"/1", alice, `{"start": "2019-09-10T12:00:00+03:00"}`, nil)
+	if status != http.StatusOK {
+		t.Fatalf("patch failed: %v %v", status, res)
+	}
+	ev := res["events"].([]interface{})[0].(map[string]interface{})
+	if ev["title"] != "standup" || ev["end"] != "2019-09-10T12:30:00+03:00" {
+		t.Errorf("unexpected patched event %v", ev)
+	}
+
+	// PUT формой заменяет событие целиком
+	status, res = doRequest(t, http.MethodPut, events+"/1", alice, "title=retro&date=2019-09-11", form)
+	if status != http.StatusOK {
+		t.Fatalf("put failed: %v %v", status, res)
+	}
+
+	// Старые методы видят изменения v2
+	status, res = doRequest(t, http.MethodGet, srv.URL+"/events_for_day?date=2019-09-11", alice, "", nil)
+	if status != http.StatusOK || len(res["events"].([]interface{})) != 1 {
+		t.Errorf("legacy day query: %v %v", status, res)
+	}
+
+	if status, res := doRequest(t, http.MethodDelete, events+"/1", alice, "", nil); status != http.StatusOK {
+		t.Fatalf("delete failed: %v %v", status, res)
+	}
+	if status, _ := doRequest(t, http.MethodDelete, events+"/1", alice, "", nil); status != http.StatusServiceUnavailable {
+		t.Errorf("second delete must fail with 503, got %v", status)
+	}
+}
+
+func TestLegacyFormEncoded(t *testing.T) {
+	srv, _ := newTestServer(t)
+	alice := issueToken(t, srv, "1")
+	form := map[string]string{"Content-Type": "application/x-www-form-urlencoded"}
+
+	body := "user_id=1&event_id=3&title=lunch&date=2019-09-09&freq=weekly&by_day=MO,WE&count=4"
+	if status, res := doRequest(t, http.MethodPost, srv.URL+"/create_event", alice, body, form); status != http.StatusCreated {
+		t.Fatalf("create failed: %v %v", status, res)
+	}
+
+	status, res := doRequest(t, http.MethodGet, srv.URL+"/events_for_week?date=2019-09-09", alice, "", nil)
+	if status != http.StatusOK || len(res["events"].([]interface{})) != 2 {
+		t.Errorf("expected two occurrences, got %v %v", status, res)
+	}
+
+	if status, _ := doRequest(t, http.MethodPost, srv.URL+"/delete_event", alice, "user_id=x", form); status != http.StatusBadRequest {
+		t.Errorf("invalid int must give 400, got %v", status)
+	}
+}
+
+func TestStorageFailureIs500(t *testing.T) {
+	srv, _ := newTestServer(t)
+	alice := issueToken(t, srv, "1")
+	storage.(*MemoryStorage).journal = func(journalRecord) error { return errors.New("disk is full") }
+
+	status, res := doRequest(t, http.MethodPost, srv.URL+"/users/1/events", alice,
+		`{"event_id": 1, "title": "x", "date": "2019-09-09T00:00:00Z"}`, nil)
+	if status != http.StatusInternalServerError {
+		t.Errorf("got %v %v, want 500", status, res)
+	}
+}
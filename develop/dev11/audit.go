@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const auditFileName = "audit.log"
+
+// Операции в истории события
+const (
+	auditCreate = "create"
+	auditUpdate = "update"
+	auditDelete = "delete"
+	// auditRestore возвращение события из корзины
+	auditRestore = "restore"
+)
+
+// auditIgnored поля, которые меняются при каждой правке и не несут смысла в diff
+var auditIgnored = map[string]bool{"version": true, "updated_by": true, "updated_at": true}
+
+// fieldChange изменение одного поля события
+type fieldChange struct {
+	Field  string          `json:"field"`
+	Before json.RawMessage `json:"before,omitempty"`
+	After  json.RawMessage `json:"after,omitempty"`
+}
+
+// auditEntry запись истории: кто, когда и как изменил событие
+type auditEntry struct {
+	Seq     int           `json:"seq"`
+	UserID  int           `json:"user_id"`
+	EventID int           `json:"event_id"`
+	Op      string        `json:"op"`
+	Actor   int           `json:"actor"`
+	At      time.Time     `json:"at"`
+	Before  *Event        `json:"before,omitempty"`
+	After   *Event        `json:"after,omitempty"`
+	Diff    []fieldChange `json:"diff,omitempty"`
+}
+
+// AuditLog история изменений всех событий. Получает изменения как наблюдатель хранилища
+// и дописывает их в файл, история удаленных событий тоже сохраняется
+type AuditLog struct {
+	mu      sync.Mutex
+	entries map[eventKey][]auditEntry
+	seq     int
+	// maxEventID наибольший EventID в истории, в том числе удаленных событий
+	maxEventID int
+	file       *os.File
+	now        func() time.Time
+}
+
+// openAuditLog загружает историю из path, пустой path - история только в памяти
+func openAuditLog(path string) (*AuditLog, error) {
+	a := &AuditLog{entries: make(map[eventKey][]auditEntry), now: time.Now}
+	if path == "" {
+		return a, nil
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("can't open audit log: %w", err)
+	}
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 16<<20)
+	for scanner.Scan() {
+		var entry auditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			// Последняя запись могла не дописаться при падении
+			log.Printf("audit: skipping broken record: %v", err)
+			continue
+		}
+		a.add(entry)
+	}
+	if err := scanner.Err(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("can't read audit log: %w", err)
+	}
+
+	a.file = f
+	return a, nil
+}
+
+// add добавляет запись в память, вызывается под блокировкой или при загрузке
+func (a *AuditLog) add(entry auditEntry) {
+	key := eventKey{entry.UserID, entry.EventID}
+	a.entries[key] = append(a.entries[key], entry)
+	if entry.Seq > a.seq {
+		a.seq = entry.Seq
+	}
+	if entry.EventID > a.maxEventID {
+		a.maxEventID = entry.EventID
+	}
+}
+
+// record наблюдатель хранилища. Вызывается под блокировкой хранилища,
+// поэтому пишет в файл без fsync: историю не ждут, в отличие от журнала событий
+func (a *AuditLog) record(change storageChange) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	ev := change.Event
+	entry := auditEntry{Seq: a.seq + 1, UserID: ev.UserID, EventID: ev.EventID, Actor: ev.UpdatedBy, At: ev.UpdatedAt}
+	switch {
+	case change.Op == opDelete:
+		// Удалить событие может только владелец
+		entry.Op, entry.Actor, entry.At = auditDelete, ev.UserID, a.now()
+		entry.Before = &ev
+	case change.Op == opRestore:
+		entry.Op, entry.After = auditRestore, &ev
+	case change.Prev == nil:
+		entry.Op, entry.After = auditCreate, &ev
+	default:
+		entry.Op, entry.Before, entry.After = auditUpdate, change.Prev, &ev
+	}
+	if entry.At.IsZero() {
+		entry.At = a.now()
+	}
+	entry.Diff = diffEvents(entry.Before, entry.After)
+
+	a.add(entry)
+
+	if a.file != nil {
+		line, err := json.Marshal(entry)
+		if err == nil {
+			_, err = a.file.Write(append(line, '\n'))
+		}
+		if err != nil {
+			log.Printf("audit: can't write record: %v", err)
+		}
+	}
+}
+
+// history записи о событии по порядку
+func (a *AuditLog) history(userID, eventID int) []auditEntry {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return append([]auditEntry(nil), a.entries[eventKey{userID, eventID}]...)
+}
+
+// lastEventID наибольший EventID, встречавшийся в истории
+func (a *AuditLog) lastEventID() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.maxEventID
+}
+
+func (a *AuditLog) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.file == nil {
+		return nil
+	}
+	if err := a.file.Sync(); err != nil {
+		a.file.Close()
+		return err
+	}
+	return a.file.Close()
+}
+
+// diffEvents изменения полей события по JSON-представлению, nil означает отсутствие события
+func diffEvents(before, after *Event) []fieldChange {
+	fields := func(ev *Event) map[string]json.RawMessage {
+		res := make(map[string]json.RawMessage)
+		if ev == nil {
+			return res
+		}
+		data, _ := json.Marshal(ev)
+		json.Unmarshal(data, &res)
+		return res
+	}
+	b, a := fields(before), fields(after)
+
+	names := make(map[string]bool)
+	for name := range b {
+		names[name] = true
+	}
+	for name := range a {
+		names[name] = true
+	}
+
+	var res []fieldChange
+	for name := range names {
+		if auditIgnored[name] || bytes.Equal(b[name], a[name]) {
+			continue
+		}
+		res = append(res, fieldChange{Field: name, Before: b[name], After: a[name]})
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Field < res[j].Field })
+	return res
+}
+
+// audit история изменений событий, реализация выбирается в main
+var audit, _ = openAuditLog("")
+
+// historyV2 GET /users/{id}/events/{eventID}/history
+func historyV2(w http.ResponseWriter, userID, eventID int) {
+	entries := audit.history(userID, eventID)
+	if len(entries) == 0 {
+		getErrResponse(w, fmt.Sprintf("no history for event %v of user %v", eventID, userID), http.StatusServiceUnavailable)
+		return
+	}
+
+	resp := struct {
+		Result  string       `json:"result"`
+		History []auditEntry `json:"history"`
+	}{Result: "Запрос успешно выполнен!", History: entries}
+
+	writeJSON(w, resp, http.StatusOK)
+}
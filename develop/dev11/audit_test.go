@@ -0,0 +1,134 @@
+package main
+
+import (
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestServerGeneratedIDs(t *testing.T) {
+	dir := t.TempDir()
+	fs, err := openFileStorage(dir, 1000, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auditLog, err := openAuditLog(filepath.Join(dir, auditFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs.subscribe(auditLog.record)
+
+	var ids []int
+	for _, userID := range []int{1, 2, 1} {
+		ev := Event{UserID: userID, Title: "auto", Start: mustTime(t, "2019-09-09T10:00:00Z")}
+		ev.validate()
+		if err := fs.Create(&ev); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, ev.EventID)
+	}
+	if ids[0] != 1 || ids[1] != 2 || ids[2] != 3 {
+		t.Fatalf("unexpected ids %v", ids)
+	}
+
+	// Событие с последним id удалено: после перезапуска id не выдается повторно
+	if _, err := fs.Delete(&Event{UserID: 1, EventID: 3}); err != nil {
+		t.Fatal(err)
+	}
+	fs.Close()
+	auditLog.Close()
+
+	fs, err = openFileStorage(dir, 1000, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+	if auditLog, err = openAuditLog(filepath.Join(dir, auditFileName)); err != nil {
+		t.Fatal(err)
+	}
+	defer auditLog.Close()
+	fs.reserveEventIDs(auditLog.lastEventID())
+
+	ev := Event{UserID: 1, Title: "after restart", Start: mustTime(t, "2019-09-09T10:00:00Z")}
+	ev.validate()
+	if err := fs.Create(&ev); err != nil {
+		t.Fatal(err)
+	}
+	if ev.EventID != 4 {
+		t.Errorf("got id %v after restart, want 4", ev.EventID)
+	}
+	if h := auditLog.history(1, 3); len(h) != 2 || h[1].Op != auditDelete {
+		t.Errorf("history of deleted event must survive restart: %+v", h)
+	}
+}
+
+func TestHistoryEndpoint(t *testing.T) {
+	srv, _ := newTestServer(t)
+	alice := issueToken(t, srv, "1")
+	bob := issueToken(t, srv, "2")
+
+	status, res := doRequest(t, http.MethodPost, srv.URL+"/users/1/events", alice,
+		`{"title": "review", "start": "2019-09-09T10:00:00Z", "attendees": [{"user_id": 2}]}`, nil)
+	if status != http.StatusCreated {
+		t.Fatalf("create failed: %v %v", status, res)
+	}
+	id := int(res["events"].([]interface{})[0].(map[string]interface{})["event_id"].(float64))
+	if id <= 0 {
+		t.Fatalf("server must assign event_id, got %v", id)
+	}
+	path := eventsPath(1, id)
+
+	if status, res := doRequest(t, http.MethodPatch, srv.URL+path, alice, `{"start": "2019-09-09T12:00:00Z"}`, nil); status != http.StatusOK {
+		t.Fatalf("patch failed: %v %v", status, res)
+	}
+	if status, res := doRequest(t, http.MethodPut, srv.URL+"/users/2/invitations/1/"+strconv.Itoa(id), bob, `{"status": "accepted"}`, nil); status != http.StatusOK {
+		t.Fatalf("rsvp failed: %v %v", status, res)
+	}
+	if status, res := doRequest(t, http.MethodDelete, srv.URL+path, alice, "", nil); status != http.StatusOK {
+		t.Fatalf("delete failed: %v %v", status, res)
+	}
+
+	status, res = doRequest(t, http.MethodGet, srv.URL+path+"/history", alice, "", nil)
+	if status != http.StatusOK {
+		t.Fatalf("history: %v %v", status, res)
+	}
+	history := res["history"].([]interface{})
+	want := []struct {
+		op     string
+		actor  float64
+		fields string
+	}{
+		{auditCreate, 1, ""},
+		{auditUpdate, 1, "date end start"},
+		{auditUpdate, 2, "attendees"},
+		{auditDelete, 1, ""},
+	}
+	if len(history) != len(want) {
+		t.Fatalf("got %v entries: %v", len(history), history)
+	}
+	for i, w := range want {
+		entry := history[i].(map[string]interface{})
+		if entry["op"] != w.op || entry["actor"] != w.actor {
+			t.Errorf("entry %v: got %v by %v, want %v by %v", i, entry["op"], entry["actor"], w.op, w.actor)
+		}
+		if w.fields == "" {
+			continue
+		}
+		fields := ""
+		for _, d := range entry["diff"].([]interface{}) {
+			if fields != "" {
+				fields += " "
+			}
+			fields += d.(map[string]interface{})["field"].(string)
+		}
+		if fields != w.fields {
+			t.Errorf("entry %v: diff of %q, want %q", i, fields, w.fields)
+		}
+	}
+
+	if status, _ := doRequest(t, http.MethodGet, srv.URL+path+"/history", bob, "", nil); status != http.StatusForbidden {
+		t.Errorf("foreign history: got %v, want 403", status)
+	}
+}
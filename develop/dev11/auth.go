@@ -0,0 +1,463 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+Аутентификация: клиенты получают bearer-токены вида base64url(claims).base64url(HMAC-SHA256).
+Подпись защищает от подделки, а реестр выданных токенов позволяет их отзывать.
+Выдать токен для любого пользователя может администратор (заголовок X-Admin-Key),
+пользователь с действующим токеном может выпустить себе еще один и отозвать свои.
+*/
+
+const (
+	tokensFileName  = "tokens.json"
+	defaultTokenTTL = 30 * 24 * time.Hour
+	adminKeyHeader  = "X-Admin-Key"
+)
+
+var (
+	errNoCredentials = errors.New("missing bearer token")
+	errInvalidToken  = errors.New("invalid or revoked token")
+	errForbidden     = errors.New("access to another user's calendar is forbidden")
+)
+
+// tokenClaims содержимое токена
+type tokenClaims struct {
+	UserID    int    `json:"uid"`
+	ID        string `json:"jti"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// issuedToken запись реестра выданных токенов
+type issuedToken struct {
+	ID        string    `json:"token_id"`
+	UserID    int       `json:"user_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Revoked   bool      `json:"revoked,omitempty"`
+}
+
+// TokenStore выдает, проверяет и отзывает токены
+type TokenStore struct {
+	secret   []byte
+	adminKey string
+	path     string // "" - реестр не сохраняется между запусками
+
+	mu     sync.Mutex
+	issued map[string]issuedToken
+
+	now func() time.Time
+}
+
+// Конструктор хранилища токенов, реестр загружается из path, если он задан
+func newTokenStore(secret []byte, adminKey, path string) (*TokenStore, error) {
+	ts := &TokenStore{
+		secret:   secret,
+		adminKey: adminKey,
+		path:     path,
+		issued:   make(map[string]issuedToken),
+		now:      time.Now,
+	}
+	if path == "" {
+		return ts, nil
+	}
+
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return ts, nil
+	case err != nil:
+		return nil, fmt.Errorf("can't read tokens: %w", err)
+	}
+	var list []issuedToken
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("corrupted tokens file: %w", err)
+	}
+	for _, t := range list {
+		ts.issued[t.ID] = t
+	}
+	return ts, nil
+}
+
+// newTokenStoreFromEnv читает AUTH_SECRET и AUTH_ADMIN_KEY. Без секрета он генерируется
+// при старте и токены не переживут перезапуск. Без ключа администратора сервер не
+// запускается: сгенерированный ключ пришлось бы передать через лог
+func newTokenStoreFromEnv(path string) (*TokenStore, error) {
+	secret := os.Getenv("AUTH_SECRET")
+	if secret == "" {
+		var err error
+		if secret, err = randomHex(32); err != nil {
+			return nil, err
+		}
+		log.Printf("auth: AUTH_SECRET is not set, issued tokens will be invalid after restart")
+	}
+
+	adminKey := os.Getenv("AUTH_ADMIN_KEY")
+	if adminKey == "" {
+		return nil, errors.New("auth: AUTH_ADMIN_KEY is not set")
+	}
+
+	return newTokenStore([]byte(secret), adminKey, path)
+}
+
+// randomHex возвращает n случайных байт в hex
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func (ts *TokenStore) sign(payload string) string {
+	mac := hmac.New(sha256.New, ts.secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// issue выпускает токен пользователю на ttl
+func (ts *TokenStore) issue(userID int, ttl time.Duration) (string, issuedToken, error) {
+	id, err := randomHex(16)
+	if err != nil {
+		return "", issuedToken{}, err
+	}
+	now := ts.now()
+	claims := tokenClaims{UserID: userID, ID: id, IssuedAt: now.Unix(), ExpiresAt: now.Add(ttl).Unix()}
+	data, err := json.Marshal(claims)
+	if err != nil {
+		return "", issuedToken{}, err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(data)
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	record := issuedToken{ID: id, UserID: userID, ExpiresAt: time.Unix(claims.ExpiresAt, 0).UTC()}
+	ts.issued[id] = record
+	if err := ts.save(); err != nil {
+		delete(ts.issued, id)
+		return "", issuedToken{}, err
+	}
+
+	return payload + "." + ts.sign(payload), record, nil
+}
+
+// verify проверяет подпись, срок действия и отзыв токена
+func (ts *TokenStore) verify(token string) (tokenClaims, error) {
+	var claims tokenClaims
+
+	payload, sig, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(ts.sign(payload))) {
+		return claims, errInvalidToken
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return claims, errInvalidToken
+	}
+	if err := json.Unmarshal(data, &claims); err != nil {
+		return claims, errInvalidToken
+	}
+	if ts.now().Unix() >= claims.ExpiresAt {
+		return claims, errInvalidToken
+	}
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	record, ok := ts.issued[claims.ID]
+	if !ok || record.Revoked || record.UserID != claims.UserID {
+		return claims, errInvalidToken
+	}
+	return claims, nil
+}
+
+// revoke отзывает токен. Пользователь может отозвать только свои токены, администратор - любые
+func (ts *TokenStore) revoke(id string, userID int, admin bool) error {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	record, ok := ts.issued[id]
+	if !ok || (!admin && record.UserID != userID) {
+		return fmt.Errorf("token %q not found", id)
+	}
+	record.Revoked = true
+	ts.issued[id] = record
+	if err := ts.save(); err != nil {
+		record.Revoked = false
+		ts.issued[id] = record
+		return err
+	}
+	return nil
+}
+
+// list возвращает действующие токены пользователя
+func (ts *TokenStore) list(userID int) []issuedToken {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	res := make([]issuedToken, 0)
+	now := ts.now()
+	for _, t := range ts.issued {
+		if t.UserID == userID && !t.Revoked && now.Before(t.ExpiresAt) {
+			res = append(res, t)
+		}
+	}
+	return res
+}
+
+// save атомарно перезаписывает реестр. Истекшие токены выбрасываются и без файла,
+// иначе реестр в памяти рос бы без предела. Вызывается под ts.mu
+func (ts *TokenStore) save() error {
+	now := ts.now()
+	list := make([]issuedToken, 0, len(ts.issued))
+	for id, t := range ts.issued {
+		if !now.Before(t.ExpiresAt) {
+			delete(ts.issued, id)
+			continue
+		}
+		list = append(list, t)
+	}
+	if ts.path == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(list)
+	if err != nil {
+		return err
+	}
+	tmp := ts.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("can't write tokens: %w", err)
+	}
+	return os.Rename(tmp, ts.path)
+}
+
+// isAdmin проверяет административный ключ запроса
+func (ts *TokenStore) isAdmin(r *http.Request) bool {
+	key := r.Header.Get(adminKeyHeader)
+	return key != "" && subtle.ConstantTimeCompare([]byte(key), []byte(ts.adminKey)) == 1
+}
+
+// ===== Middleware =====
+
+type authKey struct{}
+
+// Auth - middleware аутентификации, кладет claims bearer-токена в контекст запроса
+type Auth struct {
+	handler http.Handler
+	tokens  *TokenStore
+	public  map[string]bool
+}
+
+// Конструктор middleware, запросы к public пропускаются без токена
+func newAuth(handler http.Handler, tokens *TokenStore, public ...string) *Auth {
+	a := &Auth{handler: handler, tokens: tokens, public: make(map[string]bool)}
+	for _, p := range public {
+		a.public[p] = true
+	}
+	return a
+}
+
+// ServeHTTP логика хэндлера, опишем этот метод, чтобы удовлетворить интерфейсу
+func (a *Auth) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token := bearerToken(r)
+	if token == "" {
+		if a.public[r.URL.Path] {
+			a.handler.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("WWW-Authenticate", `Bearer realm="calendar"`)
+		getErrResponse(w, errNoCredentials.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	claims, err := a.tokens.verify(token)
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="calendar", error="invalid_token"`)
+		getErrResponse(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	if info := requestInfoFrom(r.Context()); info != nil {
+		info.userID = claims.UserID
+	}
+	a.handler.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), authKey{}, claims)))
+}
+
+// bearerToken токен из заголовка Authorization, пустая строка - если его нет
+func bearerToken(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	if len(header) > 7 && strings.EqualFold(header[:7], "Bearer ") {
+		return strings.TrimSpace(header[7:])
+	}
+	return ""
+}
+
+// credentials возвращает claims аутентифицированного запроса
+func credentials(r *http.Request) (tokenClaims, bool) {
+	claims, ok := r.Context().Value(authKey{}).(tokenClaims)
+	return claims, ok
+}
+
+// authorize возвращает пользователя из учетных данных запроса.
+// Явно переданный user_id допускается только если совпадает с ним
+func authorize(r *http.Request, claimed int) (int, int, error) {
+	claims, ok := credentials(r)
+	if !ok {
+		return 0, http.StatusUnauthorized, errNoCredentials
+	}
+	if claimed != 0 && claimed != claims.UserID {
+		return 0, http.StatusForbidden, errForbidden
+	}
+	return claims.UserID, 0, nil
+}
+
+// authorizeQuery то же для user_id из строки запроса
+func authorizeQuery(r *http.Request) (int, int, error) {
+	claimed := 0
+	if v := r.URL.Query().Get("user_id"); v != "" {
+		var err error
+		if claimed, err = strconv.Atoi(v); err != nil {
+			return 0, http.StatusBadRequest, err
+		}
+	}
+	return authorize(r, claimed)
+}
+
+// ===== Обработчики =====
+
+// IssueTokenHandler /auth/token handler. Администратор выпускает токен для user_id
+// с любым сроком, пользователь с токеном - дополнительный токен для себя, который
+// истекает не позже токена запроса: иначе истечение и отзыв обходились бы продлением
+func (ts *TokenStore) IssueTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		UserID int    `json:"user_id"`
+		TTL    string `json:"ttl"`
+	}
+	if err := decodeBody(r, &req); err != nil {
+		getErrResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	userID := req.UserID
+	admin := ts.isAdmin(r)
+	if !admin {
+		var status int
+		var err error
+		if userID, status, err = authorize(r, req.UserID); err != nil {
+			getErrResponse(w, err.Error(), status)
+			return
+		}
+	}
+	if userID <= 0 {
+		getErrResponse(w, "invalid user_id", http.StatusBadRequest)
+		return
+	}
+
+	ttl := defaultTokenTTL
+	if req.TTL != "" {
+		var err error
+		if ttl, err = time.ParseDuration(req.TTL); err != nil || ttl <= 0 {
+			getErrResponse(w, "invalid ttl", http.StatusBadRequest)
+			return
+		}
+	}
+	if claims, ok := credentials(r); ok && !admin {
+		if left := time.Unix(claims.ExpiresAt, 0).Sub(ts.now()); ttl > left {
+			ttl = left
+		}
+	}
+
+	token, record, err := ts.issue(userID, ttl)
+	if err != nil {
+		getErrResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	resp := struct {
+		Result string `json:"result"`
+		Token  string `json:"token"`
+		issuedToken
+	}{Result: "Токен выпущен!", Token: token, issuedToken: record}
+
+	writeJSON(w, resp, http.StatusCreated)
+}
+
+// RevokeTokenHandler /auth/revoke handler. Без token_id отзывает токен самого запроса
+func (ts *TokenStore) RevokeTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		TokenID string `json:"token_id"`
+	}
+	if err := decodeBody(r, &req); err != nil {
+		getErrResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	admin := ts.isAdmin(r)
+	claims, ok := credentials(r)
+	if !ok && !admin {
+		getErrResponse(w, errNoCredentials.Error(), http.StatusUnauthorized)
+		return
+	}
+	if req.TokenID == "" {
+		req.TokenID = claims.ID
+	}
+	if req.TokenID == "" {
+		getErrResponse(w, "invalid token_id", http.StatusBadRequest)
+		return
+	}
+
+	if err := ts.revoke(req.TokenID, claims.UserID, admin); err != nil {
+		getErrResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, struct {
+		Result string `json:"result"`
+	}{Result: "Токен отозван!"}, http.StatusOK)
+}
+
+// ListTokensHandler /auth/tokens handler, действующие токены текущего пользователя
+func (ts *TokenStore) ListTokensHandler(w http.ResponseWriter, r *http.Request) {
+	userID, status, err := authorizeQuery(r)
+	if err != nil {
+		getErrResponse(w, err.Error(), status)
+		return
+	}
+
+	writeJSON(w, struct {
+		Result string        `json:"result"`
+		Tokens []issuedToken `json:"tokens"`
+	}{Result: "Запрос успешно выполнен!", Tokens: ts.list(userID)}, http.StatusOK)
+}
+
+// decodeBody декодирует необязательное JSON-тело запроса
+func decodeBody(r *http.Request, v interface{}) error {
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(r.Body); err != nil {
+		return err
+	}
+	if len(bytes.TrimSpace(buf.Bytes())) == 0 {
+		return nil
+	}
+	return json.Unmarshal(buf.Bytes(), v)
+}
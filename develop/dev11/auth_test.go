@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testAdminKey = "admin-key"
+
+// newTestServer поднимает API поверх чистого хранилища в памяти
+func newTestServer(t *testing.T) (*httptest.Server, *TokenStore) {
+	t.Helper()
+	storage = newMemoryStorage()
+	audit, _ = openAuditLog("")
+	storage.subscribe(audit.record)
+	if webhooks != nil {
+		webhooks.Close()
+	}
+	hooks, _ := openWebhooks("")
+	// Получатели в тестах слушают 127.0.0.1, в рабочем клиенте такие адреса запрещены
+	hooks.client = &http.Client{Timeout: defaultWebhookTimeout}
+	t.Cleanup(func() { hooks.Close() })
+	webhooks = hooks
+	storage.subscribe(webhooks.record)
+	analytics = newAnalyticsCache()
+	storage.subscribe(analytics.record)
+	tokens, err := newTokenStore([]byte("secret"), testAdminKey, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(newRouter(tokens, defaultLimits()))
+	t.Cleanup(srv.Close)
+	return srv, tokens
+}
+
+// doRequest выполняет запрос и декодирует JSON-ответ
+func doRequest(t *testing.T, method, url, token, body string, headers map[string]string) (int, map[string]interface{}) {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if body != "" && req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var res map[string]interface{}
+	json.NewDecoder(resp.Body).Decode(&res)
+	return resp.StatusCode, res
+}
+
+// issueToken выпускает токен пользователю через API с ключом администратора
+func issueToken(t *testing.T, srv *httptest.Server, userID string) string {
+	t.Helper()
+	status, res := doRequest(t, http.MethodPost, srv.URL+"/auth/token", "",
+		`{"user_id": `+userID+`}`, map[string]string{adminKeyHeader: testAdminKey})
+	if status != http.StatusCreated {
+		t.Fatalf("can't issue token: %v %v", status, res)
+	}
+	return res["token"].(string)
+}
+
+func TestAuthorization(t *testing.T) {
+	srv, _ := newTestServer(t)
+	alice := issueToken(t, srv, "1")
+	bob := issueToken(t, srv, "2")
+
+	// Без токена и с чужим ключом администратора токен не выдается
+	if status, _ := doRequest(t, http.MethodPost, srv.URL+"/auth/token", "", `{"user_id": 1}`,
+		map[string]string{adminKeyHeader: "wrong"}); status != http.StatusUnauthorized {
+		t.Errorf("expected 401 for wrong admin key, got %v", status)
+	}
+
+	body := `{"event_id": 1, "title": "secret meeting", "date": "2019-09-09T10:00:00Z"}`
+	if status, res := doRequest(t, http.MethodPost, srv.URL+"/create_event", alice, body, nil); status != http.StatusCreated {
+		t.Fatalf("create failed: %v %v", status, res)
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		token  string
+		body   string
+		want   int
+	}{
+		{"no token", http.MethodGet, "/events_for_day?date=2019-09-09", "", "", http.StatusUnauthorized},
+		{"forged token", http.MethodGet, "/events_for_day?date=2019-09-09", alice + "x", "", http.StatusUnauthorized},
+		{"own calendar", http.MethodGet, "/events_for_day?date=2019-09-09", alice, "", http.StatusOK},
+		{"own user_id", http.MethodGet, "/events_for_day?user_id=1&date=2019-09-09", alice, "", http.StatusOK},
+		{"foreign user_id", http.MethodGet, "/events_for_day?user_id=1&date=2019-09-09", bob, "", http.StatusForbidden},
+		{"foreign delete", http.MethodPost, "/delete_event", bob, `{"user_id": 1, "event_id": 1}`, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if status, res := doRequest(t, tt.method, srv.URL+tt.path, tt.token, tt.body, nil); status != tt.want {
+				t.Errorf("got %v %v, want %v", status, res, tt.want)
+			}
+		})
+	}
+
+	// Без user_id удаление применяется к календарю владельца токена: у Боба такого события нет
+	if status, _ := doRequest(t, http.MethodPost, srv.URL+"/delete_event", bob, `{"event_id": 1}`, nil); status == http.StatusOK {
+		t.Error("bob must not delete alice's event")
+	}
+
+	// Отзыв текущего токена
+	if status, res := doRequest(t, http.MethodPost, srv.URL+"/auth/revoke", alice, "", nil); status != http.StatusOK {
+		t.Fatalf("revoke failed: %v %v", status, res)
+	}
+	if status, _ := doRequest(t, http.MethodGet, srv.URL+"/events_for_day?date=2019-09-09", alice, "", nil); status != http.StatusUnauthorized {
+		t.Errorf("revoked token must be rejected, got %v", status)
+	}
+}
+
+// TestTokenStoreFromEnv без ключа администратора сервер не запускается
+func TestTokenStoreFromEnv(t *testing.T) {
+	t.Setenv("AUTH_SECRET", "secret")
+	t.Setenv("AUTH_ADMIN_KEY", "")
+	if _, err := newTokenStoreFromEnv(""); err == nil {
+		t.Fatal("token store without admin key")
+	}
+
+	t.Setenv("AUTH_ADMIN_KEY", testAdminKey)
+	ts, err := newTokenStoreFromEnv("")
+	if err != nil || ts.adminKey != testAdminKey {
+		t.Fatalf("admin key from env: %v", err)
+	}
+}
+
+// TestIssueTokenTTL свой токен не переживает токен запроса, срок любой - только у администратора
+func TestIssueTokenTTL(t *testing.T) {
+	srv, tokens := newTestServer(t)
+	short, record, err := tokens.issue(1, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status, res := doRequest(t, http.MethodPost, srv.URL+"/auth/token", short, `{"ttl": "1000h"}`, nil)
+	if status != http.StatusCreated {
+		t.Fatalf("issue: %v %v", status, res)
+	}
+	expires, _ := time.Parse(time.RFC3339, res["expires_at"].(string))
+	if expires.After(record.ExpiresAt) {
+		t.Errorf("renewed token expires at %v, after the caller's %v", expires, record.ExpiresAt)
+	}
+
+	status, res = doRequest(t, http.MethodPost, srv.URL+"/auth/token", "", `{"user_id": 1, "ttl": "1000h"}`,
+		map[string]string{adminKeyHeader: testAdminKey})
+	if status != http.StatusCreated {
+		t.Fatalf("admin issue: %v %v", status, res)
+	}
+	if expires, _ := time.Parse(time.RFC3339, res["expires_at"].(string)); expires.Before(time.Now().Add(999 * time.Hour)) {
+		t.Errorf("admin ttl is capped: %v", expires)
+	}
+}
+
+// TestTokenStorePrunes истекшие токены удаляются и без файла реестра
+func TestTokenStorePrunes(t *testing.T) {
+	ts, err := newTokenStore([]byte("secret"), testAdminKey, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	ts.now = func() time.Time { return now }
+	for i := 0; i < 3; i++ {
+		ts.issue(1, time.Minute)
+	}
+	now = now.Add(time.Hour)
+	ts.issue(1, time.Minute)
+	if len(ts.issued) != 1 {
+		t.Errorf("expired tokens are kept: %d", len(ts.issued))
+	}
+}
@@ -0,0 +1,209 @@
+// Package client - типизированный клиент HTTP API сервера календаря (develop/dev11).
+//
+//	c, err := client.New("http://localhost:8080", client.WithToken(token))
+//	res, err := c.CreateEvent(ctx, &client.Event{Title: "standup", Start: start, End: end})
+//	if errors.Is(err, client.ErrConflict) { ... }
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	adminKeyHeader  = "X-Admin-Key"
+	requestIDHeader = "X-Request-ID"
+	defaultTimeout  = 30 * time.Second
+)
+
+// Ошибки по статусу ответа, проверяются через errors.Is
+var (
+	ErrBadRequest         = errors.New("bad request")
+	ErrUnauthorized       = errors.New("unauthorized")
+	ErrForbidden          = errors.New("forbidden")
+	ErrNotFound           = errors.New("not found")
+	ErrConflict           = errors.New("conflict")
+	ErrPreconditionFailed = errors.New("precondition failed")
+	ErrTooLarge           = errors.New("request body is too large")
+	ErrRateLimited        = errors.New("rate limited")
+	ErrUnavailable        = errors.New("business logic error")
+	ErrServer             = errors.New("server error")
+)
+
+// statusErrors соответствие статусов ошибкам. 503 сервер отдает на ошибки бизнес-логики:
+// событие не найдено, уже существует, пересекается с другими
+var statusErrors = map[int]error{
+	http.StatusBadRequest:            ErrBadRequest,
+	http.StatusUnauthorized:          ErrUnauthorized,
+	http.StatusForbidden:             ErrForbidden,
+	http.StatusNotFound:              ErrNotFound,
+	http.StatusConflict:              ErrConflict,
+	http.StatusPreconditionFailed:    ErrPreconditionFailed,
+	http.StatusRequestEntityTooLarge: ErrTooLarge,
+	http.StatusTooManyRequests:       ErrRateLimited,
+	http.StatusServiceUnavailable:    ErrUnavailable,
+	http.StatusInternalServerError:   ErrServer,
+}
+
+// APIError ответ сервера {"error": ...} с кодом не 2xx
+type APIError struct {
+	StatusCode int
+	Message    string
+	// Fields ошибки отдельных полей при ответе 400
+	Fields []FieldError
+	// Conflicts пересекающиеся события при overlap=reject
+	Conflicts []Event
+	// RetryAfter из заголовка ответа 429
+	RetryAfter time.Duration
+	RequestID  string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("calendar: %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// Is сопоставляет ошибку с ErrBadRequest, ErrConflict и другими по статусу
+func (e *APIError) Is(target error) bool {
+	if err, ok := statusErrors[e.StatusCode]; ok && err == target {
+		return true
+	}
+	return target == ErrServer && e.StatusCode >= 500 && e.StatusCode != http.StatusServiceUnavailable
+}
+
+// Client клиент API. Безопасен для использования из нескольких горутин
+type Client struct {
+	baseURL    *url.URL
+	httpClient *http.Client
+	token      string
+	adminKey   string
+}
+
+// Option настройка клиента
+type Option func(*Client)
+
+// WithToken bearer-токен пользователя
+func WithToken(token string) Option {
+	return func(c *Client) { c.token = token }
+}
+
+// WithAdminKey ключ администратора для выпуска токенов и статистики чужих календарей
+func WithAdminKey(key string) Option {
+	return func(c *Client) { c.adminKey = key }
+}
+
+// WithHTTPClient свой http.Client, по умолчанию с таймаутом 30 секунд
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) { c.httpClient = hc }
+}
+
+// New клиент сервера по адресу baseURL, например http://localhost:8080
+func New(baseURL string, opts ...Option) (*Client, error) {
+	u, err := url.Parse(strings.TrimRight(baseURL, "/"))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("client: invalid base url %q", baseURL)
+	}
+
+	c := &Client{baseURL: u, httpClient: &http.Client{Timeout: defaultTimeout}}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
+}
+
+// request параметры одного вызова API
+type request struct {
+	method string
+	path   string
+	query  url.Values
+	body   interface{}
+	header http.Header
+}
+
+// do выполняет запрос и декодирует ответ 2xx в out, иначе возвращает *APIError
+func (c *Client) do(ctx context.Context, req request, out interface{}) (*http.Response, error) {
+	u := *c.baseURL
+	u.Path += req.path
+	if len(req.query) > 0 {
+		u.RawQuery = req.query.Encode()
+	}
+
+	var body io.Reader
+	if req.body != nil {
+		data, err := json.Marshal(req.body)
+		if err != nil {
+			return nil, fmt.Errorf("client: can't encode request: %w", err)
+		}
+		body = bytes.NewReader(data)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, req.method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range req.header {
+		httpReq.Header[k] = v
+	}
+	httpReq.Header.Set("Accept", "application/json")
+	if req.body != nil {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	if c.adminKey != "" {
+		httpReq.Header.Set(adminKeyHeader, c.adminKey)
+	}
+
+	resp, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp, decodeError(resp, data)
+	}
+	if out != nil && len(data) > 0 {
+		if err := json.Unmarshal(data, out); err != nil {
+			return resp, fmt.Errorf("client: can't decode response: %w", err)
+		}
+	}
+	return resp, nil
+}
+
+// decodeError разбирает тело {"error": ..., "fields": [...], "conflicts": [...]}
+func decodeError(resp *http.Response, data []byte) error {
+	apiErr := &APIError{StatusCode: resp.StatusCode, RequestID: resp.Header.Get(requestIDHeader)}
+
+	var body struct {
+		Error     string       `json:"error"`
+		Fields    []FieldError `json:"fields"`
+		Conflicts []Event      `json:"conflicts"`
+	}
+	if err := json.Unmarshal(data, &body); err == nil && body.Error != "" {
+		apiErr.Message, apiErr.Fields, apiErr.Conflicts = body.Error, body.Fields, body.Conflicts
+	} else {
+		apiErr.Message = strings.TrimSpace(string(data))
+	}
+
+	if v := resp.Header.Get("Retry-After"); v != "" {
+		if seconds, err := strconv.Atoi(v); err == nil {
+			apiErr.RetryAfter = time.Duration(seconds) * time.Second
+		}
+	}
+	return apiErr
+}
@@ -0,0 +1,186 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// captured запрос, который получил фейковый сервер
+type captured struct {
+	method, path, query string
+	header              http.Header
+	body                map[string]interface{}
+}
+
+// newFakeServer отвечает status и body на любой запрос и запоминает последний запрос
+func newFakeServer(t *testing.T, status int, body string, header http.Header) (*Client, *captured) {
+	t.Helper()
+	got := &captured{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got.method, got.path, got.query, got.header = r.Method, r.URL.Path, r.URL.RawQuery, r.Header
+		got.body = nil
+		if data, _ := io.ReadAll(r.Body); len(data) > 0 {
+			if err := json.Unmarshal(data, &got.body); err != nil {
+				t.Errorf("body is not json: %q", data)
+			}
+		}
+		for k, v := range header {
+			w.Header()[k] = v
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		io.WriteString(w, body)
+	}))
+	t.Cleanup(srv.Close)
+
+	c, err := New(srv.URL+"/", WithToken("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, got
+}
+
+func TestCreateEvent(t *testing.T) {
+	c, got := newFakeServer(t, http.StatusCreated,
+		`{"result": "event created", "events": [{"user_id": 1, "event_id": 7, "title": "standup", "version": 1}],
+		  "conflicts": [{"user_id": 1, "event_id": 3}]}`,
+		http.Header{"Etag": {`"1"`}})
+
+	start := time.Date(2019, 9, 9, 10, 0, 0, 0, time.UTC)
+	res, err := c.CreateEvent(context.Background(), &Event{Title: "standup", Start: start, End: start.Add(time.Hour)},
+		WithOverlap(OverlapReport), WithIdempotencyKey("k1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Event.EventID != 7 || len(res.Conflicts) != 1 || res.ETag != `"1"` {
+		t.Fatalf("unexpected result %+v", res)
+	}
+
+	if got.method != http.MethodPost || got.path != "/create_event" || got.query != "overlap=report" {
+		t.Errorf("unexpected request %v %v?%v", got.method, got.path, got.query)
+	}
+	if got.header.Get("Authorization") != "Bearer secret" || got.header.Get("Idempotency-Key") != "k1" ||
+		got.header.Get("Content-Type") != "application/json" {
+		t.Errorf("unexpected headers %v", got.header)
+	}
+	if got.body["title"] != "standup" || got.body["start"] != "2019-09-09T10:00:00Z" {
+		t.Errorf("unexpected body %v", got.body)
+	}
+}
+
+func TestDeleteOccurrence(t *testing.T) {
+	c, got := newFakeServer(t, http.StatusOK, `{"result": "event deleted", "events": [{"user_id": 1, "event_id": 2}]}`, nil)
+
+	occurrence := time.Date(2019, 9, 10, 10, 0, 0, 0, time.UTC)
+	if _, err := c.DeleteEvent(context.Background(), 1, 2, Occurrence(occurrence), IfMatch(4)); err != nil {
+		t.Fatal(err)
+	}
+	if got.path != "/delete_event" || got.body["event_id"] != 2.0 || got.body["recurrence_id"] != "2019-09-10T10:00:00Z" {
+		t.Errorf("unexpected request %v %v", got.path, got.body)
+	}
+	if got.header.Get("If-Match") != `"4"` {
+		t.Errorf("If-Match %q", got.header.Get("If-Match"))
+	}
+}
+
+func TestEventsForPeriod(t *testing.T) {
+	c, got := newFakeServer(t, http.StatusOK, `{"result": "", "events": [{"event_id": 1}, {"event_id": 2}]}`, nil)
+	moscow, err := time.LoadLocation("Europe/Moscow")
+	if err != nil {
+		t.Skip("no tzdata:", err)
+	}
+
+	tests := []struct {
+		name  string
+		query func(context.Context, int, time.Time) ([]Event, error)
+		date  time.Time
+		path  string
+		raw   string
+	}{
+		{"day", c.EventsForDay, time.Date(2019, 9, 9, 23, 0, 0, 0, time.UTC), "/events_for_day", "date=2019-09-09&user_id=1"},
+		{"week", c.EventsForWeek, time.Date(2019, 9, 9, 0, 0, 0, 0, moscow), "/events_for_week",
+			"date=2019-09-09&tz=Europe%2FMoscow&user_id=1"},
+		{"month", c.EventsForMonth, time.Date(2019, 9, 1, 0, 0, 0, 0, time.UTC), "/events_for_month", "date=2019-09-01&user_id=1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events, err := tt.query(context.Background(), 1, tt.date)
+			if err != nil || len(events) != 2 {
+				t.Fatalf("got %v %v", events, err)
+			}
+			if got.method != http.MethodGet || got.path != tt.path || got.query != tt.raw || got.body != nil {
+				t.Errorf("unexpected request %v %v?%v", got.method, got.path, got.query)
+			}
+		})
+	}
+}
+
+func TestAPIErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		header http.Header
+		want   error
+	}{
+		{"bad request", http.StatusBadRequest, `{"error": "invalid date"}`, nil, ErrBadRequest},
+		{"unauthorized", http.StatusUnauthorized, `{"error": "missing token"}`, nil, ErrUnauthorized},
+		{"forbidden", http.StatusForbidden, `{"error": "forbidden"}`, nil, ErrForbidden},
+		{"business", http.StatusServiceUnavailable, `{"error": "event not found"}`, nil, ErrUnavailable},
+		{"conflict", http.StatusConflict, `{"error": "overlaps", "conflicts": [{"event_id": 3}]}`, nil, ErrConflict},
+		{"version", http.StatusPreconditionFailed, `{"error": "version mismatch"}`, nil, ErrPreconditionFailed},
+		{"too large", http.StatusRequestEntityTooLarge, `{"error": "too large"}`, nil, ErrTooLarge},
+		{"rate", http.StatusTooManyRequests, `{"error": "slow down"}`, http.Header{"Retry-After": {"3"}}, ErrRateLimited},
+		{"server", http.StatusBadGateway, `bad gateway`, nil, ErrServer},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{"X-Request-Id": {"req-1"}}
+			for k, v := range tt.header {
+				header[k] = v
+			}
+			c, _ := newFakeServer(t, tt.status, tt.body, header)
+
+			_, err := c.GetEvent(context.Background(), 1, 3)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+			var apiErr *APIError
+			if !errors.As(err, &apiErr) || apiErr.StatusCode != tt.status || apiErr.Message == "" || apiErr.RequestID != "req-1" {
+				t.Fatalf("unexpected error %#v", err)
+			}
+			if tt.status == http.StatusConflict && (len(apiErr.Conflicts) != 1 || apiErr.Conflicts[0].EventID != 3) {
+				t.Errorf("conflicts are lost: %+v", apiErr.Conflicts)
+			}
+			if tt.status == http.StatusTooManyRequests && apiErr.RetryAfter != 3*time.Second {
+				t.Errorf("retry after %v", apiErr.RetryAfter)
+			}
+			if tt.want != ErrServer && errors.Is(err, ErrServer) {
+				t.Errorf("%v must not be a server error", tt.status)
+			}
+		})
+	}
+}
+
+func TestContextCancel(t *testing.T) {
+	c, _ := newFakeServer(t, http.StatusOK, `{}`, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := c.EventsForDay(ctx, 1, time.Now()); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want context.Canceled", err)
+	}
+}
+
+func TestNewInvalidURL(t *testing.T) {
+	for _, u := range []string{"", "localhost:8080", "ftp://example.com", "http://"} {
+		if _, err := New(u); err == nil {
+			t.Errorf("%q must be rejected", u)
+		}
+	}
+}
@@ -0,0 +1,416 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const dateFormat = "2006-01-02"
+
+// WriteOption настройка запроса на изменение события
+type WriteOption func(*writeOptions)
+
+type writeOptions struct {
+	overlap        string
+	idempotencyKey string
+	ifMatch        int
+	recurrenceID   *time.Time
+}
+
+// WithOverlap режим проверки пересечений: OverlapReport или OverlapReject
+func WithOverlap(mode string) WriteOption {
+	return func(o *writeOptions) { o.overlap = mode }
+}
+
+// WithIdempotencyKey повтор создания с тем же ключом вернет первый ответ, а не создаст дубль
+func WithIdempotencyKey(key string) WriteOption {
+	return func(o *writeOptions) { o.idempotencyKey = key }
+}
+
+// IfMatch изменение применится, только если версия события на сервере равна version
+func IfMatch(version int) WriteOption {
+	return func(o *writeOptions) { o.ifMatch = version }
+}
+
+// Occurrence удаление одного повторения серии с исходным началом recurrenceID
+func Occurrence(recurrenceID time.Time) WriteOption {
+	return func(o *writeOptions) { o.recurrenceID = &recurrenceID }
+}
+
+func collect(opts []WriteOption) writeOptions {
+	var o writeOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// apply переносит параметры в запрос
+func (o writeOptions) apply(req *request) {
+	req.header = make(http.Header)
+	if o.overlap != "" {
+		req.query = url.Values{"overlap": {o.overlap}}
+	}
+	if o.idempotencyKey != "" {
+		req.header.Set("Idempotency-Key", o.idempotencyKey)
+	}
+	if o.ifMatch != 0 {
+		req.header.Set("If-Match", strconv.Quote(strconv.Itoa(o.ifMatch)))
+	}
+}
+
+// eventsResponse ответ сервера со списком событий
+type eventsResponse struct {
+	Result    string  `json:"result"`
+	Events    []Event `json:"events"`
+	Conflicts []Event `json:"conflicts"`
+}
+
+func (c *Client) save(ctx context.Context, req request, opts []WriteOption) (*SaveResult, error) {
+	collect(opts).apply(&req)
+
+	var resp eventsResponse
+	httpResp, err := c.do(ctx, req, &resp)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Events) == 0 {
+		return nil, fmt.Errorf("client: response has no event")
+	}
+	return &SaveResult{Event: resp.Events[0], Conflicts: resp.Conflicts, ETag: httpResp.Header.Get("ETag")}, nil
+}
+
+// CreateEvent POST /create_event. UserID можно не задавать: он берется из токена,
+// EventID без значения выдает сервер
+func (c *Client) CreateEvent(ctx context.Context, ev *Event, opts ...WriteOption) (*SaveResult, error) {
+	return c.save(ctx, request{method: http.MethodPost, path: "/create_event", body: ev}, opts)
+}
+
+// UpdateEvent POST /update_event, событие заменяется целиком
+func (c *Client) UpdateEvent(ctx context.Context, ev *Event, opts ...WriteOption) (*SaveResult, error) {
+	return c.save(ctx, request{method: http.MethodPost, path: "/update_event", body: ev}, opts)
+}
+
+// DeleteEvent POST /delete_event, с Occurrence удаляется одно повторение серии
+func (c *Client) DeleteEvent(ctx context.Context, userID, eventID int, opts ...WriteOption) (*Event, error) {
+	o := collect(opts)
+	req := request{method: http.MethodPost, path: "/delete_event",
+		body: Event{UserID: userID, EventID: eventID, RecurrenceID: o.recurrenceID}}
+	o.apply(&req)
+
+	var resp eventsResponse
+	if _, err := c.do(ctx, req, &resp); err != nil {
+		return nil, err
+	}
+	if len(resp.Events) == 0 {
+		return nil, fmt.Errorf("client: response has no event")
+	}
+	return &resp.Events[0], nil
+}
+
+// tzParam пояс даты для параметра tz, UTC и Local сервер понимает по умолчанию
+func tzParam(q url.Values, t time.Time) {
+	if loc := t.Location(); loc != time.UTC && loc != time.Local {
+		q.Set("tz", loc.String())
+	}
+}
+
+func (c *Client) eventsFor(ctx context.Context, path string, userID int, date time.Time) ([]Event, error) {
+	q := url.Values{"date": {date.Format(dateFormat)}}
+	if userID != 0 {
+		q.Set("user_id", strconv.Itoa(userID))
+	}
+	tzParam(q, date)
+
+	var resp eventsResponse
+	if _, err := c.do(ctx, request{method: http.MethodGet, path: path, query: q}, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Events, nil
+}
+
+// EventsForDay экземпляры событий за сутки date в поясе date
+func (c *Client) EventsForDay(ctx context.Context, userID int, date time.Time) ([]Event, error) {
+	return c.eventsFor(ctx, "/events_for_day", userID, date)
+}
+
+// EventsForWeek экземпляры событий за неделю (с понедельника), содержащую date
+func (c *Client) EventsForWeek(ctx context.Context, userID int, date time.Time) ([]Event, error) {
+	return c.eventsFor(ctx, "/events_for_week", userID, date)
+}
+
+// EventsForMonth экземпляры событий за месяц, содержащий date
+func (c *Client) EventsForMonth(ctx context.Context, userID int, date time.Time) ([]Event, error) {
+	return c.eventsFor(ctx, "/events_for_month", userID, date)
+}
+
+// ListOptions параметры поиска событий, From и To - даты включительно в поясе From
+type ListOptions struct {
+	From, To time.Time
+	// Text слова, с которых начинаются слова названия или описания
+	Text string
+	// Contains подстрока названия или описания
+	Contains string
+	Desc     bool
+	Limit    int
+	Cursor   string
+}
+
+func userPath(userID int, parts ...interface{}) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "/users/%d", userID)
+	for _, p := range parts {
+		fmt.Fprintf(&b, "/%v", p)
+	}
+	return b.String()
+}
+
+// ListEvents GET /users/{id}/events, следующая страница - с Cursor из EventPage.NextCursor
+func (c *Client) ListEvents(ctx context.Context, userID int, opts ListOptions) (*EventPage, error) {
+	q := url.Values{"from": {opts.From.Format(dateFormat)}, "to": {opts.To.Format(dateFormat)}}
+	tzParam(q, opts.From)
+	if opts.Text != "" {
+		q.Set("q", opts.Text)
+	}
+	if opts.Contains != "" {
+		q.Set("contains", opts.Contains)
+	}
+	if opts.Desc {
+		q.Set("order", "desc")
+	}
+	if opts.Limit > 0 {
+		q.Set("limit", strconv.Itoa(opts.Limit))
+	}
+	if opts.Cursor != "" {
+		q.Set("cursor", opts.Cursor)
+	}
+
+	var page EventPage
+	if _, err := c.do(ctx, request{method: http.MethodGet, path: userPath(userID, "events"), query: q}, &page); err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+// GetEvent событие как оно хранится, с правилом повторения и версией
+func (c *Client) GetEvent(ctx context.Context, userID, eventID int) (*Event, error) {
+	var resp eventsResponse
+	if _, err := c.do(ctx, request{method: http.MethodGet, path: userPath(userID, "events", eventID)}, &resp); err != nil {
+		return nil, err
+	}
+	if len(resp.Events) == 0 {
+		return nil, fmt.Errorf("client: response has no event")
+	}
+	return &resp.Events[0], nil
+}
+
+// PatchEvent меняет только переданные поля, ключи - имена полей JSON
+func (c *Client) PatchEvent(ctx context.Context, userID, eventID int, fields map[string]interface{}, opts ...WriteOption) (*SaveResult, error) {
+	return c.save(ctx, request{method: http.MethodPatch, path: userPath(userID, "events", eventID), body: fields}, opts)
+}
+
+// History история изменений события
+func (c *Client) History(ctx context.Context, userID, eventID int) ([]HistoryEntry, error) {
+	var resp struct {
+		History []HistoryEntry `json:"history"`
+	}
+	if _, err := c.do(ctx, request{method: http.MethodGet, path: userPath(userID, "events", eventID, "history")}, &resp); err != nil {
+		return nil, err
+	}
+	return resp.History, nil
+}
+
+// Trash удаленные события пользователя, сначала недавно удаленные
+func (c *Client) Trash(ctx context.Context, userID int) ([]Event, error) {
+	var resp eventsResponse
+	if _, err := c.do(ctx, request{method: http.MethodGet, path: userPath(userID, "trash")}, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Events, nil
+}
+
+// Restore возвращает событие из корзины
+func (c *Client) Restore(ctx context.Context, userID, eventID int) (*Event, error) {
+	var resp eventsResponse
+	if _, err := c.do(ctx, request{method: http.MethodPost, path: userPath(userID, "trash", eventID, "restore")}, &resp); err != nil {
+		return nil, err
+	}
+	if len(resp.Events) == 0 {
+		return nil, fmt.Errorf("client: response has no event")
+	}
+	return &resp.Events[0], nil
+}
+
+// Invitations приглашения пользователя, status "" - все
+func (c *Client) Invitations(ctx context.Context, userID int, status string) ([]Event, error) {
+	req := request{method: http.MethodGet, path: userPath(userID, "invitations")}
+	if status != "" {
+		req.query = url.Values{"status": {status}}
+	}
+
+	var resp eventsResponse
+	if _, err := c.do(ctx, req, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Events, nil
+}
+
+// Respond ответ на приглашение: StatusAccepted, StatusDeclined или StatusTentative
+func (c *Client) Respond(ctx context.Context, userID, organizerID, eventID int, status string) (*Event, error) {
+	req := request{method: http.MethodPut, path: userPath(userID, "invitations", organizerID, eventID),
+		body: map[string]string{"status": status}}
+
+	var resp eventsResponse
+	if _, err := c.do(ctx, req, &resp); err != nil {
+		return nil, err
+	}
+	if len(resp.Events) == 0 {
+		return nil, fmt.Errorf("client: response has no event")
+	}
+	return &resp.Events[0], nil
+}
+
+func joinIDs(ids []int) string {
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		parts[i] = strconv.Itoa(id)
+	}
+	return strings.Join(parts, ",")
+}
+
+// FreeBusy занятость пользователей за даты [from, to] включительно в поясе from
+func (c *Client) FreeBusy(ctx context.Context, userIDs []int, from, to time.Time) (map[int][]Interval, error) {
+	q := url.Values{"users": {joinIDs(userIDs)}, "from": {from.Format(dateFormat)}, "to": {to.Format(dateFormat)}}
+	tzParam(q, from)
+
+	var resp struct {
+		Busy map[int][]Interval `json:"busy"`
+	}
+	if _, err := c.do(ctx, request{method: http.MethodGet, path: "/free_busy", query: q}, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Busy, nil
+}
+
+// Analytics статистика за даты [from, to] включительно в поясе from, без userIDs - по своему календарю.
+// Чужие календари доступны только с ключом администратора
+func (c *Client) Analytics(ctx context.Context, userIDs []int, from, to time.Time) (*Analytics, error) {
+	q := url.Values{"from": {from.Format(dateFormat)}, "to": {to.Format(dateFormat)}}
+	if len(userIDs) > 0 {
+		q.Set("users", joinIDs(userIDs))
+	}
+	tzParam(q, from)
+
+	var resp Analytics
+	if _, err := c.do(ctx, request{method: http.MethodGet, path: "/analytics", query: q}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// SlotOptions параметры поиска времени для встречи, нулевые поля - значения сервера
+type SlotOptions struct {
+	UserIDs  []int
+	From, To time.Time
+	Duration time.Duration
+	// WorkStart и WorkEnd рабочие часы "09:00" и "18:00"
+	WorkStart, WorkEnd string
+	Weekends           bool
+	Step               time.Duration
+	Limit              int
+}
+
+// FindSlots промежутки, когда все пользователи свободны
+func (c *Client) FindSlots(ctx context.Context, opts SlotOptions) ([]Interval, error) {
+	q := url.Values{
+		"users":    {joinIDs(opts.UserIDs)},
+		"from":     {opts.From.Format(dateFormat)},
+		"to":       {opts.To.Format(dateFormat)},
+		"duration": {opts.Duration.String()},
+	}
+	tzParam(q, opts.From)
+	if opts.WorkStart != "" {
+		q.Set("work_start", opts.WorkStart)
+	}
+	if opts.WorkEnd != "" {
+		q.Set("work_end", opts.WorkEnd)
+	}
+	if opts.Weekends {
+		q.Set("weekends", "true")
+	}
+	if opts.Step > 0 {
+		q.Set("step", opts.Step.String())
+	}
+	if opts.Limit > 0 {
+		q.Set("limit", strconv.Itoa(opts.Limit))
+	}
+
+	var resp struct {
+		Slots []Interval `json:"slots"`
+	}
+	if _, err := c.do(ctx, request{method: http.MethodGet, path: "/find_slots", query: q}, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Slots, nil
+}
+
+// IssueToken выпускает токен пользователю: с ключом администратора - любому,
+// с токеном - себе. Нулевой ttl - срок сервера по умолчанию
+func (c *Client) IssueToken(ctx context.Context, userID int, ttl time.Duration) (*Token, error) {
+	body := map[string]interface{}{"user_id": userID}
+	if ttl > 0 {
+		body["ttl"] = ttl.String()
+	}
+
+	var token Token
+	if _, err := c.do(ctx, request{method: http.MethodPost, path: "/auth/token", body: body}, &token); err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// CreateWebhook подписывает url на изменения событий, без events - на все типы
+func (c *Client) CreateWebhook(ctx context.Context, userID int, callbackURL string, events ...string) (*Webhook, error) {
+	body := map[string]interface{}{"url": callbackURL, "events": events}
+
+	var resp struct {
+		Webhook Webhook `json:"webhook"`
+	}
+	if _, err := c.do(ctx, request{method: http.MethodPost, path: userPath(userID, "webhooks"), body: body}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp.Webhook, nil
+}
+
+// Webhooks подписки пользователя
+func (c *Client) Webhooks(ctx context.Context, userID int) ([]Webhook, error) {
+	var resp struct {
+		Webhooks []Webhook `json:"webhooks"`
+	}
+	if _, err := c.do(ctx, request{method: http.MethodGet, path: userPath(userID, "webhooks")}, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Webhooks, nil
+}
+
+// DeleteWebhook удаляет подписку
+func (c *Client) DeleteWebhook(ctx context.Context, userID, webhookID int) error {
+	_, err := c.do(ctx, request{method: http.MethodDelete, path: userPath(userID, "webhooks", webhookID)}, nil)
+	return err
+}
+
+// Deliveries журнал доставок подписки, новые в начале
+func (c *Client) Deliveries(ctx context.Context, userID, webhookID int) ([]Delivery, error) {
+	var resp struct {
+		Deliveries []Delivery `json:"deliveries"`
+	}
+	if _, err := c.do(ctx, request{method: http.MethodGet, path: userPath(userID, "webhooks", webhookID, "deliveries")}, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Deliveries, nil
+}
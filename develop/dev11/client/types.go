@@ -0,0 +1,187 @@
+package client
+
+import "time"
+
+// Типы повторяют JSON API сервера календаря (develop/dev11)
+
+// Event событие календаря
+type Event struct {
+	UserID      int    `json:"user_id"`
+	EventID     int    `json:"event_id"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	// Date устаревшее поле: начало события по старому API
+	Date time.Time `json:"date"`
+	// Start и End задают промежуток [start, end)
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	// TimeZone IANA-пояс события
+	TimeZone string `json:"time_zone,omitempty"`
+
+	Recurrence   *Recurrence `json:"recurrence,omitempty"`
+	RecurrenceID *time.Time  `json:"recurrence_id,omitempty"`
+	Overrides    []Event     `json:"overrides,omitempty"`
+	Reminders    []Reminder  `json:"reminders,omitempty"`
+	Attendees    []Attendee  `json:"attendees,omitempty"`
+
+	// Version, UpdatedBy, UpdatedAt и DeletedAt заполняет сервер
+	Version   int       `json:"version,omitempty"`
+	UpdatedBy int       `json:"updated_by,omitempty"`
+	UpdatedAt time.Time `json:"updated_at,omitempty"`
+	// DeletedAt когда событие попало в корзину, только у событий из Trash
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	// UID события, импортированного из чужого iCalendar
+	UID string `json:"uid,omitempty"`
+}
+
+// Recurrence правило повторения серии
+type Recurrence struct {
+	Freq     string      `json:"freq"`
+	Interval int         `json:"interval,omitempty"`
+	ByDay    []string    `json:"by_day,omitempty"`
+	Count    int         `json:"count,omitempty"`
+	Until    time.Time   `json:"until,omitempty"`
+	ExDates  []time.Time `json:"exdates,omitempty"`
+}
+
+// Reminder напоминание за MinutesBefore минут до начала
+type Reminder struct {
+	MinutesBefore int `json:"minutes_before"`
+}
+
+// Ответы на приглашение
+const (
+	StatusNeedsAction = "needs-action"
+	StatusAccepted    = "accepted"
+	StatusDeclined    = "declined"
+	StatusTentative   = "tentative"
+)
+
+// Attendee приглашенный пользователь и его ответ
+type Attendee struct {
+	UserID int    `json:"user_id"`
+	Status string `json:"status,omitempty"`
+}
+
+// Режимы проверки пересечений при сохранении, OverlapAllow - режим по умолчанию
+const (
+	OverlapAllow  = ""
+	OverlapReport = "report"
+	OverlapReject = "reject"
+)
+
+// SaveResult сохраненное событие и пересечения в режиме OverlapReport
+type SaveResult struct {
+	Event     Event
+	Conflicts []Event
+	// ETag версия события для If-Match
+	ETag string
+}
+
+// EventPage страница поиска событий, NextCursor пуст на последней странице
+type EventPage struct {
+	Events     []Event `json:"events"`
+	NextCursor string  `json:"next_cursor,omitempty"`
+}
+
+// Interval промежуток времени [Start, End)
+type Interval struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// Analytics статистика календарей за даты [From, To]
+type Analytics struct {
+	From     string `json:"from"`
+	To       string `json:"to"`
+	TimeZone string `json:"time_zone"`
+	// PerDay число событий, начинающихся в каждый день диапазона
+	PerDay []struct {
+		Date   string `json:"date"`
+		Events int    `json:"events"`
+	} `json:"per_day"`
+	// Weekdays дни недели от самого загруженного
+	Weekdays []struct {
+		Weekday string  `json:"weekday"`
+		Events  int     `json:"events"`
+		Hours   float64 `json:"hours"`
+	} `json:"weekdays"`
+	Users []struct {
+		UserID int     `json:"user_id"`
+		Events int     `json:"events"`
+		Hours  float64 `json:"hours"`
+	} `json:"users"`
+}
+
+// FieldError ошибка значения параметра (In: path, query) или поля тела (In: body)
+type FieldError struct {
+	Field   string `json:"field"`
+	In      string `json:"in"`
+	Message string `json:"message"`
+}
+
+// FieldChange изменение поля в истории события
+type FieldChange struct {
+	Field  string      `json:"field"`
+	Before interface{} `json:"before,omitempty"`
+	After  interface{} `json:"after,omitempty"`
+}
+
+// HistoryEntry запись истории изменений события
+type HistoryEntry struct {
+	Seq     int           `json:"seq"`
+	UserID  int           `json:"user_id"`
+	EventID int           `json:"event_id"`
+	Op      string        `json:"op"`
+	Actor   int           `json:"actor"`
+	At      time.Time     `json:"at"`
+	Before  *Event        `json:"before,omitempty"`
+	After   *Event        `json:"after,omitempty"`
+	Diff    []FieldChange `json:"diff,omitempty"`
+}
+
+// Token выпущенный токен доступа, Token есть только в ответе на выпуск
+type Token struct {
+	Token     string    `json:"token,omitempty"`
+	ID        string    `json:"token_id"`
+	UserID    int       `json:"user_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Revoked   bool      `json:"revoked,omitempty"`
+}
+
+// Типы изменений для подписок
+const (
+	WebhookCreated = "event.created"
+	WebhookUpdated = "event.updated"
+	WebhookDeleted = "event.deleted"
+)
+
+// Webhook подписка на изменения событий, Secret есть только в ответе на создание
+type Webhook struct {
+	ID        int       `json:"id"`
+	UserID    int       `json:"user_id"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// DeliveryAttempt попытка доставки изменения подписчику
+type DeliveryAttempt struct {
+	At         time.Time `json:"at"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	DurationMS float64   `json:"duration_ms"`
+}
+
+// Delivery запись журнала доставок подписки
+type Delivery struct {
+	ID             string            `json:"id"`
+	SubscriptionID int               `json:"subscription_id"`
+	Type           string            `json:"type"`
+	EventID        int               `json:"event_id"`
+	CreatedAt      time.Time         `json:"created_at"`
+	Status         string            `json:"status"`
+	Attempts       []DeliveryAttempt `json:"attempts"`
+	NextAttemptAt  *time.Time        `json:"next_attempt_at,omitempty"`
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"L2/develop/dev11/client"
+)
+
+// TestClientAgainstServer клиент против настоящего роутера: форматы запросов и ответов совпадают
+func TestClientAgainstServer(t *testing.T) {
+	srv, _ := newTestServer(t)
+	ctx := context.Background()
+
+	admin, err := client.New(srv.URL, client.WithAdminKey(testAdminKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := admin.IssueToken(ctx, 1, time.Hour)
+	if err != nil || token.Token == "" || token.UserID != 1 {
+		t.Fatalf("can't issue token: %+v %v", token, err)
+	}
+	c, _ := client.New(srv.URL, client.WithToken(token.Token))
+
+	start := time.Date(2019, 9, 9, 10, 0, 0, 0, time.UTC)
+	res, err := c.CreateEvent(ctx, &client.Event{Title: "standup", Start: start, End: start.Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Event.UserID != 1 || res.Event.EventID == 0 || res.ETag == "" {
+		t.Fatalf("unexpected create result %+v", res)
+	}
+	id := res.Event.EventID
+
+	_, err = c.CreateEvent(ctx, &client.Event{Title: "retro", Start: start.Add(30 * time.Minute), End: start.Add(2 * time.Hour)},
+		client.WithOverlap(client.OverlapReject))
+	var apiErr *client.APIError
+	if !errors.As(err, &apiErr) || len(apiErr.Conflicts) != 1 || apiErr.Conflicts[0].EventID != id {
+		t.Fatalf("overlap must be rejected with conflicts: %v", err)
+	}
+
+	updated := res.Event
+	updated.Title = "daily"
+	if _, err := c.UpdateEvent(ctx, &updated, client.IfMatch(updated.Version+1)); !errors.Is(err, client.ErrPreconditionFailed) {
+		t.Fatalf("stale If-Match: got %v", err)
+	}
+	if _, err := c.UpdateEvent(ctx, &updated, client.IfMatch(updated.Version)); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, query := range map[string]func(context.Context, int, time.Time) ([]client.Event, error){
+		"day": c.EventsForDay, "week": c.EventsForWeek, "month": c.EventsForMonth,
+	} {
+		events, err := query(ctx, 1, start)
+		if err != nil || len(events) != 1 || events[0].Title != "daily" {
+			t.Errorf("%s: got %+v %v", name, events, err)
+		}
+	}
+
+	_, err = c.CreateEvent(ctx, &client.Event{Start: start})
+	if !errors.As(err, &apiErr) || !errors.Is(err, client.ErrBadRequest) ||
+		len(apiErr.Fields) != 1 || apiErr.Fields[0].Field != "title" || apiErr.Fields[0].In != "body" {
+		t.Fatalf("field errors are lost: %v", err)
+	}
+
+	if _, err := c.EventsForDay(ctx, 2, start); !errors.Is(err, client.ErrForbidden) {
+		t.Errorf("foreign calendar: got %v", err)
+	}
+	if _, err := c.DeleteEvent(ctx, 1, id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.DeleteEvent(ctx, 1, id); !errors.Is(err, client.ErrUnavailable) {
+		t.Errorf("second delete: got %v", err)
+	}
+
+	trash, err := c.Trash(ctx, 1)
+	if err != nil || len(trash) != 1 || trash[0].EventID != id || trash[0].DeletedAt == nil {
+		t.Fatalf("trash: %+v %v", trash, err)
+	}
+	if restored, err := c.Restore(ctx, 1, id); err != nil || restored.DeletedAt != nil {
+		t.Fatalf("restore: %+v %v", restored, err)
+	}
+}
@@ -0,0 +1,298 @@
+// calctl - утилита командной строки для сервера календаря, для скриптов.
+//
+//	calctl [-server URL] [-token TOKEN] <команда> [флаги]
+//
+// Команды: create, update, delete, get, day, week, month, token.
+// Адрес и токен можно задать переменными CALENDAR_URL, CALENDAR_TOKEN и
+// CALENDAR_ADMIN_KEY. Ответ печатается в JSON, при ошибке код выхода 1
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"time"
+
+	"L2/develop/dev11/client"
+)
+
+const dateFormat = "2006-01-02"
+
+// command подкоманда: разбирает свои флаги и возвращает результат для печати
+type command func(ctx context.Context, c *client.Client, args []string) (interface{}, error)
+
+var commands = map[string]command{
+	"create": createCmd,
+	"update": updateCmd,
+	"delete": deleteCmd,
+	"get":    getCmd,
+	"day":    periodCmd((*client.Client).EventsForDay),
+	"week":   periodCmd((*client.Client).EventsForWeek),
+	"month":  periodCmd((*client.Client).EventsForMonth),
+	"token":  tokenCmd,
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	code := run(ctx, os.Args[1:], os.Stdout, os.Stderr, os.Getenv)
+	stop()
+	os.Exit(code)
+}
+
+func run(ctx context.Context, args []string, stdout, stderr io.Writer, getenv func(string) string) int {
+	fs := flag.NewFlagSet("calctl", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	server := fs.String("server", envOr(getenv, "CALENDAR_URL", "http://localhost:8080"), "адрес сервера")
+	token := fs.String("token", getenv("CALENDAR_TOKEN"), "bearer-токен пользователя")
+	adminKey := fs.String("admin-key", getenv("CALENDAR_ADMIN_KEY"), "ключ администратора для выпуска токенов")
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "usage: calctl [flags] <command> [command flags]")
+		fmt.Fprintln(stderr, "commands:", commandNames())
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+	cmd, ok := commands[fs.Arg(0)]
+	if !ok {
+		fmt.Fprintf(stderr, "calctl: unknown command %q\n", fs.Arg(0))
+		fs.Usage()
+		return 2
+	}
+
+	c, err := client.New(*server, client.WithToken(*token), client.WithAdminKey(*adminKey))
+	if err != nil {
+		fmt.Fprintln(stderr, "calctl:", err)
+		return 2
+	}
+
+	res, err := cmd(ctx, c, fs.Args()[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, "calctl:", err)
+		var apiErr *client.APIError
+		if errors.As(err, &apiErr) && len(apiErr.Conflicts) > 0 {
+			printJSON(stderr, map[string]interface{}{"conflicts": apiErr.Conflicts})
+		}
+		return 1
+	}
+	printJSON(stdout, res)
+	return 0
+}
+
+func envOr(getenv func(string) string, key, def string) string {
+	if v := getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+func commandNames() []string {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func printJSON(w io.Writer, v interface{}) {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+// eventFlags флаги полей события для create и update
+type eventFlags struct {
+	fs                *flag.FlagSet
+	userID, eventID   int
+	title, desc       string
+	start, end, tz    string
+	overlap, key      string
+	ifMatch           int
+	attendees, remind intList
+}
+
+func newEventFlags(name string) *eventFlags {
+	f := &eventFlags{fs: flag.NewFlagSet(name, flag.ContinueOnError)}
+	f.fs.IntVar(&f.userID, "user", 0, "владелец события, по умолчанию из токена")
+	f.fs.IntVar(&f.eventID, "id", 0, "id события")
+	f.fs.StringVar(&f.title, "title", "", "название")
+	f.fs.StringVar(&f.desc, "desc", "", "описание")
+	f.fs.StringVar(&f.start, "start", "", "начало, RFC 3339")
+	f.fs.StringVar(&f.end, "end", "", "конец, RFC 3339")
+	f.fs.StringVar(&f.tz, "tz", "", "IANA-пояс события")
+	f.fs.StringVar(&f.overlap, "overlap", "", "проверка пересечений: report или reject")
+	f.fs.StringVar(&f.key, "idempotency-key", "", "ключ идемпотентности")
+	f.fs.IntVar(&f.ifMatch, "if-match", 0, "ожидаемая версия события")
+	f.fs.Var(&f.attendees, "attendee", "id приглашенного, можно повторять")
+	f.fs.Var(&f.remind, "remind", "напомнить за N минут, можно повторять")
+	return f
+}
+
+func (f *eventFlags) parse(args []string) (*client.Event, []client.WriteOption, error) {
+	if err := f.fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+	ev := &client.Event{UserID: f.userID, EventID: f.eventID, Title: f.title, Description: f.desc, TimeZone: f.tz}
+	for _, t := range []struct {
+		name, value string
+		dst         *time.Time
+	}{{"start", f.start, &ev.Start}, {"end", f.end, &ev.End}} {
+		if t.value == "" {
+			continue
+		}
+		v, err := time.Parse(time.RFC3339, t.value)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid -%s %q: want RFC 3339", t.name, t.value)
+		}
+		*t.dst = v
+	}
+	for _, id := range f.attendees {
+		ev.Attendees = append(ev.Attendees, client.Attendee{UserID: id})
+	}
+	for _, m := range f.remind {
+		ev.Reminders = append(ev.Reminders, client.Reminder{MinutesBefore: m})
+	}
+
+	var opts []client.WriteOption
+	if f.overlap != "" {
+		opts = append(opts, client.WithOverlap(f.overlap))
+	}
+	if f.key != "" {
+		opts = append(opts, client.WithIdempotencyKey(f.key))
+	}
+	if f.ifMatch != 0 {
+		opts = append(opts, client.IfMatch(f.ifMatch))
+	}
+	return ev, opts, nil
+}
+
+// intList флаг, который можно указать несколько раз
+type intList []int
+
+func (l *intList) String() string { return fmt.Sprint([]int(*l)) }
+
+func (l *intList) Set(s string) error {
+	var v int
+	if _, err := fmt.Sscan(s, &v); err != nil {
+		return fmt.Errorf("not a number: %q", s)
+	}
+	*l = append(*l, v)
+	return nil
+}
+
+func createCmd(ctx context.Context, c *client.Client, args []string) (interface{}, error) {
+	ev, opts, err := newEventFlags("create").parse(args)
+	if err != nil {
+		return nil, err
+	}
+	return c.CreateEvent(ctx, ev, opts...)
+}
+
+func updateCmd(ctx context.Context, c *client.Client, args []string) (interface{}, error) {
+	f := newEventFlags("update")
+	ev, opts, err := f.parse(args)
+	if err != nil {
+		return nil, err
+	}
+	if ev.EventID == 0 {
+		return nil, errors.New("update: -id is required")
+	}
+	return c.UpdateEvent(ctx, ev, opts...)
+}
+
+func deleteCmd(ctx context.Context, c *client.Client, args []string) (interface{}, error) {
+	fs := flag.NewFlagSet("delete", flag.ContinueOnError)
+	userID := fs.Int("user", 0, "владелец события, по умолчанию из токена")
+	eventID := fs.Int("id", 0, "id события")
+	occurrence := fs.String("occurrence", "", "удалить одно повторение с этим началом, RFC 3339")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if *eventID == 0 {
+		return nil, errors.New("delete: -id is required")
+	}
+
+	var opts []client.WriteOption
+	if *occurrence != "" {
+		t, err := time.Parse(time.RFC3339, *occurrence)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -occurrence %q: want RFC 3339", *occurrence)
+		}
+		opts = append(opts, client.Occurrence(t))
+	}
+	return c.DeleteEvent(ctx, *userID, *eventID, opts...)
+}
+
+func getCmd(ctx context.Context, c *client.Client, args []string) (interface{}, error) {
+	fs := flag.NewFlagSet("get", flag.ContinueOnError)
+	userID := fs.Int("user", 0, "владелец события")
+	eventID := fs.Int("id", 0, "id события")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if *userID == 0 || *eventID == 0 {
+		return nil, errors.New("get: -user and -id are required")
+	}
+	return c.GetEvent(ctx, *userID, *eventID)
+}
+
+// periodCmd команды day, week и month
+func periodCmd(query func(*client.Client, context.Context, int, time.Time) ([]client.Event, error)) command {
+	return func(ctx context.Context, c *client.Client, args []string) (interface{}, error) {
+		fs := flag.NewFlagSet("events", flag.ContinueOnError)
+		userID := fs.Int("user", 0, "пользователь, по умолчанию из токена")
+		date := fs.String("date", time.Now().Format(dateFormat), "дата YYYY-MM-DD")
+		tz := fs.String("tz", "", "IANA-пояс даты")
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+
+		loc := time.UTC
+		if *tz != "" {
+			var err error
+			if loc, err = time.LoadLocation(*tz); err != nil {
+				return nil, fmt.Errorf("invalid -tz %q", *tz)
+			}
+		}
+		day, err := time.ParseInLocation(dateFormat, *date, loc)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -date %q: want YYYY-MM-DD", *date)
+		}
+
+		events, err := query(c, ctx, *userID, day)
+		if events == nil {
+			events = []client.Event{}
+		}
+		return events, err
+	}
+}
+
+func tokenCmd(ctx context.Context, c *client.Client, args []string) (interface{}, error) {
+	fs := flag.NewFlagSet("token", flag.ContinueOnError)
+	userID := fs.Int("user", 0, "пользователь")
+	ttl := fs.Duration("ttl", 0, "срок действия, по умолчанию срок сервера")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if *userID == 0 {
+		return nil, errors.New("token: -user is required")
+	}
+	return c.IssueToken(ctx, *userID, *ttl)
+}
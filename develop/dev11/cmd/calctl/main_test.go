@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	var path, query, auth string
+	var body map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, query, auth = r.URL.Path, r.URL.RawQuery, r.Header.Get("Authorization")
+		body = nil
+		if data, _ := io.ReadAll(r.Body); len(data) > 0 {
+			json.Unmarshal(data, &body)
+		}
+		if r.URL.Path == "/delete_event" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			io.WriteString(w, `{"error": "event not found"}`)
+			return
+		}
+		io.WriteString(w, `{"result": "ok", "events": [{"user_id": 1, "event_id": 5, "title": "standup"}]}`)
+	}))
+	defer srv.Close()
+
+	env := map[string]string{"CALENDAR_URL": srv.URL, "CALENDAR_TOKEN": "secret"}
+	getenv := func(key string) string { return env[key] }
+
+	tests := []struct {
+		name  string
+		args  string
+		code  int
+		path  string
+		query string
+		out   string
+	}{
+		{"create", "create -title standup -start 2019-09-09T10:00:00Z -end 2019-09-09T11:00:00Z -attendee 2 -overlap reject",
+			0, "/create_event", "overlap=reject", `"event_id": 5`},
+		{"week", "week -user 1 -date 2019-09-09 -tz Europe/Moscow",
+			0, "/events_for_week", "date=2019-09-09&tz=Europe%2FMoscow&user_id=1", `"title": "standup"`},
+		{"api error", "delete -id 9", 1, "/delete_event", "", ""},
+		{"bad date", "day -date 09.09.2019", 1, "", "", ""},
+		{"unknown command", "move", 2, "", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, query = "", ""
+			var stdout, stderr bytes.Buffer
+			code := run(context.Background(), strings.Fields(tt.args), &stdout, &stderr, getenv)
+			if code != tt.code {
+				t.Fatalf("exit code %v, want %v; stderr: %s", code, tt.code, stderr.String())
+			}
+			if path != tt.path || query != tt.query {
+				t.Errorf("request %v?%v, want %v?%v", path, query, tt.path, tt.query)
+			}
+			if !strings.Contains(stdout.String(), tt.out) {
+				t.Errorf("output %q doesn't contain %q", stdout.String(), tt.out)
+			}
+			if tt.path != "" && auth != "Bearer secret" {
+				t.Errorf("token is not sent: %q", auth)
+			}
+		})
+	}
+
+	if body == nil || body["event_id"] != 9.0 {
+		t.Errorf("delete body %v", body)
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOptimisticConcurrency(t *testing.T) {
+	srv, _ := newTestServer(t)
+	alice := issueToken(t, srv, "1")
+	event := srv.URL + "/users/1/events/1"
+
+	status, res := doRequest(t, http.MethodPost, srv.URL+"/create_event", alice,
+		`{"event_id": 1, "title": "sync", "date": "2019-09-09T10:00:00Z"}`, nil)
+	if status != http.StatusCreated {
+		t.Fatalf("create failed: %v %v", status, res)
+	}
+
+	// Два клиента прочитали версию 1, первый сохраняет, второй получает 412
+	first := `{"event_id": 1, "title": "first", "date": "2019-09-09T10:00:00Z"}`
+	second := `{"event_id": 1, "title": "second", "date": "2019-09-09T10:00:00Z"}`
+	if status, res := doRequest(t, http.MethodPost, srv.URL+"/update_event", alice, first,
+		map[string]string{"If-Match": `"1"`}); status != http.StatusOK {
+		t.Fatalf("first update failed: %v %v", status, res)
+	}
+	if status, _ := doRequest(t, http.MethodPut, event, alice, second,
+		map[string]string{"If-Match": `"1"`}); status != http.StatusPreconditionFailed {
+		t.Errorf("stale If-Match: got %v, want 412", status)
+	}
+	// Версия в теле работает так же, как If-Match
+	if status, _ := doRequest(t, http.MethodPatch, event, alice, `{"title": "x", "version": 1}`, nil); status != http.StatusPreconditionFailed {
+		t.Errorf("stale body version: got %v, want 412", status)
+	}
+	if status, _ := doRequest(t, http.MethodDelete, event, alice, "", map[string]string{"If-Match": "abc"}); status != http.StatusBadRequest {
+		t.Errorf("malformed If-Match: got %v, want 400", status)
+	}
+
+	// GET отдает ETag, совпадающий If-None-Match - 304
+	req, _ := http.NewRequest(http.MethodGet, event, nil)
+	req.Header.Set("Authorization", "Bearer "+alice)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if tag := resp.Header.Get("ETag"); tag != `"2"` {
+		t.Fatalf("ETag: got %q, want \"2\"", tag)
+	}
+	req.Header.Set("If-None-Match", `"2"`)
+	if resp, err = http.DefaultClient.Do(req); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotModified {
+		t.Errorf("If-None-Match: got %v, want 304", resp.StatusCode)
+	}
+
+	if status, _ := doRequest(t, http.MethodDelete, event, alice, "", map[string]string{"If-Match": `"1"`}); status != http.StatusPreconditionFailed {
+		t.Errorf("stale delete: got %v, want 412", status)
+	}
+	if status, res := doRequest(t, http.MethodDelete, event, alice, "", map[string]string{"If-Match": `W/"2"`}); status != http.StatusOK {
+		t.Errorf("delete failed: %v %v", status, res)
+	}
+}
+
+func TestVersionOfSeriesOccurrences(t *testing.T) {
+	st := newMemoryStorage()
+	series := Event{UserID: 1, EventID: 1, Title: "daily", Start: mustTime(t, "2019-09-09T10:00:00Z"),
+		Recurrence: &Recurrence{Freq: freqDaily}}
+	createAll(t, st, series)
+
+	// Правка одного повторения меняет версию всей серии
+	rid := mustTime(t, "2019-09-10T10:00:00Z")
+	occ := Event{UserID: 1, EventID: 1, Title: "moved", Start: rid.Add(time.Hour), RecurrenceID: &rid, Version: 1}
+	occ.validate()
+	if err := st.Update(&occ); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.Delete(&Event{UserID: 1, EventID: 1, RecurrenceID: &rid, Version: 1}); err == nil {
+		t.Fatal("delete with stale version must fail")
+	}
+
+	events, _ := st.getEventsForWeek(1, rid)
+	for _, ev := range events {
+		if ev.Version != 2 {
+			t.Errorf("occurrence %v has version %v, want 2", ev.start(), ev.Version)
+		}
+	}
+}
+
+func TestIdempotencyKey(t *testing.T) {
+	srv, _ := newTestServer(t)
+	alice := issueToken(t, srv, "1")
+	bob := issueToken(t, srv, "2")
+	body := `{"event_id": 1, "title": "once", "date": "2019-09-09T10:00:00Z"}`
+
+	post := func(token, path, body, key string) (*http.Response, string) {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodPost, srv.URL+path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set(idempotencyKeyHeader, key)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		return resp, string(data)
+	}
+
+	resp, original := post(alice, "/create_event", body, "k1")
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("create failed: %v %v", resp.StatusCode, original)
+	}
+
+	// Повтор возвращает тот же ответ, а не "already exists"
+	resp, replayed := post(alice, "/create_event", body, "k1")
+	if resp.StatusCode != http.StatusCreated || replayed != original || resp.Header.Get("Idempotent-Replayed") != "true" {
+		t.Errorf("replay: %v %v", resp.StatusCode, replayed)
+	}
+	if resp, _ := post(alice, "/create_event", strings.Replace(body, "once", "twice", 1), "k1"); resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("key reuse with other body: got %v, want 422", resp.StatusCode)
+	}
+	// Ключи разных пользователей независимы
+	if resp, data := post(bob, "/create_event", body, "k1"); resp.StatusCode != http.StatusCreated {
+		t.Errorf("bob's request with the same key: %v %v", resp.StatusCode, data)
+	}
+	// Без ключа повтор - обычная ошибка бизнес-логики
+	if resp, _ := post(alice, "/create_event", body, ""); resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("create without key: got %v, want 503", resp.StatusCode)
+	}
+	// Ошибка бизнес-логики сохраняется: повтор не выполняется заново. X-Request-ID у повтора свой
+	first, _ := post(alice, "/create_event", body, "k3")
+	if first.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("duplicate create: got %v, want 503", first.StatusCode)
+	}
+	resp, _ = post(alice, "/create_event", body, "k3")
+	if resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Idempotent-Replayed") != "true" {
+		t.Errorf("business error is not replayed: %v %q", resp.StatusCode, resp.Header.Get("Idempotent-Replayed"))
+	}
+	if id := resp.Header.Get(requestIDHeader); id == "" || id == first.Header.Get(requestIDHeader) {
+		t.Errorf("replay has request id %q of the first request", id)
+	}
+	// v2 создание тоже идемпотентно
+	v2 := `{"event_id": 2, "title": "v2", "date": "2019-09-09T10:00:00Z"}`
+	post(alice, "/users/1/events", v2, "k2")
+	if resp, data := post(alice, "/users/1/events", v2, "k2"); resp.StatusCode != http.StatusCreated || resp.Header.Get("Location") == "" {
+		t.Errorf("v2 replay: %v %v", resp.StatusCode, data)
+	}
+}
+
+func TestIdempotencyExpiry(t *testing.T) {
+	store := newIdempotencyStore(time.Hour)
+	now := mustTime(t, "2019-09-09T10:00:00Z")
+	store.now = func() time.Time { return now }
+
+	calls := 0
+	failed := true
+	h := store.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if failed {
+			w.WriteHeader(storageErrStatus(r, &storageFailure{err: errors.New("disk is full")}))
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	call := func() {
+		r, _ := http.NewRequest(http.MethodPost, "/create_event", strings.NewReader("{}"))
+		r.Header.Set(idempotencyKeyHeader, "k")
+		r = r.WithContext(context.WithValue(r.Context(), authKey{}, tokenClaims{UserID: 1}))
+		h.ServeHTTP(&discardWriter{header: http.Header{}}, r)
+	}
+
+	// Ответ на сбой хранилища не сохраняется
+	call()
+	call()
+	if calls != 2 {
+		t.Fatalf("storage failure must not be replayed, handler called %v times", calls)
+	}
+
+	failed = false
+	call()
+	call()
+	if calls != 3 {
+		t.Fatalf("success must be replayed, handler called %v times", calls)
+	}
+
+	now = now.Add(time.Hour)
+	call()
+	if calls != 4 || len(store.responses) != 1 || len(store.order) != 1 {
+		t.Errorf("expired key must be forgotten: calls %v, responses %v", calls, len(store.responses))
+	}
+}
+
+// discardWriter ResponseWriter без клиента
+type discardWriter struct {
+	header http.Header
+}
+
+func (d *discardWriter) Header() http.Header         { return d.header }
+func (d *discardWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (d *discardWriter) WriteHeader(int)             {}
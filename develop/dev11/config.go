@@ -0,0 +1,308 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Конфигурация сервера собирается по слоям, каждый следующий переопределяет предыдущий:
+// значения по умолчанию, JSON-файл (-config или CONFIG_FILE), переменные окружения, флаги
+
+// duration длительность в JSON-файле конфигурации записывается строкой: "15s", "1m30s"
+type duration time.Duration
+
+func (d duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"15s\"")
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = duration(v)
+	return nil
+}
+
+// StorageConfig выбор хранилища событий
+type StorageConfig struct {
+	// Backend memory или file
+	Backend string `json:"backend"`
+	// Dir каталог файлового хранилища
+	Dir string `json:"dir"`
+	// SnapshotEvery через сколько записей журнала делать снапшот
+	SnapshotEvery    int      `json:"snapshot_every"`
+	SnapshotInterval duration `json:"snapshot_interval"`
+	// TrashRetention сколько удаленное событие лежит в корзине до окончательного удаления
+	TrashRetention duration `json:"trash_retention"`
+}
+
+// Config настройки сервера. Нулевые ReadTimeout, WriteTimeout и IdleTimeout отключают
+// ограничение. WriteTimeout по умолчанию выключен: он оборвал бы поток /reminders_stream
+type Config struct {
+	Listen          string   `json:"listen"`
+	ReadTimeout     duration `json:"read_timeout"`
+	WriteTimeout    duration `json:"write_timeout"`
+	IdleTimeout     duration `json:"idle_timeout"`
+	ShutdownTimeout duration `json:"shutdown_timeout"`
+	// LogFormat text или json
+	LogFormat string        `json:"log_format"`
+	Storage   StorageConfig `json:"storage"`
+	// Limits маршруты из файла добавляются к ограничениям по умолчанию
+	Limits LimitsConfig `json:"limits"`
+}
+
+func defaultConfig() *Config {
+	return &Config{
+		Listen:          ":8080",
+		ReadTimeout:     duration(15 * time.Second),
+		IdleTimeout:     duration(60 * time.Second),
+		ShutdownTimeout: duration(30 * time.Second),
+		LogFormat:       "text",
+		Storage: StorageConfig{
+			Backend:          "memory",
+			Dir:              "data",
+			SnapshotEvery:    defaultSnapshotEvery,
+			SnapshotInterval: duration(defaultSnapshotInterval),
+			TrashRetention:   duration(defaultTrashRetention),
+		},
+		Limits: defaultLimits(),
+	}
+}
+
+// loadConfig собирает конфигурацию из файла, окружения и аргументов командной строки
+func loadConfig(args []string, getenv func(string) string) (*Config, error) {
+	fs := flag.NewFlagSet("dev11", flag.ContinueOnError)
+	path := fs.String("config", getenv("CONFIG_FILE"), "path to JSON config file")
+	listen := fs.String("listen", "", "listen address, e.g. :8080")
+	readTimeout := fs.Duration("read-timeout", 0, "max duration for reading the whole request")
+	writeTimeout := fs.Duration("write-timeout", 0, "max duration for writing the response, 0 - no limit")
+	idleTimeout := fs.Duration("idle-timeout", 0, "keep-alive idle timeout")
+	shutdownTimeout := fs.Duration("shutdown-timeout", 0, "how long to wait for in-flight requests on shutdown")
+	logFormat := fs.String("log-format", "", "log format: text or json")
+	backend := fs.String("storage", "", "storage backend: memory or file")
+	dir := fs.String("storage-dir", "", "directory of the file storage")
+	snapshotEvery := fs.Int("snapshot-every", 0, "journal records between snapshots")
+	trashRetention := fs.Duration("trash-retention", 0, "how long deleted events stay in trash")
+	rate := fs.Float64("rate-limit", 0, "default requests per second per client, 0 - no limit")
+	burst := fs.Int("rate-burst", 0, "default burst of requests per client")
+	maxBody := fs.Int64("max-body-bytes", 0, "max request body size")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	cfg := defaultConfig()
+	if *path != "" {
+		if err := cfg.loadFile(*path); err != nil {
+			return nil, err
+		}
+	}
+	if err := cfg.loadEnv(getenv); err != nil {
+		return nil, err
+	}
+
+	// Флаги применяются, только если заданы явно, иначе затерли бы файл и окружение
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "listen":
+			cfg.Listen = *listen
+		case "read-timeout":
+			cfg.ReadTimeout = duration(*readTimeout)
+		case "write-timeout":
+			cfg.WriteTimeout = duration(*writeTimeout)
+		case "idle-timeout":
+			cfg.IdleTimeout = duration(*idleTimeout)
+		case "shutdown-timeout":
+			cfg.ShutdownTimeout = duration(*shutdownTimeout)
+		case "log-format":
+			cfg.LogFormat = *logFormat
+		case "storage":
+			cfg.Storage.Backend = *backend
+		case "storage-dir":
+			cfg.Storage.Dir = *dir
+		case "snapshot-every":
+			cfg.Storage.SnapshotEvery = *snapshotEvery
+		case "trash-retention":
+			cfg.Storage.TrashRetention = duration(*trashRetention)
+		case "rate-limit":
+			cfg.Limits.Default.Rate = *rate
+		case "rate-burst":
+			cfg.Limits.Default.Burst = *burst
+		case "max-body-bytes":
+			cfg.Limits.MaxBodyBytes = *maxBody
+		}
+	})
+
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// loadFile накладывает JSON-файл поверх текущих значений, неизвестные поля - ошибка
+func (cfg *Config) loadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("can't open config: %w", err)
+	}
+	defer f.Close()
+
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(cfg); err != nil {
+		return fmt.Errorf("invalid config %v: %w", path, err)
+	}
+	return nil
+}
+
+// loadEnv переменные окружения. PORT оставлен для совместимости: "8080" или ":8080"
+func (cfg *Config) loadEnv(getenv func(string) string) error {
+	if v := getenv("PORT"); v != "" {
+		if !strings.Contains(v, ":") {
+			v = ":" + v
+		}
+		cfg.Listen = v
+	}
+	if v := getenv("LISTEN_ADDR"); v != "" {
+		cfg.Listen = v
+	}
+
+	durations := []struct {
+		name string
+		dst  *duration
+	}{
+		{"READ_TIMEOUT", &cfg.ReadTimeout},
+		{"WRITE_TIMEOUT", &cfg.WriteTimeout},
+		{"IDLE_TIMEOUT", &cfg.IdleTimeout},
+		{"SHUTDOWN_TIMEOUT", &cfg.ShutdownTimeout},
+		{"SNAPSHOT_INTERVAL", &cfg.Storage.SnapshotInterval},
+		{"TRASH_RETENTION", &cfg.Storage.TrashRetention},
+	}
+	for _, d := range durations {
+		v := getenv(d.name)
+		if v == "" {
+			continue
+		}
+		parsed, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("invalid %v %q", d.name, v)
+		}
+		*d.dst = duration(parsed)
+	}
+
+	if v := getenv("LOG_FORMAT"); v != "" {
+		cfg.LogFormat = v
+	}
+	if v := getenv("STORAGE"); v != "" {
+		cfg.Storage.Backend = v
+	}
+	if v := getenv("STORAGE_DIR"); v != "" {
+		cfg.Storage.Dir = v
+	}
+	if v := getenv("SNAPSHOT_EVERY"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid SNAPSHOT_EVERY %q", v)
+		}
+		cfg.Storage.SnapshotEvery = n
+	}
+
+	if v := getenv("RATE_LIMIT"); v != "" {
+		rate, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return fmt.Errorf("invalid RATE_LIMIT %q", v)
+		}
+		cfg.Limits.Default.Rate = rate
+	}
+	if v := getenv("RATE_BURST"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid RATE_BURST %q", v)
+		}
+		cfg.Limits.Default.Burst = n
+	}
+	if v := getenv("MAX_BODY_BYTES"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid MAX_BODY_BYTES %q", v)
+		}
+		cfg.Limits.MaxBodyBytes = n
+	}
+	return nil
+}
+
+// validate проверяет итоговую конфигурацию и возвращает все найденные ошибки сразу
+func (cfg *Config) validate() error {
+	var errs []string
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Sprintf(format, args...))
+	}
+
+	if _, port, err := net.SplitHostPort(cfg.Listen); err != nil {
+		fail("invalid listen address %q", cfg.Listen)
+	} else if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		fail("invalid listen port %q", port)
+	}
+	if cfg.ReadTimeout < 0 || cfg.WriteTimeout < 0 || cfg.IdleTimeout < 0 {
+		fail("timeouts can't be negative")
+	}
+	if cfg.ShutdownTimeout <= 0 {
+		fail("shutdown_timeout must be positive")
+	}
+	if cfg.LogFormat != "text" && cfg.LogFormat != "json" {
+		fail("log_format must be text or json, got %q", cfg.LogFormat)
+	}
+
+	switch cfg.Storage.Backend {
+	case "memory":
+	case "file":
+		if cfg.Storage.Dir == "" {
+			fail("storage dir is required for file backend")
+		}
+		if cfg.Storage.SnapshotEvery <= 0 {
+			fail("snapshot_every must be positive")
+		}
+		if cfg.Storage.SnapshotInterval <= 0 {
+			fail("snapshot_interval must be positive")
+		}
+	default:
+		fail("unknown storage backend %q", cfg.Storage.Backend)
+	}
+	if cfg.Storage.TrashRetention <= 0 {
+		fail("trash_retention must be positive")
+	}
+
+	if err := cfg.Limits.Default.validate("default"); err != nil {
+		fail("%v", err)
+	}
+	routes := make([]string, 0, len(cfg.Limits.Routes))
+	for route := range cfg.Limits.Routes {
+		routes = append(routes, route)
+	}
+	sort.Strings(routes)
+	for _, route := range routes {
+		if err := cfg.Limits.Routes[route].validate(route); err != nil {
+			fail("%v", err)
+		}
+	}
+	if cfg.Limits.MaxBodyBytes <= 0 {
+		fail("max_body_bytes must be positive")
+	}
+
+	if len(errs) > 0 {
+		return errors.New("invalid config: " + strings.Join(errs, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func envOf(vars map[string]string) func(string) string {
+	return func(name string) string { return vars[name] }
+}
+
+func TestLoadConfigDefaults(t *testing.T) {
+	cfg, err := loadConfig(nil, envOf(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Listen != ":8080" || cfg.Storage.Backend != "memory" || cfg.LogFormat != "text" {
+		t.Fatalf("unexpected defaults %+v", cfg)
+	}
+	if cfg.WriteTimeout != 0 {
+		t.Fatalf("write timeout must be off by default for SSE, got %v", time.Duration(cfg.WriteTimeout))
+	}
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	data := `{"listen": ":7000", "read_timeout": "5s", "log_format": "json",
+		"storage": {"backend": "file", "dir": "from-file", "snapshot_every": 10}}`
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	env := envOf(map[string]string{
+		"CONFIG_FILE":  path,
+		"PORT":         "7001",
+		"STORAGE_DIR":  "from-env",
+		"IDLE_TIMEOUT": "2m",
+	})
+	cfg, err := loadConfig([]string{"-storage-dir", "from-flag", "-read-timeout", "7s"}, env)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Файл < окружение < флаги, незаданное остается из предыдущего слоя
+	if cfg.Listen != ":7001" {
+		t.Errorf("listen %q, env must override file", cfg.Listen)
+	}
+	if cfg.Storage.Dir != "from-flag" {
+		t.Errorf("storage dir %q, flag must override env", cfg.Storage.Dir)
+	}
+	if time.Duration(cfg.ReadTimeout) != 7*time.Second || time.Duration(cfg.IdleTimeout) != 2*time.Minute {
+		t.Errorf("timeouts read=%v idle=%v", time.Duration(cfg.ReadTimeout), time.Duration(cfg.IdleTimeout))
+	}
+	if cfg.LogFormat != "json" || cfg.Storage.Backend != "file" || cfg.Storage.SnapshotEvery != 10 {
+		t.Errorf("values from file are lost: %+v", cfg)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(`{"listen": ":1", "unknown": true}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name string
+		args []string
+		env  map[string]string
+		want string
+	}{
+		{"bad listen", []string{"-listen", "localhost"}, nil, "invalid listen address"},
+		{"bad port", []string{"-listen", ":99999"}, nil, "invalid listen port"},
+		{"negative timeout", []string{"-read-timeout", "-1s"}, nil, "can't be negative"},
+		{"zero shutdown", []string{"-shutdown-timeout", "0s"}, nil, "shutdown_timeout"},
+		{"log format", nil, map[string]string{"LOG_FORMAT": "xml"}, "log_format"},
+		{"backend", nil, map[string]string{"STORAGE": "redis"}, "unknown storage backend"},
+		{"snapshot every", []string{"-storage", "file", "-snapshot-every", "-5"}, nil, "snapshot_every"},
+		{"rate limit", []string{"-rate-limit", "-1"}, nil, "invalid rate limit"},
+		{"burst", []string{"-rate-burst", "0"}, nil, "burst of default"},
+		{"body size", nil, map[string]string{"MAX_BODY_BYTES": "0"}, "max_body_bytes"},
+		{"trash retention", []string{"-trash-retention", "0s"}, nil, "trash_retention"},
+		{"env duration", nil, map[string]string{"READ_TIMEOUT": "soon"}, "invalid READ_TIMEOUT"},
+		{"unknown field", []string{"-config", path}, nil, "unknown field"},
+		{"missing file", []string{"-config", path + ".missing"}, nil, "can't open config"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := loadConfig(c.args, envOf(c.env))
+			if err == nil || !strings.Contains(err.Error(), c.want) {
+				t.Fatalf("got %v, want error containing %q", err, c.want)
+			}
+		})
+	}
+}
+
+func TestServeDrainsInFlightRequests(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	started := make(chan struct{})
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(200 * time.Millisecond)
+		w.Write([]byte("done"))
+	})}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- serve(ctx, srv, ln, 5*time.Second) }()
+
+	type result struct {
+		body string
+		err  error
+	}
+	resc := make(chan result, 1)
+	go func() {
+		resp, err := http.Get("http://" + ln.Addr().String())
+		if err != nil {
+			resc <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		var buf bytes.Buffer
+		buf.ReadFrom(resp.Body)
+		resc <- result{body: buf.String()}
+	}()
+
+	<-started
+	cancel()
+
+	res := <-resc
+	if res.err != nil || res.body != "done" {
+		t.Fatalf("in-flight request was cut: %q, %v", res.body, res.err)
+	}
+	if err := <-served; err != nil {
+		t.Fatalf("serve: %v", err)
+	}
+	if _, err := http.Get("http://" + ln.Addr().String()); err == nil {
+		t.Fatal("server still accepts connections after shutdown")
+	}
+}
+
+func TestShutdownClosesReminderStreams(t *testing.T) {
+	broker := newSSEBroker()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = r.WithContext(context.WithValue(r.Context(), authKey{}, tokenClaims{UserID: 1}))
+		broker.ServeHTTP(w, r)
+	})}
+	srv.RegisterOnShutdown(broker.Close)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- serve(ctx, srv, ln, 5*time.Second) }()
+
+	resp, err := http.Get("http://" + ln.Addr().String() + "/reminders_stream?user_id=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %v", resp.StatusCode)
+	}
+
+	cancel()
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Fatalf("serve: %v", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("open stream blocks shutdown")
+	}
+}
@@ -1,14 +1,21 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
-	"strconv"
-	"sync"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
 	"time"
 )
 
@@ -37,269 +44,127 @@ import (
 
 const dateFormat = "2006-01-02"
 
-// Logger - для логирования запросов
-type Logger struct {
-	handler http.Handler
-}
-
-// Конструктор логгера
-func newLogger(handler http.Handler) *Logger {
-	return &Logger{handler: handler}
-}
-
-// ServeHTTP логика хэндлера, опишем этот метод, чтобы удовлетворить интерфейсу
-func (l *Logger) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	start := time.Now()
-	l.handler.ServeHTTP(w, r)
-	log.Printf("%s %s %v\n", r.Method, r.URL, time.Since(start))
-}
-
-// Event - модель JSON хранилища
-type Event struct {
-	UserID      int       `json:"user_id"`
-	EventID     int       `json:"event_id"`
-	Title       string    `json:"title"`
-	Description string    `json:"description"`
-	Date        time.Time `json:"date"`
-}
-
-// decode декодирует данные из reader в json
-func (ev *Event) decode(r io.Reader) error {
-	err := json.NewDecoder(r).Decode(&ev)
+// writeJSON сериализует ответ в JSON и пишет его с нужным статусом
+func writeJSON(w http.ResponseWriter, v interface{}, status int) {
+	jsMarsh, err := json.Marshal(v)
 	if err != nil {
-		log.Printf("%+v error from decoder", err)
-	}
-	return nil
-}
-
-// validate проверяет наличие данных в обязательных полях
-func (ev *Event) validate() error {
-	switch {
-	case ev.UserID <= 0:
-		return fmt.Errorf("invalid user_id")
-	case ev.EventID <= 0:
-		return fmt.Errorf("invalid event_id")
-	case ev.Title == "":
-		return fmt.Errorf("invalid title")
-	default:
-		return nil
-	}
-}
-
-// Storage структура хранилища эвентов
-type Storage struct {
-	mu     *sync.Mutex
-	events map[int][]Event
-}
-
-//Create создание события в календаре
-func (s *Storage) Create(ev *Event) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	if events, ok := s.events[ev.UserID]; ok {
-		for _, event := range events {
-			if event.EventID == ev.EventID {
-				return fmt.Errorf("%v event for %v user already exists", ev.EventID, ev.UserID)
-			}
-		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
-	s.events[ev.UserID] = append(s.events[ev.UserID], *ev)
 
-	return nil
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(jsMarsh)
 }
 
-// Update обновление информации о событии в календаре
-func (s *Storage) Update(ev *Event) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	var index = -1
-
-	events := make([]Event, 0)
-	ok := false
-
-	if events, ok = s.events[ev.UserID]; !ok {
-		return fmt.Errorf("user %v doesn't exist", ev.UserID)
-	}
-
-	for i, event := range events {
-		if event.EventID == ev.EventID {
-			index = i
-			break
-		}
-	}
-	if index == -1 {
-		fmt.Errorf("can't find event with %v id for %v user id", ev.EventID, ev.UserID)
-	}
-
-	s.events[ev.UserID][index] = *ev
+func getResponse(w http.ResponseWriter, r string, ev []Event, status int) {
+	resp := struct {
+		Result string  `json:"result"`
+		Events []Event `json:"events"`
+	}{Result: r, Events: ev}
 
-	return nil
+	writeJSON(w, resp, status)
 }
 
-func (s *Storage) Delete(ev *Event) (*Event, error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	index := -1
-
-	events := make([]Event, 0)
-	ok := false
-
-	if events, ok = s.events[ev.UserID]; !ok {
-		return nil, fmt.Errorf("user %v doesn't exist", ev.UserID)
-	}
-
-	for i, event := range events {
-		if event.EventID == ev.EventID {
-			index = i
-			break
-		}
-	}
-
-	if index == -1 {
-		fmt.Errorf("can't find event with %v id for %v user id", ev.EventID, ev.UserID)
-	}
-
-	evLen := len(s.events[ev.UserID])
-	deleted := s.events[ev.UserID][index]
-	s.events[ev.UserID][index] = s.events[ev.UserID][evLen-1]
-	s.events[ev.UserID] = s.events[ev.UserID][:evLen-1]
+func getErrResponse(w http.ResponseWriter, e string, status int) {
+	errResp := struct {
+		Error string `json:"error"`
+	}{Error: e}
 
-	return &deleted, nil
+	writeJSON(w, errResp, status)
 }
 
-func (s *Storage) getEventsForDay(userID int, date time.Time) ([]Event, error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	var res []Event
-
-	events := make([]Event, 0)
-	ok := false
-
-	if events, ok = s.events[userID]; !ok {
-		return nil, fmt.Errorf("user %v doesn't exist", userID)
-	}
-
-	for _, ev := range events {
-		if ev.Date.Year() == date.Year() && ev.Date.Month() == date.Month() && ev.Date.Day() == date.Day() {
-			res = append(res, ev)
-		}
-	}
+// getOverlapResponse ответ на сохранение события, conflicts - пересечения в режиме overlap=report
+func getOverlapResponse(w http.ResponseWriter, r string, ev Event, conflicts []Event, status int) {
+	resp := struct {
+		Result    string  `json:"result"`
+		Events    []Event `json:"events"`
+		Conflicts []Event `json:"conflicts,omitempty"`
+	}{Result: r, Events: []Event{ev}, Conflicts: conflicts}
 
-	return res, nil
+	w.Header().Set("ETag", etag(ev.Version))
+	writeJSON(w, resp, status)
 }
 
-func (s *Storage) getEventsForWeek(userID int, date time.Time) ([]Event, error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	var res []Event
-
-	events := make([]Event, 0)
-	ok := false
-
-	if events, ok = s.events[userID]; !ok {
-		return nil, fmt.Errorf("user %v doesn't exist", userID)
+// getOverlapErrResponse ошибка сохранения события, для overlap=reject с пересекающимися событиями
+func getOverlapErrResponse(w http.ResponseWriter, err error, status int) {
+	var overlap *overlapError
+	if !errors.As(err, &overlap) {
+		getErrResponse(w, err.Error(), status)
+		return
 	}
 
-	for _, ev := range events {
-		y1, w1 := ev.Date.ISOWeek()
-		y2, w2 := date.ISOWeek()
-		if y1 == y2 && w1 == w2 {
-			res = append(res, ev)
-		}
-	}
+	errResp := struct {
+		Error     string  `json:"error"`
+		Conflicts []Event `json:"conflicts"`
+	}{Error: err.Error(), Conflicts: overlap.conflicts}
 
-	return res, nil
+	writeJSON(w, errResp, status)
 }
 
-func (s *Storage) getEventsForMonth(userID int, date time.Time) ([]Event, error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	var res []Event
-
-	events := make([]Event, 0)
-	ok := false
-
-	if events, ok = s.events[userID]; !ok {
-		return nil, fmt.Errorf("user %v doesn't exist", userID)
-	}
-
-	for _, ev := range events {
-		if ev.Date.Year() == date.Year() && ev.Date.Month() == date.Month() {
-			res = append(res, ev)
-		}
+// parseDate разбирает дату запроса в поясе tz (по умолчанию UTC),
+// чтобы границы дня, недели и месяца считались в поясе клиента
+func parseDate(q url.Values) (time.Time, error) {
+	loc, err := loadLocation(q.Get("tz"))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid tz %q", q.Get("tz"))
 	}
-
-	return res, nil
+	return time.ParseInLocation(dateFormat, q.Get("date"), loc)
 }
 
-func getResponse(w http.ResponseWriter, r string, ev []Event, status int) {
-	resp := struct {
-		Result string  `json:"result"`
-		Events []Event `json:"events"`
-	}{Result: r, Events: ev}
+// CreateEventHandler /create_event handler
+func CreateEventHandler(w http.ResponseWriter, r *http.Request) {
+	var ev Event
 
-	jsMarsh, err := json.Marshal(resp)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := decodeEvent(r, &ev); err != nil {
+		getErrResponse(w, err.Error(), decodeErrStatus(err))
 		return
 	}
 
-	w.WriteHeader(status)
-	w.Header().Set("Content-Type", "application/json")
-	w.Write(jsMarsh)
-}
-
-func getErrResponse(w http.ResponseWriter, e string, status int) {
-	errResp := struct {
-		Error string `json:"error"`
-	}{Error: e}
-
-	jsMarsh, err := json.Marshal(errResp)
+	userID, status, err := authorize(r, ev.UserID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		getErrResponse(w, err.Error(), status)
 		return
 	}
+	ev.UserID = userID
 
-	w.WriteHeader(status)
-	w.Header().Set("Content-Type", "application/json")
-	w.Write(jsMarsh)
-}
-
-// CreateEventHandler /create_event handler
-func CreateEventHandler(w http.ResponseWriter, r *http.Request) {
-	var ev Event
-
-	if err := ev.decode(r.Body); err != nil {
+	if err := ev.validate(); err != nil {
 		getErrResponse(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	if err := ev.validate(); err != nil {
+	mode, err := overlapMode(r)
+	if err != nil {
 		getErrResponse(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	if err := storage.Create(&ev); err != nil {
-		getErrResponse(w, err.Error(), http.StatusBadRequest)
+	conflicts, err := saveEvent(storage, &ev, true, mode)
+	if err != nil {
+		getOverlapErrResponse(w, err, storageErrStatus(r, err))
 		return
 	}
 
-	getResponse(w, "Событие успешно создано!", []Event{ev}, http.StatusCreated)
-
-	fmt.Println(storage.events)
+	getOverlapResponse(w, "Событие успешно создано!", ev, conflicts, http.StatusCreated)
 }
 
 // UpdateEventHandler /update_event handler
 func UpdateEventHandler(w http.ResponseWriter, r *http.Request) {
 	var ev Event
 
-	if err := ev.decode(r.Body); err != nil {
+	if err := decodeEvent(r, &ev); err != nil {
+		getErrResponse(w, err.Error(), decodeErrStatus(err))
+		return
+	}
+
+	userID, status, err := authorize(r, ev.UserID)
+	if err != nil {
+		getErrResponse(w, err.Error(), status)
+		return
+	}
+	ev.UserID = userID
+
+	if ev.Version, err = ifMatch(r, ev.Version); err != nil {
 		getErrResponse(w, err.Error(), http.StatusBadRequest)
 		return
 	}
@@ -309,29 +174,46 @@ func UpdateEventHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := storage.Update(&ev); err != nil {
+	mode, err := overlapMode(r)
+	if err != nil {
 		getErrResponse(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	getResponse(w, "Событие обновлено!", []Event{ev}, http.StatusOK)
+	conflicts, err := saveEvent(storage, &ev, false, mode)
+	if err != nil {
+		getOverlapErrResponse(w, err, storageErrStatus(r, err))
+		return
+	}
 
-	fmt.Println(storage.events)
+	getOverlapResponse(w, "Событие обновлено!", ev, conflicts, http.StatusOK)
 }
 
 // DeleteEventHandler /delete_event handler
 func DeleteEventHandler(w http.ResponseWriter, r *http.Request) {
 	var ev Event
 
-	if err := ev.decode(r.Body); err != nil {
-		getErrResponse(w, err.Error(), http.StatusBadRequest)
+	if err := decodeEvent(r, &ev); err != nil {
+		getErrResponse(w, err.Error(), decodeErrStatus(err))
 		return
 	}
 
-	var deleted *Event
-	var err error
-	if deleted, err = storage.Delete(&ev); err != nil {
+	userID, status, err := authorize(r, ev.UserID)
+	if err != nil {
+		getErrResponse(w, err.Error(), status)
+		return
+	}
+	ev.UserID = userID
+
+	if ev.Version, err = ifMatch(r, ev.Version); err != nil {
 		getErrResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	deleted, err := storage.Delete(&ev)
+	if err != nil {
+		getErrResponse(w, err.Error(), storageErrStatus(r, err))
+		return
 	}
 
 	getResponse(w, "Событие удалено!", []Event{*deleted}, http.StatusOK)
@@ -341,20 +223,20 @@ func DeleteEventHandler(w http.ResponseWriter, r *http.Request) {
 func ForDayHandler(w http.ResponseWriter, r *http.Request) {
 	var ev []Event
 
-	userID, err := strconv.Atoi(r.URL.Query().Get("user_id"))
+	userID, status, err := authorizeQuery(r)
 	if err != nil {
-		getErrResponse(w, err.Error(), http.StatusBadRequest)
+		getErrResponse(w, err.Error(), status)
 		return
 	}
 
-	date, err := time.Parse(dateFormat, r.URL.Query().Get("date"))
+	date, err := parseDate(r.URL.Query())
 	if err != nil {
 		getErrResponse(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
 	if ev, err = storage.getEventsForDay(userID, date); err != nil {
-		getErrResponse(w, err.Error(), http.StatusBadRequest)
+		getErrResponse(w, err.Error(), storageErrStatus(r, err))
 		return
 	}
 
@@ -365,20 +247,20 @@ func ForDayHandler(w http.ResponseWriter, r *http.Request) {
 func ForWeekHandler(w http.ResponseWriter, r *http.Request) {
 	var ev []Event
 
-	userID, err := strconv.Atoi(r.URL.Query().Get("user_id"))
+	userID, status, err := authorizeQuery(r)
 	if err != nil {
-		getErrResponse(w, err.Error(), http.StatusBadRequest)
+		getErrResponse(w, err.Error(), status)
 		return
 	}
 
-	date, err := time.Parse(dateFormat, r.URL.Query().Get("date"))
+	date, err := parseDate(r.URL.Query())
 	if err != nil {
 		getErrResponse(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	if ev, err = storage.getEventsForDay(userID, date); err != nil {
-		getErrResponse(w, err.Error(), http.StatusBadRequest)
+	if ev, err = storage.getEventsForWeek(userID, date); err != nil {
+		getErrResponse(w, err.Error(), storageErrStatus(r, err))
 		return
 	}
 
@@ -389,54 +271,291 @@ func ForWeekHandler(w http.ResponseWriter, r *http.Request) {
 func ForMonthHandler(w http.ResponseWriter, r *http.Request) {
 	var ev []Event
 
-	userID, err := strconv.Atoi(r.URL.Query().Get("user_id"))
+	userID, status, err := authorizeQuery(r)
 	if err != nil {
-		getErrResponse(w, err.Error(), http.StatusBadRequest)
+		getErrResponse(w, err.Error(), status)
 		return
 	}
 
-	date, err := time.Parse(dateFormat, r.URL.Query().Get("date"))
+	date, err := parseDate(r.URL.Query())
 	if err != nil {
 		getErrResponse(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	if ev, err = storage.getEventsForDay(userID, date); err != nil {
-		getErrResponse(w, err.Error(), http.StatusBadRequest)
+	if ev, err = storage.getEventsForMonth(userID, date); err != nil {
+		getErrResponse(w, err.Error(), storageErrStatus(r, err))
 		return
 	}
 
 	getResponse(w, "Запрос успешно выполнен!", ev, http.StatusOK)
 }
 
-// storage - глобальное хранилище событий
-var storage Storage = Storage{events: make(map[int][]Event), mu: &sync.Mutex{}}
+// ExportEventsHandler /export_events handler, отдает события пользователя в формате iCalendar.
+// Необязательные from и to ограничивают выгрузку диапазоном дат [from, to]
+func ExportEventsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, status, err := authorizeQuery(r)
+	if err != nil {
+		getErrResponse(w, err.Error(), status)
+		return
+	}
+
+	loc, err := loadLocation(r.URL.Query().Get("tz"))
+	if err != nil {
+		getErrResponse(w, "invalid tz", http.StatusBadRequest)
+		return
+	}
+
+	var from, to time.Time
+	if v := r.URL.Query().Get("from"); v != "" {
+		if from, err = time.ParseInLocation(dateFormat, v, loc); err != nil {
+			getErrResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if v := r.URL.Query().Get("to"); v != "" {
+		if to, err = time.ParseInLocation(dateFormat, v, loc); err != nil {
+			getErrResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		to = to.AddDate(0, 0, 1)
+	}
+	if to.IsZero() && !from.IsZero() {
+		to = time.Date(9999, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+
+	events, err := exportEvents(storage, userID, from, to)
+	if err != nil {
+		getErrResponse(w, err.Error(), storageErrStatus(r, err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="user-%d.ics"`, userID))
+	if err := encodeICal(w, events); err != nil {
+		log.Printf("%+v error from ical encoder", err)
+	}
+}
+
+// ImportEventsHandler /import_events handler, принимает .ics телом запроса или файлом формы "file".
+// В ответе для каждого VEVENT указано, создан ли он или конфликтует с существующим EventID
+func ImportEventsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, status, err := authorizeQuery(r)
+	if err != nil {
+		getErrResponse(w, err.Error(), status)
+		return
+	}
+
+	var body io.Reader = r.Body
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			getErrResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+		body = file
+	}
 
-func main() {
+	results, err := importICal(storage, userID, body)
+	if err != nil {
+		getErrResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	resp := struct {
+		Result  string         `json:"result"`
+		Imports []importResult `json:"imports"`
+	}{Result: "Импорт выполнен!", Imports: results}
+
+	writeJSON(w, resp, http.StatusOK)
+}
+
+// storage - глобальное хранилище событий, реализация выбирается в main
+var storage Storage = newMemoryStorage()
+
+// reminders - поток напоминаний для подписчиков server-sent events
+var reminders = newSSEBroker()
+
+// statePathFor путь к файлу состояния рядом с файловым хранилищем, "" для хранилища в памяти
+func statePathFor(name string) string {
+	if fs, ok := storage.(*FileStorage); ok {
+		return filepath.Join(fs.dir, name)
+	}
+	return ""
+}
+
+// newRouter регистрирует пути API и оборачивает их в middleware
+func newRouter(tokens *TokenStore, limits LimitsConfig) http.Handler {
 	mux := http.NewServeMux()
 
-	// Пропишем пути для GET
-	mux.HandleFunc("/events_for_day", ForDayHandler)
-	mux.HandleFunc("/events_for_week", ForWeekHandler)
-	mux.HandleFunc("/events_for_month", ForMonthHandler)
+	// Пропишем пути для GET, на остальные методы отвечаем 405
+	get := func(h http.HandlerFunc) http.HandlerFunc { return allowMethods(h, http.MethodGet) }
+	mux.HandleFunc("/events_for_day", get(ForDayHandler))
+	mux.HandleFunc("/events_for_week", get(ForWeekHandler))
+	mux.HandleFunc("/events_for_month", get(ForMonthHandler))
+	mux.HandleFunc("/export_events", get(ExportEventsHandler))
+	mux.HandleFunc("/free_busy", get(FreeBusyHandler))
+	mux.HandleFunc("/find_slots", get(FindSlotsHandler))
+	mux.HandleFunc("/analytics", get(analyticsHandler(tokens)))
+	mux.HandleFunc("/reminders_stream", get(reminders.ServeHTTP))
+	mux.HandleFunc("/auth/tokens", get(tokens.ListTokensHandler))
 
 	// Пропишем пути для POST
-	mux.HandleFunc("/create_event", CreateEventHandler)
-	mux.HandleFunc("/update_event", UpdateEventHandler)
-	mux.HandleFunc("/delete_event", DeleteEventHandler)
-
-	// Logger
-	wMux := newLogger(mux)
-
-	// Назначение порта из конфига
-	port := ":8080"
-	func() {
-		temp := os.Getenv("PORT")
-		if temp != "" {
-			port = temp
+	post := func(h http.HandlerFunc) http.HandlerFunc { return allowMethods(h, http.MethodPost) }
+	idempotency := newIdempotencyStore(defaultIdempotencyTTL)
+	mux.Handle("/create_event", idempotency.Wrap(post(CreateEventHandler)))
+	mux.HandleFunc("/update_event", post(UpdateEventHandler))
+	mux.HandleFunc("/delete_event", post(DeleteEventHandler))
+	mux.HandleFunc("/import_events", post(ImportEventsHandler))
+	mux.HandleFunc("/auth/token", post(tokens.IssueTokenHandler))
+	mux.HandleFunc("/auth/revoke", post(tokens.RevokeTokenHandler))
+
+	// API v2: /users/{id}/events[/{eventID}], POST создания тоже идемпотентен
+	mux.Handle(usersPrefix, idempotency.Wrap(http.HandlerFunc(UsersHandler)))
+
+	// Метрики открыты без токена, как принято для сборщика
+	metrics := newMetrics()
+	mux.HandleFunc("/metrics", get(metrics.ServeHTTP))
+	mux.HandleFunc("/openapi.json", get(OpenAPIHandler))
+
+	// Request ID, лог запросов с метриками, ограничение частоты, Auth и размера тела.
+	// Частота ограничивается до проверки токена: запросы без действительного токена
+	// считаются по IP, поэтому перебор токенов тоже получает 429. Тело читается только
+	// после проверки токена, параметры и тело проверяются по описанию API перед обработчиком
+	route := routeLabel(mux)
+	validated := newValidator(mux, openAPI)
+	auth := newAuth(limitBody(validated, limits.MaxBodyBytes), tokens, "/auth/token", "/auth/revoke", "/metrics", "/openapi.json")
+	limited := newRateLimiter(auth, limits, route, tokens)
+	return withRequestID(newRequestLogger(limited, metrics, route))
+}
+
+func main() {
+	cfg, err := loadConfig(os.Args[1:], os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	// SIGINT и SIGTERM запускают плавную остановку
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := run(ctx, cfg); err != nil {
+		log.Fatalln(err)
+	}
+}
+
+// run поднимает сервер и после отмены ctx останавливает его в обратном порядке:
+// дожидается запросов, останавливает планировщик, сбрасывает историю и хранилище
+func run(ctx context.Context, cfg *Config) error {
+	logger = newStructuredLogger(os.Stderr, cfg.LogFormat)
+	if cfg.LogFormat == "json" {
+		log.SetFlags(0)
+		log.SetOutput(stdLogWriter{logger})
+	}
+
+	// Хранилище из конфига
+	st, err := newStorage(cfg.Storage)
+	if err != nil {
+		return err
+	}
+	storage = st
+	defer func() {
+		if err := storage.Close(); err != nil {
+			log.Printf("storage: can't flush: %v", err)
 		}
 	}()
 
-	log.Printf("Server is listening for requests port%v", port)
-	log.Fatalln(http.ListenAndServe(port, wMux))
+	// История изменений событий, id удаленных событий больше не выдаются
+	auditLog, err := openAuditLog(statePathFor(auditFileName))
+	if err != nil {
+		return err
+	}
+	audit = auditLog
+	defer audit.Close()
+	storage.reserveEventIDs(audit.lastEventID())
+	storage.subscribe(audit.record)
+
+	// Подписки на изменения событий, ожидающие повторы прерываются при остановке
+	hooks, err := openWebhooks(statePathFor(webhooksFileName))
+	if err != nil {
+		return err
+	}
+	if webhooks != nil {
+		webhooks.Close()
+	}
+	webhooks = hooks
+	defer webhooks.Close()
+	storage.subscribe(webhooks.record)
+
+	// Кеш статистики сбрасывается при изменении событий
+	storage.subscribe(analytics.record)
+
+	// Планировщик напоминаний: в лог, подписчикам SSE и, если задан, на вебхук
+	notifiers := multiNotifier{logNotifier{}, reminders}
+	if url := os.Getenv("REMINDER_WEBHOOK_URL"); url != "" {
+		notifiers = append(notifiers, newWebhookNotifier(url))
+	}
+	scheduler, err := newScheduler(storage, notifiers, realClock{}, statePathFor(reminderStateFileName))
+	if err != nil {
+		return err
+	}
+	scheduler.Start()
+	defer scheduler.Stop()
+
+	// Корзина: удаленные события хранятся trash_retention, потом удаляются окончательно
+	purger := newTrashPurger(storage, realClock{}, time.Duration(cfg.Storage.TrashRetention))
+	purger.Start()
+	defer purger.Stop()
+
+	// Аутентификация: секрет подписи и ключ администратора из окружения
+	tokens, err := newTokenStoreFromEnv(statePathFor(tokensFileName))
+	if err != nil {
+		return err
+	}
+
+	srv := &http.Server{
+		Handler:      newRouter(tokens, cfg.Limits),
+		ReadTimeout:  time.Duration(cfg.ReadTimeout),
+		WriteTimeout: time.Duration(cfg.WriteTimeout),
+		IdleTimeout:  time.Duration(cfg.IdleTimeout),
+	}
+	srv.RegisterOnShutdown(reminders.Close)
+
+	ln, err := net.Listen("tcp", cfg.Listen)
+	if err != nil {
+		return err
+	}
+	log.Printf("Server is listening for requests port%v", ln.Addr())
+
+	return serve(ctx, srv, ln, time.Duration(cfg.ShutdownTimeout))
+}
+
+// serve обслуживает ln до отмены ctx, затем перестает принимать соединения
+// и ждет не дольше timeout, пока завершатся начатые запросы
+func serve(ctx context.Context, srv *http.Server, ln net.Listener, timeout time.Duration) error {
+	errc := make(chan error, 1)
+	go func() { errc <- srv.Serve(ln) }()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+
+	log.Printf("Shutting down, waiting up to %v for in-flight requests", timeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		srv.Close()
+		return fmt.Errorf("shutdown: %w", err)
+	}
+	if err := <-errc; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
 }
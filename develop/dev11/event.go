@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Event - модель JSON хранилища
+type Event struct {
+	UserID      int    `json:"user_id"`
+	EventID     int    `json:"event_id"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	// Date устаревшее поле: начало события по старому API, в ответах совпадает со start
+	Date time.Time `json:"date"`
+	// Start и End задают промежуток [start, end), событие без end длится одно мгновение
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	// TimeZone IANA-пояс события, в нем разворачиваются повторения
+	TimeZone string `json:"time_zone,omitempty"`
+
+	// Recurrence правило повторения, если событие - серия
+	Recurrence *Recurrence `json:"recurrence,omitempty"`
+	// RecurrenceID исходное начало повторения серии. В ответах помечает экземпляр серии,
+	// в запросах /update_event и /delete_event выбирает одно повторение вместо всей серии
+	RecurrenceID *time.Time `json:"recurrence_id,omitempty"`
+	// Overrides измененные отдельные повторения серии
+	Overrides []Event `json:"overrides,omitempty"`
+	// Reminders напоминания о начале события (для серии - о каждом повторении)
+	Reminders []Reminder `json:"reminders,omitempty"`
+	// Attendees приглашенные пользователи, владелец события (UserID) - организатор.
+	// Для серии список общий на все повторения
+	Attendees []Attendee `json:"attendees,omitempty"`
+	// Version растет при каждом изменении события (для серии - любого повторения).
+	// Ненулевая версия в запросе на изменение или удаление - условие: событие не менялось
+	Version int `json:"version,omitempty"`
+	// UpdatedBy и UpdatedAt кто и когда последним изменил событие, заполняет хранилище
+	UpdatedBy int       `json:"updated_by,omitempty"`
+	UpdatedAt time.Time `json:"updated_at,omitempty"`
+	// DeletedAt когда событие попало в корзину, у событий календаря пусто
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	// UID исходный UID события, импортированного из чужого iCalendar. Выгружается
+	// без изменений, чтобы другие календари узнали событие. Не меняется после создания
+	UID string `json:"uid,omitempty"`
+}
+
+// decode декодирует данные из reader в json
+func (ev *Event) decode(r io.Reader) error {
+	if err := json.NewDecoder(r).Decode(ev); err != nil {
+		return fmt.Errorf("invalid json: %v", err)
+	}
+	return nil
+}
+
+// clone возвращает глубокую копию события: срезы и указатели события из хранилища
+// нельзя менять на месте, иначе правка попадет в хранилище мимо журнала
+func (ev *Event) clone() Event {
+	res := *ev
+	if ev.Recurrence != nil {
+		rule := *ev.Recurrence
+		rule.ByDay = append([]string(nil), rule.ByDay...)
+		rule.ExDates = append([]time.Time(nil), rule.ExDates...)
+		res.Recurrence = &rule
+	}
+	if ev.RecurrenceID != nil {
+		t := *ev.RecurrenceID
+		res.RecurrenceID = &t
+	}
+	if ev.DeletedAt != nil {
+		t := *ev.DeletedAt
+		res.DeletedAt = &t
+	}
+	if ev.Reminders != nil {
+		res.Reminders = append(make([]Reminder, 0, len(ev.Reminders)), ev.Reminders...)
+	}
+	if ev.Attendees != nil {
+		res.Attendees = append(make([]Attendee, 0, len(ev.Attendees)), ev.Attendees...)
+	}
+	res.Overrides = nil
+	for i := range ev.Overrides {
+		res.Overrides = append(res.Overrides, ev.Overrides[i].clone())
+	}
+	return res
+}
+
+// validate проверяет наличие данных в обязательных полях и приводит время к поясу события
+func (ev *Event) validate() error {
+	switch {
+	case ev.UserID <= 0:
+		return fmt.Errorf("invalid user_id")
+	case ev.EventID < 0:
+		// 0 - идентификатор выдаст хранилище при создании
+		return fmt.Errorf("invalid event_id")
+	case ev.Title == "":
+		return fmt.Errorf("invalid title")
+	case ev.Recurrence != nil:
+		if err := ev.Recurrence.validate(); err != nil {
+			return err
+		}
+	}
+	for _, rem := range ev.Reminders {
+		if rem.MinutesBefore < 0 || rem.MinutesBefore > maxReminderMinutes {
+			return fmt.Errorf("invalid reminder minutes_before %v", rem.MinutesBefore)
+		}
+	}
+	if err := ev.validateAttendees(); err != nil {
+		return err
+	}
+
+	loc, err := loadLocation(ev.TimeZone)
+	if err != nil {
+		return fmt.Errorf("invalid time_zone %q", ev.TimeZone)
+	}
+	if ev.Start.IsZero() {
+		ev.Start = ev.Date
+	}
+	if ev.Start.IsZero() {
+		return fmt.Errorf("invalid start")
+	}
+	if ev.End.IsZero() {
+		ev.End = ev.Start
+	}
+	if ev.End.Before(ev.Start) {
+		return fmt.Errorf("end is before start")
+	}
+	ev.Start, ev.End = ev.Start.In(loc), ev.End.In(loc)
+	ev.Date = ev.Start
+
+	return nil
+}
+
+// locations кэш загруженных часовых поясов
+var locations sync.Map
+
+// loadLocation загружает IANA-пояс, пустое имя означает UTC
+func loadLocation(name string) (*time.Location, error) {
+	if name == "" {
+		return time.UTC, nil
+	}
+	if loc, ok := locations.Load(name); ok {
+		return loc.(*time.Location), nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, err
+	}
+	locations.Store(name, loc)
+	return loc, nil
+}
+
+// location возвращает пояс события, пояс проверен в validate
+func (ev *Event) location() *time.Location {
+	loc, err := loadLocation(ev.TimeZone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// start возвращает начало события в его поясе.
+// После сериализации время теряет пояс, поэтому он восстанавливается здесь
+func (ev *Event) start() time.Time {
+	start := ev.Start
+	if start.IsZero() {
+		// События, сохраненные до появления start
+		start = ev.Date
+	}
+	return start.In(ev.location())
+}
+
+// end возвращает конец события в его поясе
+func (ev *Event) end() time.Time {
+	if ev.End.IsZero() {
+		return ev.start()
+	}
+	return ev.End.In(ev.location())
+}
+
+// intersects проверяет пересечение промежутков [s1, e1) и [s2, e2).
+// Событие нулевой длительности считается точкой, которая тоже может пересекаться
+func intersects(s1, e1, s2, e2 time.Time) bool {
+	if !e1.After(s1) {
+		e1 = s1.Add(time.Nanosecond)
+	}
+	if !e2.After(s2) {
+		e2 = s2.Add(time.Nanosecond)
+	}
+	return s1.Before(e2) && s2.Before(e1)
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	walFileName      = "events.wal"
+	snapshotFileName = "events.snapshot"
+
+	defaultSnapshotEvery    = 1000
+	defaultSnapshotInterval = 5 * time.Minute
+)
+
+// FileStorage долговечное хранилище: состояние держится в памяти,
+// каждое изменение до применения дописывается в журнал (write-ahead log),
+// а журнал периодически сворачивается в снапшот.
+// При старте состояние восстанавливается из снапшота и хвоста журнала
+type FileStorage struct {
+	*MemoryStorage
+
+	dir           string
+	wal           *os.File // защищен MemoryStorage.mu
+	records       int      // записей в журнале с момента последнего снапшота
+	snapshotEvery int
+
+	snapshotCh chan struct{}
+	done       chan struct{}
+	wg         sync.WaitGroup
+}
+
+// Конструктор файлового хранилища, восстанавливает состояние из каталога dir
+func openFileStorage(dir string, snapshotEvery int, snapshotInterval time.Duration) (*FileStorage, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("can't create storage dir: %w", err)
+	}
+
+	fs := &FileStorage{
+		MemoryStorage: newMemoryStorage(),
+		dir:           dir,
+		snapshotEvery: snapshotEvery,
+		snapshotCh:    make(chan struct{}, 1),
+		done:          make(chan struct{}),
+	}
+
+	if err := fs.recover(); err != nil {
+		return nil, err
+	}
+
+	// После восстановления сразу сворачиваем журнал, чтобы следующий старт был быстрым
+	fs.mu.Lock()
+	err := fs.snapshot()
+	fs.mu.Unlock()
+	if err != nil {
+		fs.wal.Close()
+		return nil, err
+	}
+
+	fs.journal = fs.append
+
+	fs.wg.Add(1)
+	go fs.snapshotLoop(snapshotInterval)
+
+	return fs, nil
+}
+
+// recover загружает снапшот и проигрывает журнал.
+// Недописанная последняя запись (падение во время записи) отбрасывается
+func (fs *FileStorage) recover() error {
+	data, err := os.ReadFile(filepath.Join(fs.dir, snapshotFileName))
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return fmt.Errorf("can't read snapshot: %w", err)
+	default:
+		var events []Event
+		if err := json.Unmarshal(data, &events); err != nil {
+			return fmt.Errorf("corrupted snapshot: %w", err)
+		}
+		for _, ev := range events {
+			if err := fs.MemoryStorage.put(ev); err != nil {
+				return err
+			}
+		}
+	}
+
+	fs.wal, err = os.OpenFile(filepath.Join(fs.dir, walFileName), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return fmt.Errorf("can't open journal: %w", err)
+	}
+
+	var offset int64
+	reader := bufio.NewReader(fs.wal)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			if len(bytes.TrimSpace(line)) != 0 {
+				log.Printf("storage: dropping torn journal record at offset %d", offset)
+			}
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("can't read journal: %w", err)
+		}
+
+		var rec journalRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			// Битая запись допустима только последней
+			if _, peekErr := reader.Peek(1); peekErr != io.EOF {
+				return fmt.Errorf("corrupted journal at offset %d: %w", offset, err)
+			}
+			log.Printf("storage: dropping torn journal record at offset %d", offset)
+			break
+		}
+		if err := fs.apply(rec); err != nil {
+			return err
+		}
+		offset += int64(len(line))
+		fs.records++
+	}
+
+	if err := fs.wal.Truncate(offset); err != nil {
+		return fmt.Errorf("can't truncate journal: %w", err)
+	}
+	_, err = fs.wal.Seek(offset, io.SeekStart)
+	return err
+}
+
+// append дописывает запись в журнал и дожидается ее попадания на диск
+func (fs *FileStorage) append(rec journalRecord) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	if _, err := fs.wal.Write(line); err != nil {
+		return fmt.Errorf("can't write journal: %w", err)
+	}
+	if err := fs.wal.Sync(); err != nil {
+		return fmt.Errorf("can't sync journal: %w", err)
+	}
+
+	fs.records++
+	if fs.records >= fs.snapshotEvery {
+		// Снапшот делается в фоне, сейчас мы под блокировкой хранилища
+		select {
+		case fs.snapshotCh <- struct{}{}:
+		default:
+		}
+	}
+
+	return nil
+}
+
+// snapshot атомарно записывает текущее состояние и обнуляет журнал, вызывается под блокировкой.
+// События корзины лежат в том же списке и отличаются DeletedAt
+func (fs *FileStorage) snapshot() error {
+	data, err := json.Marshal(append(fs.all(), fs.allTrashed()...))
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(fs.dir, snapshotFileName)
+	tmp, err := os.Create(path + ".tmp")
+	if err != nil {
+		return fmt.Errorf("can't create snapshot: %w", err)
+	}
+	if _, err = tmp.Write(data); err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("can't write snapshot: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("can't replace snapshot: %w", err)
+	}
+	if dir, err := os.Open(fs.dir); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+
+	// Если упадем до обрезки журнала, при восстановлении записи применятся повторно - это безопасно
+	if err := fs.wal.Truncate(0); err != nil {
+		return fmt.Errorf("can't truncate journal: %w", err)
+	}
+	if _, err := fs.wal.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	fs.records = 0
+
+	return nil
+}
+
+// snapshotLoop делает снапшоты по таймеру и по заполнению журнала
+func (fs *FileStorage) snapshotLoop(interval time.Duration) {
+	defer fs.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-fs.done:
+			return
+		case <-ticker.C:
+		case <-fs.snapshotCh:
+		}
+
+		fs.mu.Lock()
+		var err error
+		if fs.records > 0 {
+			err = fs.snapshot()
+		}
+		fs.mu.Unlock()
+
+		if err != nil {
+			log.Printf("storage: %v", err)
+		}
+	}
+}
+
+// Close делает финальный снапшот и закрывает журнал
+func (fs *FileStorage) Close() error {
+	close(fs.done)
+	fs.wg.Wait()
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	err := fs.snapshot()
+	if closeErr := fs.wal.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
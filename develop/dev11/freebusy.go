@@ -0,0 +1,325 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// maxFreeBusyRange наибольший диапазон запроса занятости и поиска слотов
+	maxFreeBusyRange = 62 * 24 * time.Hour
+	maxFreeBusyUsers = 50
+	defaultSlotStep  = 15 * time.Minute
+	defaultSlots     = 10
+	maxSlots         = 100
+)
+
+// interval промежуток времени [start, end)
+type interval struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// mergeIntervals сортирует промежутки и склеивает пересекающиеся и смежные
+func mergeIntervals(in []interval) []interval {
+	sort.Slice(in, func(i, j int) bool { return in[i].Start.Before(in[j].Start) })
+
+	res := make([]interval, 0, len(in))
+	for _, iv := range in {
+		if n := len(res); n > 0 && !iv.Start.After(res[n-1].End) {
+			if iv.End.After(res[n-1].End) {
+				res[n-1].End = iv.End
+			}
+			continue
+		}
+		res = append(res, iv)
+	}
+	return res
+}
+
+// busyIntervals занятость пользователя в [from, to): свои события и приглашения,
+// на которые он ответил accepted или tentative. Время событий не раскрывается
+func busyIntervals(st Storage, userID int, from, to time.Time) ([]interval, error) {
+	events, err := st.between(userID, from, to)
+	var unknown *unknownUserError
+	if errors.As(err, &unknown) {
+		return []interval{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var res []interval
+	for i := range events {
+		ev := &events[i]
+		if ev.UserID != userID && ev.attendeeStatus(userID) == rsvpNeedsAction {
+			continue
+		}
+		start, end := ev.start(), ev.end()
+		if !end.After(start) {
+			continue
+		}
+		if start.Before(from) {
+			start = from
+		}
+		if end.After(to) {
+			end = to
+		}
+		res = append(res, interval{Start: start.In(from.Location()), End: end.In(from.Location())})
+	}
+	return mergeIntervals(res), nil
+}
+
+// freeBusy занятость каждого из пользователей в [from, to)
+func freeBusy(st Storage, userIDs []int, from, to time.Time) (map[int][]interval, error) {
+	res := make(map[int][]interval, len(userIDs))
+	for _, id := range userIDs {
+		busy, err := busyIntervals(st, id, from, to)
+		if err != nil {
+			return nil, err
+		}
+		res[id] = busy
+	}
+	return res, nil
+}
+
+// slotRequest параметры поиска времени для встречи
+type slotRequest struct {
+	UserIDs  []int
+	From, To time.Time
+	Duration time.Duration
+	// WorkStart и WorkEnd рабочие часы как смещение от полуночи по местным часам пояса From
+	WorkStart, WorkEnd time.Duration
+	Weekends           bool
+	Step               time.Duration
+	Limit              int
+}
+
+// findSlots предлагает до Limit промежутков длительностью Duration, когда все
+// пользователи свободны. Рабочие часы задаются по местным часам каждых суток,
+// поэтому переход на летнее время их не сдвигает
+func findSlots(st Storage, req slotRequest) ([]interval, error) {
+	busyByUser, err := freeBusy(st, req.UserIDs, req.From, req.To)
+	if err != nil {
+		return nil, err
+	}
+	var busy []interval
+	for _, b := range busyByUser {
+		busy = append(busy, b...)
+	}
+	busy = mergeIntervals(busy)
+
+	loc := req.From.Location()
+	res := make([]interval, 0)
+	day, _ := dayWindow(req.From)
+	for ; day.Before(req.To) && len(res) < req.Limit; day = day.AddDate(0, 0, 1) {
+		if !req.Weekends && (day.Weekday() == time.Saturday || day.Weekday() == time.Sunday) {
+			continue
+		}
+
+		hm := func(d time.Duration) time.Time {
+			return time.Date(day.Year(), day.Month(), day.Day(), int(d/time.Hour), int(d%time.Hour/time.Minute), 0, 0, loc)
+		}
+		workStart, workEnd := hm(req.WorkStart), hm(req.WorkEnd)
+
+		for start := workStart; len(res) < req.Limit; start = start.Add(req.Step) {
+			end := start.Add(req.Duration)
+			if end.After(workEnd) || end.After(req.To) {
+				break
+			}
+			if start.Before(req.From) {
+				continue
+			}
+			if free, next := isFree(busy, start, end); !free {
+				// Пропускаем занятый промежуток целиком, оставаясь на сетке шага
+				steps := (next.Sub(start) + req.Step - 1) / req.Step
+				start = start.Add((steps - 1) * req.Step)
+				continue
+			}
+			res = append(res, interval{Start: start, End: end})
+		}
+	}
+
+	return res, nil
+}
+
+// isFree проверяет, что [start, end) не пересекается с занятостью,
+// иначе возвращает конец мешающего промежутка
+func isFree(busy []interval, start, end time.Time) (bool, time.Time) {
+	i := sort.Search(len(busy), func(i int) bool { return busy[i].End.After(start) })
+	if i < len(busy) && busy[i].Start.Before(end) {
+		return false, busy[i].End
+	}
+	return true, time.Time{}
+}
+
+// ===== Разбор параметров и обработчики =====
+
+// parseUserIDs список пользователей users=3,7,12
+func parseUserIDs(v string) ([]int, error) {
+	var res []int
+	seen := make(map[int]bool)
+	for _, part := range strings.Split(v, ",") {
+		id, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || id <= 0 {
+			return nil, fmt.Errorf("invalid users")
+		}
+		if !seen[id] {
+			seen[id] = true
+			res = append(res, id)
+		}
+	}
+	if len(res) > maxFreeBusyUsers {
+		return nil, fmt.Errorf("too many users, max %d", maxFreeBusyUsers)
+	}
+	return res, nil
+}
+
+// parseRange даты from и to включительно в поясе tz
+func parseRange(q url.Values) (time.Time, time.Time, error) {
+	loc, err := loadLocation(q.Get("tz"))
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid tz %q", q.Get("tz"))
+	}
+	from, err := time.ParseInLocation(dateFormat, q.Get("from"), loc)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid from")
+	}
+	to, err := time.ParseInLocation(dateFormat, q.Get("to"), loc)
+	if err != nil || to.Before(from) {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid to")
+	}
+	to = to.AddDate(0, 0, 1)
+	if to.Sub(from) > maxFreeBusyRange {
+		return time.Time{}, time.Time{}, fmt.Errorf("range is too long, max %v days", int(maxFreeBusyRange.Hours()/24))
+	}
+	return from, to, nil
+}
+
+// parseMinutes длительность "45m", "1h30m" или число минут
+func parseMinutes(v string) (time.Duration, error) {
+	if n, err := strconv.Atoi(v); err == nil {
+		return time.Duration(n) * time.Minute, nil
+	}
+	return time.ParseDuration(v)
+}
+
+// parseClock время суток "09:00" как смещение от полуночи
+func parseClock(v string) (time.Duration, error) {
+	t, err := time.Parse("15:04", v)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// FreeBusyHandler /free_busy?users=3,7,12&from=2019-09-09&to=2019-09-15&tz=Europe/Moscow
+func FreeBusyHandler(w http.ResponseWriter, r *http.Request) {
+	if _, status, err := authorize(r, 0); err != nil {
+		getErrResponse(w, err.Error(), status)
+		return
+	}
+
+	q := r.URL.Query()
+	userIDs, err := parseUserIDs(q.Get("users"))
+	if err != nil {
+		getErrResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	from, to, err := parseRange(q)
+	if err != nil {
+		getErrResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	busy, err := freeBusy(storage, userIDs, from, to)
+	if err != nil {
+		getErrResponse(w, err.Error(), storageErrStatus(r, err))
+		return
+	}
+
+	resp := struct {
+		Result string             `json:"result"`
+		Busy   map[int][]interval `json:"busy"`
+	}{Result: "Запрос успешно выполнен!", Busy: busy}
+
+	writeJSON(w, resp, http.StatusOK)
+}
+
+// FindSlotsHandler /find_slots?users=3,7,12&from=...&to=...&duration=45m
+// &work_start=09:00&work_end=18:00&tz=...&weekends=false&step=15m&limit=10
+func FindSlotsHandler(w http.ResponseWriter, r *http.Request) {
+	if _, status, err := authorize(r, 0); err != nil {
+		getErrResponse(w, err.Error(), status)
+		return
+	}
+
+	q := r.URL.Query()
+	req := slotRequest{WorkStart: 9 * time.Hour, WorkEnd: 18 * time.Hour, Step: defaultSlotStep, Limit: defaultSlots}
+	var err error
+	if req.UserIDs, err = parseUserIDs(q.Get("users")); err != nil {
+		getErrResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.From, req.To, err = parseRange(q); err != nil {
+		getErrResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Duration, err = parseMinutes(q.Get("duration")); err != nil || req.Duration <= 0 {
+		getErrResponse(w, "invalid duration", http.StatusBadRequest)
+		return
+	}
+	if v := q.Get("work_start"); v != "" {
+		if req.WorkStart, err = parseClock(v); err != nil {
+			getErrResponse(w, "invalid work_start", http.StatusBadRequest)
+			return
+		}
+	}
+	if v := q.Get("work_end"); v != "" {
+		if req.WorkEnd, err = parseClock(v); err != nil {
+			getErrResponse(w, "invalid work_end", http.StatusBadRequest)
+			return
+		}
+	}
+	if req.WorkEnd <= req.WorkStart {
+		getErrResponse(w, "work_end must be after work_start", http.StatusBadRequest)
+		return
+	}
+	if v := q.Get("weekends"); v != "" {
+		if req.Weekends, err = strconv.ParseBool(v); err != nil {
+			getErrResponse(w, "invalid weekends", http.StatusBadRequest)
+			return
+		}
+	}
+	if v := q.Get("step"); v != "" {
+		if req.Step, err = parseMinutes(v); err != nil || req.Step < time.Minute {
+			getErrResponse(w, "invalid step", http.StatusBadRequest)
+			return
+		}
+	}
+	if v := q.Get("limit"); v != "" {
+		if req.Limit, err = strconv.Atoi(v); err != nil || req.Limit <= 0 || req.Limit > maxSlots {
+			getErrResponse(w, fmt.Sprintf("limit must be between 1 and %d", maxSlots), http.StatusBadRequest)
+			return
+		}
+	}
+
+	slots, err := findSlots(storage, req)
+	if err != nil {
+		getErrResponse(w, err.Error(), storageErrStatus(r, err))
+		return
+	}
+
+	resp := struct {
+		Result string     `json:"result"`
+		Slots  []interval `json:"slots"`
+	}{Result: "Запрос успешно выполнен!", Slots: slots}
+
+	writeJSON(w, resp, http.StatusOK)
+}
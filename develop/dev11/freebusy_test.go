@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// createAll сохраняет события в хранилище, приводя их к валидному виду
+func createAll(t *testing.T, st Storage, events ...Event) {
+	t.Helper()
+	for i := range events {
+		if err := events[i].validate(); err != nil {
+			t.Fatal(err)
+		}
+		if err := st.Create(&events[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestFreeBusy(t *testing.T) {
+	st := newMemoryStorage()
+	createAll(t, st,
+		Event{UserID: 3, EventID: 1, Title: "a", Start: mustTime(t, "2019-09-09T09:00:00Z"), End: mustTime(t, "2019-09-09T10:00:00Z")},
+		Event{UserID: 3, EventID: 2, Title: "b", Start: mustTime(t, "2019-09-09T09:30:00Z"), End: mustTime(t, "2019-09-09T11:00:00Z")},
+		Event{UserID: 3, EventID: 3, Title: "c", Start: mustTime(t, "2019-09-09T11:00:00Z"), End: mustTime(t, "2019-09-09T11:30:00Z")},
+		// Приглашение без ответа не занимает время, принятое - занимает
+		Event{UserID: 7, EventID: 1, Title: "d", Start: mustTime(t, "2019-09-09T14:00:00Z"), End: mustTime(t, "2019-09-09T15:00:00Z"),
+			Attendees: []Attendee{{UserID: 3}, {UserID: 12}}},
+	)
+	if _, err := st.respond(7, 1, 12, rsvpAccepted); err != nil {
+		t.Fatal(err)
+	}
+
+	from, to := mustTime(t, "2019-09-09T00:00:00Z"), mustTime(t, "2019-09-10T00:00:00Z")
+	busy, err := freeBusy(st, []int{3, 7, 12, 99}, from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	format := func(in []interval) string {
+		res := ""
+		for _, iv := range in {
+			res += fmt.Sprintf("[%s-%s)", iv.Start.Format("15:04"), iv.End.Format("15:04"))
+		}
+		return res
+	}
+	want := map[int]string{3: "[09:00-11:30)", 7: "[14:00-15:00)", 12: "[14:00-15:00)", 99: ""}
+	for id, w := range want {
+		if got := format(busy[id]); got != w {
+			t.Errorf("user %v: got %v, want %v", id, got, w)
+		}
+	}
+}
+
+func TestFindSlots(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip(err)
+	}
+
+	st := newMemoryStorage()
+	createAll(t, st,
+		// Пятница 2019-03-29: 09:00-12:00 и 12:30-17:30 по Берлину заняты
+		Event{UserID: 3, EventID: 1, Title: "a", Start: mustTime(t, "2019-03-29T08:00:00Z"), End: mustTime(t, "2019-03-29T11:00:00Z")},
+		Event{UserID: 7, EventID: 1, Title: "b", Start: mustTime(t, "2019-03-29T11:30:00Z"), End: mustTime(t, "2019-03-29T16:30:00Z")},
+	)
+
+	req := slotRequest{
+		UserIDs: []int{3, 7},
+		From:    time.Date(2019, 3, 29, 0, 0, 0, 0, berlin), To: time.Date(2019, 4, 2, 0, 0, 0, 0, berlin),
+		Duration: 30 * time.Minute, WorkStart: 9 * time.Hour, WorkEnd: 18 * time.Hour, Step: 30 * time.Minute, Limit: 3,
+	}
+	slots, err := findSlots(st, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Пятница: 12:00 и 17:30, выходные пропускаются, в понедельник после перехода
+	// на летнее время рабочий день все равно начинается в 09:00 по местному времени
+	want := []string{"2019-03-29T12:00:00+01:00", "2019-03-29T17:30:00+01:00", "2019-04-01T09:00:00+02:00"}
+	if len(slots) != len(want) {
+		t.Fatalf("got %v slots: %v", len(slots), slots)
+	}
+	for i, w := range want {
+		if got := slots[i].Start.Format(time.RFC3339); got != w {
+			t.Errorf("slot %v: got %v, want %v", i, got, w)
+		}
+	}
+}
+
+func TestFreeBusyEndpoints(t *testing.T) {
+	srv, _ := newTestServer(t)
+	alice := issueToken(t, srv, "1")
+
+	status, res := doRequest(t, http.MethodGet, srv.URL+"/free_busy?users=3,7&from=2019-09-09&to=2019-09-15", alice, "", nil)
+	if status != http.StatusOK {
+		t.Fatalf("free_busy: %v %v", status, res)
+	}
+	if busy := res["busy"].(map[string]interface{}); len(busy) != 2 {
+		t.Errorf("expected both users in response, got %v", busy)
+	}
+
+	status, res = doRequest(t, http.MethodGet, srv.URL+"/find_slots?users=3,7&from=2019-09-09&to=2019-09-09&duration=45&limit=2", alice, "", nil)
+	if status != http.StatusOK || len(res["slots"].([]interface{})) != 2 {
+		t.Fatalf("find_slots: %v %v", status, res)
+	}
+
+	for _, bad := range []string{
+		"/free_busy?users=x&from=2019-09-09&to=2019-09-15",
+		"/free_busy?users=3&from=2019-09-09&to=2020-09-15",
+		"/find_slots?users=3&from=2019-09-09&to=2019-09-15",
+		"/find_slots?users=3&from=2019-09-09&to=2019-09-15&duration=45m&work_start=18:00&work_end=09:00",
+	} {
+		if status, _ := doRequest(t, http.MethodGet, srv.URL+bad, alice, "", nil); status != http.StatusBadRequest {
+			t.Errorf("%v: got %v, want 400", bad, status)
+		}
+	}
+}
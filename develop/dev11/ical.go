@@ -0,0 +1,541 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+/*
+Импорт и экспорт событий в формате iCalendar (RFC 5545).
+Поддерживается подмножество, нужное для обмена с обычными календарями:
+VEVENT с UID, SUMMARY, DESCRIPTION, DTSTART, DTEND, RRULE, EXDATE и RECURRENCE-ID.
+*/
+
+const (
+	icalDateTimeUTC = "20060102T150405Z"
+	icalDateTime    = "20060102T150405"
+	icalDate        = "20060102"
+	icalUIDDomain   = "dev11"
+	icalLineLimit   = 75
+)
+
+// icalProperty строка контента iCalendar: NAME;PARAM=VALUE:value
+type icalProperty struct {
+	name   string
+	params map[string]string
+	value  string
+}
+
+// icalEvent разобранный VEVENT до преобразования в Event
+type icalEvent struct {
+	uid   string
+	props []icalProperty
+}
+
+func (ie *icalEvent) get(name string) (icalProperty, bool) {
+	for _, p := range ie.props {
+		if p.name == name {
+			return p, true
+		}
+	}
+	return icalProperty{}, false
+}
+
+// importResult результат импорта одного VEVENT
+type importResult struct {
+	UID     string `json:"uid"`
+	EventID int    `json:"event_id,omitempty"`
+	Status  string `json:"status"`
+	Error   string `json:"error,omitempty"`
+}
+
+const (
+	importCreated  = "created"
+	importConflict = "conflict"
+	importInvalid  = "invalid"
+)
+
+// ===== Экспорт =====
+
+// icalWriter пишет строки контента с переносом длинных строк и CRLF
+type icalWriter struct {
+	w   *bufio.Writer
+	err error
+}
+
+func (iw *icalWriter) line(s string) {
+	if iw.err != nil {
+		return
+	}
+	// Длинные строки переносятся по 75 октетов (с учетом пробела продолжения), не разрывая руны
+	limit := icalLineLimit
+	for len(s) > limit && iw.err == nil {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(s[cut]) {
+			cut--
+		}
+		_, iw.err = iw.w.WriteString(s[:cut] + "\r\n ")
+		s = s[cut:]
+		limit = icalLineLimit - 1
+	}
+	if iw.err == nil {
+		_, iw.err = iw.w.WriteString(s + "\r\n")
+	}
+}
+
+func escapeText(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`)
+	return r.Replace(s)
+}
+
+// isAllDay событие по одной дате без пояса (полночь UTC, длительность 0 или сутки) выгружается целодневным
+func isAllDay(ev *Event) bool {
+	start := ev.start().UTC()
+	if ev.TimeZone != "" || start.Hour() != 0 || start.Minute() != 0 || start.Second() != 0 || start.Nanosecond() != 0 {
+		return false
+	}
+	d := ev.end().Sub(ev.start())
+	return d == 0 || d == 24*time.Hour
+}
+
+// formatICalTime выводит время целой датой, в поясе события (TZID) или в UTC
+func formatICalTime(name string, t time.Time, allDay bool, tzid string) string {
+	switch {
+	case allDay:
+		return name + ";VALUE=DATE:" + t.UTC().Format(icalDate)
+	case tzid != "":
+		return name + ";TZID=" + tzid + ":" + t.Format(icalDateTime)
+	default:
+		return name + ":" + t.UTC().Format(icalDateTimeUTC)
+	}
+}
+
+func eventUID(ev Event) string {
+	return fmt.Sprintf("%d.%d@%s", ev.EventID, ev.UserID, icalUIDDomain)
+}
+
+func formatRRule(r *Recurrence, allDay bool) string {
+	parts := []string{"FREQ=" + strings.ToUpper(r.Freq)}
+	if r.Interval > 1 {
+		parts = append(parts, "INTERVAL="+strconv.Itoa(r.Interval))
+	}
+	if len(r.ByDay) > 0 {
+		parts = append(parts, "BYDAY="+strings.Join(r.ByDay, ","))
+	}
+	if r.Count > 0 {
+		parts = append(parts, "COUNT="+strconv.Itoa(r.Count))
+	}
+	if !r.Until.IsZero() {
+		if allDay {
+			parts = append(parts, "UNTIL="+r.Until.UTC().Format(icalDate))
+		} else {
+			parts = append(parts, "UNTIL="+r.Until.UTC().Format(icalDateTimeUTC))
+		}
+	}
+	return "RRULE:" + strings.Join(parts, ";")
+}
+
+func writeVEvent(iw *icalWriter, ev Event, uid string, stamp time.Time) {
+	allDay := isAllDay(&ev)
+	start, end := ev.start(), ev.end()
+
+	iw.line("BEGIN:VEVENT")
+	iw.line("UID:" + uid)
+	iw.line("DTSTAMP:" + stamp.UTC().Format(icalDateTimeUTC))
+	iw.line(formatICalTime("DTSTART", start, allDay, ev.TimeZone))
+	switch {
+	case allDay:
+		iw.line(formatICalTime("DTEND", start.AddDate(0, 0, 1), true, ""))
+	case end.After(start):
+		iw.line(formatICalTime("DTEND", end, false, ev.TimeZone))
+	}
+	if ev.RecurrenceID != nil {
+		iw.line(formatICalTime("RECURRENCE-ID", ev.RecurrenceID.In(ev.location()), allDay, ev.TimeZone))
+	}
+	iw.line("SUMMARY:" + escapeText(ev.Title))
+	if ev.Description != "" {
+		iw.line("DESCRIPTION:" + escapeText(ev.Description))
+	}
+	if ev.Recurrence != nil {
+		iw.line(formatRRule(ev.Recurrence, allDay))
+		for _, ex := range ev.Recurrence.ExDates {
+			iw.line(formatICalTime("EXDATE", ex.In(ev.location()), allDay, ev.TimeZone))
+		}
+	}
+	iw.line("END:VEVENT")
+}
+
+// encodeICal пишет события в виде документа VCALENDAR.
+// Измененные повторения серии выгружаются отдельными VEVENT с RECURRENCE-ID
+func encodeICal(w io.Writer, events []Event) error {
+	iw := &icalWriter{w: bufio.NewWriter(w)}
+	stamp := time.Now()
+
+	iw.line("BEGIN:VCALENDAR")
+	iw.line("VERSION:2.0")
+	iw.line("PRODID:-//L2//dev11 calendar//RU")
+	iw.line("CALSCALE:GREGORIAN")
+	for _, ev := range events {
+		uid := ev.UID
+		if uid == "" {
+			uid = eventUID(ev)
+		}
+		writeVEvent(iw, ev, uid, stamp)
+		for _, o := range ev.Overrides {
+			writeVEvent(iw, o, uid, stamp)
+		}
+	}
+	iw.line("END:VCALENDAR")
+
+	if iw.err != nil {
+		return iw.err
+	}
+	return iw.w.Flush()
+}
+
+// exportEvents выбирает события пользователя для выгрузки.
+// Если задан диапазон, серия выгружается целиком, если хотя бы одно повторение попадает в него
+func exportEvents(st Storage, userID int, from, to time.Time) ([]Event, error) {
+	events, err := st.getEvents(userID)
+	if err != nil {
+		return nil, err
+	}
+	if from.IsZero() && to.IsZero() {
+		return events, nil
+	}
+
+	res := make([]Event, 0, len(events))
+	for i := range events {
+		if len(events[i].expand(from, to)) > 0 {
+			res = append(res, events[i])
+		}
+	}
+	return res, nil
+}
+
+// ===== Импорт =====
+
+// unfoldLines читает строки контента, склеивая перенесенные
+func unfoldLines(r io.Reader) ([]string, error) {
+	var lines []string
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for sc.Scan() {
+		line := strings.TrimRight(sc.Text(), "\r")
+		if line == "" {
+			continue
+		}
+		if (line[0] == ' ' || line[0] == '\t') && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines, sc.Err()
+}
+
+// parseProperty разбирает строку NAME;PARAM=VALUE;PARAM="V:1":value
+func parseProperty(line string) (icalProperty, error) {
+	p := icalProperty{params: map[string]string{}}
+
+	inQuotes := false
+	colon := -1
+	for i, c := range line {
+		if c == '"' {
+			inQuotes = !inQuotes
+		} else if c == ':' && !inQuotes {
+			colon = i
+			break
+		}
+	}
+	if colon == -1 {
+		return p, fmt.Errorf("invalid content line %q", line)
+	}
+
+	head := strings.Split(line[:colon], ";")
+	p.name = strings.ToUpper(head[0])
+	p.value = line[colon+1:]
+	for _, param := range head[1:] {
+		k, v, _ := strings.Cut(param, "=")
+		p.params[strings.ToUpper(k)] = strings.Trim(v, `"`)
+	}
+	return p, nil
+}
+
+func unescapeText(s string) string {
+	r := strings.NewReplacer(`\\`, `\`, `\;`, ";", `\,`, ",", `\n`, "\n", `\N`, "\n")
+	return r.Replace(s)
+}
+
+// parseICalTime разбирает DATE, DATE-TIME в UTC, с TZID или "плавающее" время (считаем UTC)
+func parseICalTime(p icalProperty) (time.Time, error) {
+	value := p.value
+	if p.params["VALUE"] == "DATE" || len(value) == len(icalDate) {
+		return time.Parse(icalDate, value)
+	}
+	if strings.HasSuffix(value, "Z") {
+		return time.Parse(icalDateTimeUTC, value)
+	}
+	loc := time.UTC
+	if tzid := p.params["TZID"]; tzid != "" {
+		var err error
+		if loc, err = time.LoadLocation(tzid); err != nil {
+			return time.Time{}, fmt.Errorf("unknown TZID %q", tzid)
+		}
+	}
+	return time.ParseInLocation(icalDateTime, value, loc)
+}
+
+func parseICalTimes(p icalProperty) ([]time.Time, error) {
+	var res []time.Time
+	for _, v := range strings.Split(p.value, ",") {
+		p.value = v
+		t, err := parseICalTime(p)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, t)
+	}
+	return res, nil
+}
+
+// parseRRule разбирает RRULE, неподдерживаемые части приводят к ошибке, а не к неверным повторениям
+func parseRRule(value string) (*Recurrence, error) {
+	r := &Recurrence{}
+	for _, part := range strings.Split(value, ";") {
+		k, v, _ := strings.Cut(part, "=")
+		var err error
+		switch strings.ToUpper(k) {
+		case "FREQ":
+			r.Freq = strings.ToLower(v)
+		case "INTERVAL":
+			r.Interval, err = strconv.Atoi(v)
+		case "COUNT":
+			r.Count, err = strconv.Atoi(v)
+		case "UNTIL":
+			r.Until, err = parseICalTime(icalProperty{value: v})
+		case "BYDAY":
+			r.ByDay = strings.Split(strings.ToUpper(v), ",")
+		case "WKST":
+			if strings.ToUpper(v) != "MO" {
+				err = errors.New("only WKST=MO is supported")
+			}
+		default:
+			err = fmt.Errorf("unsupported RRULE part %s", k)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid RRULE %q: %v", value, err)
+		}
+	}
+	return r, r.validate()
+}
+
+// decodeICal разбирает документ и возвращает VEVENT в порядке появления
+func decodeICal(r io.Reader) ([]icalEvent, error) {
+	lines, err := unfoldLines(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var res []icalEvent
+	var current *icalEvent
+	depth := 0 // вложенные компоненты вроде VALARM пропускаем
+
+	for _, line := range lines {
+		p, err := parseProperty(line)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case p.name == "BEGIN" && strings.EqualFold(p.value, "VEVENT") && current == nil:
+			current = &icalEvent{}
+		case p.name == "BEGIN" && current != nil:
+			depth++
+		case p.name == "END" && current != nil && depth > 0:
+			depth--
+		case p.name == "END" && strings.EqualFold(p.value, "VEVENT") && current != nil:
+			res = append(res, *current)
+			current = nil
+		case current != nil && depth == 0:
+			if p.name == "UID" {
+				current.uid = p.value
+			}
+			current.props = append(current.props, p)
+		}
+	}
+	if current != nil {
+		return nil, errors.New("unterminated VEVENT")
+	}
+
+	return res, nil
+}
+
+// eventIDFromUID сопоставляет UID целочисленный EventID. Свои UID разбираются
+// обратно, числовые берутся как есть. Для чужих UID возвращает 0: такое событие
+// хранит UID, а EventID выдает хранилище
+func eventIDFromUID(uid string) int {
+	if strings.HasSuffix(uid, "@"+icalUIDDomain) {
+		local := strings.TrimSuffix(uid, "@"+icalUIDDomain)
+		if id, _, found := strings.Cut(local, "."); found {
+			if n, err := strconv.Atoi(id); err == nil && n > 0 {
+				return n
+			}
+		}
+	}
+	if n, err := strconv.Atoi(uid); err == nil && n > 0 {
+		return n
+	}
+	return 0
+}
+
+// toEvent преобразует VEVENT в событие пользователя
+func (ie *icalEvent) toEvent(userID int) (Event, error) {
+	ev := Event{UserID: userID, EventID: eventIDFromUID(ie.uid)}
+	if ev.EventID == 0 {
+		ev.UID = ie.uid
+	}
+
+	start, ok := ie.get("DTSTART")
+	if !ok {
+		return ev, errors.New("DTSTART is required")
+	}
+	var err error
+	if ev.Start, err = parseICalTime(start); err != nil {
+		return ev, fmt.Errorf("invalid DTSTART: %v", err)
+	}
+	ev.TimeZone = start.params["TZID"]
+	if end, ok := ie.get("DTEND"); ok {
+		if ev.End, err = parseICalTime(end); err != nil {
+			return ev, fmt.Errorf("invalid DTEND: %v", err)
+		}
+		if ev.End.Before(ev.Start) {
+			return ev, errors.New("DTEND is before DTSTART")
+		}
+	}
+	if p, ok := ie.get("SUMMARY"); ok {
+		ev.Title = unescapeText(p.value)
+	}
+	if p, ok := ie.get("DESCRIPTION"); ok {
+		ev.Description = unescapeText(p.value)
+	}
+	if p, ok := ie.get("RECURRENCE-ID"); ok {
+		rid, err := parseICalTime(p)
+		if err != nil {
+			return ev, fmt.Errorf("invalid RECURRENCE-ID: %v", err)
+		}
+		ev.RecurrenceID = &rid
+	}
+	if p, ok := ie.get("RRULE"); ok {
+		if ev.Recurrence, err = parseRRule(p.value); err != nil {
+			return ev, err
+		}
+		for _, ex := range ie.props {
+			if ex.name != "EXDATE" {
+				continue
+			}
+			dates, err := parseICalTimes(ex)
+			if err != nil {
+				return ev, fmt.Errorf("invalid EXDATE: %v", err)
+			}
+			ev.Recurrence.ExDates = append(ev.Recurrence.ExDates, dates...)
+		}
+	}
+
+	return ev, ev.validate()
+}
+
+// importICal создает события пользователя из документа.
+// Конфликт с существующим EventID или UID не прерывает импорт, а попадает в отчет
+func importICal(st Storage, userID int, r io.Reader) ([]importResult, error) {
+	vevents, err := decodeICal(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// События, импортированные раньше, узнаются по сохраненному UID
+	existing, err := st.getEvents(userID)
+	if err != nil {
+		var unknown *unknownUserError
+		if !errors.As(err, &unknown) {
+			return nil, err
+		}
+	}
+	known := make(map[string]int, len(existing))
+	for _, ev := range existing {
+		if ev.UID != "" {
+			known[ev.UID] = ev.EventID
+		}
+	}
+
+	// Сначала собираем серии, чтобы приложить к ним измененные повторения
+	var order []string
+	series := map[string]*Event{}
+	var results []importResult
+
+	for i := range vevents {
+		ie := &vevents[i]
+		if ie.uid == "" {
+			results = append(results, importResult{Status: importInvalid, Error: "UID is required"})
+			continue
+		}
+		ev, err := ie.toEvent(userID)
+		if err != nil {
+			results = append(results, importResult{UID: ie.uid, EventID: ev.EventID, Status: importInvalid, Error: err.Error()})
+			continue
+		}
+		if ev.RecurrenceID == nil {
+			if _, dup := series[ie.uid]; dup {
+				results = append(results, importResult{UID: ie.uid, EventID: ev.EventID, Status: importInvalid, Error: "duplicate UID"})
+				continue
+			}
+			order = append(order, ie.uid)
+			series[ie.uid] = &ev
+		}
+	}
+	for i := range vevents {
+		ie := &vevents[i]
+		ev, err := ie.toEvent(userID)
+		if err != nil || ev.RecurrenceID == nil {
+			continue
+		}
+		parent, ok := series[ie.uid]
+		if !ok || !parent.hasOccurrence(*ev.RecurrenceID) {
+			results = append(results, importResult{UID: ie.uid, EventID: ev.EventID, Status: importInvalid,
+				Error: "RECURRENCE-ID does not match any occurrence"})
+			continue
+		}
+		ev.UID = ""
+		parent.Overrides = append(parent.Overrides, ev)
+	}
+
+	for _, uid := range order {
+		ev := series[uid]
+		res := importResult{UID: uid, EventID: ev.EventID, Status: importCreated}
+		if id, ok := known[ev.UID]; ok && ev.UID != "" {
+			res.EventID, res.Status = id, importConflict
+			res.Error = (&existsError{userID: userID, eventID: id}).Error()
+			results = append(results, res)
+			continue
+		}
+		err := st.Create(ev)
+		res.EventID = ev.EventID
+		if err != nil {
+			var exists *existsError
+			if errors.As(err, &exists) {
+				res.Status = importConflict
+			} else {
+				res.Status = importInvalid
+			}
+			res.Error = err.Error()
+		}
+		results = append(results, res)
+	}
+
+	return results, nil
+}
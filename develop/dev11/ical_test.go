@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestICalRoundTrip(t *testing.T) {
+	src := newMemoryStorage()
+	day := Event{UserID: 1, EventID: 1, Title: "Отпуск; без связи, совсем",
+		Description: "строка 1\nстрока 2", Date: mustTime(t, "2019-09-09T00:00:00Z")}
+	rid := mustTime(t, "2019-09-09T10:00:00Z")
+	weekly := Event{UserID: 1, EventID: 2, Title: strings.Repeat("длинный заголовок ", 10),
+		Date: mustTime(t, "2019-09-02T10:00:00Z"),
+		Recurrence: &Recurrence{Freq: freqWeekly, ByDay: []string{"MO"}, Count: 4,
+			ExDates: []time.Time{mustTime(t, "2019-09-16T10:00:00Z")}},
+		Overrides: []Event{{UserID: 1, EventID: 2, Title: "moved",
+			Date: mustTime(t, "2019-09-10T10:00:00Z"), RecurrenceID: &rid}},
+	}
+	for _, ev := range []Event{day, weekly} {
+		ev := ev
+		if err := src.Create(&ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	events, err := exportEvents(src, 1, time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := encodeICal(&buf, events); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range strings.Split(buf.String(), "\r\n") {
+		if len(line) > icalLineLimit {
+			t.Errorf("line is not folded: %q", line)
+		}
+	}
+
+	dst := newMemoryStorage()
+	results, err := importICal(dst, 1, bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0].Status != importCreated || results[1].Status != importCreated {
+		t.Fatalf("unexpected import results %+v", results)
+	}
+
+	want, _ := src.getEventsForMonth(1, day.Date)
+	got, _ := dst.getEventsForMonth(1, day.Date)
+	if len(got) != len(want) {
+		t.Fatalf("got %d occurrences, want %d", len(got), len(want))
+	}
+	for i := range got {
+		if got[i].Title != want[i].Title || got[i].Description != want[i].Description || !got[i].Date.Equal(want[i].Date) {
+			t.Errorf("occurrence %d: got %+v, want %+v", i, got[i], want[i])
+		}
+	}
+
+	// Повторный импорт того же документа - конфликты по EventID
+	results, err = importICal(dst, 1, bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, res := range results {
+		if res.Status != importConflict {
+			t.Errorf("expected conflict, got %+v", res)
+		}
+	}
+}
+
+func TestICalImportForeignUID(t *testing.T) {
+	doc := "BEGIN:VCALENDAR\r\nVERSION:2.0\r\n" +
+		"BEGIN:VEVENT\r\nUID:abc@example.com\r\nDTSTART;TZID=Europe/Moscow:20190909T130000\r\n" +
+		"DTEND;TZID=Europe/Moscow:20190909T140000\r\nSUMMARY:Встреча\r\n" +
+		"BEGIN:VALARM\r\nACTION:DISPLAY\r\nEND:VALARM\r\nEND:VEVENT\r\n" +
+		"BEGIN:VEVENT\r\nUID:bad\r\nDTSTART:20190909T100000Z\r\nSUMMARY:x\r\nRRULE:FREQ=WEEKLY;BYMONTH=1\r\nEND:VEVENT\r\n" +
+		"END:VCALENDAR\r\n"
+
+	st := newMemoryStorage()
+	results, err := importICal(st, 5, strings.NewReader(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("unexpected results %+v", results)
+	}
+	if results[0].Status != importInvalid || results[0].UID != "bad" {
+		t.Errorf("unsupported RRULE should be reported, got %+v", results[0])
+	}
+	if results[1].Status != importCreated || results[1].EventID == 0 {
+		t.Errorf("unexpected result %+v", results[1])
+	}
+
+	events, _ := st.getEventsForDay(5, mustTime(t, "2019-09-09T00:00:00Z"))
+	if len(events) != 1 || !events[0].Date.Equal(mustTime(t, "2019-09-09T10:00:00Z")) {
+		t.Fatalf("unexpected events %+v", events)
+	}
+	if events[0].EventID != results[1].EventID || events[0].UID != "abc@example.com" {
+		t.Errorf("foreign UID is not kept: %+v", events[0])
+	}
+
+	// Чужой UID выгружается как есть, и повторный импорт выгрузки узнает событие
+	exported, _ := exportEvents(st, 5, time.Time{}, time.Time{})
+	var buf bytes.Buffer
+	if err := encodeICal(&buf, exported); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "\r\nUID:abc@example.com\r\n") {
+		t.Errorf("UID is changed on export:\n%s", buf.String())
+	}
+	results, err = importICal(st, 5, bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Status != importConflict || results[0].EventID != events[0].EventID {
+		t.Errorf("reimport: %+v", results)
+	}
+
+	// Правка события не теряет UID
+	moved := events[0]
+	moved.Title = "moved"
+	moved.Version = 0
+	if err := st.Update(&moved); err != nil {
+		t.Fatal(err)
+	}
+	if ev, _ := st.getEvent(5, moved.EventID); ev.UID != "abc@example.com" {
+		t.Errorf("UID is lost on update: %+v", ev)
+	}
+}
+
+// TestICalImportAllocatesIDs разные чужие UID получают разные EventID, а не хэш
+func TestICalImportAllocatesIDs(t *testing.T) {
+	var doc strings.Builder
+	doc.WriteString("BEGIN:VCALENDAR\r\nVERSION:2.0\r\n")
+	for i := 0; i < 20; i++ {
+		fmt.Fprintf(&doc, "BEGIN:VEVENT\r\nUID:%d-event@example.com\r\nDTSTART:20190909T100000Z\r\nSUMMARY:x\r\nEND:VEVENT\r\n", i)
+	}
+	doc.WriteString("END:VCALENDAR\r\n")
+
+	st := newMemoryStorage()
+	existing := testEvent(5, 1, "2019-09-09")
+	st.Create(&existing)
+	results, err := importICal(st, 5, strings.NewReader(doc.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := map[int]bool{1: true}
+	for _, res := range results {
+		if res.Status != importCreated || seen[res.EventID] {
+			t.Fatalf("unexpected result %+v", res)
+		}
+		seen[res.EventID] = true
+	}
+}
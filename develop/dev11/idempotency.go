@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	idempotencyKeyHeader  = "Idempotency-Key"
+	defaultIdempotencyTTL = 24 * time.Hour
+	maxIdempotencyKeyLen  = 255
+)
+
+// idempotencyKey ключи разных пользователей не пересекаются
+type idempotencyKey struct {
+	userID int
+	key    string
+}
+
+// savedResponse ответ на первый запрос с ключом. Пока done == false, запрос еще выполняется
+type savedResponse struct {
+	fingerprint [sha256.Size]byte
+	done        bool
+	status      int
+	header      http.Header
+	body        []byte
+	expires     time.Time
+}
+
+// IdempotencyStore повторяет сохраненный ответ на POST с тем же Idempotency-Key,
+// чтобы переотправленный клиентом /create_event не создавал событие второй раз
+// и не получал "already exists". Ключи живут ttl и хранятся только в памяти
+type IdempotencyStore struct {
+	ttl time.Duration
+	now func() time.Time
+
+	mu        sync.Mutex
+	responses map[idempotencyKey]*savedResponse
+	// order ответы в порядке истечения: ttl у всех одинаковый
+	order []orderedResponse
+}
+
+type orderedResponse struct {
+	id    idempotencyKey
+	saved *savedResponse
+}
+
+func newIdempotencyStore(ttl time.Duration) *IdempotencyStore {
+	return &IdempotencyStore{ttl: ttl, now: time.Now, responses: make(map[idempotencyKey]*savedResponse)}
+}
+
+// expire удаляет ключи старше ttl, вызывается под блокировкой
+func (s *IdempotencyStore) expire(now time.Time) {
+	n := 0
+	for ; n < len(s.order); n++ {
+		entry := s.order[n]
+		if entry.saved.expires.After(now) {
+			break
+		}
+		// Ответ на сбой мог быть удален, а ключ занят заново более новым ответом
+		if s.responses[entry.id] == entry.saved {
+			delete(s.responses, entry.id)
+		}
+	}
+	s.order = s.order[n:]
+}
+
+type idempotentCallKey struct{}
+
+// idempotentCall отметка запроса с Idempotency-Key: failed - хранилище не смогло
+// выполнить запрос, ответ не сохраняется
+type idempotentCall struct {
+	failed bool
+}
+
+func idempotentCallFrom(ctx context.Context) *idempotentCall {
+	call, _ := ctx.Value(idempotentCallKey{}).(*idempotentCall)
+	return call
+}
+
+// responseRecorder пишет ответ клиенту и запоминает его для повтора
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
+
+// Wrap оборачивает обработчик: POST с Idempotency-Key выполняется один раз за ttl,
+// повтор с тем же телом получает сохраненный ответ, с другим телом - 422.
+// Ошибки бизнес-логики сохраняются как есть, повтор получает тот же ответ. Ответ
+// на сбой хранилища не сохраняется, такой запрос можно повторить. X-Request-ID
+// не сохраняется: у повтора свой идентификатор
+func (s *IdempotencyStore) Wrap(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyKeyHeader)
+		claims, ok := credentials(r)
+		if r.Method != http.MethodPost || key == "" || !ok {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLen {
+			getErrResponse(w, "Idempotency-Key is too long", http.StatusBadRequest)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			getErrResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		fingerprint := sha256.Sum256(append([]byte(r.URL.String()+"\n"), body...))
+		id := idempotencyKey{userID: claims.UserID, key: key}
+
+		s.mu.Lock()
+		now := s.now()
+		s.expire(now)
+		saved, exists := s.responses[id]
+		if !exists {
+			saved = &savedResponse{fingerprint: fingerprint, expires: now.Add(s.ttl)}
+			s.responses[id] = saved
+			s.order = append(s.order, orderedResponse{id: id, saved: saved})
+		}
+		s.mu.Unlock()
+
+		if exists {
+			s.replay(w, saved, fingerprint)
+			return
+		}
+
+		call := &idempotentCall{}
+		rec := &responseRecorder{ResponseWriter: w}
+		h.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), idempotentCallKey{}, call)))
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if call.failed || rec.status == 0 {
+			delete(s.responses, id)
+			return
+		}
+		saved.done = true
+		saved.status = rec.status
+		saved.header = w.Header().Clone()
+		saved.header.Del(requestIDHeader)
+		saved.body = rec.body.Bytes()
+	})
+}
+
+// replay отдает сохраненный ответ
+func (s *IdempotencyStore) replay(w http.ResponseWriter, saved *savedResponse, fingerprint [sha256.Size]byte) {
+	s.mu.Lock()
+	done, status, header, body := saved.done, saved.status, saved.header, saved.body
+	s.mu.Unlock()
+
+	switch {
+	case saved.fingerprint != fingerprint:
+		getErrResponse(w, "Idempotency-Key was used with a different request", http.StatusUnprocessableEntity)
+	case !done:
+		getErrResponse(w, "request with this Idempotency-Key is still in progress", http.StatusConflict)
+	default:
+		for k, v := range header {
+			w.Header()[k] = v
+		}
+		w.Header().Set("Idempotent-Replayed", "true")
+		w.WriteHeader(status)
+		w.Write(body)
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Индексы MemoryStorage. Все методы вызываются под блокировкой хранилища:
+// индекс меняется в put и remove вместе с s.events, поэтому его не нужно
+// отдельно восстанавливать после загрузки снапшота или проигрывания журнала
+
+// indexEntry одиночное (неповторяющееся) событие в индексе по времени начала
+type indexEntry struct {
+	start   time.Time
+	eventID int
+}
+
+// eventKey событие организатора, на которое приглашен пользователь
+type eventKey struct {
+	userID, eventID int
+}
+
+// userIndex индексы событий одного пользователя
+type userIndex struct {
+	// position позиция события в s.events[userID] по EventID
+	position map[int]int
+	// byStart одиночные события, отсортированные по началу
+	byStart []indexEntry
+	// maxDuration верхняя граница длительности одиночных событий: событие, начавшееся
+	// раньше from-maxDuration, не может пересекать окно [from, to)
+	maxDuration time.Duration
+	// series повторяющиеся события, их экземпляры разворачиваются при запросе
+	series map[int]struct{}
+	// terms обратный индекс: слово из title и description -> EventID
+	terms map[string]map[int]struct{}
+	// sortedTerms ключи terms по алфавиту: слова с общим началом идут подряд
+	sortedTerms []string
+	// invited события других пользователей, куда пользователь приглашен
+	invited map[eventKey]struct{}
+}
+
+func newUserIndex() *userIndex {
+	return &userIndex{
+		position: make(map[int]int),
+		series:   make(map[int]struct{}),
+		terms:    make(map[string]map[int]struct{}),
+		invited:  make(map[eventKey]struct{}),
+	}
+}
+
+// userIndexFor индекс пользователя, создается при первом его событии или приглашении
+func (s *MemoryStorage) userIndexFor(userID int) *userIndex {
+	ui, ok := s.index[userID]
+	if !ok {
+		ui = newUserIndex()
+		s.index[userID] = ui
+	}
+	return ui
+}
+
+// tokenize разбивает текст на слова в нижнем регистре без повторов
+func tokenize(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	seen := make(map[string]bool, len(words))
+	res := words[:0]
+	for _, w := range words {
+		if !seen[w] {
+			seen[w] = true
+			res = append(res, w)
+		}
+	}
+	return res
+}
+
+// eventTerms слова события вместе с измененными повторениями серии
+func eventTerms(ev *Event) []string {
+	text := ev.Title + " " + ev.Description
+	for i := range ev.Overrides {
+		text += " " + ev.Overrides[i].Title + " " + ev.Overrides[i].Description
+	}
+	return tokenize(text)
+}
+
+// indexAdd добавляет событие, лежащее в s.events[userID][pos]
+func (s *MemoryStorage) indexAdd(ev *Event, pos int) {
+	ui := s.userIndexFor(ev.UserID)
+	ui.position[ev.EventID] = pos
+
+	if ev.Recurrence != nil {
+		ui.series[ev.EventID] = struct{}{}
+	} else {
+		entry := indexEntry{start: ev.start(), eventID: ev.EventID}
+		i := sort.Search(len(ui.byStart), func(i int) bool { return !ui.byStart[i].less(entry) })
+		ui.byStart = append(ui.byStart, indexEntry{})
+		copy(ui.byStart[i+1:], ui.byStart[i:])
+		ui.byStart[i] = entry
+		if d := ev.end().Sub(ev.start()); d > ui.maxDuration {
+			ui.maxDuration = d
+		}
+	}
+
+	for _, term := range eventTerms(ev) {
+		if ui.terms[term] == nil {
+			ui.terms[term] = make(map[int]struct{})
+			i := sort.SearchStrings(ui.sortedTerms, term)
+			ui.sortedTerms = append(ui.sortedTerms, "")
+			copy(ui.sortedTerms[i+1:], ui.sortedTerms[i:])
+			ui.sortedTerms[i] = term
+		}
+		ui.terms[term][ev.EventID] = struct{}{}
+	}
+
+	for _, a := range ev.Attendees {
+		s.userIndexFor(a.UserID).invited[eventKey{ev.UserID, ev.EventID}] = struct{}{}
+	}
+}
+
+// indexRemove убирает событие из индексов, позиции остальных событий правит вызывающий
+func (s *MemoryStorage) indexRemove(ev *Event) {
+	ui := s.index[ev.UserID]
+	delete(ui.position, ev.EventID)
+
+	if ev.Recurrence != nil {
+		delete(ui.series, ev.EventID)
+	} else {
+		entry := indexEntry{start: ev.start(), eventID: ev.EventID}
+		i := sort.Search(len(ui.byStart), func(i int) bool { return !ui.byStart[i].less(entry) })
+		if i < len(ui.byStart) && ui.byStart[i] == entry {
+			ui.byStart = append(ui.byStart[:i], ui.byStart[i+1:]...)
+		}
+	}
+
+	for _, term := range eventTerms(ev) {
+		delete(ui.terms[term], ev.EventID)
+		if ids, ok := ui.terms[term]; ok && len(ids) == 0 {
+			delete(ui.terms, term)
+			i := sort.SearchStrings(ui.sortedTerms, term)
+			ui.sortedTerms = append(ui.sortedTerms[:i], ui.sortedTerms[i+1:]...)
+		}
+	}
+
+	for _, a := range ev.Attendees {
+		delete(s.index[a.UserID].invited, eventKey{ev.UserID, ev.EventID})
+	}
+}
+
+func (e indexEntry) less(other indexEntry) bool {
+	if !e.start.Equal(other.start) {
+		return e.start.Before(other.start)
+	}
+	return e.eventID < other.eventID
+}
+
+// candidates возвращает события пользователя, которые могут пересекать [from, to):
+// одиночные события из окна индекса, все серии и приглашения, от которых пользователь
+// не отказался. Точную проверку делает expand
+func (s *MemoryStorage) candidates(userID int, from, to time.Time) []*Event {
+	ui, ok := s.index[userID]
+	if !ok {
+		return nil
+	}
+
+	lower := from.Add(-ui.maxDuration)
+	i := sort.Search(len(ui.byStart), func(i int) bool { return !ui.byStart[i].start.Before(lower) })
+
+	var res []*Event
+	for ; i < len(ui.byStart) && !ui.byStart[i].start.After(to); i++ {
+		res = append(res, &s.events[userID][ui.position[ui.byStart[i].eventID]])
+	}
+	return append(res, s.unindexed(userID)...)
+}
+
+// unindexed серии пользователя и приглашения, от которых он не отказался: по началу
+// они не упорядочены, их экземпляры разворачиваются при каждом запросе
+func (s *MemoryStorage) unindexed(userID int) []*Event {
+	ui := s.index[userID]
+
+	var res []*Event
+	for id := range ui.series {
+		res = append(res, &s.events[userID][ui.position[id]])
+	}
+	for key := range ui.invited {
+		ev := &s.events[key.userID][s.index[key.userID].position[key.eventID]]
+		if ev.attendeeStatus(userID) != rsvpDeclined {
+			res = append(res, ev)
+		}
+	}
+	return res
+}
+
+// matchTerms возвращает EventID событий, в которых каждое слово запроса
+// является началом какого-то слова события, nil - если совпадений нет.
+// Слова с началом w занимают в sortedTerms отрезок, он находится двоичным поиском
+func (s *MemoryStorage) matchTerms(userID int, words []string) map[int]struct{} {
+	ui, ok := s.index[userID]
+	if !ok {
+		return nil
+	}
+
+	var res map[int]struct{}
+	for _, w := range words {
+		found := make(map[int]struct{})
+		for i := sort.SearchStrings(ui.sortedTerms, w); i < len(ui.sortedTerms) && strings.HasPrefix(ui.sortedTerms[i], w); i++ {
+			for id := range ui.terms[ui.sortedTerms[i]] {
+				if _, ok := res[id]; res == nil || ok {
+					found[id] = struct{}{}
+				}
+			}
+		}
+		if len(found) == 0 {
+			return nil
+		}
+		res = found
+	}
+	return res
+}
+
+// matchText проверяет слова запроса на одном экземпляре события
+func matchText(ev *Event, words []string) bool {
+	terms := tokenize(ev.Title + " " + ev.Description)
+	for _, w := range words {
+		found := false
+		for _, term := range terms {
+			if strings.HasPrefix(term, w) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
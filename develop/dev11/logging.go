@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Уровни структурированного лога
+const (
+	levelInfo  = "info"
+	levelWarn  = "warn"
+	levelError = "error"
+)
+
+// field поле записи лога, порядок полей сохраняется
+type field struct {
+	key   string
+	value interface{}
+}
+
+// structuredLogger пишет записи с полями: в формате json - по объекту на строку,
+// в формате text - "время уровень сообщение ключ=значение"
+type structuredLogger struct {
+	mu   sync.Mutex
+	out  io.Writer
+	json bool
+	now  func() time.Time
+}
+
+func newStructuredLogger(out io.Writer, format string) *structuredLogger {
+	return &structuredLogger{out: out, json: format == "json", now: time.Now}
+}
+
+// logger лог сервера, формат выбирается в main
+var logger = newStructuredLogger(os.Stderr, "text")
+
+func (l *structuredLogger) log(level, msg string, fields ...field) {
+	var buf bytes.Buffer
+	now := l.now()
+
+	if l.json {
+		buf.WriteString(`{"time":`)
+		writeJSONValue(&buf, now.Format(time.RFC3339Nano))
+		buf.WriteString(`,"level":`)
+		writeJSONValue(&buf, level)
+		buf.WriteString(`,"msg":`)
+		writeJSONValue(&buf, msg)
+		for _, f := range fields {
+			buf.WriteByte(',')
+			writeJSONValue(&buf, f.key)
+			buf.WriteByte(':')
+			writeJSONValue(&buf, f.value)
+		}
+		buf.WriteString("}\n")
+	} else {
+		fmt.Fprintf(&buf, "%s %s %s", now.Format("2006/01/02 15:04:05"), strings.ToUpper(level), msg)
+		for _, f := range fields {
+			v := fmt.Sprint(f.value)
+			if v == "" || strings.ContainsAny(v, " \"=") {
+				v = strconv.Quote(v)
+			}
+			fmt.Fprintf(&buf, " %s=%s", f.key, v)
+		}
+		buf.WriteByte('\n')
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.out.Write(buf.Bytes())
+}
+
+// writeJSONValue значение, которое не удалось сериализовать, пишется строкой
+func writeJSONValue(buf *bytes.Buffer, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		data, _ = json.Marshal(fmt.Sprint(v))
+	}
+	buf.Write(data)
+}
+
+// stdLogWriter направляет строки стандартного log в структурированный лог,
+// чтобы в формате json все строки были объектами
+type stdLogWriter struct {
+	l *structuredLogger
+}
+
+func (w stdLogWriter) Write(p []byte) (int, error) {
+	w.l.log(levelInfo, strings.TrimRight(string(p), "\n"))
+	return len(p), nil
+}
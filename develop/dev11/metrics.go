@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaultLatencyBuckets границы гистограммы длительности запросов в секундах
+var defaultLatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// knownMethods остальные методы попадают в метку method="other"
+var knownMethods = map[string]bool{
+	http.MethodGet: true, http.MethodHead: true, http.MethodPost: true, http.MethodPut: true,
+	http.MethodPatch: true, http.MethodDelete: true, http.MethodOptions: true,
+}
+
+type routeKey struct {
+	method, route string
+}
+
+type statusKey struct {
+	routeKey
+	code int
+}
+
+// histogram накапливает число наблюдений не больше каждой границы, +Inf - это count
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// Metrics метрики HTTP-запросов, отдаются на /metrics в текстовом формате Prometheus
+type Metrics struct {
+	buckets []float64
+	// inFlight меняется через atomic, остальное под mu
+	inFlight int64
+
+	mu        sync.Mutex
+	requests  map[statusKey]uint64
+	errors    map[statusKey]uint64
+	durations map[routeKey]*histogram
+}
+
+func newMetrics() *Metrics {
+	return &Metrics{
+		buckets:   defaultLatencyBuckets,
+		requests:  make(map[statusKey]uint64),
+		errors:    make(map[statusKey]uint64),
+		durations: make(map[routeKey]*histogram),
+	}
+}
+
+// observe учитывает завершенный запрос. Ошибками считаются ответы 4xx и 5xx
+func (m *Metrics) observe(method, route string, status int, elapsed time.Duration) {
+	if !knownMethods[method] {
+		method = "other"
+	}
+	rk := routeKey{method: method, route: route}
+	sk := statusKey{routeKey: rk, code: status}
+	seconds := elapsed.Seconds()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.requests[sk]++
+	if status >= 400 {
+		m.errors[sk]++
+	}
+
+	h, ok := m.durations[rk]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(m.buckets))}
+		m.durations[rk] = h
+	}
+	for i, le := range m.buckets {
+		if seconds <= le {
+			h.counts[i]++
+		}
+	}
+	h.sum += seconds
+	h.count++
+}
+
+func (m *Metrics) requestStarted() {
+	atomic.AddInt64(&m.inFlight, 1)
+}
+
+func (m *Metrics) requestFinished() {
+	atomic.AddInt64(&m.inFlight, -1)
+}
+
+// escapeLabel экранирование значения метки по правилам текстового формата
+func escapeLabel(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+func (k routeKey) labels() string {
+	return fmt.Sprintf(`method="%s",route="%s"`, escapeLabel(k.method), escapeLabel(k.route))
+}
+
+func (k statusKey) labels() string {
+	return fmt.Sprintf(`%s,code="%d"`, k.routeKey.labels(), k.code)
+}
+
+func (k routeKey) less(other routeKey) bool {
+	if k.route != other.route {
+		return k.route < other.route
+	}
+	return k.method < other.method
+}
+
+func sortedStatusKeys(m map[statusKey]uint64) []statusKey {
+	keys := make([]statusKey, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].routeKey != keys[j].routeKey {
+			return keys[i].routeKey.less(keys[j].routeKey)
+		}
+		return keys[i].code < keys[j].code
+	})
+	return keys
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// ServeHTTP GET /metrics, ряды отсортированы, чтобы вывод был стабильным
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	out := bufio.NewWriter(w)
+	defer out.Flush()
+
+	fmt.Fprintln(out, "# HELP http_requests_in_flight Requests currently being served.")
+	fmt.Fprintln(out, "# TYPE http_requests_in_flight gauge")
+	fmt.Fprintf(out, "http_requests_in_flight %d\n", atomic.LoadInt64(&m.inFlight))
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintln(out, "# HELP http_requests_total Requests by route, method and status code.")
+	fmt.Fprintln(out, "# TYPE http_requests_total counter")
+	for _, k := range sortedStatusKeys(m.requests) {
+		fmt.Fprintf(out, "http_requests_total{%s} %d\n", k.labels(), m.requests[k])
+	}
+
+	fmt.Fprintln(out, "# HELP http_request_errors_total Requests answered with 4xx or 5xx.")
+	fmt.Fprintln(out, "# TYPE http_request_errors_total counter")
+	for _, k := range sortedStatusKeys(m.errors) {
+		fmt.Fprintf(out, "http_request_errors_total{%s} %d\n", k.labels(), m.errors[k])
+	}
+
+	keys := make([]routeKey, 0, len(m.durations))
+	for k := range m.durations {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].less(keys[j]) })
+
+	fmt.Fprintln(out, "# HELP http_request_duration_seconds Request latency by route and method.")
+	fmt.Fprintln(out, "# TYPE http_request_duration_seconds histogram")
+	for _, k := range keys {
+		h := m.durations[k]
+		labels := k.labels()
+		for i, le := range m.buckets {
+			fmt.Fprintf(out, "http_request_duration_seconds_bucket{%s,le=\"%s\"} %d\n", labels, formatFloat(le), h.counts[i])
+		}
+		fmt.Fprintf(out, "http_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels, h.count)
+		fmt.Fprintf(out, "http_request_duration_seconds_sum{%s} %s\n", labels, formatFloat(h.sum))
+		fmt.Fprintf(out, "http_request_duration_seconds_count{%s} %d\n", labels, h.count)
+	}
+}
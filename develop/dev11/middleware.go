@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	requestIDHeader = "X-Request-ID"
+	maxRequestIDLen = 128
+)
+
+type requestInfoKey struct{}
+
+// requestInfo сведения о запросе, которые внутренние обработчики сообщают внешним:
+// Auth записывает сюда пользователя, чтобы он попал в лог
+type requestInfo struct {
+	id     string
+	userID int
+}
+
+func requestInfoFrom(ctx context.Context) *requestInfo {
+	info, _ := ctx.Value(requestInfoKey{}).(*requestInfo)
+	return info
+}
+
+// requestID идентификатор запроса, "" вне цепочки middleware
+func requestID(r *http.Request) string {
+	if info := requestInfoFrom(r.Context()); info != nil {
+		return info.id
+	}
+	return ""
+}
+
+// validRequestID принимаем от клиента только короткий id из печатных ASCII без пробелов
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// withRequestID берет X-Request-ID клиента или выдает новый и возвращает его в ответе
+func withRequestID(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id, _ = randomHex(12)
+		}
+		w.Header().Set(requestIDHeader, id)
+
+		ctx := context.WithValue(r.Context(), requestInfoKey{}, &requestInfo{id: id})
+		h.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// statusRecorder запоминает статус и размер ответа
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += int64(n)
+	return n, err
+}
+
+// Flush нужен потоку /reminders_stream
+func (rec *statusRecorder) Flush() {
+	if f, ok := rec.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// RequestLogger - middleware, которое пишет каждый запрос в структурированный лог
+// и учитывает его в метриках
+type RequestLogger struct {
+	handler http.Handler
+	metrics *Metrics
+	route   func(*http.Request) string
+}
+
+// Конструктор middleware, route дает шаблон пути для меток метрик
+func newRequestLogger(handler http.Handler, metrics *Metrics, route func(*http.Request) string) *RequestLogger {
+	return &RequestLogger{handler: handler, metrics: metrics, route: route}
+}
+
+// ServeHTTP логика хэндлера, опишем этот метод, чтобы удовлетворить интерфейсу
+func (l *RequestLogger) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	route := l.route(r)
+	rec := &statusRecorder{ResponseWriter: w}
+
+	l.metrics.requestStarted()
+	defer l.metrics.requestFinished()
+
+	l.handler.ServeHTTP(rec, r)
+
+	// Обработчик, ничего не записавший, отвечает 200
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	elapsed := time.Since(start)
+	l.metrics.observe(r.Method, route, rec.status, elapsed)
+
+	level := levelInfo
+	switch {
+	case rec.status >= 500:
+		level = levelError
+	case rec.status >= 400:
+		level = levelWarn
+	}
+
+	remote, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		remote = r.RemoteAddr
+	}
+	fields := []field{
+		{"request_id", requestID(r)},
+		{"method", r.Method},
+		{"path", r.URL.Path},
+		{"route", route},
+		{"status", rec.status},
+		{"bytes", rec.bytes},
+		{"duration_ms", float64(elapsed.Microseconds()) / 1000},
+		{"remote", remote},
+	}
+	if info := requestInfoFrom(r.Context()); info != nil && info.userID != 0 {
+		fields = append(fields, field{"user_id", info.userID})
+	}
+	logger.log(level, "request", fields...)
+}
+
+// v2Segments постоянные части путей API v2, остальные сегменты - идентификаторы
+var v2Segments = map[string]bool{
+	"events": true, "invitations": true, "history": true, "webhooks": true, "deliveries": true,
+	"trash": true, "restore": true,
+}
+
+// routeLabel шаблон пути по mux: зарегистрированный путь, для API v2 - путь с {id}
+// вместо идентификаторов. Незнакомые пути сводятся к "other", чтобы число рядов
+// метрик не росло от произвольных URL
+func routeLabel(mux *http.ServeMux) func(*http.Request) string {
+	return func(r *http.Request) string {
+		_, pattern := mux.Handler(r)
+		switch pattern {
+		case "":
+			return "other"
+		case usersPrefix:
+		default:
+			return pattern
+		}
+
+		parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, usersPrefix), "/"), "/")
+		for i, part := range parts {
+			switch {
+			case v2Segments[part]:
+			case part != "" && strings.Trim(part, "0123456789") == "":
+				parts[i] = "{id}"
+			default:
+				return usersPrefix
+			}
+		}
+		return usersPrefix + strings.Join(parts, "/")
+	}
+}
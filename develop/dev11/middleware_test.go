@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRequestIDPropagation(t *testing.T) {
+	var seen string
+	h := withRequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = requestID(r)
+	}))
+
+	// id клиента возвращается как есть
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(requestIDHeader, "abc-123")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if seen != "abc-123" || rec.Header().Get(requestIDHeader) != "abc-123" {
+		t.Fatalf("client id is lost: handler %q, response %q", seen, rec.Header().Get(requestIDHeader))
+	}
+
+	// Без id и с недопустимым id выдается новый
+	for _, id := range []string{"", "with space", strings.Repeat("x", maxRequestIDLen+1)} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(requestIDHeader, id)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		got := rec.Header().Get(requestIDHeader)
+		if got == "" || got == id || got != seen {
+			t.Errorf("for %q got response id %q, handler id %q", id, got, seen)
+		}
+	}
+}
+
+func TestRequestLoggerJSON(t *testing.T) {
+	var buf bytes.Buffer
+	saved := logger
+	logger = newStructuredLogger(&buf, "json")
+	t.Cleanup(func() { logger = saved })
+
+	metrics := newMetrics()
+	inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestInfoFrom(r.Context()).userID = 7
+		getErrResponse(w, "boom", http.StatusServiceUnavailable)
+	})
+	route := func(*http.Request) string { return "/create_event" }
+	h := withRequestID(newRequestLogger(inner, metrics, route))
+
+	req := httptest.NewRequest(http.MethodPost, "/create_event", nil)
+	req.Header.Set(requestIDHeader, "req-1")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("log line is not JSON: %q", buf.String())
+	}
+	want := map[string]interface{}{
+		"level": "error", "msg": "request", "request_id": "req-1", "method": "POST",
+		"route": "/create_event", "status": float64(503), "bytes": float64(rec.Body.Len()), "user_id": float64(7),
+	}
+	for k, v := range want {
+		if entry[k] != v {
+			t.Errorf("%v = %v, want %v", k, entry[k], v)
+		}
+	}
+	if _, ok := entry["duration_ms"]; !ok {
+		t.Error("duration_ms is missing")
+	}
+}
+
+func TestStructuredLoggerText(t *testing.T) {
+	var buf bytes.Buffer
+	l := newStructuredLogger(&buf, "text")
+	l.now = func() time.Time { return time.Date(2019, 9, 9, 10, 0, 0, 0, time.UTC) }
+	l.log(levelWarn, "request", field{"path", "/a b"}, field{"status", 404})
+
+	want := "2019/09/09 10:00:00 WARN request path=\"/a b\" status=404\n"
+	if buf.String() != want {
+		t.Fatalf("got %q, want %q", buf.String(), want)
+	}
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	srv, _ := newTestServer(t)
+	alice := issueToken(t, srv, "1")
+
+	doRequest(t, http.MethodPost, srv.URL+"/users/1/events", alice,
+		`{"title": "standup", "date": "2019-09-09T00:00:00Z"}`, nil)
+	doRequest(t, http.MethodGet, srv.URL+"/users/1/events/42", alice, "", nil)
+	doRequest(t, http.MethodGet, srv.URL+"/events_for_day?date=2019-09-09", "", "", nil)
+
+	// Метрики доступны без токена
+	resp, err := http.Get(srv.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/plain") {
+		t.Fatalf("status %v, content type %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	text := string(body)
+	for _, line := range []string{
+		`http_requests_total{method="POST",route="/users/{id}/events",code="201"} 1`,
+		`http_requests_total{method="GET",route="/users/{id}/events/{id}",code="503"} 1`,
+		`http_request_errors_total{method="GET",route="/events_for_day",code="401"} 1`,
+		`http_request_duration_seconds_bucket{method="POST",route="/users/{id}/events",le="+Inf"} 1`,
+		`http_request_duration_seconds_count{method="GET",route="/events_for_day"} 1`,
+		"# TYPE http_request_duration_seconds histogram",
+		"http_requests_in_flight 1",
+	} {
+		if !strings.Contains(text, line+"\n") {
+			t.Errorf("metrics miss %q", line)
+		}
+	}
+	if strings.Contains(text, `http_request_errors_total{method="POST"`) {
+		t.Error("successful request counted as error")
+	}
+}
+
+func TestRouteLabel(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/events_for_day", func(http.ResponseWriter, *http.Request) {})
+	mux.HandleFunc(usersPrefix, func(http.ResponseWriter, *http.Request) {})
+	route := routeLabel(mux)
+
+	cases := map[string]string{
+		"/events_for_day":            "/events_for_day",
+		"/users/3/events/17/history": "/users/{id}/events/{id}/history",
+		"/users/3/invitations/2/5":   "/users/{id}/invitations/{id}/{id}",
+		"/users/3/../../etc":         "other",
+		"/users/abc/events":          "/users/",
+		"/nowhere":                   "other",
+	}
+	for path, want := range cases {
+		if got := route(httptest.NewRequest(http.MethodGet, path, nil)); got != want {
+			t.Errorf("%v: got %q, want %q", path, got, want)
+		}
+	}
+}
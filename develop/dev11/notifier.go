@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Notifier способ доставки сработавшего напоминания
+type Notifier interface {
+	Notify(ctx context.Context, n Notification) error
+}
+
+// multiNotifier доставляет напоминание всеми способами, ошибка одного не мешает остальным
+type multiNotifier []Notifier
+
+func (m multiNotifier) Notify(ctx context.Context, n Notification) error {
+	var firstErr error
+	for _, notifier := range m {
+		if err := notifier.Notify(ctx, n); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// logNotifier пишет напоминание в лог
+type logNotifier struct{}
+
+func (logNotifier) Notify(_ context.Context, n Notification) error {
+	log.Printf("reminder: user %v event %v %q starts at %v", n.UserID, n.EventID, n.Title, n.Start.Format(time.RFC3339))
+	return nil
+}
+
+// webhookNotifier отправляет напоминание POST-запросом с JSON на заданный URL
+type webhookNotifier struct {
+	url    string
+	client *http.Client
+}
+
+func newWebhookNotifier(url string) *webhookNotifier {
+	return &webhookNotifier{url: url, client: &http.Client{Timeout: notifyTimeout}}
+}
+
+func (wn *webhookNotifier) Notify(ctx context.Context, n Notification) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, wn.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := wn.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with %v", resp.Status)
+	}
+	return nil
+}
+
+// sseBroker раздает напоминания подписчикам server-sent events по пользователям
+type sseBroker struct {
+	mu   sync.Mutex
+	subs map[int]map[chan Notification]struct{}
+	// done закрывается при остановке сервера, чтобы бесконечные потоки не держали Shutdown
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newSSEBroker() *sseBroker {
+	return &sseBroker{subs: make(map[int]map[chan Notification]struct{}), done: make(chan struct{})}
+}
+
+// Close завершает все открытые потоки, новые потоки сразу закрываются
+func (b *sseBroker) Close() {
+	b.closeOnce.Do(func() { close(b.done) })
+}
+
+func (b *sseBroker) subscribe(userID int) chan Notification {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ch := make(chan Notification, 16)
+	if b.subs[userID] == nil {
+		b.subs[userID] = make(map[chan Notification]struct{})
+	}
+	b.subs[userID][ch] = struct{}{}
+	return ch
+}
+
+func (b *sseBroker) unsubscribe(userID int, ch chan Notification) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.subs[userID], ch)
+	if len(b.subs[userID]) == 0 {
+		delete(b.subs, userID)
+	}
+}
+
+// Notify не блокируется: медленный подписчик теряет напоминание, а не тормозит планировщик
+func (b *sseBroker) Notify(_ context.Context, n Notification) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subs[n.UserID] {
+		select {
+		case ch <- n:
+		default:
+			log.Printf("sse: dropping reminder for slow subscriber of user %v", n.UserID)
+		}
+	}
+	return nil
+}
+
+// ServeHTTP поток напоминаний пользователя в формате text/event-stream
+func (b *sseBroker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	userID, status, err := authorizeQuery(r)
+	if err != nil {
+		getErrResponse(w, err.Error(), status)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		getErrResponse(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	ch := b.subscribe(userID)
+	defer b.unsubscribe(userID, ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-b.done:
+			return
+		case n := <-ch:
+			data, err := json.Marshal(n)
+			if err != nil {
+				log.Printf("sse: %v", err)
+				continue
+			}
+			fmt.Fprintf(w, "event: reminder\ndata: %s\n\n", data)
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,698 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Описание API в формате OpenAPI 3. Документ отдается по /openapi.json, и по нему же
+// проверяются параметры пути, строки запроса и тела до передачи запроса обработчику.
+// Проверяется подмножество JSON Schema, которое используется в документе: $ref, allOf,
+// type, format, enum, minimum, maximum, minLength, maxLength, minItems, maxItems,
+// pattern, required, properties, items и nullable
+
+//go:embed openapi.json
+var openAPIDoc []byte
+
+// openAPI разобранный openAPIDoc, документ входит в бинарник, поэтому ошибка в нем - паника при старте
+var openAPI = mustLoadSpec(openAPIDoc)
+
+// apiSchema схема значения
+type apiSchema struct {
+	Ref        string                `json:"$ref"`
+	Type       string                `json:"type"`
+	Format     string                `json:"format"`
+	Enum       []interface{}         `json:"enum"`
+	Minimum    *float64              `json:"minimum"`
+	Maximum    *float64              `json:"maximum"`
+	MinLength  *int                  `json:"minLength"`
+	MaxLength  *int                  `json:"maxLength"`
+	MinItems   *int                  `json:"minItems"`
+	MaxItems   *int                  `json:"maxItems"`
+	Pattern    string                `json:"pattern"`
+	Required   []string              `json:"required"`
+	Properties map[string]*apiSchema `json:"properties"`
+	Items      *apiSchema            `json:"items"`
+	AllOf      []*apiSchema          `json:"allOf"`
+	Nullable   bool                  `json:"nullable"`
+
+	pattern *regexp.Regexp
+}
+
+// apiParameter параметр пути, строки запроса или заголовка
+type apiParameter struct {
+	Ref      string     `json:"$ref"`
+	Name     string     `json:"name"`
+	In       string     `json:"in"`
+	Required bool       `json:"required"`
+	Schema   *apiSchema `json:"schema"`
+	// Explode false - массив передается одним значением через запятую
+	Explode *bool `json:"explode"`
+}
+
+type apiRequestBody struct {
+	Required bool `json:"required"`
+	Content  map[string]struct {
+		Schema *apiSchema `json:"schema"`
+	} `json:"content"`
+}
+
+type apiOperation struct {
+	OperationID string          `json:"operationId"`
+	Parameters  []*apiParameter `json:"parameters"`
+	RequestBody *apiRequestBody `json:"requestBody"`
+}
+
+// apiPathItem операции одного пути по методам и общие для них параметры
+type apiPathItem struct {
+	Parameters []*apiParameter
+	Operations map[string]*apiOperation
+}
+
+func (p *apiPathItem) UnmarshalJSON(data []byte) error {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	p.Operations = make(map[string]*apiOperation)
+	for key, value := range raw {
+		if key == "parameters" {
+			if err := json.Unmarshal(value, &p.Parameters); err != nil {
+				return err
+			}
+			continue
+		}
+		var op apiOperation
+		if err := json.Unmarshal(value, &op); err != nil {
+			return fmt.Errorf("%s: %v", key, err)
+		}
+		p.Operations[strings.ToUpper(key)] = &op
+	}
+	return nil
+}
+
+// apiRoute путь документа, разбитый на сегменты: "{id}" - параметр
+type apiRoute struct {
+	template string
+	segments []string
+	literals int
+	item     *apiPathItem
+}
+
+// apiSpec разобранный документ OpenAPI
+type apiSpec struct {
+	Paths      map[string]*apiPathItem `json:"paths"`
+	Components struct {
+		Schemas    map[string]*apiSchema    `json:"schemas"`
+		Parameters map[string]*apiParameter `json:"parameters"`
+	} `json:"components"`
+
+	routes []apiRoute
+}
+
+func mustLoadSpec(data []byte) *apiSpec {
+	spec, err := loadSpec(data)
+	if err != nil {
+		panic(fmt.Sprintf("openapi.json: %v", err))
+	}
+	return spec
+}
+
+// loadSpec разбирает документ, подставляет ссылки на параметры и проверяет ссылки на схемы
+func loadSpec(data []byte) (*apiSpec, error) {
+	var spec apiSpec
+	if err := json.Unmarshal(data, &spec); err != nil {
+		return nil, err
+	}
+
+	for template, item := range spec.Paths {
+		params := [][]*apiParameter{item.Parameters}
+		for _, op := range item.Operations {
+			params = append(params, op.Parameters)
+		}
+		for _, list := range params {
+			for i, p := range list {
+				if p.Ref == "" {
+					continue
+				}
+				resolved, ok := spec.Components.Parameters[strings.TrimPrefix(p.Ref, "#/components/parameters/")]
+				if !ok {
+					return nil, fmt.Errorf("%s: unknown parameter %s", template, p.Ref)
+				}
+				list[i] = resolved
+			}
+		}
+
+		segments := strings.Split(strings.Trim(template, "/"), "/")
+		route := apiRoute{template: template, segments: segments, item: item}
+		for _, s := range segments {
+			if !strings.HasPrefix(s, "{") {
+				route.literals++
+			}
+		}
+		spec.routes = append(spec.routes, route)
+	}
+	// При совпадении нескольких путей выбирается путь с большим числом постоянных сегментов
+	sort.Slice(spec.routes, func(i, j int) bool {
+		if spec.routes[i].literals != spec.routes[j].literals {
+			return spec.routes[i].literals > spec.routes[j].literals
+		}
+		return spec.routes[i].template < spec.routes[j].template
+	})
+
+	seen := make(map[*apiSchema]bool)
+	for _, item := range spec.Paths {
+		for _, op := range item.Operations {
+			for _, p := range append(append([]*apiParameter(nil), item.Parameters...), op.Parameters...) {
+				if err := spec.prepare(p.Schema, seen); err != nil {
+					return nil, err
+				}
+			}
+			if op.RequestBody == nil {
+				continue
+			}
+			for _, media := range op.RequestBody.Content {
+				if err := spec.prepare(media.Schema, seen); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	for _, s := range spec.Components.Schemas {
+		if err := spec.prepare(s, seen); err != nil {
+			return nil, err
+		}
+	}
+	return &spec, nil
+}
+
+// prepare проверяет ссылки и компилирует pattern во всех вложенных схемах
+func (spec *apiSpec) prepare(s *apiSchema, seen map[*apiSchema]bool) error {
+	if s == nil || seen[s] {
+		return nil
+	}
+	seen[s] = true
+
+	if s.Ref != "" {
+		target := spec.resolve(s)
+		if target == s {
+			return fmt.Errorf("unknown schema %s", s.Ref)
+		}
+		return spec.prepare(target, seen)
+	}
+	if s.Pattern != "" {
+		var err error
+		if s.pattern, err = regexp.Compile(s.Pattern); err != nil {
+			return err
+		}
+	}
+	for _, sub := range s.Properties {
+		if err := spec.prepare(sub, seen); err != nil {
+			return err
+		}
+	}
+	for _, sub := range s.AllOf {
+		if err := spec.prepare(sub, seen); err != nil {
+			return err
+		}
+	}
+	return spec.prepare(s.Items, seen)
+}
+
+// resolve схема по $ref, для схемы без ссылки или с неизвестной ссылкой - она сама
+func (spec *apiSpec) resolve(s *apiSchema) *apiSchema {
+	if s.Ref == "" {
+		return s
+	}
+	if target, ok := spec.Components.Schemas[strings.TrimPrefix(s.Ref, "#/components/schemas/")]; ok {
+		return target
+	}
+	return s
+}
+
+// find путь документа для пути запроса и значения параметров пути
+func (spec *apiSpec) find(path string) (*apiPathItem, map[string]string) {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	for _, route := range spec.routes {
+		if len(route.segments) != len(segments) {
+			continue
+		}
+		params := make(map[string]string)
+		matched := true
+		for i, s := range route.segments {
+			switch {
+			case strings.HasPrefix(s, "{"):
+				params[strings.Trim(s, "{}")] = segments[i]
+			case s != segments[i]:
+				matched = false
+			}
+			if !matched {
+				break
+			}
+		}
+		if matched {
+			return route.item, params
+		}
+	}
+	return nil, nil
+}
+
+// fieldError ошибка значения одного параметра или поля тела
+type fieldError struct {
+	Field   string `json:"field"`
+	In      string `json:"in"`
+	Message string `json:"message"`
+}
+
+// fieldCheck собирает ошибки проверки. lenient - значение пришло строкой из запроса или формы:
+// время принимается в любом из timeLayouts, как его разбирают обработчики
+type fieldCheck struct {
+	spec    *apiSpec
+	in      string
+	lenient bool
+	errs    []fieldError
+}
+
+func (c *fieldCheck) fail(field, format string, args ...interface{}) {
+	c.errs = append(c.errs, fieldError{Field: field, In: c.in, Message: fmt.Sprintf(format, args...)})
+}
+
+// validateRequest проверяет запрос по операции документа, тело после проверки остается доступным обработчику
+func (spec *apiSpec) validateRequest(r *http.Request, item *apiPathItem, op *apiOperation, pathParams map[string]string) []fieldError {
+	var errs []fieldError
+
+	// Параметры операции переопределяют одноименные параметры пути
+	params := make(map[string]*apiParameter)
+	var order []string
+	for _, p := range append(append([]*apiParameter(nil), item.Parameters...), op.Parameters...) {
+		key := p.In + ":" + p.Name
+		if _, ok := params[key]; !ok {
+			order = append(order, key)
+		}
+		params[key] = p
+	}
+
+	query := r.URL.Query()
+	for _, key := range order {
+		p := params[key]
+		var values []string
+		switch p.In {
+		case "path":
+			values = []string{pathParams[p.Name]}
+		case "query":
+			values = query[p.Name]
+		default:
+			continue
+		}
+		c := fieldCheck{spec: spec, in: p.In, lenient: true}
+		c.strings(p.Schema, p.Name, values, p.Required, p.Explode == nil || *p.Explode)
+		errs = append(errs, c.errs...)
+	}
+
+	if op.RequestBody != nil {
+		errs = append(errs, spec.validateBody(r, op.RequestBody)...)
+	}
+	return errs
+}
+
+// validateBody проверяет тело JSON или формы. Тела других типов проверяет обработчик
+func (spec *apiSpec) validateBody(r *http.Request, body *apiRequestBody) []fieldError {
+	mediaType := "application/json"
+	if ct := r.Header.Get("Content-Type"); ct != "" {
+		var err error
+		if mediaType, _, err = mime.ParseMediaType(ct); err != nil {
+			return nil
+		}
+	}
+	content, ok := body.Content[mediaType]
+	if !ok || content.Schema == nil || r.Body == nil || (mediaType != "application/json" && mediaType != "application/x-www-form-urlencoded") {
+		return nil
+	}
+
+	data, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(data))
+	if err != nil {
+		return nil
+	}
+
+	c := fieldCheck{spec: spec, in: "body"}
+	if len(bytes.TrimSpace(data)) == 0 {
+		if body.Required {
+			c.fail("", "request body is required")
+		}
+		return c.errs
+	}
+
+	if mediaType == "application/json" {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		var v interface{}
+		if err := dec.Decode(&v); err != nil {
+			// Синтаксическую ошибку сообщит обработчик
+			return nil
+		}
+		c.value(content.Schema, "", v)
+		return c.errs
+	}
+
+	form, err := url.ParseQuery(string(data))
+	if err != nil {
+		return nil
+	}
+	c.lenient = true
+	c.form(content.Schema, form)
+	return c.errs
+}
+
+// form проверяет поля формы по свойствам схемы объекта
+func (c *fieldCheck) form(s *apiSchema, form url.Values) {
+	s = c.spec.resolve(s)
+	for _, sub := range s.AllOf {
+		c.form(sub, form)
+	}
+	required := make(map[string]bool, len(s.Required))
+	for _, name := range s.Required {
+		required[name] = true
+		if _, ok := s.Properties[name]; !ok && len(form[name]) == 0 {
+			c.fail(name, "is required")
+		}
+	}
+
+	names := make([]string, 0, len(s.Properties))
+	for name := range s.Properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		c.strings(s.Properties[name], name, form[name], required[name], true)
+	}
+}
+
+// strings проверяет значение, пришедшее строками: параметр или поле формы
+func (c *fieldCheck) strings(s *apiSchema, field string, values []string, required, explode bool) {
+	s = c.spec.resolve(s)
+	if len(values) == 0 || (len(values) == 1 && values[0] == "") {
+		if required {
+			c.fail(field, "is required")
+		}
+		return
+	}
+
+	if s.Type != "array" {
+		if v, ok := c.coerce(s, field, values[0]); ok {
+			c.value(s, field, v)
+		}
+		return
+	}
+
+	var items []interface{}
+	for _, v := range values {
+		parts := []string{v}
+		if !explode {
+			parts = strings.Split(v, ",")
+		}
+		for _, part := range parts {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				continue
+			}
+			i := len(items)
+			if item, ok := c.coerce(c.spec.resolve(s.Items), fmt.Sprintf("%s[%d]", field, i), part); ok {
+				items = append(items, item)
+			} else {
+				return
+			}
+		}
+	}
+	c.value(s, field, items)
+}
+
+// coerce приводит строку к типу схемы
+func (c *fieldCheck) coerce(s *apiSchema, field, v string) (interface{}, bool) {
+	s = c.spec.resolve(s)
+	switch s.Type {
+	case "integer", "number":
+		if _, err := strconv.ParseFloat(v, 64); err != nil {
+			c.fail(field, "must be %s", typeName(s.Type))
+			return nil, false
+		}
+		return json.Number(v), true
+	case "boolean":
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			c.fail(field, "must be a boolean")
+			return nil, false
+		}
+		return b, true
+	default:
+		return v, true
+	}
+}
+
+func typeName(t string) string {
+	switch t {
+	case "integer", "object", "array":
+		return "an " + t
+	default:
+		return "a " + t
+	}
+}
+
+// value проверяет значение JSON: json.Number, string, bool, []interface{}, map[string]interface{} или nil
+func (c *fieldCheck) value(s *apiSchema, field string, v interface{}) {
+	if s == nil || (v == nil && s.Nullable) {
+		return
+	}
+	s = c.spec.resolve(s)
+	for _, sub := range s.AllOf {
+		c.value(sub, field, v)
+	}
+	if v == nil {
+		if s.Type != "" && !s.Nullable {
+			c.fail(field, "must not be null")
+		}
+		return
+	}
+
+	switch s.Type {
+	case "integer":
+		n, ok := v.(json.Number)
+		if _, err := strconv.ParseInt(string(n), 10, 64); !ok || err != nil {
+			c.fail(field, "must be an integer")
+			return
+		}
+	case "number":
+		if _, ok := v.(json.Number); !ok {
+			c.fail(field, "must be a number")
+			return
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			c.fail(field, "must be a boolean")
+			return
+		}
+	case "string":
+		str, ok := v.(string)
+		if !ok {
+			c.fail(field, "must be a string")
+			return
+		}
+		c.stringValue(s, field, str)
+	case "array":
+		items, ok := v.([]interface{})
+		if !ok {
+			c.fail(field, "must be an array")
+			return
+		}
+		switch {
+		case s.MinItems != nil && len(items) < *s.MinItems:
+			c.fail(field, "must have at least %d items", *s.MinItems)
+		case s.MaxItems != nil && len(items) > *s.MaxItems:
+			c.fail(field, "must have at most %d items", *s.MaxItems)
+		}
+		for i, item := range items {
+			c.value(s.Items, fmt.Sprintf("%s[%d]", field, i), item)
+		}
+	case "object":
+		if _, ok := v.(map[string]interface{}); !ok {
+			c.fail(field, "must be an object")
+			return
+		}
+	}
+
+	if n, ok := v.(json.Number); ok {
+		f, _ := n.Float64()
+		switch {
+		case s.Minimum != nil && f < *s.Minimum:
+			c.fail(field, "must be >= %v", *s.Minimum)
+		case s.Maximum != nil && f > *s.Maximum:
+			c.fail(field, "must be <= %v", *s.Maximum)
+		}
+	}
+	if len(s.Enum) > 0 && !inEnum(s.Enum, v) {
+		c.fail(field, "must be one of %s", enumList(s.Enum))
+	}
+
+	obj, ok := v.(map[string]interface{})
+	if !ok {
+		return
+	}
+	for _, name := range s.Required {
+		if _, ok := obj[name]; !ok {
+			c.fail(joinField(field, name), "is required")
+		}
+	}
+	names := make([]string, 0, len(obj))
+	for name := range obj {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if sub, ok := s.Properties[name]; ok {
+			c.value(sub, joinField(field, name), obj[name])
+		}
+	}
+}
+
+// stringValue проверяет длину, pattern и format строки
+func (c *fieldCheck) stringValue(s *apiSchema, field, v string) {
+	switch {
+	case s.MinLength != nil && len(v) < *s.MinLength:
+		c.fail(field, "must be at least %d characters", *s.MinLength)
+	case s.MaxLength != nil && len(v) > *s.MaxLength:
+		c.fail(field, "must be at most %d characters", *s.MaxLength)
+	case s.pattern != nil && !s.pattern.MatchString(v):
+		c.fail(field, "must match %s", s.Pattern)
+	}
+
+	var err error
+	switch s.Format {
+	case "date":
+		_, err = time.Parse(dateFormat, v)
+		if err != nil {
+			c.fail(field, "must be a date YYYY-MM-DD")
+		}
+	case "date-time":
+		if c.lenient {
+			_, err = parseTimeValue(v, time.UTC)
+		} else {
+			_, err = time.Parse(time.RFC3339, v)
+		}
+		if err != nil {
+			c.fail(field, "must be a date-time in RFC 3339")
+		}
+	case "timezone":
+		if _, err = loadLocation(v); err != nil {
+			c.fail(field, "must be an IANA time zone")
+		}
+	case "duration":
+		if _, err = time.ParseDuration(v); err != nil {
+			c.fail(field, "must be a duration like 24h")
+		}
+	case "minutes":
+		if _, err = parseMinutes(v); err != nil {
+			c.fail(field, "must be a number of minutes or a duration like 1h30m")
+		}
+	case "clock":
+		if _, err = parseClock(v); err != nil {
+			c.fail(field, "must be a time of day HH:MM")
+		}
+	case "uri":
+		if u, err := url.Parse(v); err != nil || !u.IsAbs() {
+			c.fail(field, "must be an absolute URL")
+		}
+	}
+}
+
+func joinField(parent, name string) string {
+	if parent == "" {
+		return name
+	}
+	return parent + "." + name
+}
+
+func inEnum(enum []interface{}, v interface{}) bool {
+	for _, e := range enum {
+		if fmt.Sprint(e) == fmt.Sprint(v) {
+			return true
+		}
+	}
+	return false
+}
+
+func enumList(enum []interface{}) string {
+	parts := make([]string, len(enum))
+	for i, e := range enum {
+		parts[i] = fmt.Sprint(e)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Validator проверяет запросы по описанию API. Пути и методы, которых нет в описании,
+// передаются дальше: на них ответит роутер
+type Validator struct {
+	handler http.Handler
+	spec    *apiSpec
+}
+
+// Конструктор middleware
+func newValidator(handler http.Handler, spec *apiSpec) *Validator {
+	return &Validator{handler: handler, spec: spec}
+}
+
+// ServeHTTP логика хэндлера, опишем этот метод, чтобы удовлетворить интерфейсу
+func (v *Validator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	item, pathParams := v.spec.find(r.URL.Path)
+	if item == nil {
+		v.handler.ServeHTTP(w, r)
+		return
+	}
+	op, ok := item.Operations[r.Method]
+	if !ok {
+		v.handler.ServeHTTP(w, r)
+		return
+	}
+
+	if errs := v.spec.validateRequest(r, item, op, pathParams); len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+	v.handler.ServeHTTP(w, r)
+}
+
+// writeValidationErrors ответ 400: в error - все ошибки строкой, в fields - по полям
+func writeValidationErrors(w http.ResponseWriter, errs []fieldError) {
+	parts := make([]string, len(errs))
+	for i, e := range errs {
+		if e.Field == "" {
+			parts[i] = e.Message
+		} else {
+			parts[i] = e.Field + ": " + e.Message
+		}
+	}
+
+	resp := struct {
+		Error  string       `json:"error"`
+		Fields []fieldError `json:"fields"`
+	}{Error: "invalid request: " + strings.Join(parts, "; "), Fields: errs}
+
+	writeJSON(w, resp, http.StatusBadRequest)
+}
+
+// OpenAPIHandler /openapi.json handler
+func OpenAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPIDoc)
+}
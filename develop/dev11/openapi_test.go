@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestOpenAPIDocument(t *testing.T) {
+	srv, _ := newTestServer(t)
+
+	resp, err := http.Get(srv.URL + "/openapi.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var doc struct {
+		OpenAPI string                     `json:"openapi"`
+		Paths   map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("document is not served without token: %v %v", resp.StatusCode, err)
+	}
+	if !strings.HasPrefix(doc.OpenAPI, "3.") {
+		t.Fatalf("openapi version %q", doc.OpenAPI)
+	}
+
+	// Каждый путь роутера описан в документе
+	for _, path := range []string{
+		"/create_event", "/update_event", "/delete_event", "/events_for_day", "/events_for_week", "/events_for_month",
+		"/export_events", "/import_events", "/free_busy", "/find_slots", "/reminders_stream",
+		"/auth/token", "/auth/revoke", "/auth/tokens", "/metrics", "/openapi.json",
+		"/analytics", "/users/{id}/events", "/users/{id}/events/{eventID}", "/users/{id}/events/{eventID}/history",
+		"/users/{id}/trash", "/users/{id}/trash/{eventID}/restore",
+		"/users/{id}/invitations", "/users/{id}/invitations/{organizerID}/{eventID}",
+		"/users/{id}/webhooks", "/users/{id}/webhooks/{webhookID}", "/users/{id}/webhooks/{webhookID}/deliveries",
+	} {
+		if _, ok := doc.Paths[path]; !ok {
+			t.Errorf("%s is not documented", path)
+		}
+	}
+}
+
+// TestOpenAPIMatchesRouter каждая операция документа есть в роутере: ни 404, ни 405
+func TestOpenAPIMatchesRouter(t *testing.T) {
+	srv, _ := newTestServer(t)
+	alice := issueToken(t, srv, "1")
+
+	var ops []string
+	for template, item := range openAPI.Paths {
+		if template == "/reminders_stream" {
+			continue
+		}
+		for method := range item.Operations {
+			ops = append(ops, method+" "+template)
+		}
+	}
+	sort.Strings(ops)
+
+	for _, op := range ops {
+		parts := strings.SplitN(op, " ", 2)
+		path := strings.NewReplacer("{id}", "1", "{eventID}", "1", "{organizerID}", "2", "{webhookID}", "1").Replace(parts[1])
+		status, res := doRequest(t, parts[0], srv.URL+path, alice, "", nil)
+		if status == http.StatusNotFound || status == http.StatusMethodNotAllowed {
+			t.Errorf("%s: %v %v", op, status, res)
+		}
+	}
+}
+
+func TestValidationErrors(t *testing.T) {
+	srv, _ := newTestServer(t)
+	alice := issueToken(t, srv, "1")
+	form := map[string]string{"Content-Type": "application/x-www-form-urlencoded"}
+
+	tests := []struct {
+		name    string
+		method  string
+		path    string
+		body    string
+		headers map[string]string
+		fields  []string
+		in      string
+	}{
+		{"bad date", http.MethodGet, "/events_for_day?date=09.09.2019", "", nil, []string{"date"}, "query"},
+		{"missing date", http.MethodGet, "/events_for_week", "", nil, []string{"date"}, "query"},
+		{"bad user_id and tz", http.MethodGet, "/events_for_month?user_id=abc&date=2019-09-09&tz=Mars/Base", "", nil,
+			[]string{"tz", "user_id"}, "query"},
+		{"json types", http.MethodPost, "/create_event",
+			`{"user_id": "1", "title": 5, "start": "2019-09-09", "attendees": [{"user_id": 0}], "reminders": [{"minutes_before": -1}]}`, nil,
+			[]string{"attendees[0].user_id", "reminders[0].minutes_before", "start", "title", "user_id"}, "body"},
+		{"missing title", http.MethodPost, "/create_event", `{"start": "2019-09-09T10:00:00Z"}`, nil, []string{"title"}, "body"},
+		{"update without event_id", http.MethodPost, "/update_event", `{"title": "standup"}`, nil, []string{"event_id"}, "body"},
+		{"recurrence", http.MethodPost, "/users/1/events",
+			`{"title": "standup", "date": "2019-09-09T10:00:00Z", "recurrence": {"freq": "hourly", "by_day": ["XX"]}}`, nil,
+			[]string{"recurrence.by_day[0]", "recurrence.freq"}, "body"},
+		{"form int", http.MethodPost, "/create_event", "event_id=x&title=standup&date=2019-09-09", form, []string{"event_id"}, "body"},
+		{"form list", http.MethodPost, "/users/1/events", "title=standup&date=2019-09-09&attendee=2&attendee=y", form,
+			[]string{"attendee[1]"}, "body"},
+		{"path id", http.MethodGet, "/users/abc/events?from=2019-09-09&to=2019-09-10", "", nil, []string{"id"}, "path"},
+		{"user list", http.MethodGet, "/free_busy?users=3,x&from=2019-09-09&to=2019-09-15", "", nil, []string{"users[1]"}, "query"},
+		{"enum", http.MethodGet, "/users/1/events?from=2019-09-09&to=2019-09-10&order=random", "", nil, []string{"order"}, "query"},
+		{"webhook url", http.MethodPost, "/users/1/webhooks", `{"url": "/callback", "events": ["event.moved"]}`, nil,
+			[]string{"events[0]", "url"}, "body"},
+		{"rsvp", http.MethodPut, "/users/1/invitations/2/1", `{}`, nil, []string{"status"}, "body"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, res := doRequest(t, tt.method, srv.URL+tt.path, alice, tt.body, tt.headers)
+			if status != http.StatusBadRequest {
+				t.Fatalf("got %v %v, want 400", status, res)
+			}
+			list, _ := res["fields"].([]interface{})
+			var fields []string
+			for _, f := range list {
+				f := f.(map[string]interface{})
+				if f["in"] != tt.in || f["message"] == "" {
+					t.Errorf("unexpected field error %v", f)
+				}
+				fields = append(fields, f["field"].(string))
+			}
+			sort.Strings(fields)
+			if strings.Join(fields, ",") != strings.Join(tt.fields, ",") {
+				t.Errorf("fields %v, want %v (%v)", fields, tt.fields, res["error"])
+			}
+			if msg, _ := res["error"].(string); !strings.HasPrefix(msg, "invalid request: ") {
+				t.Errorf("error %q", msg)
+			}
+		})
+	}
+}
+
+func TestValidationPassesValidRequests(t *testing.T) {
+	srv, _ := newTestServer(t)
+	alice := issueToken(t, srv, "1")
+
+	status, res := doRequest(t, http.MethodPost, srv.URL+"/create_event",
+		alice, `{"title": "standup", "start": "2019-09-09T10:00:00+03:00", "recurrence": null, "recurrence_id": null,
+		"time_zone": "Europe/Moscow", "reminders": [{"minutes_before": 15}], "unknown": true}`, nil)
+	if status != http.StatusCreated {
+		t.Fatalf("valid event is rejected: %v %v", status, res)
+	}
+	status, res = doRequest(t, http.MethodGet, srv.URL+"/find_slots?users=1&from=2019-09-09&to=2019-09-09&duration=1h30m&weekends=true",
+		alice, "", nil)
+	if status != http.StatusOK {
+		t.Fatalf("valid query is rejected: %v %v", status, res)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// Режимы проверки пересечений для /create_event и /update_event
+const (
+	overlapAllow  = ""
+	overlapReject = "reject"
+	overlapReport = "report"
+)
+
+// overlapHorizon насколько вперед проверяются пересечения бесконечной серии
+const overlapHorizon = 366 * 24 * time.Hour
+
+// overlapError событие пересекается с другими событиями пользователя
+type overlapError struct {
+	conflicts []Event
+}
+
+func (e *overlapError) Error() string {
+	return fmt.Sprintf("event overlaps with %d existing event(s)", len(e.conflicts))
+}
+
+// findOverlaps возвращает экземпляры других событий пользователя, пересекающиеся с ev,
+// вызывается под блокировкой. Серии сравниваются по повторениям в пределах overlapHorizon.
+// Для измененного повторения пропускается только оно само, а не вся его серия
+func (s *MemoryStorage) findOverlaps(ev *Event) []Event {
+	from, to := ev.start(), ev.end()
+	if ev.Recurrence != nil && ev.RecurrenceID == nil {
+		to = from.Add(overlapHorizon)
+	}
+
+	var own []Event
+	if ev.RecurrenceID != nil {
+		own = []Event{*ev}
+	} else {
+		own = ev.expand(from, to)
+	}
+	if len(own) == 0 {
+		return nil
+	}
+	from, to = own[0].start(), own[0].end()
+	for i := range own {
+		if own[i].start().Before(from) {
+			from = own[i].start()
+		}
+		if own[i].end().After(to) {
+			to = own[i].end()
+		}
+	}
+	// Окно не должно быть пустым даже для события нулевой длительности
+	to = to.Add(time.Nanosecond)
+
+	var res []Event
+	for _, other := range s.candidates(ev.UserID, from, to) {
+		same := other.UserID == ev.UserID && other.EventID == ev.EventID
+		if same && ev.RecurrenceID == nil {
+			continue
+		}
+		for _, occ := range other.expand(from, to) {
+			// Переносимое повторение не пересекается само с собой, остальные повторения серии - могут
+			if same && occ.RecurrenceID != nil && occ.RecurrenceID.Equal(*ev.RecurrenceID) {
+				continue
+			}
+			for j := range own {
+				if intersects(occ.start(), occ.end(), own[j].start(), own[j].end()) {
+					res = append(res, occ)
+					break
+				}
+			}
+		}
+	}
+
+	return res
+}
+
+func (s *MemoryStorage) overlaps(ev *Event) ([]Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.findOverlaps(ev), nil
+}
+
+// createExclusive создает событие, только если оно ни с чем не пересекается
+func (s *MemoryStorage) createExclusive(ev *Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if conflicts := s.findOverlaps(ev); len(conflicts) > 0 {
+		return &overlapError{conflicts: conflicts}
+	}
+	return s.create(ev)
+}
+
+// updateExclusive обновляет событие, только если новое время ни с чем не пересекается
+func (s *MemoryStorage) updateExclusive(ev *Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if conflicts := s.findOverlaps(ev); len(conflicts) > 0 {
+		return &overlapError{conflicts: conflicts}
+	}
+	return s.update(ev)
+}
+
+// saveEvent создает или обновляет событие с учетом режима проверки пересечений.
+// В режиме report событие сохраняется, а найденные пересечения возвращаются вызывающему
+func saveEvent(st Storage, ev *Event, create bool, mode string) ([]Event, error) {
+	switch mode {
+	case overlapAllow:
+		if create {
+			return nil, st.Create(ev)
+		}
+		return nil, st.Update(ev)
+	case overlapReject:
+		if create {
+			return nil, st.createExclusive(ev)
+		}
+		return nil, st.updateExclusive(ev)
+	case overlapReport:
+		conflicts, err := st.overlaps(ev)
+		if err != nil {
+			return nil, err
+		}
+		if create {
+			err = st.Create(ev)
+		} else {
+			err = st.Update(ev)
+		}
+		return conflicts, err
+	default:
+		return nil, fmt.Errorf("invalid overlap mode %q", mode)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestOverlapModes(t *testing.T) {
+	s := newMemoryStorage()
+	meeting := Event{UserID: 1, EventID: 1, Title: "meeting", TimeZone: "Europe/Moscow",
+		Start: mustTime(t, "2019-09-09T14:00:00+03:00"), End: mustTime(t, "2019-09-09T15:30:00+03:00")}
+	if err := meeting.validate(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := saveEvent(s, &meeting, true, overlapReject); err != nil {
+		t.Fatal(err)
+	}
+
+	// Встреча сразу после не пересекается: промежутки полуоткрытые
+	next := Event{UserID: 1, EventID: 2, Title: "next",
+		Start: mustTime(t, "2019-09-09T12:30:00Z"), End: mustTime(t, "2019-09-09T13:00:00Z")}
+	next.validate()
+	if _, err := saveEvent(s, &next, true, overlapReject); err != nil {
+		t.Fatalf("adjacent event rejected: %v", err)
+	}
+
+	clash := Event{UserID: 1, EventID: 3, Title: "clash",
+		Start: mustTime(t, "2019-09-09T12:00:00Z"), End: mustTime(t, "2019-09-09T12:15:00Z")}
+	clash.validate()
+	_, err := saveEvent(s, &clash, true, overlapReject)
+	var overlap *overlapError
+	if !errors.As(err, &overlap) || len(overlap.conflicts) != 1 || overlap.conflicts[0].EventID != 1 {
+		t.Fatalf("expected overlap with event 1, got %v", err)
+	}
+
+	conflicts, err := saveEvent(s, &clash, true, overlapReport)
+	if err != nil || len(conflicts) != 1 {
+		t.Fatalf("report mode should save and report, got %v %v", conflicts, err)
+	}
+
+	// Еженедельная серия пересекается с событием через две недели
+	later := Event{UserID: 1, EventID: 4, Title: "later",
+		Start: mustTime(t, "2019-09-23T11:00:00Z"), End: mustTime(t, "2019-09-23T11:30:00Z")}
+	later.validate()
+	if _, err := saveEvent(s, &later, true, overlapReject); err != nil {
+		t.Fatal(err)
+	}
+	series := Event{UserID: 1, EventID: 5, Title: "standup",
+		Start: mustTime(t, "2019-09-02T11:15:00Z"), End: mustTime(t, "2019-09-02T11:45:00Z"),
+		Recurrence: &Recurrence{Freq: freqWeekly}}
+	series.validate()
+	if _, err := saveEvent(s, &series, true, overlapReject); !errors.As(err, &overlap) {
+		t.Fatalf("expected series overlap, got %v", err)
+	}
+}
+
+func TestTimeZoneWindows(t *testing.T) {
+	s := newMemoryStorage()
+	// Еженедельно в 10:00 по Берлину: время суток сохраняется при переходе на зимнее время
+	ev := Event{UserID: 1, EventID: 1, Title: "standup", TimeZone: "Europe/Berlin",
+		Start: mustTime(t, "2019-10-21T10:00:00+02:00"), End: mustTime(t, "2019-10-21T10:15:00+02:00"),
+		Recurrence: &Recurrence{Freq: freqWeekly}}
+	if err := ev.validate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Create(&ev); err != nil {
+		t.Fatal(err)
+	}
+
+	berlin, _ := time.LoadLocation("Europe/Berlin")
+	date, _ := time.ParseInLocation(dateFormat, "2019-10-28", berlin)
+	events, err := s.getEventsForDay(1, date)
+	if err != nil || len(events) != 1 {
+		t.Fatalf("unexpected %v %v", events, err)
+	}
+	if !events[0].Start.Equal(mustTime(t, "2019-10-28T09:00:00Z")) {
+		t.Errorf("occurrence after DST switch should stay at 10:00 local, got %v", events[0].Start)
+	}
+
+	// Для клиента в Лос-Анджелесе это 02:00 28 октября по его времени
+	la, _ := time.LoadLocation("America/Los_Angeles")
+	date, _ = time.ParseInLocation(dateFormat, "2019-10-27", la)
+	if events, _ := s.getEventsForDay(1, date); len(events) != 0 {
+		t.Errorf("no occurrence expected on 2019-10-27 in LA, got %v", events)
+	}
+	date, _ = time.ParseInLocation(dateFormat, "2019-10-28", la)
+	if events, _ := s.getEventsForDay(1, date); len(events) != 1 {
+		t.Errorf("occurrence expected on 2019-10-28 in LA, got %v", events)
+	}
+}
+
+// TestOverlapOwnSeries перенос повторения на следующее повторение той же серии - пересечение
+func TestOverlapOwnSeries(t *testing.T) {
+	s := newMemoryStorage()
+	series := Event{UserID: 1, EventID: 1, Title: "standup",
+		Start: mustTime(t, "2019-09-02T11:00:00Z"), End: mustTime(t, "2019-09-02T11:30:00Z"),
+		Recurrence: &Recurrence{Freq: freqWeekly}}
+	series.validate()
+	if _, err := saveEvent(s, &series, true, overlapReject); err != nil {
+		t.Fatal(err)
+	}
+
+	rid := mustTime(t, "2019-09-09T11:00:00Z")
+	moved := series.occurrence(rid)
+	moved.Start, moved.End = mustTime(t, "2019-09-09T11:15:00Z"), mustTime(t, "2019-09-09T11:45:00Z")
+	moved.Date = moved.Start
+	if _, err := saveEvent(s, &moved, false, overlapReject); err != nil {
+		t.Fatalf("occurrence overlaps with itself: %v", err)
+	}
+
+	moved.Start, moved.End = mustTime(t, "2019-09-16T11:10:00Z"), mustTime(t, "2019-09-16T11:40:00Z")
+	moved.Date = moved.Start
+	_, err := saveEvent(s, &moved, false, overlapReject)
+	var overlap *overlapError
+	if !errors.As(err, &overlap) || len(overlap.conflicts) != 1 ||
+		!overlap.conflicts[0].RecurrenceID.Equal(mustTime(t, "2019-09-16T11:00:00Z")) {
+		t.Fatalf("expected overlap with the next occurrence, got %v", err)
+	}
+}
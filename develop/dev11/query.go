@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultQueryLimit = 100
+	maxQueryLimit     = 1000
+)
+
+// eventQuery параметры поиска экземпляров событий пользователя в окне [From, To)
+type eventQuery struct {
+	UserID   int
+	From, To time.Time
+	// Text слова, каждое должно быть началом слова в title или description
+	Text string
+	// Contains подстрока title или description без учета регистра
+	Contains string
+	// Desc сортировка от поздних к ранним
+	Desc  bool
+	Limit int
+	// After курсор: страница начинается со следующего за ним экземпляра
+	After *eventCursor
+}
+
+// eventCursor позиция экземпляра в выдаче. Порядок по (Start, EventID, UserID) не зависит
+// от вставок и удалений, поэтому страницы не съезжают при изменении календаря.
+// UserID различает одинаковые EventID своих событий и приглашений
+type eventCursor struct {
+	Start   time.Time
+	EventID int
+	UserID  int
+}
+
+// compare сравнивает позицию курсора с экземпляром ev: -1, 0 или 1
+func (c eventCursor) compare(ev *Event) int {
+	switch {
+	case c.Start.Before(ev.start()):
+		return -1
+	case c.Start.After(ev.start()):
+		return 1
+	case c.EventID != ev.EventID:
+		return sign(c.EventID - ev.EventID)
+	default:
+		return sign(c.UserID - ev.UserID)
+	}
+}
+
+func sign(n int) int {
+	switch {
+	case n < 0:
+		return -1
+	case n > 0:
+		return 1
+	}
+	return 0
+}
+
+// precedes проверяет, что экземпляр ev идет в выдаче строго после курсора
+func (c eventCursor) precedes(ev *Event, desc bool) bool {
+	if desc {
+		return c.compare(ev) > 0
+	}
+	return c.compare(ev) < 0
+}
+
+// encode непрозрачная строка курсора для клиента
+func (c *eventCursor) encode() string {
+	raw := fmt.Sprintf("%d.%d.%d", c.Start.UnixNano(), c.EventID, c.UserID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeCursor(s string) (*eventCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	parts := strings.Split(string(raw), ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	nanos, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	eventID, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	userID, err := strconv.Atoi(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	return &eventCursor{Start: time.Unix(0, nanos), EventID: eventID, UserID: userID}, nil
+}
+
+// query ищет экземпляры по запросу. Одиночные события обходятся по индексу начала
+// от курсора и только до Limit+1 совпадений, серии и приглашения разворачиваются
+// целиком и вливаются в выдачу по порядку
+func (s *MemoryStorage) query(q eventQuery) ([]Event, *eventCursor, error) {
+	if !q.From.Before(q.To) {
+		return nil, nil, fmt.Errorf("empty date range")
+	}
+	if q.Limit <= 0 {
+		q.Limit = defaultQueryLimit
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ui, ok := s.index[q.UserID]
+	if !ok {
+		return nil, nil, &unknownUserError{userID: q.UserID}
+	}
+
+	words := tokenize(q.Text)
+	var matched map[int]struct{}
+	if len(words) > 0 {
+		if matched = s.matchTerms(q.UserID, words); matched == nil {
+			matched = make(map[int]struct{})
+		}
+	}
+	contains := strings.ToLower(q.Contains)
+
+	// Экземпляры события, подходящие под запрос и идущие после курсора
+	instances := func(ev *Event) []Event {
+		// Слова приглашений лежат в индексе организатора, их проверяет matchText
+		if _, ok := matched[ev.EventID]; matched != nil && !ok && ev.UserID == q.UserID {
+			return nil
+		}
+		var res []Event
+		for _, occ := range ev.expand(q.From, q.To) {
+			if len(words) > 0 && !matchText(&occ, words) {
+				continue
+			}
+			if contains != "" && !strings.Contains(strings.ToLower(occ.Title), contains) &&
+				!strings.Contains(strings.ToLower(occ.Description), contains) {
+				continue
+			}
+			if q.After != nil && !q.After.precedes(&occ, q.Desc) {
+				continue
+			}
+			res = append(res, occ)
+		}
+		return res
+	}
+	before := func(a, b *Event) bool {
+		if q.Desc {
+			return eventLess(b, a)
+		}
+		return eventLess(a, b)
+	}
+
+	var rest []Event
+	for _, ev := range s.unindexed(q.UserID) {
+		rest = append(rest, instances(ev)...)
+	}
+	sort.SliceStable(rest, func(i, j int) bool { return before(&rest[i], &rest[j]) })
+
+	res := make([]Event, 0)
+	visit := func(entry indexEntry) {
+		for _, occ := range instances(&s.events[q.UserID][ui.position[entry.eventID]]) {
+			for len(rest) > 0 && before(&rest[0], &occ) {
+				res, rest = append(res, rest[0]), rest[1:]
+			}
+			res = append(res, occ)
+		}
+	}
+
+	// Событие, начавшееся раньше lower, не может пересекать окно
+	lower := q.From.Add(-ui.maxDuration)
+	if q.Desc {
+		upper := q.To
+		if q.After != nil && q.After.Start.Before(upper) {
+			upper = q.After.Start
+		}
+		i := sort.Search(len(ui.byStart), func(i int) bool { return ui.byStart[i].start.After(upper) }) - 1
+		for ; i >= 0 && !ui.byStart[i].start.Before(lower) && len(res) <= q.Limit; i-- {
+			visit(ui.byStart[i])
+		}
+	} else {
+		if q.After != nil && q.After.Start.After(lower) {
+			lower = q.After.Start
+		}
+		i := sort.Search(len(ui.byStart), func(i int) bool { return !ui.byStart[i].start.Before(lower) })
+		for ; i < len(ui.byStart) && !ui.byStart[i].start.After(q.To) && len(res) <= q.Limit; i++ {
+			visit(ui.byStart[i])
+		}
+	}
+	for ; len(rest) > 0 && len(res) <= q.Limit; rest = rest[1:] {
+		res = append(res, rest[0])
+	}
+
+	if len(res) <= q.Limit {
+		return res, nil, nil
+	}
+	res = res[:q.Limit]
+	last := res[len(res)-1]
+	return res, &eventCursor{Start: last.start(), EventID: last.EventID, UserID: last.UserID}, nil
+}
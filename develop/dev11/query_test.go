@@ -0,0 +1,238 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"testing"
+	"time"
+)
+
+// eventIDs идентификаторы экземпляров в порядке выдачи
+func eventIDs(events []Event) []int {
+	res := make([]int, 0, len(events))
+	for _, ev := range events {
+		res = append(res, ev.EventID)
+	}
+	return res
+}
+
+func TestIndexFollowsMutations(t *testing.T) {
+	st := newMemoryStorage()
+	for i := 1; i <= 30; i++ {
+		ev := testEvent(1, i, fmt.Sprintf("2019-09-%02d", i))
+		if err := st.Create(&ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Удаление из середины переставляет последнее событие на место удаленного
+	for _, id := range []int{5, 12, 29} {
+		if _, err := st.Delete(&Event{UserID: 1, EventID: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Перенос события меняет его место в индексе по началу
+	moved := testEvent(1, 30, "2019-09-01")
+	moved.Title = "moved"
+	if err := st.Update(&moved); err != nil {
+		t.Fatal(err)
+	}
+
+	for id, pos := range st.index[1].position {
+		if st.events[1][pos].EventID != id {
+			t.Fatalf("position of %v points to %v", id, st.events[1][pos].EventID)
+		}
+	}
+	// Отсортированный список слов совпадает с обратным индексом
+	terms := st.index[1].sortedTerms
+	if len(terms) != len(st.index[1].terms) || !sort.StringsAreSorted(terms) {
+		t.Fatalf("sorted terms %v do not follow the index", terms)
+	}
+	for _, term := range terms {
+		if _, ok := st.index[1].terms[term]; !ok {
+			t.Fatalf("removed term %q is left in sorted terms", term)
+		}
+	}
+	if ids := st.matchTerms(1, []string{"mov"}); len(ids) != 1 {
+		t.Errorf("prefix match: %v", ids)
+	}
+
+	events, err := st.getEventsForWeek(1, mustTime(t, "2019-09-02T00:00:00Z"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(eventIDs(events)); got != "[2 3 4 6 7 8]" {
+		t.Errorf("week: got %v", got)
+	}
+	events, _ = st.getEventsForDay(1, mustTime(t, "2019-09-01T00:00:00Z"))
+	if got := fmt.Sprint(eventIDs(events)); got != "[1 30]" {
+		t.Errorf("day: got %v", got)
+	}
+}
+
+func TestQuery(t *testing.T) {
+	st := newMemoryStorage()
+	events := []Event{
+		{UserID: 1, EventID: 1, Title: "Planning meeting", Start: mustTime(t, "2019-09-02T10:00:00Z")},
+		{UserID: 1, EventID: 2, Title: "Lunch", Description: "with the design team", Start: mustTime(t, "2019-09-03T13:00:00Z")},
+		{UserID: 1, EventID: 3, Title: "Standup", Start: mustTime(t, "2019-09-02T09:00:00Z"),
+			Recurrence: &Recurrence{Freq: freqDaily, Count: 5}},
+		// Длинное событие началось до окна, но пересекает его
+		{UserID: 1, EventID: 4, Title: "Conference", Start: mustTime(t, "2019-08-30T00:00:00Z"),
+			End: mustTime(t, "2019-09-04T00:00:00Z")},
+	}
+	for i := range events {
+		if err := events[i].validate(); err != nil {
+			t.Fatal(err)
+		}
+		if err := st.Create(&events[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	from, to := mustTime(t, "2019-09-02T00:00:00Z"), mustTime(t, "2019-09-05T00:00:00Z")
+	tests := []struct {
+		name  string
+		query eventQuery
+		want  string
+	}{
+		{"all", eventQuery{}, "[4 3 1 3 2 3]"},
+		{"desc", eventQuery{Desc: true}, "[3 2 3 1 3 4]"},
+		{"word prefix", eventQuery{Text: "meet"}, "[1]"},
+		{"description", eventQuery{Text: "DESIGN team"}, "[2]"},
+		{"all words required", eventQuery{Text: "design planning"}, "[]"},
+		{"substring", eventQuery{Contains: "and"}, "[3 3 3]"},
+		{"long event", eventQuery{Text: "conference"}, "[4]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := tt.query
+			q.UserID, q.From, q.To = 1, from, to
+			res, next, err := st.query(q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := fmt.Sprint(eventIDs(res)); got != tt.want || next != nil {
+				t.Errorf("got %v (next %v), want %v", got, next, tt.want)
+			}
+		})
+	}
+
+	// Постраничная выдача в обе стороны совпадает с полной
+	for _, desc := range []bool{false, true} {
+		full, _, _ := st.query(eventQuery{UserID: 1, From: from, To: to, Desc: desc})
+		var pages []Event
+		q := eventQuery{UserID: 1, From: from, To: to, Desc: desc, Limit: 2}
+		for {
+			page, next, err := st.query(q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			pages = append(pages, page...)
+			if next == nil {
+				break
+			}
+			if q.After, err = decodeCursor(next.encode()); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if fmt.Sprint(eventIDs(pages)) != fmt.Sprint(eventIDs(full)) {
+			t.Errorf("desc=%v: pages %v, full %v", desc, eventIDs(pages), eventIDs(full))
+		}
+	}
+}
+
+// TestQueryPages страницы из индекса начала, серий и приглашений совпадают с полной сортировкой
+func TestQueryPages(t *testing.T) {
+	st := newMemoryStorage()
+	day := mustTime(t, "2019-09-02T00:00:00Z")
+	var all []Event
+	for id := 1; id <= 300; id++ {
+		ev := Event{UserID: 1, EventID: id, Title: "task", Start: day.Add(time.Duration(id%50) * time.Hour)}
+		all = append(all, ev)
+	}
+	all = append(all,
+		Event{UserID: 1, EventID: 301, Title: "standup", Start: day.Add(10 * time.Hour),
+			Recurrence: &Recurrence{Freq: freqDaily}},
+		Event{UserID: 2, EventID: 1, Title: "review", Start: day.Add(10 * time.Hour),
+			Attendees: []Attendee{{UserID: 1}}})
+	for i := range all {
+		if err := all[i].validate(); err != nil {
+			t.Fatal(err)
+		}
+		if err := st.Create(&all[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	from, to := day, day.Add(48*time.Hour)
+	var want []Event
+	for i := range all {
+		want = append(want, all[i].expand(from, to)...)
+	}
+	sortEvents(want)
+
+	for _, desc := range []bool{false, true} {
+		var pages []Event
+		q := eventQuery{UserID: 1, From: from, To: to, Desc: desc, Limit: 7}
+		for {
+			page, next, err := st.query(q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(page) > q.Limit {
+				t.Fatalf("page of %d events", len(page))
+			}
+			pages = append(pages, page...)
+			if next == nil {
+				break
+			}
+			q.After = next
+		}
+		if desc {
+			for i, j := 0, len(pages)-1; i < j; i, j = i+1, j-1 {
+				pages[i], pages[j] = pages[j], pages[i]
+			}
+		}
+		if len(pages) != len(want) {
+			t.Fatalf("desc=%v: %d events, want %d", desc, len(pages), len(want))
+		}
+		for i := range want {
+			if pages[i].EventID != want[i].EventID || pages[i].UserID != want[i].UserID || !pages[i].start().Equal(want[i].start()) {
+				t.Fatalf("desc=%v: event %d is %v, want %v", desc, i, pages[i], want[i])
+			}
+		}
+	}
+}
+
+func TestQueryEndpoint(t *testing.T) {
+	srv, _ := newTestServer(t)
+	alice := issueToken(t, srv, "1")
+	for i := 1; i <= 3; i++ {
+		body := fmt.Sprintf(`{"event_id": %d, "title": "review %d", "start": "2019-09-0%dT10:00:00Z"}`, i, i, i)
+		if status, res := doRequest(t, http.MethodPost, srv.URL+"/users/1/events", alice, body, nil); status != http.StatusCreated {
+			t.Fatalf("create failed: %v %v", status, res)
+		}
+	}
+
+	params := url.Values{"from": {"2019-09-01"}, "to": {"2019-09-30"}, "q": {"review"}, "limit": {"2"}}
+	status, res := doRequest(t, http.MethodGet, srv.URL+"/users/1/events?"+params.Encode(), alice, "", nil)
+	if status != http.StatusOK || len(res["events"].([]interface{})) != 2 || res["next_cursor"] == nil {
+		t.Fatalf("first page: %v %v", status, res)
+	}
+
+	params.Set("cursor", res["next_cursor"].(string))
+	status, res = doRequest(t, http.MethodGet, srv.URL+"/users/1/events?"+params.Encode(), alice, "", nil)
+	if status != http.StatusOK || len(res["events"].([]interface{})) != 1 || res["next_cursor"] != nil {
+		t.Fatalf("last page: %v %v", status, res)
+	}
+
+	for _, bad := range []string{"limit=0", "limit=x", "cursor=!!!", "order=random"} {
+		status, _ := doRequest(t, http.MethodGet, srv.URL+"/users/1/events?from=2019-09-01&to=2019-09-30&"+bad, alice, "", nil)
+		if status != http.StatusBadRequest {
+			t.Errorf("%v: got %v, want 400", bad, status)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMaxBodyBytes = 1 << 20
+	// bucketSweepEvery как часто удалять полные корзины неактивных клиентов
+	bucketSweepEvery = time.Minute
+)
+
+// rateLimit ограничение корзины: Rate токенов в секунду, не больше Burst подряд.
+// Нулевой Rate отключает ограничение
+type rateLimit struct {
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+}
+
+// LimitsConfig ограничения запросов. Ключ Routes - шаблон пути, как в метках метрик,
+// с методом или без: "POST /users/{id}/events", "/create_event"
+type LimitsConfig struct {
+	Default      rateLimit            `json:"default"`
+	Routes       map[string]rateLimit `json:"routes"`
+	MaxBodyBytes int64                `json:"max_body_bytes"`
+}
+
+func defaultLimits() LimitsConfig {
+	create := rateLimit{Rate: 5, Burst: 10}
+	return LimitsConfig{
+		Default: rateLimit{Rate: 20, Burst: 40},
+		Routes: map[string]rateLimit{
+			"/create_event":           create,
+			"POST /users/{id}/events": create,
+			"/import_events":          {Rate: 1, Burst: 3},
+		},
+		MaxBodyBytes: defaultMaxBodyBytes,
+	}
+}
+
+func (l rateLimit) validate(name string) error {
+	if l.Rate < 0 || math.IsNaN(l.Rate) || math.IsInf(l.Rate, 0) {
+		return fmt.Errorf("invalid rate limit %v for %v", l.Rate, name)
+	}
+	if l.Rate > 0 && l.Burst < 1 {
+		return fmt.Errorf("burst of %v must be at least 1", name)
+	}
+	return nil
+}
+
+// tokenBucket корзина одного клиента на одном маршруте
+type tokenBucket struct {
+	limit  rateLimit
+	tokens float64
+	last   time.Time
+}
+
+type bucketKey struct {
+	client string
+	route  string
+}
+
+// RateLimiter - middleware ограничения частоты запросов по алгоритму token bucket.
+// Клиент - пользователь действительного токена, без токена или с недействительным -
+// IP-адрес. Маршруты без своего ограничения делят общую корзину клиента
+type RateLimiter struct {
+	handler http.Handler
+	limits  LimitsConfig
+	route   func(*http.Request) string
+	tokens  *TokenStore
+	now     func() time.Time
+
+	mu        sync.Mutex
+	buckets   map[bucketKey]*tokenBucket
+	lastSweep time.Time
+}
+
+// Конструктор middleware, route дает шаблон пути, как для метрик. Ограничитель стоит
+// до Auth, поэтому пользователя определяет сам по токену из tokens
+func newRateLimiter(handler http.Handler, limits LimitsConfig, route func(*http.Request) string, tokens *TokenStore) *RateLimiter {
+	return &RateLimiter{
+		handler: handler,
+		limits:  limits,
+		route:   route,
+		tokens:  tokens,
+		now:     time.Now,
+		buckets: make(map[bucketKey]*tokenBucket),
+	}
+}
+
+// limitFor ограничение маршрута и имя его корзины
+func (rl *RateLimiter) limitFor(method, route string) (rateLimit, string) {
+	if l, ok := rl.limits.Routes[method+" "+route]; ok {
+		return l, method + " " + route
+	}
+	if l, ok := rl.limits.Routes[route]; ok {
+		return l, route
+	}
+	return rl.limits.Default, ""
+}
+
+// clientKey пользователь из действительного токена или IP-адрес клиента. Перебор
+// токенов и запросы без них ограничиваются по адресу
+func (rl *RateLimiter) clientKey(r *http.Request) string {
+	if claims, ok := credentials(r); ok {
+		return "user:" + strconv.Itoa(claims.UserID)
+	}
+	if token := bearerToken(r); token != "" && rl.tokens != nil {
+		if claims, err := rl.tokens.verify(token); err == nil {
+			return "user:" + strconv.Itoa(claims.UserID)
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// allow забирает токен из корзины, иначе возвращает, через сколько он появится
+func (rl *RateLimiter) allow(key bucketKey, limit rateLimit) (bool, time.Duration) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := rl.now()
+	rl.sweep(now)
+
+	b, ok := rl.buckets[key]
+	if !ok {
+		b = &tokenBucket{limit: limit, tokens: float64(limit.Burst), last: now}
+		rl.buckets[key] = b
+	}
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(float64(limit.Burst), b.tokens+elapsed*limit.Rate)
+	}
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	wait := time.Duration((1 - b.tokens) / limit.Rate * float64(time.Second))
+	return false, wait
+}
+
+// sweep удаляет корзины, которые успели наполниться: они не отличаются от новых.
+// Вызывается под блокировкой
+func (rl *RateLimiter) sweep(now time.Time) {
+	if now.Sub(rl.lastSweep) < bucketSweepEvery {
+		return
+	}
+	rl.lastSweep = now
+
+	for key, b := range rl.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*b.limit.Rate >= float64(b.limit.Burst) {
+			delete(rl.buckets, key)
+		}
+	}
+}
+
+// ServeHTTP логика хэндлера, опишем этот метод, чтобы удовлетворить интерфейсу
+func (rl *RateLimiter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	limit, name := rl.limitFor(r.Method, rl.route(r))
+	if limit.Rate == 0 {
+		rl.handler.ServeHTTP(w, r)
+		return
+	}
+
+	ok, wait := rl.allow(bucketKey{client: rl.clientKey(r), route: name}, limit)
+	if !ok {
+		seconds := int(math.Ceil(wait.Seconds()))
+		if seconds < 1 {
+			seconds = 1
+		}
+		w.Header().Set("Retry-After", strconv.Itoa(seconds))
+		getErrResponse(w, "too many requests, retry later", http.StatusTooManyRequests)
+		return
+	}
+	rl.handler.ServeHTTP(w, r)
+}
+
+// limitBody - middleware ограничения размера тела запроса. Тело читается целиком
+// до обработчика, поэтому превышение всегда дает 413 с JSON-ошибкой, а не ошибку
+// разбора посреди обработки
+func limitBody(h http.Handler, maxBytes int64) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if maxBytes <= 0 || r.Body == nil || r.Body == http.NoBody {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		tooLarge := func() {
+			// Остаток тела не читаем, соединение лучше закрыть
+			w.Header().Set("Connection", "close")
+			getErrResponse(w, fmt.Sprintf("request body is larger than %d bytes", maxBytes), http.StatusRequestEntityTooLarge)
+		}
+		if r.ContentLength > maxBytes {
+			tooLarge()
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxBytes+1))
+		if err != nil {
+			getErrResponse(w, "can't read request body", http.StatusBadRequest)
+			return
+		}
+		if int64(len(body)) > maxBytes {
+			tooLarge()
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		h.ServeHTTP(w, r)
+	})
+}
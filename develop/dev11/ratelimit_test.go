@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestLimiter ограничитель с управляемыми часами поверх обработчика, отвечающего 200
+func newTestLimiter(limits LimitsConfig) (*RateLimiter, *time.Time) {
+	now := time.Date(2019, 9, 9, 10, 0, 0, 0, time.UTC)
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	route := func(r *http.Request) string { return r.URL.Path }
+	rl := newRateLimiter(ok, limits, route, nil)
+	rl.now = func() time.Time { return now }
+	return rl, &now
+}
+
+func limitedRequest(rl *RateLimiter, method, path string, userID int, ip string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	req.RemoteAddr = ip + ":5555"
+	if userID != 0 {
+		req = req.WithContext(context.WithValue(req.Context(), authKey{}, tokenClaims{UserID: userID}))
+	}
+	rec := httptest.NewRecorder()
+	rl.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestRateLimiterBucket(t *testing.T) {
+	rl, now := newTestLimiter(LimitsConfig{Default: rateLimit{Rate: 2, Burst: 3}})
+
+	// Burst запросов подряд проходит, следующий получает 429
+	for i := 0; i < 3; i++ {
+		if rec := limitedRequest(rl, http.MethodGet, "/events_for_day", 1, "10.0.0.1"); rec.Code != http.StatusOK {
+			t.Fatalf("request %d: status %v", i, rec.Code)
+		}
+	}
+	rec := limitedRequest(rl, http.MethodGet, "/events_for_day", 1, "10.0.0.1")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "1" {
+		t.Fatalf("expected 429 with Retry-After 1, got %v %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	var body map[string]string
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body["error"] == "" {
+		t.Fatalf("429 must be a JSON error, got %q", rec.Body.String())
+	}
+
+	// Другой пользователь с того же адреса ограничен отдельно
+	if rec := limitedRequest(rl, http.MethodGet, "/events_for_day", 2, "10.0.0.1"); rec.Code != http.StatusOK {
+		t.Fatalf("other user is limited: %v", rec.Code)
+	}
+
+	// За полсекунды при rate 2 появляется один токен
+	*now = now.Add(500 * time.Millisecond)
+	if rec := limitedRequest(rl, http.MethodGet, "/events_for_day", 1, "10.0.0.1"); rec.Code != http.StatusOK {
+		t.Fatalf("token is not refilled: %v", rec.Code)
+	}
+	if rec := limitedRequest(rl, http.MethodGet, "/events_for_day", 1, "10.0.0.1"); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429, got %v", rec.Code)
+	}
+}
+
+func TestRateLimiterRoutes(t *testing.T) {
+	rl, _ := newTestLimiter(LimitsConfig{
+		Default: rateLimit{Rate: 100, Burst: 100},
+		Routes: map[string]rateLimit{
+			"POST /users/{id}/events": {Rate: 0.1, Burst: 1},
+			"/free_busy":              {},
+		},
+	})
+
+	if rec := limitedRequest(rl, http.MethodPost, "/users/{id}/events", 1, "10.0.0.1"); rec.Code != http.StatusOK {
+		t.Fatalf("first create: %v", rec.Code)
+	}
+	rec := limitedRequest(rl, http.MethodPost, "/users/{id}/events", 1, "10.0.0.1")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "10" {
+		t.Fatalf("expected 429 with Retry-After 10, got %v %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+
+	// GET того же пути и остальные маршруты идут по общему ограничению
+	if rec := limitedRequest(rl, http.MethodGet, "/users/{id}/events", 1, "10.0.0.1"); rec.Code != http.StatusOK {
+		t.Fatalf("list is limited by create limit: %v", rec.Code)
+	}
+
+	// Нулевой rate снимает ограничение с маршрута
+	for i := 0; i < 200; i++ {
+		if rec := limitedRequest(rl, http.MethodGet, "/free_busy", 0, "10.0.0.2"); rec.Code != http.StatusOK {
+			t.Fatalf("unlimited route: %v", rec.Code)
+		}
+	}
+}
+
+func TestRateLimiterByIP(t *testing.T) {
+	rl, _ := newTestLimiter(LimitsConfig{Default: rateLimit{Rate: 1, Burst: 1}})
+
+	limitedRequest(rl, http.MethodPost, "/auth/token", 0, "10.0.0.1")
+	if rec := limitedRequest(rl, http.MethodPost, "/auth/token", 0, "10.0.0.1"); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("anonymous client is not limited by IP: %v", rec.Code)
+	}
+	if rec := limitedRequest(rl, http.MethodPost, "/auth/token", 0, "10.0.0.2"); rec.Code != http.StatusOK {
+		t.Fatalf("other IP is limited: %v", rec.Code)
+	}
+}
+
+func TestRateLimiterSweep(t *testing.T) {
+	rl, now := newTestLimiter(LimitsConfig{Default: rateLimit{Rate: 1, Burst: 2}})
+	for i := 1; i <= 10; i++ {
+		limitedRequest(rl, http.MethodGet, "/events_for_day", i, "10.0.0.1")
+	}
+
+	*now = now.Add(2 * bucketSweepEvery)
+	limitedRequest(rl, http.MethodGet, "/events_for_day", 1, "10.0.0.1")
+	if len(rl.buckets) != 1 {
+		t.Fatalf("idle buckets are kept: %d", len(rl.buckets))
+	}
+}
+
+func TestLimitBody(t *testing.T) {
+	var got string
+	h := limitBody(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		got = string(data)
+	}), 10)
+
+	cases := []struct {
+		name   string
+		body   io.Reader
+		status int
+	}{
+		{"fits", strings.NewReader("0123456789"), http.StatusOK},
+		{"content length", strings.NewReader("0123456789a"), http.StatusRequestEntityTooLarge},
+		// Без Content-Length превышение видно только при чтении
+		{"chunked", io.MultiReader(strings.NewReader("01234"), strings.NewReader("567890")), http.StatusRequestEntityTooLarge},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got = ""
+			req := httptest.NewRequest(http.MethodPost, "/create_event", c.body)
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != c.status {
+				t.Fatalf("status %v, want %v", rec.Code, c.status)
+			}
+			if c.status == http.StatusOK {
+				if got != "0123456789" {
+					t.Fatalf("handler got %q", got)
+				}
+				return
+			}
+			var body map[string]string
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || !strings.Contains(body["error"], "larger than 10 bytes") {
+				t.Fatalf("unexpected error body %q", rec.Body.String())
+			}
+			if got != "" {
+				t.Fatal("handler must not run")
+			}
+		})
+	}
+}
+
+func TestRouterLimits(t *testing.T) {
+	storage = newMemoryStorage()
+	tokens, err := newTokenStore([]byte("secret"), testAdminKey, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	limits := LimitsConfig{Default: rateLimit{Rate: 1, Burst: 2}, MaxBodyBytes: 64}
+	srv := httptest.NewServer(newRouter(tokens, limits))
+	defer srv.Close()
+
+	token, _, err := tokens.issue(1, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	big := `{"title": "` + strings.Repeat("x", 100) + `"}`
+	if status, _ := doRequest(t, http.MethodPost, srv.URL+"/users/1/events", token, big, nil); status != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected 413, got %v", status)
+	}
+	doRequest(t, http.MethodGet, srv.URL+"/events_for_day?date=2019-09-09", token, "", nil)
+	if status, _ := doRequest(t, http.MethodGet, srv.URL+"/events_for_day?date=2019-09-09", token, "", nil); status != http.StatusTooManyRequests {
+		t.Fatalf("expected 429, got %v", status)
+	}
+}
+
+// TestRouterLimitsBadToken перебор токенов ограничивается по IP до ответа 401
+func TestRouterLimitsBadToken(t *testing.T) {
+	storage = newMemoryStorage()
+	tokens, err := newTokenStore([]byte("secret"), testAdminKey, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(newRouter(tokens, LimitsConfig{Default: rateLimit{Rate: 1, Burst: 3}}))
+	defer srv.Close()
+
+	url := srv.URL + "/events_for_day?date=2019-09-09"
+	for i := 0; i < 3; i++ {
+		if status, _ := doRequest(t, http.MethodGet, url, "bad.token", "", nil); status != http.StatusUnauthorized {
+			t.Fatalf("request %d: status %v, want 401", i, status)
+		}
+	}
+	if status, _ := doRequest(t, http.MethodGet, url, "other.token", "", nil); status != http.StatusTooManyRequests {
+		t.Fatalf("bad tokens are not limited: %v", status)
+	}
+	if status, _ := doRequest(t, http.MethodGet, url, "", "", nil); status != http.StatusTooManyRequests {
+		t.Fatalf("request without token shares the IP limit: %v", status)
+	}
+
+	// Действительный токен получает свою корзину
+	token, _, err := tokens.issue(1, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status, _ := doRequest(t, http.MethodGet, url, token, "", nil); status == http.StatusTooManyRequests || status == http.StatusUnauthorized {
+		t.Fatalf("authenticated user is limited by IP: %v", status)
+	}
+}
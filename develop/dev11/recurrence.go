@@ -0,0 +1,300 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Частоты повторения, как FREQ в RRULE (RFC 5545)
+const (
+	freqDaily   = "daily"
+	freqWeekly  = "weekly"
+	freqMonthly = "monthly"
+	freqYearly  = "yearly"
+)
+
+// maxPeriods ограничивает перебор периодов, чтобы правило без COUNT и UNTIL не зациклило запрос
+const maxPeriods = 50000
+
+var weekdays = map[string]time.Weekday{
+	"MO": time.Monday, "TU": time.Tuesday, "WE": time.Wednesday, "TH": time.Thursday,
+	"FR": time.Friday, "SA": time.Saturday, "SU": time.Sunday,
+}
+
+// Recurrence правило повторения события в духе RRULE
+type Recurrence struct {
+	Freq     string `json:"freq"`
+	Interval int    `json:"interval,omitempty"`
+	// ByDay дни недели: "MO", для monthly допускается номер "1MO", "-1FR"
+	ByDay   []string    `json:"by_day,omitempty"`
+	Count   int         `json:"count,omitempty"`
+	Until   time.Time   `json:"until,omitempty"`
+	ExDates []time.Time `json:"exdates,omitempty"`
+}
+
+// byDay разобранный элемент BYDAY: n-й (с конца, если n < 0) день недели, n == 0 - каждый
+type byDay struct {
+	n  int
+	wd time.Weekday
+}
+
+func parseByDay(s string) (byDay, error) {
+	if len(s) < 2 {
+		return byDay{}, fmt.Errorf("invalid by_day %q", s)
+	}
+	wd, ok := weekdays[s[len(s)-2:]]
+	if !ok {
+		return byDay{}, fmt.Errorf("invalid by_day %q", s)
+	}
+	var n int
+	if prefix := s[:len(s)-2]; prefix != "" {
+		var err error
+		if n, err = strconv.Atoi(prefix); err != nil || n == 0 || n < -5 || n > 5 {
+			return byDay{}, fmt.Errorf("invalid by_day %q", s)
+		}
+	}
+	return byDay{n: n, wd: wd}, nil
+}
+
+// validate проверяет правило повторения
+func (r *Recurrence) validate() error {
+	switch r.Freq {
+	case freqDaily, freqWeekly, freqMonthly, freqYearly:
+	default:
+		return fmt.Errorf("invalid recurrence freq %q", r.Freq)
+	}
+	if r.Interval < 0 {
+		return fmt.Errorf("invalid recurrence interval")
+	}
+	if r.Count < 0 {
+		return fmt.Errorf("invalid recurrence count")
+	}
+	if r.Count > 0 && !r.Until.IsZero() {
+		return fmt.Errorf("recurrence count and until are mutually exclusive")
+	}
+	if len(r.ByDay) > 0 && (r.Freq == freqDaily || r.Freq == freqYearly) {
+		return fmt.Errorf("by_day is supported only for weekly and monthly recurrence")
+	}
+	for _, s := range r.ByDay {
+		d, err := parseByDay(s)
+		if err != nil {
+			return err
+		}
+		if d.n != 0 && r.Freq != freqMonthly {
+			return fmt.Errorf("numbered by_day %q is supported only for monthly recurrence", s)
+		}
+	}
+	return nil
+}
+
+func (r *Recurrence) interval() int {
+	if r.Interval < 1 {
+		return 1
+	}
+	return r.Interval
+}
+
+// excluded проверяет, исключено ли повторение через EXDATE
+func (r *Recurrence) excluded(t time.Time) bool {
+	for _, ex := range r.ExDates {
+		if ex.Equal(t) {
+			return true
+		}
+	}
+	return false
+}
+
+func daysIn(year int, month time.Month, loc *time.Location) int {
+	return time.Date(year, month+1, 0, 0, 0, 0, 0, loc).Day()
+}
+
+// candidates возвращает отсортированные начала повторений в периоде с номером k.
+// Время суток и часовой пояс берутся из начала серии
+func (r *Recurrence) candidates(start time.Time, k int) []time.Time {
+	y, m, d := start.Date()
+	hh, mm, ss := start.Clock()
+	ns, loc := start.Nanosecond(), start.Location()
+	at := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, hh, mm, ss, ns, loc)
+	}
+
+	var res []time.Time
+
+	switch r.Freq {
+	case freqDaily:
+		res = append(res, at(y, m, d+k))
+
+	case freqWeekly:
+		if len(r.ByDay) == 0 {
+			return []time.Time{at(y, m, d+7*k)}
+		}
+		// Неделя начинается с понедельника (WKST=MO)
+		monday := d - (int(start.Weekday())+6)%7 + 7*k
+		for _, s := range r.ByDay {
+			bd, _ := parseByDay(s)
+			res = append(res, at(y, m, monday+(int(bd.wd)+6)%7))
+		}
+
+	case freqMonthly:
+		first := time.Date(y, m+time.Month(k), 1, 0, 0, 0, 0, loc)
+		year, month := first.Year(), first.Month()
+		days := daysIn(year, month, loc)
+		if len(r.ByDay) == 0 {
+			// Как в RFC 5545: месяцы без такого числа пропускаются
+			if d <= days {
+				res = append(res, at(year, month, d))
+			}
+			break
+		}
+		for _, s := range r.ByDay {
+			bd, _ := parseByDay(s)
+			firstDay := 1 + (int(bd.wd)-int(first.Weekday())+7)%7
+			var matches []int
+			for day := firstDay; day <= days; day += 7 {
+				matches = append(matches, day)
+			}
+			switch {
+			case bd.n == 0:
+				for _, day := range matches {
+					res = append(res, at(year, month, day))
+				}
+			case bd.n > 0 && bd.n <= len(matches):
+				res = append(res, at(year, month, matches[bd.n-1]))
+			case bd.n < 0 && -bd.n <= len(matches):
+				res = append(res, at(year, month, matches[len(matches)+bd.n]))
+			}
+		}
+
+	case freqYearly:
+		// 29 февраля повторяется только в високосные годы
+		if d <= daysIn(y+k, m, loc) {
+			res = append(res, at(y+k, m, d))
+		}
+	}
+
+	sort.Slice(res, func(i, j int) bool { return res[i].Before(res[j]) })
+	return dedupTimes(res)
+}
+
+func dedupTimes(ts []time.Time) []time.Time {
+	if len(ts) < 2 {
+		return ts
+	}
+	res := ts[:1]
+	for _, t := range ts[1:] {
+		if !t.Equal(res[len(res)-1]) {
+			res = append(res, t)
+		}
+	}
+	return res
+}
+
+// between возвращает начала повторений серии, начавшейся в start, попадающие в [from, to).
+// COUNT считается от начала серии с учетом исключенных дат, как в RFC 5545
+func (r *Recurrence) between(start, from, to time.Time) []time.Time {
+	var res []time.Time
+	emitted := 0
+
+	for period := 0; period < maxPeriods; period++ {
+		for _, c := range r.candidates(start, period*r.interval()) {
+			switch {
+			case c.Before(start):
+				continue
+			case !r.Until.IsZero() && c.After(r.Until):
+				return res
+			case r.Count > 0 && emitted >= r.Count:
+				return res
+			case !c.Before(to):
+				return res
+			}
+			emitted++
+			if !c.Before(from) && !r.excluded(c) {
+				res = append(res, c)
+			}
+		}
+	}
+
+	return res
+}
+
+// hasOccurrence проверяет, что серия действительно повторяется в момент t
+func (ev *Event) hasOccurrence(t time.Time) bool {
+	return ev.Recurrence != nil && len(ev.Recurrence.between(ev.start(), t, t.Add(time.Nanosecond))) == 1
+}
+
+// occurrence строит экземпляр повторения серии, начинающийся в t
+func (ev *Event) occurrence(t time.Time) Event {
+	for _, o := range ev.Overrides {
+		if o.RecurrenceID != nil && o.RecurrenceID.Equal(t) {
+			o.Attendees, o.Version = ev.Attendees, ev.Version
+			return o
+		}
+	}
+	occ := *ev
+	occ.Start = t.In(ev.location())
+	occ.End = occ.Start.Add(ev.end().Sub(ev.start()))
+	occ.Date = occ.Start
+	occ.RecurrenceID = &t
+	occ.Overrides = nil
+	return occ
+}
+
+// expand возвращает экземпляры события, пересекающиеся с [from, to).
+// Обычное событие возвращается как есть, серия разворачивается в повторения,
+// а измененные повторения попадают в окно по своему новому времени
+func (ev *Event) expand(from, to time.Time) []Event {
+	inWindow := func(e *Event) bool { return intersects(e.start(), e.end(), from, to) }
+
+	if ev.Recurrence == nil {
+		if inWindow(ev) {
+			return []Event{*ev}
+		}
+		return nil
+	}
+
+	var res []Event
+	// Повторения, начавшиеся до окна, тоже могут в него заходить
+	starts := ev.Recurrence.between(ev.start(), from.Add(-ev.end().Sub(ev.start())), to)
+	for _, t := range starts {
+		if occ := ev.occurrence(t); inWindow(&occ) {
+			res = append(res, occ)
+		}
+	}
+	// Повторения, перенесенные в окно извне
+	for i := range ev.Overrides {
+		o := &ev.Overrides[i]
+		if inWindow(o) && !containsTime(starts, *o.RecurrenceID) && ev.hasOccurrence(*o.RecurrenceID) {
+			occ := *o
+			occ.Attendees, occ.Version = ev.Attendees, ev.Version
+			res = append(res, occ)
+		}
+	}
+
+	return res
+}
+
+func containsTime(ts []time.Time, t time.Time) bool {
+	for _, x := range ts {
+		if x.Equal(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// lastCounted начало последнего повторения серии с COUNT, исключенные даты тоже считаются
+func (r *Recurrence) lastCounted(start time.Time) time.Time {
+	last, emitted := start, 0
+	for period := 0; period < maxPeriods && emitted < r.Count; period++ {
+		for _, c := range r.candidates(start, period*r.interval()) {
+			if c.Before(start) || emitted >= r.Count {
+				continue
+			}
+			last = c
+			emitted++
+		}
+	}
+	return last
+}
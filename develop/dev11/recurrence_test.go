@@ -0,0 +1,144 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func mustTime(t *testing.T, s string) time.Time {
+	t.Helper()
+	res, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func TestRecurrenceBetween(t *testing.T) {
+	tests := []struct {
+		name     string
+		rule     Recurrence
+		start    string
+		from, to string
+		want     []string
+	}{
+		{
+			name:  "weekly standup",
+			rule:  Recurrence{Freq: freqWeekly},
+			start: "2019-09-02T10:00:00Z",
+			from:  "2019-09-01T00:00:00Z", to: "2019-09-20T00:00:00Z",
+			want: []string{"2019-09-02T10:00:00Z", "2019-09-09T10:00:00Z", "2019-09-16T10:00:00Z"},
+		},
+		{
+			name:  "by day with count",
+			rule:  Recurrence{Freq: freqWeekly, ByDay: []string{"MO", "WE"}, Count: 3},
+			start: "2019-09-04T10:00:00Z",
+			from:  "2019-09-01T00:00:00Z", to: "2019-10-01T00:00:00Z",
+			want: []string{"2019-09-04T10:00:00Z", "2019-09-09T10:00:00Z", "2019-09-11T10:00:00Z"},
+		},
+		{
+			name:  "every other day until",
+			rule:  Recurrence{Freq: freqDaily, Interval: 2, Until: mustTime(t, "2019-09-05T10:00:00Z")},
+			start: "2019-09-01T10:00:00Z",
+			from:  "2019-09-01T00:00:00Z", to: "2019-10-01T00:00:00Z",
+			want: []string{"2019-09-01T10:00:00Z", "2019-09-03T10:00:00Z", "2019-09-05T10:00:00Z"},
+		},
+		{
+			name:  "monthly skips short months",
+			rule:  Recurrence{Freq: freqMonthly, Count: 3},
+			start: "2019-01-31T09:00:00Z",
+			from:  "2019-01-01T00:00:00Z", to: "2020-01-01T00:00:00Z",
+			want: []string{"2019-01-31T09:00:00Z", "2019-03-31T09:00:00Z", "2019-05-31T09:00:00Z"},
+		},
+		{
+			name:  "last friday of month",
+			rule:  Recurrence{Freq: freqMonthly, ByDay: []string{"-1FR"}},
+			start: "2019-09-27T18:00:00Z",
+			from:  "2019-10-01T00:00:00Z", to: "2019-12-01T00:00:00Z",
+			want: []string{"2019-10-25T18:00:00Z", "2019-11-29T18:00:00Z"},
+		},
+		{
+			name: "exdate is skipped but counted",
+			rule: Recurrence{Freq: freqDaily, Count: 3,
+				ExDates: []time.Time{mustTime(t, "2019-09-02T10:00:00Z")}},
+			start: "2019-09-01T10:00:00Z",
+			from:  "2019-09-01T00:00:00Z", to: "2019-10-01T00:00:00Z",
+			want: []string{"2019-09-01T10:00:00Z", "2019-09-03T10:00:00Z"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.rule.validate(); err != nil {
+				t.Fatal(err)
+			}
+			got := tt.rule.between(mustTime(t, tt.start), mustTime(t, tt.from), mustTime(t, tt.to))
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if !got[i].Equal(mustTime(t, tt.want[i])) {
+					t.Errorf("occurrence %d: got %v, want %v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestStorageSingleOccurrence(t *testing.T) {
+	s := newMemoryStorage()
+	series := Event{UserID: 1, EventID: 1, Title: "standup",
+		Date: mustTime(t, "2019-09-02T10:00:00Z"), Recurrence: &Recurrence{Freq: freqWeekly}}
+	if err := s.Create(&series); err != nil {
+		t.Fatal(err)
+	}
+
+	// Переносим одно повторение на вторник
+	second := mustTime(t, "2019-09-09T10:00:00Z")
+	moved := Event{UserID: 1, EventID: 1, Title: "standup (moved)",
+		Date: mustTime(t, "2019-09-10T11:00:00Z"), RecurrenceID: &second}
+	if err := s.Update(&moved); err != nil {
+		t.Fatal(err)
+	}
+	// Удаляем третье
+	third := mustTime(t, "2019-09-16T10:00:00Z")
+	if _, err := s.Delete(&Event{UserID: 1, EventID: 1, RecurrenceID: &third}); err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := s.getEventsForMonth(1, mustTime(t, "2019-09-01T00:00:00Z"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, ev := range events {
+		got = append(got, ev.Date.Format(time.RFC3339)+" "+ev.Title)
+	}
+	want := []string{
+		"2019-09-02T10:00:00Z standup",
+		"2019-09-10T11:00:00Z standup (moved)",
+		"2019-09-23T10:00:00Z standup",
+		"2019-09-30T10:00:00Z standup",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Errorf("got %v, want %v", got[i], want[i])
+		}
+	}
+
+	// Удаленное повторение нельзя изменить
+	if err := s.Update(&Event{UserID: 1, EventID: 1, Title: "x", Date: third, RecurrenceID: &third}); err == nil {
+		t.Error("expected error for deleted occurrence")
+	}
+
+	// Удаление всей серии
+	if _, err := s.Delete(&Event{UserID: 1, EventID: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if events, _ := s.getEventsForMonth(1, mustTime(t, "2019-09-01T00:00:00Z")); len(events) != 0 {
+		t.Errorf("expected no events after series delete, got %v", events)
+	}
+}
@@ -0,0 +1,457 @@
+package main
+
+import (
+	"container/heap"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// maxReminderMinutes напоминание не раньше чем за 4 недели
+	maxReminderMinutes = 4 * 7 * 24 * 60
+
+	reminderStateFileName = "reminders.state"
+
+	defaultMaxLateness = time.Hour
+	defaultLookahead   = 24 * time.Hour
+	notifyTimeout      = 10 * time.Second
+)
+
+// Reminder напоминание за MinutesBefore минут до начала события
+type Reminder struct {
+	MinutesBefore int `json:"minutes_before"`
+}
+
+func (r Reminder) before() time.Duration {
+	return time.Duration(r.MinutesBefore) * time.Minute
+}
+
+// Notification сработавшее напоминание
+type Notification struct {
+	UserID        int        `json:"user_id"`
+	EventID       int        `json:"event_id"`
+	Title         string     `json:"title"`
+	Start         time.Time  `json:"start"`
+	RecurrenceID  *time.Time `json:"recurrence_id,omitempty"`
+	MinutesBefore int        `json:"minutes_before"`
+	FireAt        time.Time  `json:"fire_at"`
+}
+
+// clock источник времени планировщика, в тестах подменяется фейковым
+type clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// Scheduler фоновый планировщик напоминаний.
+// Время последнего срабатывания (watermark) сохраняется в файл, поэтому после перезапуска
+// пропущенные за время простоя напоминания досылаются, если опоздание не больше maxLateness
+type Scheduler struct {
+	storage  Storage
+	notifier Notifier
+	clock    clock
+
+	statePath   string
+	maxLateness time.Duration
+	lookahead   time.Duration
+
+	mu        sync.Mutex
+	watermark time.Time
+	index     *reminderIndex
+
+	wake chan struct{}
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// schedulerState содержимое файла состояния планировщика
+type schedulerState struct {
+	Watermark time.Time `json:"watermark"`
+}
+
+// Конструктор планировщика, statePath == "" - состояние не сохраняется
+func newScheduler(st Storage, n Notifier, c clock, statePath string) (*Scheduler, error) {
+	s := &Scheduler{
+		storage:     st,
+		notifier:    n,
+		clock:       c,
+		statePath:   statePath,
+		maxLateness: defaultMaxLateness,
+		lookahead:   defaultLookahead,
+		watermark:   c.Now(),
+		wake:        make(chan struct{}, 1),
+		done:        make(chan struct{}),
+	}
+
+	if statePath != "" {
+		data, err := os.ReadFile(statePath)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			// Первый запуск: отсчет пропущенных напоминаний ведется с этого момента
+			if err := s.saveState(); err != nil {
+				return nil, err
+			}
+		case err != nil:
+			return nil, fmt.Errorf("can't read scheduler state: %w", err)
+		default:
+			var state schedulerState
+			if err := json.Unmarshal(data, &state); err != nil {
+				return nil, fmt.Errorf("corrupted scheduler state: %w", err)
+			}
+			s.watermark = state.Watermark
+		}
+	}
+
+	events, err := st.getAllEvents()
+	if err != nil {
+		return nil, err
+	}
+	s.index = newReminderIndex(s.watermark, s.lookahead)
+	for i := range events {
+		s.index.update(&events[i])
+	}
+
+	// Индекс пересчитывает только измененное событие. Оно может сработать раньше,
+	// чем планировщик собирался проснуться
+	st.subscribe(func(change storageChange) {
+		if change.Op == opDelete {
+			s.index.remove(change.Event.UserID, change.Event.EventID)
+		} else {
+			s.index.update(&change.Event)
+		}
+		s.poke()
+	})
+
+	return s, nil
+}
+
+// poke будит цикл планировщика, не блокируется
+func (s *Scheduler) poke() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Start запускает цикл планировщика
+func (s *Scheduler) Start() {
+	s.wg.Add(1)
+	go s.loop()
+}
+
+// Stop останавливает цикл и дожидается его завершения
+func (s *Scheduler) Stop() {
+	close(s.done)
+	s.wg.Wait()
+}
+
+func (s *Scheduler) loop() {
+	defer s.wg.Done()
+
+	for {
+		now := s.clock.Now()
+		s.tick(now)
+
+		wait := s.lookahead
+		if next, ok := s.index.next(); ok {
+			wait = next.Sub(now)
+		}
+
+		select {
+		case <-s.clock.After(wait):
+		case <-s.wake:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// tick рассылает напоминания, сработавшие в (watermark, now], и сдвигает watermark
+func (s *Scheduler) tick(now time.Time) {
+	s.mu.Lock()
+	if !now.After(s.watermark) {
+		s.mu.Unlock()
+		return
+	}
+
+	if oldest := now.Add(-s.maxLateness); s.watermark.Before(oldest) {
+		if skipped := s.index.pop(oldest); len(skipped) > 0 {
+			log.Printf("scheduler: skipping %d reminder(s) due before %v", len(skipped), oldest.Format(time.RFC3339))
+		}
+	}
+	due := s.index.pop(now)
+	s.watermark = now
+	s.mu.Unlock()
+
+	// Получатель может отвечать до notifyTimeout, s.mu на это время не держится
+	for _, n := range due {
+		ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+		if err := s.notifier.Notify(ctx, n); err != nil {
+			log.Printf("scheduler: reminder for event %v of user %v: %v", n.EventID, n.UserID, err)
+		}
+		cancel()
+	}
+
+	// Состояние сохраняется после отправки: при падении посреди рассылки напоминания повторятся
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.saveState(); err != nil {
+		log.Printf("scheduler: %v", err)
+	}
+}
+
+// saveState атомарно сохраняет watermark, вызывается под s.mu
+func (s *Scheduler) saveState() error {
+	if s.statePath == "" {
+		return nil
+	}
+	data, err := json.Marshal(schedulerState{Watermark: s.watermark})
+	if err != nil {
+		return err
+	}
+	tmp := s.statePath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("can't write scheduler state: %w", err)
+	}
+	return os.Rename(tmp, s.statePath)
+}
+
+// forEachReminder перебирает напоминания экземпляров события, срабатывающие в (from, to]
+func forEachReminder(ev *Event, from, to time.Time, fn func(occ *Event, rem Reminder, fireAt time.Time)) {
+	for _, rem := range ev.Reminders {
+		b := rem.before()
+		for _, occ := range ev.expand(from.Add(b), to.Add(b).Add(time.Nanosecond)) {
+			// У измененного повторения свои напоминания
+			if !hasReminder(occ.Reminders, rem) {
+				continue
+			}
+			fireAt := occ.start().Add(-b)
+			if fireAt.After(from) && !fireAt.After(to) {
+				fn(&occ, rem, fireAt)
+			}
+		}
+	}
+	// Напоминания, добавленные только к измененному повторению
+	for j := range ev.Overrides {
+		o := &ev.Overrides[j]
+		for _, rem := range o.Reminders {
+			if hasReminder(ev.Reminders, rem) {
+				continue
+			}
+			fireAt := o.start().Add(-rem.before())
+			if fireAt.After(from) && !fireAt.After(to) && ev.hasOccurrence(*o.RecurrenceID) {
+				fn(o, rem, fireAt)
+			}
+		}
+	}
+}
+
+func hasReminder(reminders []Reminder, rem Reminder) bool {
+	for _, r := range reminders {
+		if r == rem {
+			return true
+		}
+	}
+	return false
+}
+
+// hasReminders есть ли у события или его повторений напоминания
+func hasReminders(ev *Event) bool {
+	if len(ev.Reminders) > 0 {
+		return true
+	}
+	for i := range ev.Overrides {
+		if len(ev.Overrides[i].Reminders) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// lastStart позднее начало экземпляра события, false - у серии нет конца по времени
+func lastStart(ev *Event) (time.Time, bool) {
+	if ev.Recurrence == nil {
+		return ev.start(), true
+	}
+	var last time.Time
+	switch {
+	case ev.Recurrence.Count > 0:
+		last = ev.Recurrence.lastCounted(ev.start())
+	case !ev.Recurrence.Until.IsZero():
+		last = ev.Recurrence.Until
+	default:
+		return time.Time{}, false
+	}
+	for i := range ev.Overrides {
+		if start := ev.Overrides[i].start(); start.After(last) {
+			last = start
+		}
+	}
+	return last, true
+}
+
+type reminderKey struct {
+	userID  int
+	eventID int
+}
+
+// reminderEntry ближайшее срабатывание события в индексе. Пустой due - срабатываний
+// в окне lookahead нет, в at событие пересматривается
+type reminderEntry struct {
+	key   reminderKey
+	event Event
+	at    time.Time
+	due   []Notification
+	pos   int
+}
+
+// reminderQueue куча записей по времени срабатывания
+type reminderQueue []*reminderEntry
+
+func (q reminderQueue) Len() int { return len(q) }
+
+func (q reminderQueue) Less(i, j int) bool {
+	if !q[i].at.Equal(q[j].at) {
+		return q[i].at.Before(q[j].at)
+	}
+	if q[i].key.userID != q[j].key.userID {
+		return q[i].key.userID < q[j].key.userID
+	}
+	return q[i].key.eventID < q[j].key.eventID
+}
+
+func (q reminderQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].pos, q[j].pos = i, j
+}
+
+func (q *reminderQueue) Push(x interface{}) {
+	e := x.(*reminderEntry)
+	e.pos = len(*q)
+	*q = append(*q, e)
+}
+
+func (q *reminderQueue) Pop() interface{} {
+	old := *q
+	e := old[len(old)-1]
+	old[len(old)-1] = nil
+	*q = old[:len(old)-1]
+	return e
+}
+
+// reminderIndex ближайшее срабатывание каждого события с напоминаниями. Изменение
+// события пересчитывает только его запись, тик забирает из кучи только наступившие.
+// Срабатывания раньше cursor уже выданы
+type reminderIndex struct {
+	lookahead time.Duration
+
+	mu      sync.Mutex
+	cursor  time.Time
+	queue   reminderQueue
+	entries map[reminderKey]*reminderEntry
+}
+
+func newReminderIndex(cursor time.Time, lookahead time.Duration) *reminderIndex {
+	return &reminderIndex{lookahead: lookahead, cursor: cursor, entries: make(map[reminderKey]*reminderEntry)}
+}
+
+// update пересчитывает ближайшее срабатывание события после cursor
+func (ix *reminderIndex) update(ev *Event) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	key := reminderKey{userID: ev.UserID, eventID: ev.EventID}
+	ix.removeLocked(key)
+	if hasReminders(ev) {
+		ix.schedule(&reminderEntry{key: key, event: ev.clone()}, ix.cursor)
+	}
+}
+
+// remove убирает событие из индекса
+func (ix *reminderIndex) remove(userID, eventID int) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ix.removeLocked(reminderKey{userID: userID, eventID: eventID})
+}
+
+func (ix *reminderIndex) removeLocked(key reminderKey) {
+	if e, ok := ix.entries[key]; ok {
+		heap.Remove(&ix.queue, e.pos)
+		delete(ix.entries, key)
+	}
+}
+
+// schedule кладет в кучу ближайшие после after срабатывания события. Событие,
+// у которого срабатываний больше не будет, в индекс не попадает. Вызывается под блокировкой
+func (ix *reminderIndex) schedule(e *reminderEntry, after time.Time) {
+	last, bounded := lastStart(&e.event)
+	if bounded && !last.After(after) {
+		return
+	}
+
+	e.at, e.due = after.Add(ix.lookahead), nil
+	forEachReminder(&e.event, after, e.at, func(occ *Event, rem Reminder, fireAt time.Time) {
+		if fireAt.After(e.at) {
+			return
+		}
+		if fireAt.Before(e.at) {
+			e.at, e.due = fireAt, e.due[:0]
+		}
+		e.due = append(e.due, Notification{
+			UserID:        occ.UserID,
+			EventID:       occ.EventID,
+			Title:         occ.Title,
+			Start:         occ.start(),
+			RecurrenceID:  occ.RecurrenceID,
+			MinutesBefore: rem.MinutesBefore,
+			FireAt:        fireAt,
+		})
+	})
+	// Напоминаний до конца события или серии не осталось
+	if len(e.due) == 0 && bounded && !last.After(e.at) {
+		return
+	}
+	ix.entries[e.key] = e
+	heap.Push(&ix.queue, e)
+}
+
+// pop возвращает напоминания, срабатывающие не позже to, в порядке срабатывания,
+// и переносит их события на следующие срабатывания
+func (ix *reminderIndex) pop(to time.Time) []Notification {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	var res []Notification
+	for len(ix.queue) > 0 && !ix.queue[0].at.After(to) {
+		e := heap.Pop(&ix.queue).(*reminderEntry)
+		delete(ix.entries, e.key)
+		res = append(res, e.due...)
+		ix.schedule(e, e.at)
+	}
+	if to.After(ix.cursor) {
+		ix.cursor = to
+	}
+	return res
+}
+
+// next время ближайшей записи индекса
+func (ix *reminderIndex) next() (time.Time, bool) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	if len(ix.queue) == 0 {
+		return time.Time{}, false
+	}
+	return ix.queue[0].at, true
+}
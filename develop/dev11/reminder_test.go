@@ -0,0 +1,343 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock время, которое двигает тест
+type fakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []fakeWaiter
+	added   chan struct{}
+}
+
+type fakeWaiter struct {
+	at time.Time
+	ch chan time.Time
+}
+
+func newFakeClock(now time.Time) *fakeClock {
+	return &fakeClock{now: now, added: make(chan struct{}, 100)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- c.now
+		return ch
+	}
+	c.waiters = append(c.waiters, fakeWaiter{at: c.now.Add(d), ch: ch})
+	c.added <- struct{}{}
+	return ch
+}
+
+// Advance сдвигает время и будит ожидающих, чей срок наступил
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+	rest := c.waiters[:0]
+	for _, w := range c.waiters {
+		if !w.at.After(c.now) {
+			w.ch <- c.now
+		} else {
+			rest = append(rest, w)
+		}
+	}
+	c.waiters = rest
+}
+
+// waitForSleep дожидается, пока планировщик уснет на часах
+func (c *fakeClock) waitForSleep(t *testing.T) {
+	t.Helper()
+	select {
+	case <-c.added:
+	case <-time.After(time.Second):
+		t.Fatal("scheduler did not go to sleep")
+	}
+}
+
+// recordNotifier запоминает доставленные напоминания
+type recordNotifier struct {
+	ch chan Notification
+}
+
+func (rn *recordNotifier) Notify(_ context.Context, n Notification) error {
+	rn.ch <- n
+	return nil
+}
+
+func expectNotification(t *testing.T, ch chan Notification) Notification {
+	t.Helper()
+	select {
+	case n := <-ch:
+		return n
+	case <-time.After(time.Second):
+		t.Fatal("reminder was not delivered")
+	}
+	return Notification{}
+}
+
+func expectNoNotification(t *testing.T, ch chan Notification) {
+	t.Helper()
+	select {
+	case n := <-ch:
+		t.Fatalf("unexpected reminder %+v", n)
+	default:
+	}
+}
+
+func TestSchedulerFiresOnTime(t *testing.T) {
+	clk := newFakeClock(mustTime(t, "2019-09-09T09:00:00Z"))
+	st := newMemoryStorage()
+	rn := &recordNotifier{ch: make(chan Notification, 10)}
+
+	ev := Event{UserID: 1, EventID: 1, Title: "standup",
+		Start: mustTime(t, "2019-09-09T10:00:00Z"), Recurrence: &Recurrence{Freq: freqDaily},
+		Reminders: []Reminder{{MinutesBefore: 10}}}
+	if err := ev.validate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Create(&ev); err != nil {
+		t.Fatal(err)
+	}
+
+	sched, err := newScheduler(st, rn, clk, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sched.Start()
+	defer sched.Stop()
+
+	clk.waitForSleep(t)
+	clk.Advance(49 * time.Minute)
+	expectNoNotification(t, rn.ch)
+
+	clk.Advance(time.Minute)
+	n := expectNotification(t, rn.ch)
+	if n.EventID != 1 || !n.FireAt.Equal(mustTime(t, "2019-09-09T09:50:00Z")) {
+		t.Errorf("unexpected reminder %+v", n)
+	}
+
+	// Следующее повторение - через сутки
+	clk.waitForSleep(t)
+	clk.Advance(24 * time.Hour)
+	n = expectNotification(t, rn.ch)
+	if !n.Start.Equal(mustTime(t, "2019-09-10T10:00:00Z")) {
+		t.Errorf("unexpected reminder %+v", n)
+	}
+}
+
+func TestSchedulerWakesOnNewEvent(t *testing.T) {
+	clk := newFakeClock(mustTime(t, "2019-09-09T09:00:00Z"))
+	st := newMemoryStorage()
+	rn := &recordNotifier{ch: make(chan Notification, 10)}
+
+	sched, err := newScheduler(st, rn, clk, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sched.Start()
+	defer sched.Stop()
+	// Событий нет - планировщик спит весь lookahead
+	clk.waitForSleep(t)
+
+	ev := Event{UserID: 2, EventID: 1, Title: "call",
+		Start: mustTime(t, "2019-09-09T09:30:00Z"), Reminders: []Reminder{{MinutesBefore: 5}}}
+	ev.validate()
+	if err := st.Create(&ev); err != nil {
+		t.Fatal(err)
+	}
+	clk.waitForSleep(t)
+
+	clk.Advance(25 * time.Minute)
+	if n := expectNotification(t, rn.ch); n.UserID != 2 {
+		t.Errorf("unexpected reminder %+v", n)
+	}
+}
+
+func TestSchedulerCatchesUpAfterRestart(t *testing.T) {
+	statePath := filepath.Join(t.TempDir(), reminderStateFileName)
+	clk := newFakeClock(mustTime(t, "2019-09-09T09:00:00Z"))
+	st := newMemoryStorage()
+	rn := &recordNotifier{ch: make(chan Notification, 10)}
+
+	for i, start := range []string{"2019-09-09T09:20:00Z", "2019-09-09T12:00:00Z"} {
+		ev := Event{UserID: 1, EventID: i + 1, Title: "ev", Start: mustTime(t, start),
+			Reminders: []Reminder{{MinutesBefore: 10}}}
+		ev.validate()
+		st.Create(&ev)
+	}
+
+	sched, err := newScheduler(st, rn, clk, statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sched.tick(clk.Now())
+
+	// Сервер лежал 30 минут: напоминание в 09:10 досылается после старта
+	clk.Advance(30 * time.Minute)
+	restarted, err := newScheduler(st, rn, clk, statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restarted.tick(clk.Now())
+	if n := expectNotification(t, rn.ch); n.EventID != 1 {
+		t.Errorf("unexpected reminder %+v", n)
+	}
+	restarted.tick(clk.Now())
+	expectNoNotification(t, rn.ch)
+
+	// После долгого простоя опоздавшие больше чем на maxLateness пропускаются
+	clk.Advance(4 * time.Hour)
+	restarted, _ = newScheduler(st, rn, clk, statePath)
+	restarted.tick(clk.Now())
+	expectNoNotification(t, rn.ch)
+}
+
+// countingStorage считает полные выборки событий
+type countingStorage struct {
+	Storage
+	scans int
+}
+
+func (cs *countingStorage) getAllEvents() ([]Event, error) {
+	cs.scans++
+	return cs.Storage.getAllEvents()
+}
+
+// TestSchedulerIndex тик и изменения событий не перебирают все события хранилища
+func TestSchedulerIndex(t *testing.T) {
+	clk := newFakeClock(mustTime(t, "2019-09-09T09:00:00Z"))
+	st := &countingStorage{Storage: newMemoryStorage()}
+	rn := &recordNotifier{ch: make(chan Notification, 10)}
+
+	for i, start := range []string{"2019-09-09T10:00:00Z", "2019-09-09T11:00:00Z"} {
+		ev := Event{UserID: 1, EventID: i + 1, Title: "ev", Start: mustTime(t, start),
+			Reminders: []Reminder{{MinutesBefore: 10}}}
+		ev.validate()
+		st.Create(&ev)
+	}
+	sched, err := newScheduler(st, rn, clk, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Перенос события пересчитывает его срабатывание, удаление убирает из индекса
+	moved := Event{UserID: 1, EventID: 1, Title: "moved", Start: mustTime(t, "2019-09-09T09:30:00Z"),
+		Reminders: []Reminder{{MinutesBefore: 10}}}
+	moved.validate()
+	if err := st.Update(&moved); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.Delete(&Event{UserID: 1, EventID: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if next, ok := sched.index.next(); !ok || !next.Equal(mustTime(t, "2019-09-09T09:20:00Z")) {
+		t.Fatalf("next reminder %v %v, want 09:20", next, ok)
+	}
+
+	for i := 0; i < 4; i++ {
+		clk.Advance(time.Hour)
+		sched.tick(clk.Now())
+	}
+	if n := expectNotification(t, rn.ch); n.Title != "moved" {
+		t.Errorf("unexpected reminder %+v", n)
+	}
+	expectNoNotification(t, rn.ch)
+	if st.scans != 1 {
+		t.Errorf("storage scanned %d times, want once at start", st.scans)
+	}
+	if _, ok := sched.index.next(); ok {
+		t.Error("past event is kept in the index")
+	}
+}
+
+// blockingNotifier держит отправку, пока тест не отпустит release
+type blockingNotifier struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (bn *blockingNotifier) Notify(_ context.Context, _ Notification) error {
+	bn.started <- struct{}{}
+	<-bn.release
+	return nil
+}
+
+func TestSchedulerNotifiesWithoutLock(t *testing.T) {
+	clk := newFakeClock(mustTime(t, "2019-09-09T09:00:00Z"))
+	st := newMemoryStorage()
+	bn := &blockingNotifier{started: make(chan struct{}), release: make(chan struct{})}
+
+	ev := Event{UserID: 1, EventID: 1, Title: "ev", Start: mustTime(t, "2019-09-09T10:00:00Z"),
+		Reminders: []Reminder{{MinutesBefore: 10}}}
+	ev.validate()
+	st.Create(&ev)
+	sched, err := newScheduler(st, bn, clk, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clk.Advance(time.Hour)
+	ticked := make(chan struct{})
+	go func() {
+		sched.tick(clk.Now())
+		close(ticked)
+	}()
+	<-bn.started
+
+	// Пока получатель не ответил, планировщик не держит блокировку
+	again := make(chan struct{})
+	go func() {
+		sched.tick(clk.Now())
+		close(again)
+	}()
+	select {
+	case <-again:
+	case <-time.After(time.Second):
+		t.Fatal("scheduler lock is held while notifying")
+	}
+
+	close(bn.release)
+	<-ticked
+}
+
+func TestLastStartCount(t *testing.T) {
+	ev := Event{UserID: 1, EventID: 1, Title: "ev", Start: mustTime(t, "2019-09-09T10:00:00Z"),
+		Recurrence: &Recurrence{Freq: freqWeekly, ByDay: []string{"MO", "WE"}, Count: 5}}
+	if err := ev.validate(); err != nil {
+		t.Fatal(err)
+	}
+	last, ok := lastStart(&ev)
+	if !ok || !last.Equal(mustTime(t, "2019-09-23T10:00:00Z")) {
+		t.Errorf("last start %v %v, want 2019-09-23 10:00", last, ok)
+	}
+
+	// Прошедшая серия с COUNT не остается в индексе напоминаний
+	st := newMemoryStorage()
+	st.Create(&ev)
+	sched, err := newScheduler(st, &recordNotifier{ch: make(chan Notification, 10)},
+		newFakeClock(mustTime(t, "2019-10-01T00:00:00Z")), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := sched.index.next(); ok {
+		t.Error("finished series is kept in the index")
+	}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Вспомогательные функции разбора запросов и выбора HTTP-статуса по ошибке
+
+// errUnsupportedMediaType тело запроса не JSON и не форма
+var errUnsupportedMediaType = errors.New("unsupported content type, use application/json or application/x-www-form-urlencoded")
+
+// decodeErrStatus статус ответа на ошибку разбора тела запроса
+func decodeErrStatus(err error) int {
+	if errors.Is(err, errUnsupportedMediaType) {
+		return http.StatusUnsupportedMediaType
+	}
+	return http.StatusBadRequest
+}
+
+// overlapMode режим проверки пересечений из query или из полей формы
+func overlapMode(r *http.Request) (string, error) {
+	mode := r.FormValue("overlap")
+	if mode != overlapAllow && mode != overlapReject && mode != overlapReport {
+		return "", fmt.Errorf("invalid overlap mode")
+	}
+	return mode, nil
+}
+
+// timeLayouts допустимые форматы времени в формах. Форматы без смещения разбираются в поясе события
+var timeLayouts = []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02T15:04", dateFormat}
+
+// bodyFormat определяет формат тела по Content-Type: "json" или "form"
+func bodyFormat(r *http.Request) (string, error) {
+	ct := r.Header.Get("Content-Type")
+	if ct == "" {
+		return "json", nil
+	}
+	mediaType, _, err := mime.ParseMediaType(ct)
+	if err != nil {
+		return "", errUnsupportedMediaType
+	}
+	switch mediaType {
+	case "application/json":
+		return "json", nil
+	case "application/x-www-form-urlencoded", "multipart/form-data":
+		return "form", nil
+	default:
+		return "", errUnsupportedMediaType
+	}
+}
+
+// decodeEvent разбирает событие из тела запроса в JSON или www-url-form-encoded.
+// Уже заполненные поля ev сохраняются, если в запросе их нет (используется для PATCH)
+func decodeEvent(r *http.Request, ev *Event) error {
+	format, err := bodyFormat(r)
+	if err != nil {
+		return err
+	}
+
+	if format == "json" {
+		return ev.decode(r.Body)
+	}
+
+	if err := r.ParseMultipartForm(1 << 20); err != nil && !errors.Is(err, http.ErrNotMultipart) {
+		return err
+	}
+	return parseFormEvent(r.PostForm, ev)
+}
+
+// parseFormEvent заполняет событие из полей формы
+func parseFormEvent(form url.Values, ev *Event) error {
+	has := func(key string) bool { _, ok := form[key]; return ok }
+
+	for key, dst := range map[string]*int{"user_id": &ev.UserID, "event_id": &ev.EventID} {
+		if has(key) {
+			n, err := strconv.Atoi(form.Get(key))
+			if err != nil {
+				return fmt.Errorf("invalid %s", key)
+			}
+			*dst = n
+		}
+	}
+	if has("title") {
+		ev.Title = form.Get("title")
+	}
+	if has("description") {
+		ev.Description = form.Get("description")
+	}
+	if has("time_zone") {
+		ev.TimeZone = form.Get("time_zone")
+	}
+
+	loc, err := loadLocation(ev.TimeZone)
+	if err != nil {
+		return fmt.Errorf("invalid time_zone %q", ev.TimeZone)
+	}
+	times := map[string]*time.Time{"date": &ev.Date, "start": &ev.Start, "end": &ev.End}
+	for _, key := range []string{"date", "start", "end"} {
+		if has(key) {
+			t, err := parseTimeValue(form.Get(key), loc)
+			if err != nil {
+				return fmt.Errorf("invalid %s", key)
+			}
+			*times[key] = t
+		}
+	}
+	if has("recurrence_id") {
+		t, err := parseTimeValue(form.Get("recurrence_id"), loc)
+		if err != nil {
+			return fmt.Errorf("invalid recurrence_id")
+		}
+		ev.RecurrenceID = &t
+	}
+
+	if has("freq") {
+		rule, err := parseFormRecurrence(form, loc)
+		if err != nil {
+			return err
+		}
+		ev.Recurrence = rule
+	}
+	if has("reminder") {
+		ev.Reminders = nil
+		for _, v := range form["reminder"] {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return fmt.Errorf("invalid reminder")
+			}
+			ev.Reminders = append(ev.Reminders, Reminder{MinutesBefore: n})
+		}
+	}
+	if has("attendee") {
+		ev.Attendees = nil
+		for _, v := range form["attendee"] {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return fmt.Errorf("invalid attendee")
+			}
+			ev.Attendees = append(ev.Attendees, Attendee{UserID: n})
+		}
+	}
+
+	return nil
+}
+
+// parseFormRecurrence правило повторения из полей freq, interval, by_day, count, until и exdate
+func parseFormRecurrence(form url.Values, loc *time.Location) (*Recurrence, error) {
+	rule := &Recurrence{Freq: form.Get("freq")}
+	var err error
+	if v := form.Get("interval"); v != "" {
+		if rule.Interval, err = strconv.Atoi(v); err != nil {
+			return nil, fmt.Errorf("invalid interval")
+		}
+	}
+	if v := form.Get("count"); v != "" {
+		if rule.Count, err = strconv.Atoi(v); err != nil {
+			return nil, fmt.Errorf("invalid count")
+		}
+	}
+	if v := form.Get("until"); v != "" {
+		if rule.Until, err = parseTimeValue(v, loc); err != nil {
+			return nil, fmt.Errorf("invalid until")
+		}
+	}
+	for _, v := range form["by_day"] {
+		rule.ByDay = append(rule.ByDay, strings.Split(strings.ToUpper(v), ",")...)
+	}
+	for _, v := range form["exdate"] {
+		t, err := parseTimeValue(v, loc)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exdate")
+		}
+		rule.ExDates = append(rule.ExDates, t)
+	}
+	return rule, nil
+}
+
+// parseTimeValue разбирает время в одном из timeLayouts
+func parseTimeValue(v string, loc *time.Location) (time.Time, error) {
+	for _, layout := range timeLayouts {
+		if t, err := time.ParseInLocation(layout, v, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q", v)
+}
+
+// storageErrStatus статус ответа по ошибке хранилища: сбой хранилища - 500,
+// устаревшая версия - 412, остальное - ошибка бизнес-логики, 503.
+// Сбой отмечается в запросе, чтобы ответ не сохранился для Idempotency-Key
+func storageErrStatus(r *http.Request, err error) int {
+	var failure *storageFailure
+	var version *versionError
+	switch {
+	case errors.As(err, &failure):
+		if call := idempotentCallFrom(r.Context()); call != nil {
+			call.failed = true
+		}
+		return http.StatusInternalServerError
+	case errors.As(err, &version):
+		return http.StatusPreconditionFailed
+	default:
+		return http.StatusServiceUnavailable
+	}
+}
+
+// etag значение заголовка ETag для версии события
+func etag(version int) string {
+	return strconv.Quote(strconv.Itoa(version))
+}
+
+// ifMatch разбирает заголовок If-Match в ожидаемую версию события: "3", W/"3" или *.
+// Без заголовка возвращает версию из тела запроса
+func ifMatch(r *http.Request, bodyVersion int) (int, error) {
+	v := strings.TrimSpace(r.Header.Get("If-Match"))
+	switch v {
+	case "":
+		return bodyVersion, nil
+	case "*":
+		return 0, nil
+	}
+	version, err := strconv.Atoi(strings.Trim(strings.TrimPrefix(v, "W/"), `"`))
+	if err != nil || version <= 0 {
+		return 0, fmt.Errorf("invalid If-Match %q", v)
+	}
+	return version, nil
+}
+
+// allowMethods отвечает 405 на методы, не предусмотренные API
+func allowMethods(h http.HandlerFunc, methods ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		for _, m := range methods {
+			if r.Method == m {
+				h(w, r)
+				return
+			}
+		}
+		w.Header().Set("Allow", strings.Join(methods, ", "))
+		getErrResponse(w, fmt.Sprintf("method %s is not allowed", r.Method), http.StatusMethodNotAllowed)
+	}
+}
+
+// acceptsJSON проверяет, что клиент согласен получить JSON
+func acceptsJSON(r *http.Request) bool {
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return true
+	}
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		switch mediaType {
+		case "application/json", "application/*", "*/*":
+			return true
+		}
+	}
+	return false
+}
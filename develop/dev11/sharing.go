@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// Совместные календари: организатор перечисляет в attendees приглашенных пользователей.
+// Событие хранится один раз у организатора, поэтому его правки и удаление сразу видны
+// всем приглашенным, а те видят событие в своих выборках за день, неделю и месяц
+
+const (
+	rsvpNeedsAction = "needs-action"
+	rsvpAccepted    = "accepted"
+	rsvpDeclined    = "declined"
+	rsvpTentative   = "tentative"
+)
+
+// Attendee приглашенный пользователь и его ответ
+type Attendee struct {
+	UserID int    `json:"user_id"`
+	Status string `json:"status,omitempty"`
+}
+
+// validRSVP ответы, которые может дать приглашенный
+func validRSVP(status string) bool {
+	return status == rsvpAccepted || status == rsvpDeclined || status == rsvpTentative
+}
+
+// validateAttendees проверяет список приглашенных, пустой статус означает needs-action
+func (ev *Event) validateAttendees() error {
+	seen := make(map[int]bool, len(ev.Attendees))
+	for i := range ev.Attendees {
+		a := &ev.Attendees[i]
+		switch {
+		case a.UserID <= 0:
+			return fmt.Errorf("invalid attendee user_id %v", a.UserID)
+		case a.UserID == ev.UserID:
+			return fmt.Errorf("organizer can't be an attendee")
+		case seen[a.UserID]:
+			return fmt.Errorf("duplicate attendee %v", a.UserID)
+		case a.Status == "":
+			a.Status = rsvpNeedsAction
+		case a.Status != rsvpNeedsAction && !validRSVP(a.Status):
+			return fmt.Errorf("invalid attendee status %q", a.Status)
+		}
+		seen[a.UserID] = true
+	}
+	return nil
+}
+
+// attendeeStatus ответ пользователя на приглашение, "" - если он не приглашен
+func (ev *Event) attendeeStatus(userID int) string {
+	for _, a := range ev.Attendees {
+		if a.UserID == userID {
+			return a.Status
+		}
+	}
+	return ""
+}
+
+// keepResponses переносит ответы уже приглашенных из stored в новый список организатора:
+// отвечать за приглашенных организатор не может
+func keepResponses(attendees, stored []Attendee) []Attendee {
+	if len(attendees) == 0 {
+		return attendees
+	}
+	res := make([]Attendee, len(attendees))
+	for i, a := range attendees {
+		a.Status = rsvpNeedsAction
+		for _, old := range stored {
+			if old.UserID == a.UserID {
+				a.Status = old.Status
+			}
+		}
+		res[i] = a
+	}
+	return res
+}
+
+func (s *MemoryStorage) respond(organizerID, eventID, userID int, status string) (*Event, error) {
+	if !validRSVP(status) {
+		return nil, fmt.Errorf("invalid status %q", status)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	index := s.find(organizerID, eventID)
+	if index == -1 {
+		return nil, fmt.Errorf("can't find event with %v id for %v user id", eventID, organizerID)
+	}
+
+	ev := s.events[organizerID][index]
+	if ev.attendeeStatus(userID) == "" {
+		return nil, fmt.Errorf("user %v is not invited to event %v", userID, eventID)
+	}
+
+	// Срез приглашенных принадлежит хранилищу до записи в журнал, меняем копию
+	attendees := make([]Attendee, len(ev.Attendees))
+	for i, a := range ev.Attendees {
+		if a.UserID == userID {
+			a.Status = status
+		}
+		attendees[i] = a
+	}
+	ev.Attendees = attendees
+	ev.Version++
+	ev.UpdatedBy, ev.UpdatedAt = userID, s.now()
+
+	if err := s.put(ev); err != nil {
+		return nil, err
+	}
+	return &ev, nil
+}
+
+func (s *MemoryStorage) invitations(userID int) ([]Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ui, ok := s.index[userID]
+	if !ok {
+		return nil, &unknownUserError{userID: userID}
+	}
+
+	res := make([]Event, 0, len(ui.invited))
+	for key := range ui.invited {
+		res = append(res, s.events[key.userID][s.index[key.userID].position[key.eventID]])
+	}
+	sortEvents(res)
+
+	return res, nil
+}
+
+// ===== Обработчики API v2 =====
+
+// listInvitationsV2 GET /users/{id}/invitations[?status=needs-action]
+func listInvitationsV2(w http.ResponseWriter, r *http.Request, userID int) {
+	status := r.URL.Query().Get("status")
+	if status != "" && status != rsvpNeedsAction && !validRSVP(status) {
+		getErrResponse(w, "invalid status", http.StatusBadRequest)
+		return
+	}
+
+	events, err := storage.invitations(userID)
+	if err != nil {
+		getErrResponse(w, err.Error(), storageErrStatus(r, err))
+		return
+	}
+
+	res := events[:0]
+	for _, ev := range events {
+		if status == "" || ev.attendeeStatus(userID) == status {
+			res = append(res, ev)
+		}
+	}
+
+	getResponse(w, "Запрос успешно выполнен!", res, http.StatusOK)
+}
+
+// respondV2 PUT /users/{id}/invitations/{organizerID}/{eventID} с полем status
+func respondV2(w http.ResponseWriter, r *http.Request, userID, organizerID, eventID int) {
+	var req struct {
+		Status string `json:"status"`
+	}
+
+	format, err := bodyFormat(r)
+	if err != nil {
+		getErrResponse(w, err.Error(), decodeErrStatus(err))
+		return
+	}
+	if format == "json" {
+		err = decodeBody(r, &req)
+	} else {
+		req.Status = r.FormValue("status")
+	}
+	if err != nil {
+		getErrResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !validRSVP(req.Status) {
+		getErrResponse(w, "status must be accepted, declined or tentative", http.StatusBadRequest)
+		return
+	}
+
+	ev, err := storage.respond(organizerID, eventID, userID, req.Status)
+	if err != nil {
+		getErrResponse(w, err.Error(), storageErrStatus(r, err))
+		return
+	}
+
+	getResponse(w, "Ответ сохранен!", []Event{*ev}, http.StatusOK)
+}
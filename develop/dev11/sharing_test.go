@@ -0,0 +1,118 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestSharedEvents(t *testing.T) {
+	srv, _ := newTestServer(t)
+	alice := issueToken(t, srv, "1")
+	bob := issueToken(t, srv, "2")
+	carol := issueToken(t, srv, "3")
+
+	body := `{"event_id": 1, "title": "design review", "start": "2019-09-09T10:00:00Z",
+		"attendees": [{"user_id": 2, "status": "accepted"}, {"user_id": 3}]}`
+	status, res := doRequest(t, http.MethodPost, srv.URL+"/create_event", alice, body, nil)
+	if status != http.StatusCreated {
+		t.Fatalf("create failed: %v %v", status, res)
+	}
+
+	dayCount := func(token string) int {
+		t.Helper()
+		status, res := doRequest(t, http.MethodGet, srv.URL+"/events_for_day?date=2019-09-09", token, "", nil)
+		if status != http.StatusOK {
+			t.Fatalf("day query: %v %v", status, res)
+		}
+		events, _ := res["events"].([]interface{})
+		return len(events)
+	}
+
+	// Приглашенные видят событие у себя, даже без собственных событий
+	if dayCount(bob) != 1 || dayCount(carol) != 1 {
+		t.Fatal("attendees must see the shared event")
+	}
+
+	// Организатор не может ответить за приглашенного: accepted при создании сброшен
+	status, res = doRequest(t, http.MethodGet, srv.URL+"/users/2/invitations?status=needs-action", bob, "", nil)
+	if status != http.StatusOK || len(res["events"].([]interface{})) != 1 {
+		t.Fatalf("bob must have a pending invitation: %v %v", status, res)
+	}
+
+	tests := []struct {
+		name  string
+		path  string
+		token string
+		body  string
+		want  int
+	}{
+		{"accept", "/users/2/invitations/1/1", bob, `{"status": "accepted"}`, http.StatusOK},
+		{"decline", "/users/3/invitations/1/1", carol, `{"status": "declined"}`, http.StatusOK},
+		{"bad status", "/users/2/invitations/1/1", bob, `{"status": "maybe"}`, http.StatusBadRequest},
+		{"not invited", "/users/1/invitations/1/1", alice, `{"status": "accepted"}`, http.StatusServiceUnavailable},
+		{"foreign answer", "/users/2/invitations/1/1", carol, `{"status": "declined"}`, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if status, res := doRequest(t, http.MethodPut, srv.URL+tt.path, tt.token, tt.body, nil); status != tt.want {
+				t.Errorf("got %v %v, want %v", status, res, tt.want)
+			}
+		})
+	}
+
+	// Отказавшийся больше не видит событие
+	if dayCount(carol) != 0 {
+		t.Error("declined event must be hidden")
+	}
+
+	// Перенос организатором виден приглашенным, ответы сохраняются
+	body = `{"event_id": 1, "title": "design review", "start": "2019-09-10T10:00:00Z",
+		"attendees": [{"user_id": 2}, {"user_id": 3}]}`
+	if status, res := doRequest(t, http.MethodPost, srv.URL+"/update_event", alice, body, nil); status != http.StatusOK {
+		t.Fatalf("update failed: %v %v", status, res)
+	}
+	if dayCount(bob) != 0 {
+		t.Error("moved event must leave the old day")
+	}
+	status, res = doRequest(t, http.MethodGet, srv.URL+"/users/2/invitations?status=accepted", bob, "", nil)
+	if status != http.StatusOK || len(res["events"].([]interface{})) != 1 {
+		t.Errorf("bob's answer must survive the update: %v %v", status, res)
+	}
+
+	// Приглашенный не может править чужое событие
+	if status, _ := doRequest(t, http.MethodPost, srv.URL+"/delete_event", bob, `{"user_id": 1, "event_id": 1}`, nil); status != http.StatusForbidden {
+		t.Errorf("attendee delete: got %v, want 403", status)
+	}
+
+	// Удаление организатором убирает событие у всех
+	if status, res := doRequest(t, http.MethodPost, srv.URL+"/delete_event", alice, `{"event_id": 1}`, nil); status != http.StatusOK {
+		t.Fatalf("delete failed: %v %v", status, res)
+	}
+	status, res = doRequest(t, http.MethodGet, srv.URL+"/users/2/invitations", bob, "", nil)
+	if status != http.StatusOK || len(res["events"].([]interface{})) != 0 {
+		t.Errorf("deleted event must disappear from invitations: %v %v", status, res)
+	}
+}
+
+func TestAttendeesValidation(t *testing.T) {
+	tests := []struct {
+		name      string
+		attendees []Attendee
+		ok        bool
+	}{
+		{"valid", []Attendee{{UserID: 2}, {UserID: 3, Status: rsvpTentative}}, true},
+		{"organizer", []Attendee{{UserID: 1}}, false},
+		{"duplicate", []Attendee{{UserID: 2}, {UserID: 2}}, false},
+		{"bad id", []Attendee{{UserID: 0}}, false},
+		{"bad status", []Attendee{{UserID: 2, Status: "maybe"}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ev := testEvent(1, 1, "2019-09-09")
+			ev.Attendees = tt.attendees
+			if err := ev.validate(); (err == nil) != tt.ok {
+				t.Errorf("validate() = %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,674 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Storage интерфейс хранилища событий. Обработчики работают только с ним,
+// поэтому конкретная реализация выбирается конфигурацией (см. newStorage)
+type Storage interface {
+	Create(ev *Event) error
+	Update(ev *Event) error
+	Delete(ev *Event) (*Event, error)
+	getEventsForDay(userID int, date time.Time) ([]Event, error)
+	getEventsForWeek(userID int, date time.Time) ([]Event, error)
+	getEventsForMonth(userID int, date time.Time) ([]Event, error)
+	// getEvents возвращает события пользователя как они хранятся, без разворачивания серий
+	getEvents(userID int) ([]Event, error)
+	// getEvent возвращает одно событие пользователя как оно хранится
+	getEvent(userID, eventID int) (*Event, error)
+	// between возвращает экземпляры событий пользователя, пересекающиеся с [from, to)
+	between(userID int, from, to time.Time) ([]Event, error)
+	// respond сохраняет ответ приглашенного userID на событие организатора
+	respond(organizerID, eventID, userID int, status string) (*Event, error)
+	// invitations возвращает события других пользователей, куда приглашен userID, как они хранятся
+	invitations(userID int) ([]Event, error)
+	// query ищет экземпляры событий по eventQuery и возвращает страницу и курсор следующей
+	query(q eventQuery) ([]Event, *eventCursor, error)
+	// overlaps возвращает экземпляры других событий пользователя, пересекающиеся с ev
+	overlaps(ev *Event) ([]Event, error)
+	// createExclusive и updateExclusive отказывают с overlapError, если событие с чем-то пересекается
+	createExclusive(ev *Event) error
+	updateExclusive(ev *Event) error
+	// getAllEvents возвращает события всех пользователей как они хранятся, без корзины
+	getAllEvents() ([]Event, error)
+	// trashed возвращает удаленные события пользователя, сначала недавно удаленные
+	trashed(userID int) ([]Event, error)
+	// restore возвращает событие из корзины в календарь
+	restore(userID, eventID int) (*Event, error)
+	// purge окончательно удаляет события, попавшие в корзину раньше before, и возвращает их число
+	purge(before time.Time) (int, error)
+	// reserveEventIDs запрещает выдавать при создании EventID не больше id
+	reserveEventIDs(id int)
+	// subscribe регистрирует наблюдателя за изменениями хранилища
+	subscribe(fn func(change storageChange))
+	// Close сбрасывает данные на диск и освобождает ресурсы хранилища
+	Close() error
+}
+
+// existsError событие с таким EventID у пользователя уже есть
+type existsError struct {
+	userID, eventID int
+}
+
+func (e *existsError) Error() string {
+	return fmt.Sprintf("%v event for %v user already exists", e.eventID, e.userID)
+}
+
+// unknownUserError у пользователя нет ни событий, ни приглашений
+type unknownUserError struct {
+	userID int
+}
+
+func (e *unknownUserError) Error() string {
+	return fmt.Sprintf("user %v doesn't exist", e.userID)
+}
+
+// versionError событие изменилось с тех пор, как клиент его прочитал
+type versionError struct {
+	expected, actual int
+}
+
+func (e *versionError) Error() string {
+	return fmt.Sprintf("event version is %v, not %v", e.actual, e.expected)
+}
+
+// checkVersion проверяет ожидаемую клиентом версию, 0 - без проверки
+func checkVersion(expected int, stored *Event) error {
+	if expected != 0 && expected != stored.Version {
+		return &versionError{expected: expected, actual: stored.Version}
+	}
+	return nil
+}
+
+// storageFailure хранилище не смогло сохранить изменение (ошибка диска, журнала).
+// В отличие от ошибок бизнес-логики это ошибка сервера
+type storageFailure struct {
+	err error
+}
+
+func (e *storageFailure) Error() string {
+	return e.err.Error()
+}
+
+func (e *storageFailure) Unwrap() error {
+	return e.err
+}
+
+// journalRecord - запись об изменении хранилища.
+// Любая мутация сводится к put (создать или заменить событие) или delete.
+// Перенос в корзину и восстановление - тоже put: событие с DeletedAt хранится в корзине
+type journalRecord struct {
+	Op    string `json:"op"`
+	Event Event  `json:"event"`
+}
+
+const (
+	opPut    = "put"
+	opDelete = "delete"
+	// opRestore бывает только в storageChange: в журнал восстановление пишется как put
+	opRestore = "restore"
+)
+
+// storageChange уже примененное изменение хранилища для наблюдателей.
+// Prev - прежнее состояние события, nil при создании и восстановлении.
+// Перенос в корзину наблюдатели видят как delete, окончательное удаление из корзины не видят
+type storageChange struct {
+	Op    string
+	Event Event
+	Prev  *Event
+}
+
+// MemoryStorage хранилище эвентов в памяти
+type MemoryStorage struct {
+	mu     *sync.Mutex
+	events map[int][]Event
+	// index индексы по EventID, времени начала и словам, см. index.go
+	index map[int]*userIndex
+	// trash удаленные события по пользователю и EventID. В events и индексах их нет,
+	// поэтому поиск и выборки корзину не видят
+	trash map[int]map[int]Event
+	// nextID следующий EventID, который выдаст create. Больше любого EventID,
+	// когда-либо попадавшего в хранилище, поэтому выданные ID не повторяются
+	nextID int
+	now    func() time.Time
+
+	// journal вызывается под блокировкой перед применением каждого изменения.
+	// Если он вернул ошибку, изменение не применяется
+	journal func(rec journalRecord) error
+	// observers вызываются под блокировкой после применения изменения, поэтому не должны блокироваться
+	observers []func(change storageChange)
+}
+
+// Конструктор хранилища в памяти
+func newMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{events: make(map[int][]Event), index: make(map[int]*userIndex), trash: make(map[int]map[int]Event),
+		nextID: 1, now: time.Now, mu: &sync.Mutex{}}
+}
+
+// find возвращает индекс события пользователя или -1
+func (s *MemoryStorage) find(userID, eventID int) int {
+	if ui, ok := s.index[userID]; ok {
+		if pos, ok := ui.position[eventID]; ok {
+			return pos
+		}
+	}
+	return -1
+}
+
+// put создает или заменяет событие, вызывается под блокировкой
+func (s *MemoryStorage) put(ev Event) error {
+	return s.store(ev, opPut)
+}
+
+// store сохраняет событие: с DeletedAt - в корзину, без него - в календарь.
+// У пользователя EventID либо в календаре, либо в корзине: событие с ID из корзины
+// вытесняет удаленное (create такое не пропускает). op - операция для наблюдателей, opPut или opRestore
+func (s *MemoryStorage) store(ev Event, op string) error {
+	if s.journal != nil {
+		if err := s.journal(journalRecord{Op: opPut, Event: ev}); err != nil {
+			return &storageFailure{err: err}
+		}
+	}
+
+	var prev *Event
+	if index := s.find(ev.UserID, ev.EventID); index != -1 {
+		old := s.events[ev.UserID][index]
+		prev = &old
+		if ev.DeletedAt != nil {
+			s.detach(ev.UserID, index)
+		} else {
+			s.indexRemove(&old)
+			s.events[ev.UserID][index] = ev
+			s.indexAdd(&ev, index)
+		}
+	} else if ev.DeletedAt == nil {
+		s.events[ev.UserID] = append(s.events[ev.UserID], ev)
+		s.indexAdd(&ev, len(s.events[ev.UserID])-1)
+	}
+
+	if ev.DeletedAt != nil {
+		if s.trash[ev.UserID] == nil {
+			s.trash[ev.UserID] = make(map[int]Event)
+		}
+		s.trash[ev.UserID][ev.EventID] = ev
+	} else {
+		delete(s.trash[ev.UserID], ev.EventID)
+	}
+	s.reserveID(ev.EventID)
+
+	switch {
+	case ev.DeletedAt != nil && prev != nil:
+		s.notify(storageChange{Op: opDelete, Event: ev, Prev: prev})
+	case ev.DeletedAt != nil:
+		// Замена события в корзине или восстановление снапшота: в календаре ничего не изменилось
+	case op == opRestore:
+		s.notify(storageChange{Op: opRestore, Event: ev})
+	default:
+		s.notify(storageChange{Op: opPut, Event: ev, Prev: prev})
+	}
+
+	return nil
+}
+
+// reserveID гарантирует, что create не выдаст id и меньшие, вызывается под блокировкой
+func (s *MemoryStorage) reserveID(id int) {
+	if id >= s.nextID {
+		s.nextID = id + 1
+	}
+}
+
+// reserveEventIDs то же для id удаленных событий, которых нет в снапшоте, но есть в истории
+func (s *MemoryStorage) reserveEventIDs(id int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.reserveID(id)
+}
+
+// notify оповещает наблюдателей, вызывается под блокировкой
+func (s *MemoryStorage) notify(change storageChange) {
+	for _, fn := range s.observers {
+		fn(change)
+	}
+}
+
+func (s *MemoryStorage) subscribe(fn func(change storageChange)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.observers = append(s.observers, fn)
+}
+
+// remove удаляет событие по индексу, вызывается под блокировкой
+func (s *MemoryStorage) remove(userID, index int) (Event, error) {
+	deleted := s.events[userID][index]
+
+	if s.journal != nil {
+		if err := s.journal(journalRecord{Op: opDelete, Event: deleted}); err != nil {
+			return Event{}, &storageFailure{err: err}
+		}
+	}
+
+	s.detach(userID, index)
+	s.notify(storageChange{Op: opDelete, Event: deleted, Prev: &deleted})
+
+	return deleted, nil
+}
+
+// detach убирает событие из календаря и индексов без журнала и наблюдателей:
+// на его место встает последнее, вызывается под блокировкой
+func (s *MemoryStorage) detach(userID, index int) {
+	deleted := s.events[userID][index]
+
+	s.indexRemove(&deleted)
+	evLen := len(s.events[userID])
+	if index != evLen-1 {
+		moved := s.events[userID][evLen-1]
+		s.events[userID][index] = moved
+		s.index[userID].position[moved.EventID] = index
+	}
+	s.events[userID] = s.events[userID][:evLen-1]
+}
+
+// discard окончательно удаляет событие из корзины, вызывается под блокировкой
+func (s *MemoryStorage) discard(ev Event) error {
+	if s.journal != nil {
+		if err := s.journal(journalRecord{Op: opDelete, Event: ev}); err != nil {
+			return &storageFailure{err: err}
+		}
+	}
+
+	delete(s.trash[ev.UserID], ev.EventID)
+	if len(s.trash[ev.UserID]) == 0 {
+		delete(s.trash, ev.UserID)
+	}
+	return nil
+}
+
+// apply применяет запись журнала без повторного журналирования, используется при восстановлении
+func (s *MemoryStorage) apply(rec journalRecord) error {
+	switch rec.Op {
+	case opPut:
+		return s.put(rec.Event)
+	case opDelete:
+		// Повторное удаление не ошибка: запись могла попасть и в снапшот, и в журнал.
+		// Записи до появления корзины удаляют событие из календаря, новые - из корзины
+		if index := s.find(rec.Event.UserID, rec.Event.EventID); index != -1 {
+			_, err := s.remove(rec.Event.UserID, index)
+			return err
+		}
+		if _, ok := s.trash[rec.Event.UserID][rec.Event.EventID]; ok {
+			return s.discard(rec.Event)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown journal operation %q", rec.Op)
+	}
+}
+
+// all возвращает копию всех событий, вызывается под блокировкой
+func (s *MemoryStorage) all() []Event {
+	res := make([]Event, 0)
+	for _, events := range s.events {
+		res = append(res, events...)
+	}
+	return res
+}
+
+// allTrashed возвращает копию всех событий корзины, вызывается под блокировкой
+func (s *MemoryStorage) allTrashed() []Event {
+	res := make([]Event, 0)
+	for _, events := range s.trash {
+		for _, ev := range events {
+			res = append(res, ev)
+		}
+	}
+	return res
+}
+
+// Create создание события в календаре
+func (s *MemoryStorage) Create(ev *Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.create(ev)
+}
+
+// create создание события, вызывается под блокировкой. Событию без EventID хранилище выдает новый
+func (s *MemoryStorage) create(ev *Event) error {
+	if ev.EventID == 0 {
+		ev.EventID = s.nextID
+	}
+	// ID удаленного события занят, пока оно в корзине: иначе store вытеснил бы его без следа
+	_, trashed := s.trash[ev.UserID][ev.EventID]
+	if trashed || s.find(ev.UserID, ev.EventID) != -1 {
+		return &existsError{userID: ev.UserID, eventID: ev.EventID}
+	}
+	if ev.RecurrenceID != nil {
+		return fmt.Errorf("recurrence_id is not allowed on create")
+	}
+	ev.Attendees = keepResponses(ev.Attendees, nil)
+	// В корзину событие попадает только через Delete
+	ev.DeletedAt = nil
+	ev.Version = 1
+	ev.UpdatedBy, ev.UpdatedAt = ev.UserID, s.now()
+
+	return s.put(*ev)
+}
+
+// Update обновление информации о событии в календаре
+func (s *MemoryStorage) Update(ev *Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.update(ev)
+}
+
+// update обновление события, вызывается под блокировкой
+func (s *MemoryStorage) update(ev *Event) error {
+	if _, ok := s.events[ev.UserID]; !ok {
+		return &unknownUserError{userID: ev.UserID}
+	}
+
+	index := s.find(ev.UserID, ev.EventID)
+	if index == -1 {
+		return fmt.Errorf("can't find event with %v id for %v user id", ev.EventID, ev.UserID)
+	}
+
+	stored := s.events[ev.UserID][index]
+	if err := checkVersion(ev.Version, &stored); err != nil {
+		return err
+	}
+	ev.Version = stored.Version + 1
+	ev.UpdatedBy, ev.UpdatedAt = ev.UserID, s.now()
+	ev.DeletedAt = nil
+	if ev.RecurrenceID != nil {
+		return s.updateOccurrence(stored, *ev)
+	}
+
+	ev.UID = stored.UID
+	// Изменения отдельных повторений и исключенные даты переживают правку всей серии
+	if ev.Overrides == nil {
+		ev.Overrides = stored.Overrides
+	}
+	if ev.Recurrence != nil && ev.Recurrence.ExDates == nil && stored.Recurrence != nil {
+		ev.Recurrence.ExDates = stored.Recurrence.ExDates
+	}
+	// Организатор не может ответить за приглашенных: ответы сохраняются
+	ev.Attendees = keepResponses(ev.Attendees, stored.Attendees)
+
+	return s.put(*ev)
+}
+
+// updateOccurrence заменяет одно повторение серии, вызывается под блокировкой
+func (s *MemoryStorage) updateOccurrence(series, ev Event) error {
+	if !series.hasOccurrence(*ev.RecurrenceID) {
+		return fmt.Errorf("event %v has no occurrence at %v", ev.EventID, ev.RecurrenceID.Format(time.RFC3339))
+	}
+
+	ev.Recurrence, ev.Overrides, ev.Attendees = nil, nil, nil
+	if ev.Reminders == nil {
+		ev.Reminders = series.Reminders
+	}
+	series.Version, series.UpdatedBy, series.UpdatedAt = ev.Version, ev.UpdatedBy, ev.UpdatedAt
+	series.Overrides = withoutOverride(series.Overrides, *ev.RecurrenceID)
+	series.Overrides = append(series.Overrides, ev)
+
+	return s.put(series)
+}
+
+// deleteOccurrence исключает одно повторение серии, вызывается под блокировкой
+func (s *MemoryStorage) deleteOccurrence(series Event, recurrenceID time.Time) (Event, error) {
+	if !series.hasOccurrence(recurrenceID) {
+		return Event{}, fmt.Errorf("event %v has no occurrence at %v", series.EventID, recurrenceID.Format(time.RFC3339))
+	}
+
+	deleted := series.occurrence(recurrenceID)
+
+	rule := *series.Recurrence
+	rule.ExDates = append(append([]time.Time(nil), rule.ExDates...), recurrenceID)
+	series.Recurrence = &rule
+	series.Overrides = withoutOverride(series.Overrides, recurrenceID)
+	series.Version++
+	series.UpdatedBy, series.UpdatedAt = series.UserID, s.now()
+
+	return deleted, s.put(series)
+}
+
+// withoutOverride возвращает новый срез изменений без повторения recurrenceID,
+// исходный срез не трогаем: он принадлежит хранилищу до записи в журнал
+func withoutOverride(overrides []Event, recurrenceID time.Time) []Event {
+	res := make([]Event, 0, len(overrides)+1)
+	for _, o := range overrides {
+		if !o.RecurrenceID.Equal(recurrenceID) {
+			res = append(res, o)
+		}
+	}
+	return res
+}
+
+// Delete удаление события из календаря. Событие целиком переносится в корзину,
+// откуда его можно восстановить до окончательного удаления (см. purge).
+// Удаленное повторение серии становится исключенной датой и в корзину не попадает
+func (s *MemoryStorage) Delete(ev *Event) (*Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.events[ev.UserID]; !ok {
+		return nil, &unknownUserError{userID: ev.UserID}
+	}
+
+	index := s.find(ev.UserID, ev.EventID)
+	if index == -1 {
+		return nil, fmt.Errorf("can't find event with %v id for %v user id", ev.EventID, ev.UserID)
+	}
+
+	if err := checkVersion(ev.Version, &s.events[ev.UserID][index]); err != nil {
+		return nil, err
+	}
+
+	var deleted Event
+	var err error
+	if ev.RecurrenceID != nil {
+		deleted, err = s.deleteOccurrence(s.events[ev.UserID][index], *ev.RecurrenceID)
+	} else {
+		deleted, err = s.moveToTrash(s.events[ev.UserID][index])
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &deleted, nil
+}
+
+// moveToTrash переносит событие в корзину, вызывается под блокировкой
+func (s *MemoryStorage) moveToTrash(ev Event) (Event, error) {
+	now := s.now()
+	ev.DeletedAt = &now
+	ev.Version++
+	ev.UpdatedBy, ev.UpdatedAt = ev.UserID, now
+
+	return ev, s.put(ev)
+}
+
+func (s *MemoryStorage) trashed(userID int) ([]Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	res := make([]Event, 0, len(s.trash[userID]))
+	for _, ev := range s.trash[userID] {
+		res = append(res, ev)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if !res[i].DeletedAt.Equal(*res[j].DeletedAt) {
+			return res[i].DeletedAt.After(*res[j].DeletedAt)
+		}
+		return res[i].EventID < res[j].EventID
+	})
+
+	return res, nil
+}
+
+func (s *MemoryStorage) restore(userID, eventID int) (*Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ev, ok := s.trash[userID][eventID]
+	if !ok {
+		return nil, fmt.Errorf("can't find event with %v id for %v user id in trash", eventID, userID)
+	}
+
+	ev.DeletedAt = nil
+	ev.Version++
+	ev.UpdatedBy, ev.UpdatedAt = userID, s.now()
+	if err := s.store(ev, opRestore); err != nil {
+		return nil, err
+	}
+
+	return &ev, nil
+}
+
+func (s *MemoryStorage) purge(before time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	purged := 0
+	for _, ev := range s.allTrashed() {
+		if !ev.DeletedAt.Before(before) {
+			continue
+		}
+		if err := s.discard(ev); err != nil {
+			return purged, err
+		}
+		purged++
+	}
+
+	return purged, nil
+}
+
+// between возвращает экземпляры событий пользователя, пересекающиеся с [from, to),
+// серии разворачиваются в отдельные повторения
+func (s *MemoryStorage) between(userID int, from, to time.Time) ([]Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.index[userID]; !ok {
+		return nil, &unknownUserError{userID: userID}
+	}
+
+	var res []Event
+	for _, ev := range s.candidates(userID, from, to) {
+		res = append(res, ev.expand(from, to)...)
+	}
+	sortEvents(res)
+
+	return res, nil
+}
+
+// sortEvents упорядочивает экземпляры по началу, при равном начале - по EventID и организатору
+func sortEvents(events []Event) {
+	sort.SliceStable(events, func(i, j int) bool { return eventLess(&events[i], &events[j]) })
+}
+
+// eventLess порядок выдачи экземпляров: по началу, затем по EventID и UserID
+func eventLess(a, b *Event) bool {
+	if !a.start().Equal(b.start()) {
+		return a.start().Before(b.start())
+	}
+	if a.EventID != b.EventID {
+		return a.EventID < b.EventID
+	}
+	return a.UserID < b.UserID
+}
+
+func (s *MemoryStorage) getEvents(userID int) ([]Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	events, ok := s.events[userID]
+	if !ok {
+		return nil, &unknownUserError{userID: userID}
+	}
+
+	res := append([]Event(nil), events...)
+	sort.SliceStable(res, func(i, j int) bool { return res[i].start().Before(res[j].start()) })
+
+	return res, nil
+}
+
+func (s *MemoryStorage) getEvent(userID, eventID int) (*Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.events[userID]; !ok {
+		return nil, &unknownUserError{userID: userID}
+	}
+
+	index := s.find(userID, eventID)
+	if index == -1 {
+		return nil, fmt.Errorf("can't find event with %v id for %v user id", eventID, userID)
+	}
+
+	ev := s.events[userID][index]
+	return &ev, nil
+}
+
+func (s *MemoryStorage) getAllEvents() ([]Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.all(), nil
+}
+
+// dayWindow, weekWindow и monthWindow возвращают границы [from, to) суток, ISO-недели и месяца даты
+func dayWindow(date time.Time) (time.Time, time.Time) {
+	from := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, date.Location())
+	return from, from.AddDate(0, 0, 1)
+}
+
+func weekWindow(date time.Time) (time.Time, time.Time) {
+	day, _ := dayWindow(date)
+	from := day.AddDate(0, 0, -((int(date.Weekday()) + 6) % 7))
+	return from, from.AddDate(0, 0, 7)
+}
+
+func monthWindow(date time.Time) (time.Time, time.Time) {
+	from := time.Date(date.Year(), date.Month(), 1, 0, 0, 0, 0, date.Location())
+	return from, from.AddDate(0, 1, 0)
+}
+
+func (s *MemoryStorage) getEventsForDay(userID int, date time.Time) ([]Event, error) {
+	from, to := dayWindow(date)
+	return s.between(userID, from, to)
+}
+
+func (s *MemoryStorage) getEventsForWeek(userID int, date time.Time) ([]Event, error) {
+	from, to := weekWindow(date)
+	return s.between(userID, from, to)
+}
+
+func (s *MemoryStorage) getEventsForMonth(userID int, date time.Time) ([]Event, error) {
+	from, to := monthWindow(date)
+	return s.between(userID, from, to)
+}
+
+// Close хранилищу в памяти сбрасывать нечего
+func (s *MemoryStorage) Close() error {
+	return nil
+}
+
+// newStorage выбирает реализацию хранилища по конфигурации
+func newStorage(cfg StorageConfig) (Storage, error) {
+	switch cfg.Backend {
+	case "", "memory":
+		return newMemoryStorage(), nil
+	case "file":
+		return openFileStorage(cfg.Dir, cfg.SnapshotEvery, time.Duration(cfg.SnapshotInterval))
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", cfg.Backend)
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testEvent(userID, eventID int, date string) Event {
+	d, _ := time.Parse(dateFormat, date)
+	return Event{UserID: userID, EventID: eventID, Title: "event", Date: d}
+}
+
+func TestFileStorageRecovery(t *testing.T) {
+	dir := t.TempDir()
+
+	fs, err := openFileStorage(dir, 1000, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 3; i++ {
+		ev := testEvent(1, i, "2019-09-09")
+		if err := fs.Create(&ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+	upd := testEvent(1, 2, "2019-09-10")
+	if err := fs.Update(&upd); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Delete(&Event{UserID: 1, EventID: 3}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Имитируем падение: журнал не свернут, файл не закрыт корректно
+	fs.journal = nil
+	fs.wal.Close()
+	close(fs.done)
+	fs.wg.Wait()
+
+	restored, err := openFileStorage(dir, 1000, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restored.Close()
+
+	day, err := restored.getEventsForDay(1, upd.Date)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(day) != 1 || day[0].EventID != 2 {
+		t.Errorf("expected updated event 2 on %v, got %+v", upd.Date, day)
+	}
+	month, _ := restored.getEventsForMonth(1, upd.Date)
+	if len(month) != 2 {
+		t.Errorf("expected 2 events after recovery, got %+v", month)
+	}
+}
+
+func TestFileStorageTornRecord(t *testing.T) {
+	dir := t.TempDir()
+
+	fs, err := openFileStorage(dir, 1000, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ev := testEvent(1, 1, "2019-09-09")
+	if err := fs.Create(&ev); err != nil {
+		t.Fatal(err)
+	}
+	fs.journal = nil
+	fs.wal.Close()
+	close(fs.done)
+	fs.wg.Wait()
+
+	// Запись оборвалась на середине
+	f, err := os.OpenFile(filepath.Join(dir, walFileName), os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"op":"put","event":{"user_id":1,"event_id":2`)
+	f.Close()
+
+	restored, err := openFileStorage(dir, 1000, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restored.Close()
+
+	events, _ := restored.getEventsForDay(1, ev.Date)
+	if len(events) != 1 || events[0].EventID != 1 {
+		t.Errorf("expected only event 1, got %+v", events)
+	}
+}
+
+func TestFileStorageSnapshotOnClose(t *testing.T) {
+	dir := t.TempDir()
+
+	fs, err := openFileStorage(dir, 1, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ev := testEvent(2, 1, "2019-09-09")
+	if err := fs.Create(&ev); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if info, err := os.Stat(filepath.Join(dir, walFileName)); err != nil || info.Size() != 0 {
+		t.Errorf("journal should be empty after close: %v %v", info, err)
+	}
+
+	restored, err := openFileStorage(dir, 1, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restored.Close()
+	if events, _ := restored.getEventsForDay(2, ev.Date); len(events) != 1 {
+		t.Errorf("expected event from snapshot, got %+v", events)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultTrashRetention сколько удаленное событие хранится в корзине по умолчанию
+	defaultTrashRetention = 30 * 24 * time.Hour
+	// maxPurgeInterval наибольший период проверки корзины
+	maxPurgeInterval = time.Hour
+)
+
+// TrashPurger в фоне окончательно удаляет события, пролежавшие в корзине дольше retention
+type TrashPurger struct {
+	storage   Storage
+	clock     clock
+	retention time.Duration
+	interval  time.Duration
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// Конструктор очистки корзины. Корзина проверяется раз в час,
+// при меньшем сроке хранения - с периодом этого срока
+func newTrashPurger(st Storage, c clock, retention time.Duration) *TrashPurger {
+	interval := retention
+	if interval > maxPurgeInterval {
+		interval = maxPurgeInterval
+	}
+	return &TrashPurger{storage: st, clock: c, retention: retention, interval: interval, done: make(chan struct{})}
+}
+
+// Start запускает цикл очистки в отдельной горутине
+func (p *TrashPurger) Start() {
+	p.wg.Add(1)
+	go p.loop()
+}
+
+// Stop останавливает цикл и дожидается его завершения
+func (p *TrashPurger) Stop() {
+	close(p.done)
+	p.wg.Wait()
+}
+
+func (p *TrashPurger) loop() {
+	defer p.wg.Done()
+
+	for {
+		p.purge()
+
+		select {
+		case <-p.clock.After(p.interval):
+		case <-p.done:
+			return
+		}
+	}
+}
+
+// purge удаляет из корзины события старше срока хранения. Ошибка хранилища
+// только логируется: оставшиеся события удалятся на следующем проходе
+func (p *TrashPurger) purge() {
+	n, err := p.storage.purge(p.clock.Now().Add(-p.retention))
+	if err != nil {
+		logger.log(levelError, "trash: can't purge", field{"error", err.Error()})
+	}
+	if n > 0 {
+		logger.log(levelInfo, "trash: purged", field{"events", n})
+	}
+}
+
+// trashV2 удаленные события пользователя, сначала недавно удаленные
+func trashV2(w http.ResponseWriter, r *http.Request, userID int) {
+	events, err := storage.trashed(userID)
+	if err != nil {
+		getErrResponse(w, err.Error(), storageErrStatus(r, err))
+		return
+	}
+
+	getResponse(w, "Запрос успешно выполнен!", events, http.StatusOK)
+}
+
+// restoreV2 возвращает событие из корзины с прежним EventID и новой версией
+func restoreV2(w http.ResponseWriter, r *http.Request, userID, eventID int) {
+	ev, err := storage.restore(userID, eventID)
+	if err != nil {
+		getErrResponse(w, err.Error(), storageErrStatus(r, err))
+		return
+	}
+
+	getOverlapResponse(w, "Событие восстановлено!", *ev, nil, http.StatusOK)
+}
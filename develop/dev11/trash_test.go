@@ -0,0 +1,250 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestTrashRestoreAndPurge(t *testing.T) {
+	st := newMemoryStorage()
+	now := mustTime(t, "2019-09-09T12:00:00Z")
+	st.now = func() time.Time { return now }
+
+	for i := 1; i <= 2; i++ {
+		ev := testEvent(1, i, "2019-09-09")
+		if err := st.Create(&ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var changes []storageChange
+	st.subscribe(func(change storageChange) { changes = append(changes, change) })
+
+	deleted, err := st.Delete(&Event{UserID: 1, EventID: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted.DeletedAt == nil || !deleted.DeletedAt.Equal(now) || deleted.Version != 2 {
+		t.Fatalf("unexpected deleted event %+v", deleted)
+	}
+	if day, _ := st.getEventsForDay(1, deleted.Date); len(day) != 1 || day[0].EventID != 2 {
+		t.Errorf("trashed event is still in the calendar: %+v", day)
+	}
+	if _, err := st.getEvent(1, 1); err == nil {
+		t.Error("trashed event must not be found")
+	}
+	trash, _ := st.trashed(1)
+	if len(trash) != 1 || trash[0].EventID != 1 {
+		t.Fatalf("unexpected trash %+v", trash)
+	}
+
+	// Восстановленное событие возвращается с прежним ID и новой версией
+	restored, err := st.restore(1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.DeletedAt != nil || restored.Version != 3 {
+		t.Errorf("unexpected restored event %+v", restored)
+	}
+	if day, _ := st.getEventsForDay(1, restored.Date); len(day) != 2 {
+		t.Errorf("restored event is not in the calendar: %+v", day)
+	}
+	if trash, _ := st.trashed(1); len(trash) != 0 {
+		t.Errorf("trash must be empty after restore: %+v", trash)
+	}
+	if _, err := st.restore(1, 1); err == nil {
+		t.Error("second restore must fail")
+	}
+
+	if len(changes) != 2 || changes[0].Op != opDelete || changes[1].Op != opRestore || changes[1].Prev != nil {
+		t.Errorf("observers got %+v", changes)
+	}
+
+	// Удаленные раньше before удаляются окончательно, остальные ждут
+	st.Delete(&Event{UserID: 1, EventID: 1})
+	now = now.Add(time.Hour)
+	st.Delete(&Event{UserID: 1, EventID: 2})
+	if trash, _ := st.trashed(1); len(trash) != 2 || trash[0].EventID != 2 {
+		t.Fatalf("trash must start with recently deleted: %+v", trash)
+	}
+	n, err := st.purge(now)
+	if err != nil || n != 1 {
+		t.Fatalf("purged %v %v, want 1", n, err)
+	}
+	if trash, _ := st.trashed(1); len(trash) != 1 || trash[0].EventID != 2 {
+		t.Errorf("unexpected trash after purge: %+v", trash)
+	}
+	if _, err := st.restore(1, 1); err == nil {
+		t.Error("purged event must not be restored")
+	}
+	if len(changes) != 4 {
+		t.Errorf("purge must not notify observers, got %+v", changes[4:])
+	}
+
+	// ID окончательно удаленного события можно занять снова, ID из корзины - нет
+	ev := testEvent(1, 1, "2019-09-10")
+	if err := st.Create(&ev); err != nil {
+		t.Fatal(err)
+	}
+	ev = testEvent(1, 2, "2019-09-10")
+	if err := st.Create(&ev); err == nil {
+		t.Error("create must not replace the trashed event")
+	}
+	if trash, _ := st.trashed(1); len(trash) != 1 || trash[0].EventID != 2 {
+		t.Errorf("trashed event is lost: %+v", trash)
+	}
+}
+
+// TestCreateKeepsTrashed создание с ID события из корзины не уничтожает удаленное
+func TestCreateKeepsTrashed(t *testing.T) {
+	st := newMemoryStorage()
+	ev := testEvent(1, 1, "2019-09-09")
+	if err := st.Create(&ev); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.Delete(&Event{UserID: 1, EventID: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	again := testEvent(1, 1, "2019-09-10")
+	var exists *existsError
+	if err := st.Create(&again); !errors.As(err, &exists) {
+		t.Fatalf("create with trashed id: got %v, want existsError", err)
+	}
+
+	restored, err := st.restore(1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !restored.Date.Equal(ev.Date) {
+		t.Errorf("restored the wrong event %+v", restored)
+	}
+}
+
+func TestFileStorageKeepsTrash(t *testing.T) {
+	dir := t.TempDir()
+
+	fs, err := openFileStorage(dir, 1000, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 2; i++ {
+		ev := testEvent(1, i, "2019-09-09")
+		if err := fs.Create(&ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fs.Delete(&Event{UserID: 1, EventID: 1})
+	fs.Delete(&Event{UserID: 1, EventID: 2})
+	if err := fs.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Корзина переживает снапшот, окончательное удаление - журнал
+	fs, err = openFileStorage(dir, 1000, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if trash, _ := fs.trashed(1); len(trash) != 2 {
+		t.Fatalf("trash is lost after restart: %+v", trash)
+	}
+	if _, err := fs.restore(1, 2); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := fs.purge(time.Now().Add(time.Hour)); err != nil || n != 1 {
+		t.Fatalf("purged %v %v, want 1", n, err)
+	}
+	fs.journal = nil
+	fs.wal.Close()
+	close(fs.done)
+	fs.wg.Wait()
+
+	restored, err := openFileStorage(dir, 1000, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restored.Close()
+
+	if trash, _ := restored.trashed(1); len(trash) != 0 {
+		t.Errorf("purged event is back: %+v", trash)
+	}
+	if ev, err := restored.getEvent(1, 2); err != nil || ev.DeletedAt != nil {
+		t.Errorf("restored event is lost: %+v %v", ev, err)
+	}
+}
+
+func TestTrashPurger(t *testing.T) {
+	st := newMemoryStorage()
+	clock := newFakeClock(mustTime(t, "2019-09-09T12:00:00Z"))
+	st.now = clock.Now
+
+	ev := testEvent(1, 1, "2019-09-09")
+	st.Create(&ev)
+	st.Delete(&Event{UserID: 1, EventID: 1})
+
+	p := newTrashPurger(st, clock, 24*time.Hour)
+	if p.interval != time.Hour {
+		t.Fatalf("interval %v, want at most an hour", p.interval)
+	}
+	p.purge()
+	if trash, _ := st.trashed(1); len(trash) != 1 {
+		t.Fatalf("event purged before retention: %+v", trash)
+	}
+
+	clock.Advance(24*time.Hour + time.Second)
+	p.purge()
+	if trash, _ := st.trashed(1); len(trash) != 0 {
+		t.Errorf("event is not purged after retention: %+v", trash)
+	}
+}
+
+func TestTrashAPI(t *testing.T) {
+	srv, _ := newTestServer(t)
+	alice := issueToken(t, srv, "1")
+	events := srv.URL + "/users/1/events"
+
+	status, res := doRequest(t, http.MethodPost, events, alice, `{"event_id": 1, "title": "standup", "date": "2019-09-09T10:00:00Z"}`, nil)
+	if status != http.StatusCreated {
+		t.Fatalf("create failed: %v %v", status, res)
+	}
+	if status, res := doRequest(t, http.MethodDelete, events+"/1", alice, "", nil); status != http.StatusOK {
+		t.Fatalf("delete failed: %v %v", status, res)
+	}
+
+	status, res = doRequest(t, http.MethodGet, srv.URL+"/users/1/trash", alice, "", nil)
+	if status != http.StatusOK {
+		t.Fatalf("trash failed: %v %v", status, res)
+	}
+	trash := res["events"].([]interface{})
+	if len(trash) != 1 || trash[0].(map[string]interface{})["deleted_at"] == nil {
+		t.Fatalf("unexpected trash %v", res)
+	}
+	if status, _ := doRequest(t, http.MethodGet, srv.URL+"/users/2/trash", alice, "", nil); status != http.StatusForbidden {
+		t.Errorf("foreign trash: %v, want 403", status)
+	}
+	if status, _ := doRequest(t, http.MethodGet, srv.URL+"/users/1/trash/1/restore", alice, "", nil); status != http.StatusMethodNotAllowed {
+		t.Errorf("restore by GET: %v, want 405", status)
+	}
+
+	status, res = doRequest(t, http.MethodPost, srv.URL+"/users/1/trash/1/restore", alice, "", nil)
+	if status != http.StatusOK {
+		t.Fatalf("restore failed: %v %v", status, res)
+	}
+	if status, _ := doRequest(t, http.MethodGet, events+"/1", alice, "", nil); status != http.StatusOK {
+		t.Errorf("restored event: %v, want 200", status)
+	}
+	if status, _ := doRequest(t, http.MethodPost, srv.URL+"/users/1/trash/1/restore", alice, "", nil); status != http.StatusServiceUnavailable {
+		t.Errorf("second restore: %v, want 503", status)
+	}
+
+	// В истории события видны удаление и восстановление
+	_, res = doRequest(t, http.MethodGet, events+"/1/history", alice, "", nil)
+	var ops []string
+	for _, e := range res["history"].([]interface{}) {
+		ops = append(ops, e.(map[string]interface{})["op"].(string))
+	}
+	if len(ops) != 3 || ops[1] != auditDelete || ops[2] != auditRestore {
+		t.Errorf("unexpected history %v", ops)
+	}
+}
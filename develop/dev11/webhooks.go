@@ -0,0 +1,702 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Подписки на изменения событий: пользователь регистрирует URL и типы изменений,
+// сервер асинхронно отправляет туда подписанный JSON и повторяет неудачные доставки
+// с экспоненциальной задержкой. Доставки разбирает фиксированное число горутин из
+// ограниченной очереди, при переполнении новые доставки отбрасываются с пометкой в журнале.
+// Подписки хранятся в файле рядом с хранилищем, журнал доставок и ожидающие повторы - только в памяти
+
+const (
+	webhooksFileName = "webhooks.json"
+
+	webhookCreated = "event.created"
+	webhookUpdated = "event.updated"
+	webhookDeleted = "event.deleted"
+
+	webhookSignatureHeader = "X-Webhook-Signature"
+	webhookTimestampHeader = "X-Webhook-Timestamp"
+	webhookEventHeader     = "X-Webhook-Event"
+	webhookDeliveryHeader  = "X-Webhook-Delivery"
+
+	maxWebhooksPerUser    = 20
+	maxDeliveryLog        = 100
+	defaultWebhookTimeout = 10 * time.Second
+	defaultWebhookWorkers = 4
+	// defaultWebhookQueue сколько доставок может ждать попытки или повтора одновременно
+	defaultWebhookQueue = 1000
+)
+
+// Состояния доставки
+const (
+	deliveryPending   = "pending"
+	deliverySucceeded = "succeeded"
+	deliveryFailed    = "failed"
+	deliveryCanceled  = "canceled"
+	// deliveryDropped очередь была полна, доставка не выполнялась
+	deliveryDropped = "dropped"
+)
+
+var webhookTypes = []string{webhookCreated, webhookUpdated, webhookDeleted}
+
+// Subscription подписка пользователя. Secret показывается только при создании
+type Subscription struct {
+	ID        int       `json:"id"`
+	UserID    int       `json:"user_id"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (s *Subscription) wants(typ string) bool {
+	for _, e := range s.Events {
+		if e == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// public подписка без секрета для ответов API
+func (s *Subscription) public() Subscription {
+	res := *s
+	res.Secret = ""
+	res.Events = append([]string(nil), s.Events...)
+	return res
+}
+
+// webhookPayload тело запроса к подписчику
+type webhookPayload struct {
+	ID         string    `json:"id"`
+	Type       string    `json:"type"`
+	OccurredAt time.Time `json:"occurred_at"`
+	UserID     int       `json:"user_id"`
+	Actor      int       `json:"actor,omitempty"`
+	Event      Event     `json:"event"`
+	Previous   *Event    `json:"previous,omitempty"`
+}
+
+// webhookAttempt одна попытка доставки
+type webhookAttempt struct {
+	At         time.Time `json:"at"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	DurationMS float64   `json:"duration_ms"`
+}
+
+// webhookDelivery запись журнала доставок
+type webhookDelivery struct {
+	ID             string           `json:"id"`
+	SubscriptionID int              `json:"subscription_id"`
+	Type           string           `json:"type"`
+	EventID        int              `json:"event_id"`
+	CreatedAt      time.Time        `json:"created_at"`
+	Status         string           `json:"status"`
+	Attempts       []webhookAttempt `json:"attempts"`
+	NextAttemptAt  *time.Time       `json:"next_attempt_at,omitempty"`
+
+	payload []byte
+}
+
+// WebhookDispatcher хранит подписки и доставляет им изменения хранилища
+type WebhookDispatcher struct {
+	client      *http.Client
+	now         func() time.Time
+	maxAttempts int
+	// backoff задержка перед попыткой attempt+1 после неудачной попытки attempt
+	backoff func(attempt int) time.Duration
+	path    string
+
+	mu         sync.Mutex
+	subs       map[int]*Subscription
+	nextID     int
+	deliveries map[int][]*webhookDelivery
+	// pending незавершенные доставки: в очереди, в работе и ждущие повтора.
+	// Их не больше cap(queue), поэтому запись в очередь не блокируется
+	pending int
+	// retries таймеры ждущих повтора доставок, по срабатыванию доставка снова встает в очередь
+	retries map[*webhookDelivery]*time.Timer
+
+	// queue доставки, готовые к попытке, их разбирают рабочие горутины
+	queue  chan *webhookDelivery
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// exponentialBackoff base, 2*base, 4*base... но не больше limit
+func exponentialBackoff(base, limit time.Duration) func(int) time.Duration {
+	return func(attempt int) time.Duration {
+		d := base
+		for i := 1; i < attempt && d < limit; i++ {
+			d *= 2
+		}
+		if d > limit {
+			d = limit
+		}
+		return d
+	}
+}
+
+// newWebhookDispatcher диспетчер без подписок с workers рабочими горутинами
+// и очередью на queueSize доставок
+func newWebhookDispatcher(workers, queueSize int) *WebhookDispatcher {
+	ctx, cancel := context.WithCancel(context.Background())
+	wd := &WebhookDispatcher{
+		client:      newWebhookClient(),
+		now:         time.Now,
+		maxAttempts: 8,
+		backoff:     exponentialBackoff(time.Second, time.Hour),
+		subs:        make(map[int]*Subscription),
+		deliveries:  make(map[int][]*webhookDelivery),
+		retries:     make(map[*webhookDelivery]*time.Timer),
+		queue:       make(chan *webhookDelivery, queueSize),
+		ctx:         ctx,
+		cancel:      cancel,
+	}
+	for i := 0; i < workers; i++ {
+		wd.wg.Add(1)
+		go wd.work()
+	}
+	return wd
+}
+
+// openWebhooks загружает подписки из path, пустой path - подписки только в памяти
+func openWebhooks(path string) (*WebhookDispatcher, error) {
+	wd := newWebhookDispatcher(defaultWebhookWorkers, defaultWebhookQueue)
+	wd.path = path
+	if path == "" {
+		return wd, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return wd, nil
+	}
+	if err != nil {
+		wd.Close()
+		return nil, fmt.Errorf("can't read webhooks: %w", err)
+	}
+	var list []*Subscription
+	if err := json.Unmarshal(data, &list); err != nil {
+		wd.Close()
+		return nil, fmt.Errorf("can't read webhooks: %w", err)
+	}
+	for _, s := range list {
+		wd.subs[s.ID] = s
+		if s.ID > wd.nextID {
+			wd.nextID = s.ID
+		}
+	}
+	return wd, nil
+}
+
+// newWebhookClient HTTP-клиент доставок. Адрес проверяется при подключении, после разрешения
+// имени: запрос не уйдет во внутреннюю сеть ни по имени, которое указывает на нее, ни по редиректу.
+// Прокси из окружения не используется, иначе проверялся бы адрес прокси
+func newWebhookClient() *http.Client {
+	dialer := &net.Dialer{Timeout: defaultWebhookTimeout, Control: checkWebhookAddr}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{Timeout: defaultWebhookTimeout, Transport: transport}
+}
+
+// checkWebhookAddr запрещает подключение к внутренним адресам, address - уже IP и порт
+func checkWebhookAddr(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || internalIP(ip) {
+		return fmt.Errorf("webhook address %s is not allowed", host)
+	}
+	return nil
+}
+
+// internalIP адреса, куда подписчик не должен заставлять сервер отправлять запросы:
+// loopback, частные сети, link-local (в том числе 169.254.169.254 облачных метаданных) и служебные
+func internalIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified()
+}
+
+// save атомарно перезаписывает файл подписок. Вызывается под wd.mu
+func (wd *WebhookDispatcher) save() error {
+	if wd.path == "" {
+		return nil
+	}
+
+	list := make([]*Subscription, 0, len(wd.subs))
+	for _, s := range wd.subs {
+		list = append(list, s)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	data, err := json.Marshal(list)
+	if err != nil {
+		return err
+	}
+	tmp := wd.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("can't write webhooks: %w", err)
+	}
+	return os.Rename(tmp, wd.path)
+}
+
+// validateWebhook проверяет URL и типы, пустой список типов означает все
+func validateWebhook(rawURL string, events []string) ([]string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("url must be an absolute http or https URL")
+	}
+	if len(events) == 0 {
+		return append([]string(nil), webhookTypes...), nil
+	}
+
+	seen := make(map[string]bool)
+	res := make([]string, 0, len(events))
+	for _, e := range events {
+		known := false
+		for _, t := range webhookTypes {
+			known = known || t == e
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown event type %q", e)
+		}
+		if !seen[e] {
+			seen[e] = true
+			res = append(res, e)
+		}
+	}
+	return res, nil
+}
+
+func (wd *WebhookDispatcher) create(userID int, rawURL string, events []string) (*Subscription, error) {
+	events, err := validateWebhook(rawURL, events)
+	if err != nil {
+		return nil, err
+	}
+
+	wd.mu.Lock()
+	defer wd.mu.Unlock()
+
+	count := 0
+	for _, s := range wd.subs {
+		if s.UserID == userID {
+			count++
+		}
+	}
+	if count >= maxWebhooksPerUser {
+		return nil, fmt.Errorf("too many webhooks, max %d", maxWebhooksPerUser)
+	}
+
+	secret, err := randomHex(32)
+	if err != nil {
+		return nil, &storageFailure{err: err}
+	}
+	wd.nextID++
+	s := &Subscription{ID: wd.nextID, UserID: userID, URL: rawURL, Events: events,
+		Secret: secret, CreatedAt: wd.now()}
+	wd.subs[s.ID] = s
+	if err := wd.save(); err != nil {
+		delete(wd.subs, s.ID)
+		return nil, &storageFailure{err: err}
+	}
+
+	res := *s
+	return &res, nil
+}
+
+// get подписка пользователя без секрета
+func (wd *WebhookDispatcher) get(userID, id int) (*Subscription, error) {
+	wd.mu.Lock()
+	defer wd.mu.Unlock()
+
+	s, ok := wd.subs[id]
+	if !ok || s.UserID != userID {
+		return nil, fmt.Errorf("can't find webhook with %v id for %v user id", id, userID)
+	}
+	res := s.public()
+	return &res, nil
+}
+
+func (wd *WebhookDispatcher) list(userID int) []Subscription {
+	wd.mu.Lock()
+	defer wd.mu.Unlock()
+
+	res := make([]Subscription, 0)
+	for _, s := range wd.subs {
+		if s.UserID == userID {
+			res = append(res, s.public())
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].ID < res[j].ID })
+	return res
+}
+
+// remove удаляет подписку, ожидающие повторы ее доставок отменяются
+func (wd *WebhookDispatcher) remove(userID, id int) error {
+	wd.mu.Lock()
+	defer wd.mu.Unlock()
+
+	s, ok := wd.subs[id]
+	if !ok || s.UserID != userID {
+		return fmt.Errorf("can't find webhook with %v id for %v user id", id, userID)
+	}
+	delete(wd.subs, id)
+	if err := wd.save(); err != nil {
+		wd.subs[id] = s
+		return &storageFailure{err: err}
+	}
+	return nil
+}
+
+// log журнал доставок подписки, новые в начале
+func (wd *WebhookDispatcher) log(userID, id int) ([]webhookDelivery, error) {
+	wd.mu.Lock()
+	defer wd.mu.Unlock()
+
+	s, ok := wd.subs[id]
+	if !ok || s.UserID != userID {
+		return nil, fmt.Errorf("can't find webhook with %v id for %v user id", id, userID)
+	}
+
+	list := wd.deliveries[id]
+	res := make([]webhookDelivery, 0, len(list))
+	for i := len(list) - 1; i >= 0; i-- {
+		d := *list[i]
+		d.Attempts = append([]webhookAttempt(nil), d.Attempts...)
+		if d.NextAttemptAt != nil {
+			next := *d.NextAttemptAt
+			d.NextAttemptAt = &next
+		}
+		res = append(res, d)
+	}
+	return res, nil
+}
+
+// record наблюдатель хранилища. Вызывается под блокировкой хранилища, поэтому
+// только ставит доставки в очередь: запросы к подписчикам идут в рабочих горутинах.
+// Если очередь полна, доставка сразу попадает в журнал как dropped
+func (wd *WebhookDispatcher) record(change storageChange) {
+	ev := change.Event
+	typ, prev := webhookUpdated, change.Prev
+	switch {
+	case change.Op == opDelete:
+		typ, prev = webhookDeleted, nil
+	case change.Prev == nil:
+		// Восстановленное из корзины событие для подписчиков снова создано
+		typ = webhookCreated
+	}
+
+	// Изменение видят организатор и приглашенные. Исключенным из списка новое состояние
+	// события не показывается: им приходит event.deleted с прежней версией, которую они видели
+	users := map[int]bool{ev.UserID: true}
+	for _, a := range ev.Attendees {
+		users[a.UserID] = true
+	}
+	removed := make(map[int]bool)
+	if change.Op != opDelete && change.Prev != nil {
+		for _, a := range change.Prev.Attendees {
+			if !users[a.UserID] {
+				removed[a.UserID] = true
+			}
+		}
+	}
+
+	wd.mu.Lock()
+	defer wd.mu.Unlock()
+
+	if wd.ctx.Err() != nil {
+		return
+	}
+
+	now := wd.now()
+	for _, s := range wd.subs {
+		typ, event, prev := typ, ev, prev
+		switch {
+		case users[s.UserID]:
+		case removed[s.UserID]:
+			typ, event, prev = webhookDeleted, *change.Prev, nil
+		default:
+			continue
+		}
+		if !s.wants(typ) {
+			continue
+		}
+
+		id, err := randomHex(12)
+		if err != nil {
+			logger.log(levelError, "webhook: can't generate delivery id", field{"error", err.Error()})
+			continue
+		}
+		d := &webhookDelivery{ID: id, SubscriptionID: s.ID, Type: typ, EventID: ev.EventID,
+			CreatedAt: now, Status: deliveryPending, Attempts: []webhookAttempt{}}
+		payload := webhookPayload{ID: d.ID, Type: typ, OccurredAt: now, UserID: s.UserID,
+			Actor: ev.UpdatedBy, Event: event, Previous: prev}
+		if change.Op == opDelete {
+			payload.Actor = ev.UserID
+		}
+		data, err := json.Marshal(payload)
+		if err != nil {
+			logger.log(levelError, "webhook: can't encode payload", field{"error", err.Error()})
+			continue
+		}
+		d.payload = data
+
+		list := append(wd.deliveries[s.ID], d)
+		if len(list) > maxDeliveryLog {
+			list = list[len(list)-maxDeliveryLog:]
+		}
+		wd.deliveries[s.ID] = list
+
+		if wd.pending >= cap(wd.queue) {
+			d.Status = deliveryDropped
+			logger.log(levelWarn, "webhook: delivery queue is full, delivery dropped",
+				field{"subscription", s.ID}, field{"delivery", d.ID})
+			continue
+		}
+		wd.pending++
+		wd.queue <- d
+	}
+}
+
+// signWebhook подпись тела: HMAC-SHA256 от "timestamp.body" в hex
+func signWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifyWebhook проверка подписи на стороне получателя
+func verifyWebhook(secret, timestamp string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(signWebhook(secret, timestamp, body)), []byte(signature))
+}
+
+// work рабочая горутина: выполняет попытки доставок из очереди до остановки диспетчера
+func (wd *WebhookDispatcher) work() {
+	defer wd.wg.Done()
+	for {
+		select {
+		case d := <-wd.queue:
+			wd.deliver(d)
+		case <-wd.ctx.Done():
+			return
+		}
+	}
+}
+
+// deliver одна попытка доставки d. После неудачи повтор ставится на таймер,
+// доставка завершается после 2xx, исчерпанных попыток или удаления подписки
+func (wd *WebhookDispatcher) deliver(d *webhookDelivery) {
+	wd.mu.Lock()
+	s, ok := wd.subs[d.SubscriptionID]
+	var sub Subscription
+	if ok {
+		sub = *s
+	} else {
+		wd.finish(d, deliveryCanceled)
+	}
+	wd.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	result := wd.attempt(sub, d)
+
+	wd.mu.Lock()
+	defer wd.mu.Unlock()
+	d.Attempts = append(d.Attempts, result)
+	d.NextAttemptAt = nil
+	switch {
+	case result.Error == "":
+		wd.finish(d, deliverySucceeded)
+	case len(d.Attempts) >= wd.maxAttempts:
+		wd.finish(d, deliveryFailed)
+	case wd.ctx.Err() != nil:
+		// При остановке повтор не планируется, доставка остается pending
+	default:
+		wait := wd.backoff(len(d.Attempts))
+		next := wd.now().Add(wait)
+		d.NextAttemptAt = &next
+		wd.retries[d] = time.AfterFunc(wait, func() { wd.retry(d) })
+	}
+}
+
+// retry возвращает доставку в очередь, когда подошло время повтора
+func (wd *WebhookDispatcher) retry(d *webhookDelivery) {
+	wd.mu.Lock()
+	defer wd.mu.Unlock()
+
+	// Таймер, остановленный Close, мог уже сработать
+	if _, ok := wd.retries[d]; !ok {
+		return
+	}
+	delete(wd.retries, d)
+	select {
+	case wd.queue <- d:
+	default:
+		wd.finish(d, deliveryDropped)
+	}
+}
+
+// finish завершает доставку со статусом status. Вызывается под wd.mu
+func (wd *WebhookDispatcher) finish(d *webhookDelivery, status string) {
+	d.Status, d.NextAttemptAt = status, nil
+	wd.pending--
+}
+
+// attempt один POST к подписчику, ошибкой считается все, кроме 2xx
+func (wd *WebhookDispatcher) attempt(s Subscription, d *webhookDelivery) (res webhookAttempt) {
+	res.At = wd.now()
+	start := time.Now()
+	defer func() { res.DurationMS = float64(time.Since(start).Microseconds()) / 1000 }()
+
+	req, err := http.NewRequestWithContext(wd.ctx, http.MethodPost, s.URL, bytes.NewReader(d.payload))
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	timestamp := strconv.FormatInt(res.At.Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(webhookEventHeader, d.Type)
+	req.Header.Set(webhookDeliveryHeader, d.ID)
+	req.Header.Set(webhookTimestampHeader, timestamp)
+	req.Header.Set(webhookSignatureHeader, signWebhook(s.Secret, timestamp, d.payload))
+
+	resp, err := wd.client.Do(req)
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	resp.Body.Close()
+
+	res.StatusCode = resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		res.Error = fmt.Sprintf("receiver responded with %v", resp.Status)
+	}
+	return res
+}
+
+// Close прерывает ожидающие повторы и дожидается начатых попыток
+func (wd *WebhookDispatcher) Close() error {
+	wd.mu.Lock()
+	wd.cancel()
+	for d, timer := range wd.retries {
+		timer.Stop()
+		delete(wd.retries, d)
+	}
+	wd.mu.Unlock()
+
+	wd.wg.Wait()
+	return nil
+}
+
+// webhooks подписки на изменения, открываются в run: у диспетчера свои горутины,
+// запускать их при инициализации пакета незачем
+var webhooks *WebhookDispatcher
+
+// ===== Обработчики API v2 =====
+
+// listWebhooksV2 GET /users/{id}/webhooks
+func listWebhooksV2(w http.ResponseWriter, userID int) {
+	resp := struct {
+		Result   string         `json:"result"`
+		Webhooks []Subscription `json:"webhooks"`
+	}{Result: "Запрос успешно выполнен!", Webhooks: webhooks.list(userID)}
+
+	writeJSON(w, resp, http.StatusOK)
+}
+
+// createWebhookV2 POST /users/{id}/webhooks с полями url и events
+func createWebhookV2(w http.ResponseWriter, r *http.Request, userID int) {
+	var req struct {
+		URL    string   `json:"url"`
+		Events []string `json:"events"`
+	}
+	if err := decodeBody(r, &req); err != nil {
+		getErrResponse(w, fmt.Sprintf("invalid json: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	s, err := webhooks.create(userID, req.URL, req.Events)
+	var failure *storageFailure
+	switch {
+	case errors.As(err, &failure):
+		getErrResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	case err != nil:
+		getErrResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("%s%d/webhooks/%d", usersPrefix, userID, s.ID))
+	writeWebhook(w, "Подписка создана!", *s, http.StatusCreated)
+}
+
+// getWebhookV2 GET /users/{id}/webhooks/{webhookID}
+func getWebhookV2(w http.ResponseWriter, r *http.Request, userID, id int) {
+	s, err := webhooks.get(userID, id)
+	if err != nil {
+		getErrResponse(w, err.Error(), storageErrStatus(r, err))
+		return
+	}
+	writeWebhook(w, "Запрос успешно выполнен!", *s, http.StatusOK)
+}
+
+// deleteWebhookV2 DELETE /users/{id}/webhooks/{webhookID}
+func deleteWebhookV2(w http.ResponseWriter, r *http.Request, userID, id int) {
+	if err := webhooks.remove(userID, id); err != nil {
+		getErrResponse(w, err.Error(), storageErrStatus(r, err))
+		return
+	}
+	writeJSON(w, struct {
+		Result string `json:"result"`
+	}{Result: "Подписка удалена!"}, http.StatusOK)
+}
+
+// deliveriesV2 GET /users/{id}/webhooks/{webhookID}/deliveries
+func deliveriesV2(w http.ResponseWriter, r *http.Request, userID, id int) {
+	list, err := webhooks.log(userID, id)
+	if err != nil {
+		getErrResponse(w, err.Error(), storageErrStatus(r, err))
+		return
+	}
+
+	resp := struct {
+		Result     string            `json:"result"`
+		Deliveries []webhookDelivery `json:"deliveries"`
+	}{Result: "Запрос успешно выполнен!", Deliveries: list}
+
+	writeJSON(w, resp, http.StatusOK)
+}
+
+func writeWebhook(w http.ResponseWriter, result string, s Subscription, status int) {
+	resp := struct {
+		Result  string       `json:"result"`
+		Webhook Subscription `json:"webhook"`
+	}{Result: result, Webhook: s}
+
+	writeJSON(w, resp, status)
+}
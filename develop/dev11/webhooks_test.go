@@ -0,0 +1,417 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// webhookReceiver httptest-получатель: проверяет подпись и отдает статусы из fail по очереди
+type webhookReceiver struct {
+	*httptest.Server
+	secret string
+
+	mu       sync.Mutex
+	fail     []int
+	payloads chan webhookPayload
+}
+
+func newWebhookReceiver(t *testing.T, fail ...int) *webhookReceiver {
+	t.Helper()
+	rcv := &webhookReceiver{fail: fail, payloads: make(chan webhookPayload, 16)}
+	rcv.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		rcv.mu.Lock()
+		secret := rcv.secret
+		status := http.StatusNoContent
+		if len(rcv.fail) > 0 {
+			status, rcv.fail = rcv.fail[0], rcv.fail[1:]
+		}
+		rcv.mu.Unlock()
+
+		if !verifyWebhook(secret, r.Header.Get(webhookTimestampHeader), body, r.Header.Get(webhookSignatureHeader)) {
+			t.Errorf("bad signature %q", r.Header.Get(webhookSignatureHeader))
+		}
+		if status >= 300 {
+			w.WriteHeader(status)
+			return
+		}
+
+		var p webhookPayload
+		if err := json.Unmarshal(body, &p); err != nil {
+			t.Errorf("bad payload %q: %v", body, err)
+		}
+		if p.Type != r.Header.Get(webhookEventHeader) || p.ID != r.Header.Get(webhookDeliveryHeader) {
+			t.Errorf("headers don't match payload %+v", p)
+		}
+		rcv.payloads <- p
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(rcv.Close)
+	return rcv
+}
+
+// subscribe регистрирует получателя через API и запоминает секрет
+func (rcv *webhookReceiver) subscribe(t *testing.T, srvURL, token string, userID int, events string) int {
+	t.Helper()
+	status, res := doRequest(t, http.MethodPost, srvURL+"/users/"+strconv.Itoa(userID)+"/webhooks", token,
+		`{"url": "`+rcv.URL+`", "events": `+events+`}`, nil)
+	if status != http.StatusCreated {
+		t.Fatalf("can't subscribe: %v %v", status, res)
+	}
+	hook := res["webhook"].(map[string]interface{})
+
+	rcv.mu.Lock()
+	rcv.secret = hook["secret"].(string)
+	rcv.mu.Unlock()
+	return int(hook["id"].(float64))
+}
+
+func (rcv *webhookReceiver) expect(t *testing.T) webhookPayload {
+	t.Helper()
+	select {
+	case p := <-rcv.payloads:
+		return p
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook is not delivered")
+		return webhookPayload{}
+	}
+}
+
+// waitDeliveries ждет, пока все доставки подписки завершатся
+func waitDeliveries(t *testing.T, userID, id int) []webhookDelivery {
+	t.Helper()
+	return waitDispatcher(t, webhooks, userID, id)
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	srv, _ := newTestServer(t)
+	alice := issueToken(t, srv, "1")
+	bob := issueToken(t, srv, "2")
+
+	all := newWebhookReceiver(t)
+	allID := all.subscribe(t, srv.URL, alice, 1, `[]`)
+	deletes := newWebhookReceiver(t)
+	deletes.subscribe(t, srv.URL, alice, 1, `["event.deleted"]`)
+	invited := newWebhookReceiver(t)
+	invited.subscribe(t, srv.URL, bob, 2, `["event.created"]`)
+
+	events := srv.URL + "/users/1/events"
+	doRequest(t, http.MethodPost, events, alice,
+		`{"event_id": 1, "title": "standup", "date": "2019-09-09T00:00:00Z", "attendees": [{"user_id": 2}]}`, nil)
+	p := all.expect(t)
+	if p.Type != webhookCreated || p.Event.EventID != 1 || p.UserID != 1 || p.Previous != nil {
+		t.Fatalf("unexpected create payload %+v", p)
+	}
+	if p := invited.expect(t); p.Type != webhookCreated || p.UserID != 2 {
+		t.Fatalf("attendee must get the invite: %+v", p)
+	}
+
+	doRequest(t, http.MethodPatch, events+"/1", alice, `{"title": "retro"}`, nil)
+	p = all.expect(t)
+	if p.Type != webhookUpdated || p.Event.Title != "retro" || p.Previous == nil || p.Previous.Title != "standup" {
+		t.Fatalf("unexpected update payload %+v", p)
+	}
+
+	doRequest(t, http.MethodDelete, events+"/1", alice, "", nil)
+	if p := all.expect(t); p.Type != webhookDeleted || p.Event.EventID != 1 {
+		t.Fatalf("unexpected delete payload %+v", p)
+	}
+	if p := deletes.expect(t); p.Type != webhookDeleted {
+		t.Fatalf("filtered subscription got %v", p.Type)
+	}
+
+	// Журнал доставок: новые в начале, у каждой по одной успешной попытке
+	status, res := doRequest(t, http.MethodGet, srv.URL+"/users/1/webhooks/"+strconv.Itoa(allID)+"/deliveries", alice, "", nil)
+	if status != http.StatusOK {
+		t.Fatalf("deliveries: %v %v", status, res)
+	}
+	list := waitDeliveries(t, 1, allID)
+	if len(list) != 3 || list[0].Type != webhookDeleted || list[2].Type != webhookCreated {
+		t.Fatalf("unexpected log %+v", list)
+	}
+	for _, d := range list {
+		if d.Status != deliverySucceeded || len(d.Attempts) != 1 || d.Attempts[0].StatusCode != http.StatusNoContent {
+			t.Errorf("unexpected delivery %+v", d)
+		}
+	}
+
+	select {
+	case p := <-deletes.payloads:
+		t.Fatalf("filtered subscription got %v", p.Type)
+	case p := <-invited.payloads:
+		t.Fatalf("attendee subscription for created only got %v", p.Type)
+	default:
+	}
+}
+
+// TestWebhookRemovedAttendee исключенный из приглашенных не получает новое состояние события
+func TestWebhookRemovedAttendee(t *testing.T) {
+	srv, _ := newTestServer(t)
+	alice := issueToken(t, srv, "1")
+	bob := issueToken(t, srv, "2")
+	carol := issueToken(t, srv, "3")
+
+	removed := newWebhookReceiver(t)
+	removed.subscribe(t, srv.URL, bob, 2, `[]`)
+	added := newWebhookReceiver(t)
+	added.subscribe(t, srv.URL, carol, 3, `[]`)
+
+	events := srv.URL + "/users/1/events"
+	doRequest(t, http.MethodPost, events, alice,
+		`{"event_id": 1, "title": "standup", "date": "2019-09-09T00:00:00Z", "attendees": [{"user_id": 2}]}`, nil)
+	removed.expect(t)
+
+	doRequest(t, http.MethodPatch, events+"/1", alice, `{"title": "layoffs", "attendees": [{"user_id": 3}]}`, nil)
+	p := removed.expect(t)
+	if p.Type != webhookDeleted || p.Event.Title != "standup" || p.Previous != nil ||
+		len(p.Event.Attendees) != 1 || p.Event.Attendees[0].UserID != 2 {
+		t.Fatalf("removed attendee got %+v", p)
+	}
+	if p := added.expect(t); p.Type != webhookUpdated || p.Event.Title != "layoffs" {
+		t.Fatalf("new attendee got %+v", p)
+	}
+}
+
+func TestWebhookRetries(t *testing.T) {
+	srv, _ := newTestServer(t)
+	alice := issueToken(t, srv, "1")
+	webhooks.backoff = exponentialBackoff(time.Millisecond, 4*time.Millisecond)
+	webhooks.maxAttempts = 3
+
+	flaky := newWebhookReceiver(t, http.StatusInternalServerError, http.StatusBadGateway)
+	flakyID := flaky.subscribe(t, srv.URL, alice, 1, `["event.created"]`)
+
+	doRequest(t, http.MethodPost, srv.URL+"/users/1/events", alice,
+		`{"event_id": 1, "title": "standup", "date": "2019-09-09T00:00:00Z"}`, nil)
+	flaky.expect(t)
+
+	list := waitDeliveries(t, 1, flakyID)
+	if len(list) != 1 || list[0].Status != deliverySucceeded {
+		t.Fatalf("unexpected log %+v", list)
+	}
+	var codes []int
+	for _, a := range list[0].Attempts {
+		codes = append(codes, a.StatusCode)
+	}
+	if len(codes) != 3 || codes[0] != 500 || codes[1] != 502 || codes[2] != 204 {
+		t.Fatalf("attempts %v, want [500 502 204]", codes)
+	}
+
+	// Когда попытки кончились, доставка помечается failed
+	down := newWebhookReceiver(t, 503, 503, 503)
+	downID := down.subscribe(t, srv.URL, alice, 1, `["event.created"]`)
+	doRequest(t, http.MethodPost, srv.URL+"/users/1/events", alice,
+		`{"event_id": 2, "title": "retro", "date": "2019-09-09T00:00:00Z"}`, nil)
+	flaky.expect(t)
+
+	list = waitDeliveries(t, 1, downID)
+	if len(list) != 1 || list[0].Status != deliveryFailed || len(list[0].Attempts) != 3 || list[0].NextAttemptAt != nil {
+		t.Fatalf("unexpected log %+v", list)
+	}
+}
+
+// TestWebhookInternalAddress подписка не может заставить сервер отправлять запросы во внутреннюю сеть
+func TestWebhookInternalAddress(t *testing.T) {
+	rcv := newWebhookReceiver(t)
+	wd, err := openWebhooks("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wd.Close()
+	wd.maxAttempts = 1
+
+	s, err := wd.create(1, rcv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wd.record(storageChange{Op: opPut, Event: Event{UserID: 1, EventID: 1, Title: "standup"}})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		list, _ := wd.log(1, s.ID)
+		if len(list) == 1 && list[0].Status == deliveryFailed {
+			if !strings.Contains(list[0].Attempts[0].Error, "is not allowed") {
+				t.Errorf("unexpected error %q", list[0].Attempts[0].Error)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("delivery to loopback is not rejected: %+v", list)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	select {
+	case <-rcv.payloads:
+		t.Fatal("request reached loopback receiver")
+	default:
+	}
+
+	for addr, internal := range map[string]bool{
+		"127.0.0.1": true, "::1": true, "10.1.2.3": true, "172.16.0.1": true, "192.168.1.1": true,
+		"169.254.169.254": true, "fe80::1": true, "fd00::1": true, "0.0.0.0": true, "::ffff:127.0.0.1": true,
+		"93.184.216.34": false, "2606:4700::1111": false,
+	} {
+		if got := internalIP(net.ParseIP(addr)); got != internal {
+			t.Errorf("internalIP(%s) = %v, want %v", addr, got, internal)
+		}
+	}
+}
+
+// TestWebhookQueueFull при полной очереди доставки отбрасываются, а не копятся
+func TestWebhookQueueFull(t *testing.T) {
+	release := make(chan struct{})
+	received := make(chan struct{}, 8)
+	rcv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		received <- struct{}{}
+	}))
+	defer rcv.Close()
+	defer close(release)
+
+	wd := newWebhookDispatcher(1, 2)
+	defer wd.Close()
+	wd.client = &http.Client{Timeout: defaultWebhookTimeout}
+	s, err := wd.create(1, rcv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Одна доставка в работе, одна в очереди, остальные не помещаются
+	for id := 1; id <= 4; id++ {
+		wd.record(storageChange{Op: opPut, Event: Event{UserID: 1, EventID: id}})
+	}
+	list, _ := wd.log(1, s.ID)
+	if len(list) != 4 || list[0].Status != deliveryDropped || list[1].Status != deliveryDropped {
+		t.Fatalf("unexpected log %+v", list)
+	}
+
+	for i := 0; i < 2; i++ {
+		release <- struct{}{}
+		<-received
+	}
+	list = waitDispatcher(t, wd, 1, s.ID)
+	if list[2].Status != deliverySucceeded || list[3].Status != deliverySucceeded {
+		t.Fatalf("accepted deliveries are not delivered: %+v", list)
+	}
+
+	// Место в очереди освободилось
+	wd.record(storageChange{Op: opPut, Event: Event{UserID: 1, EventID: 5}})
+	if list, _ := wd.log(1, s.ID); list[0].Status != deliveryPending {
+		t.Fatalf("delivery after queue drained: %+v", list[0])
+	}
+}
+
+// waitDispatcher ждет, пока завершатся все доставки подписки в диспетчере wd
+func waitDispatcher(t *testing.T, wd *WebhookDispatcher, userID, id int) []webhookDelivery {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		list, err := wd.log(userID, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		done := true
+		for _, d := range list {
+			done = done && d.Status != deliveryPending
+		}
+		if done || time.Now().After(deadline) {
+			return list
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestExponentialBackoff(t *testing.T) {
+	backoff := exponentialBackoff(time.Second, 10*time.Second)
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second}
+	for i, w := range want {
+		if got := backoff(i + 1); got != w {
+			t.Errorf("attempt %d: got %v, want %v", i+1, got, w)
+		}
+	}
+}
+
+func TestWebhookAPI(t *testing.T) {
+	srv, _ := newTestServer(t)
+	alice := issueToken(t, srv, "1")
+	hooks := srv.URL + "/users/1/webhooks"
+
+	tests := []struct {
+		name   string
+		method string
+		url    string
+		body   string
+		want   int
+	}{
+		{"relative url", http.MethodPost, hooks, `{"url": "/callback"}`, http.StatusBadRequest},
+		{"bad scheme", http.MethodPost, hooks, `{"url": "ftp://example.com"}`, http.StatusBadRequest},
+		{"unknown type", http.MethodPost, hooks, `{"url": "http://example.com", "events": ["event.moved"]}`, http.StatusBadRequest},
+		{"create", http.MethodPost, hooks, `{"url": "http://example.com/hook"}`, http.StatusCreated},
+		{"foreign user", http.MethodGet, srv.URL + "/users/2/webhooks", "", http.StatusForbidden},
+		{"get", http.MethodGet, hooks + "/1", "", http.StatusOK},
+		{"missing", http.MethodGet, hooks + "/7", "", http.StatusServiceUnavailable},
+		{"bad suffix", http.MethodGet, hooks + "/1/history", "", http.StatusNotFound},
+		{"method", http.MethodPut, hooks + "/1", "", http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if status, res := doRequest(t, tt.method, tt.url, alice, tt.body, nil); status != tt.want {
+				t.Errorf("got %v %v, want %v", status, res, tt.want)
+			}
+		})
+	}
+
+	// Секрет не показывается после создания
+	_, res := doRequest(t, http.MethodGet, hooks, alice, "", nil)
+	list := res["webhooks"].([]interface{})
+	hook := list[0].(map[string]interface{})
+	if len(list) != 1 || hook["secret"] != nil || len(hook["events"].([]interface{})) != 3 {
+		t.Fatalf("unexpected list %v", list)
+	}
+
+	if status, _ := doRequest(t, http.MethodDelete, hooks+"/1", alice, "", nil); status != http.StatusOK {
+		t.Fatalf("delete failed: %v", status)
+	}
+	if status, _ := doRequest(t, http.MethodGet, hooks+"/1", alice, "", nil); status != http.StatusServiceUnavailable {
+		t.Fatalf("deleted webhook is still there: %v", status)
+	}
+}
+
+func TestWebhooksPersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), webhooksFileName)
+	wd, err := openWebhooks(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	created, err := wd.create(1, "https://example.com/hook", []string{webhookDeleted})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wd.Close()
+
+	wd, err = openWebhooks(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wd.Close()
+
+	wd.mu.Lock()
+	s := wd.subs[created.ID]
+	wd.mu.Unlock()
+	if s == nil || s.Secret != created.Secret || !s.wants(webhookDeleted) || s.wants(webhookCreated) {
+		t.Fatalf("subscription is not restored: %+v", s)
+	}
+	if next, _ := wd.create(1, "https://example.com/other", nil); next.ID != created.ID+1 {
+		t.Fatalf("id is reused: %v", next.ID)
+	}
+}